@@ -0,0 +1,274 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/report"
+)
+
+// StatusRunning is the status of a job that has yet to complete. Completed jobs report
+// the status they would with --jsonOutput.
+const StatusRunning = "running"
+
+// Duration is a time.Duration that is written as, and read from, a string such as "1h30m".
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("a duration must be a string such as \"1h30m\" - %v", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// SendRequest starts a backup the same way the send command does. Fields left out of the
+// request take the same defaults as the send command's flags.
+type SendRequest struct {
+	// Target is the volume@snapshot to backup, or just the volume when using a "smart" option.
+	Target       string
+	Destinations []string
+	// Require is how many destinations the backup must complete to: all, any, or a number.
+	Require string
+
+	Full            bool
+	Incremental     bool
+	FullIfOlderThan Duration
+	SnapshotPrefix  string
+	// IncrementalFrom is the snapshot, or #bookmark, to send an incremental stream from when not using a "smart" option.
+	IncrementalFrom string
+	Intermediary    bool
+
+	Replication   bool
+	SkipMissing   bool
+	Deduplication bool
+	Properties    bool
+	Raw           bool
+	Resume        bool
+
+	VolumeSize         uint64
+	MaxFileBuffer      int
+	MaxParallelUploads int
+	MaxRetryTime       Duration
+	MaxBackoffTime     Duration
+	Separator          string
+	UploadChunkSize    int
+}
+
+func defaultSendRequest() SendRequest {
+	return SendRequest{
+		Require:            "all",
+		FullIfOlderThan:    Duration(-1 * time.Minute),
+		VolumeSize:         200,
+		MaxFileBuffer:      5,
+		MaxParallelUploads: 4,
+		MaxRetryTime:       Duration(12 * time.Hour),
+		MaxBackoffTime:     Duration(30 * time.Minute),
+		Separator:          "|",
+		UploadChunkSize:    10,
+	}
+}
+
+func (r *SendRequest) apply(j *files.JobInfo) error {
+	required, err := backup.ParseRequire(r.Require)
+	if err != nil {
+		return err
+	}
+	if r.UploadChunkSize < 5 || r.UploadChunkSize > 100 {
+		return fmt.Errorf("%w: the UploadChunkSize provided (%d) is not between 5 and 100", backup.ErrInvalidInput, r.UploadChunkSize)
+	}
+
+	j.Destinations = r.Destinations
+	j.RequiredDestinations = required
+	j.Full = r.Full
+	j.Incremental = r.Incremental
+	j.FullIfOlderThan = time.Duration(r.FullIfOlderThan)
+	j.SnapshotPrefix = r.SnapshotPrefix
+	j.IncrementalSnapshot = files.SnapshotInfo{Name: r.IncrementalFrom}
+	j.IntermediaryIncremental = r.Intermediary && r.IncrementalFrom != ""
+	j.Replication = r.Replication
+	j.SkipMissing = r.SkipMissing
+	j.Deduplication = r.Deduplication
+	j.Properties = r.Properties
+	j.Raw = r.Raw
+	j.Resume = r.Resume
+	j.VolumeSize = r.VolumeSize
+	j.MaxFileBuffer = r.MaxFileBuffer
+	j.MaxParallelUploads = r.MaxParallelUploads
+	j.MaxRetryTime = time.Duration(r.MaxRetryTime)
+	j.MaxBackoffTime = time.Duration(r.MaxBackoffTime)
+	j.Separator = r.Separator
+	j.UploadChunkSize = r.UploadChunkSize
+	return nil
+}
+
+// ReceiveRequest starts a restore the same way the receive command does. Fields left out of
+// the request take the same defaults as the receive command's flags.
+type ReceiveRequest struct {
+	// Target is the volume@snapshot to restore, or just the volume when using AutoRestore.
+	Target       string
+	Destinations []string
+	// LocalVolume is the volume to restore to.
+	LocalVolume string
+
+	AutoRestore bool
+	FullPath    bool
+	LastPath    bool
+	Force       bool
+	NotMounted  bool
+	Origin      string
+	// IncrementalFrom is the snapshot to restore an incremental backup from.
+	IncrementalFrom string
+
+	MaxFileBuffer  int
+	MaxRetryTime   Duration
+	MaxBackoffTime Duration
+	Separator      string
+}
+
+func defaultReceiveRequest() ReceiveRequest {
+	return ReceiveRequest{
+		MaxFileBuffer:  5,
+		MaxRetryTime:   Duration(12 * time.Hour),
+		MaxBackoffTime: Duration(30 * time.Minute),
+		Separator:      "|",
+	}
+}
+
+func (r *ReceiveRequest) apply(j *files.JobInfo) {
+	j.Destinations = r.Destinations
+	j.LocalVolume = r.LocalVolume
+	j.AutoRestore = r.AutoRestore
+	j.FullPath = r.FullPath
+	j.LastPath = r.LastPath
+	j.Force = r.Force
+	j.NotMounted = r.NotMounted
+	j.Origin = r.Origin
+	j.IncrementalSnapshot = files.SnapshotInfo{Name: r.IncrementalFrom}
+	j.MaxFileBuffer = r.MaxFileBuffer
+	j.MaxRetryTime = time.Duration(r.MaxRetryTime)
+	j.MaxBackoffTime = time.Duration(r.MaxBackoffTime)
+	j.Separator = r.Separator
+}
+
+// VerifyRequest starts downloading a backup set, and the ones it is restored from, to check every volume
+// against the hash recorded in its manifest without running zfs receive.
+type VerifyRequest struct {
+	// Target is the volume@snapshot to verify, or just the volume to verify its latest backup set.
+	Target       string
+	Destinations []string
+
+	MaxParallelDownloads int
+	MaxRetryTime         Duration
+	MaxBackoffTime       Duration
+	Separator            string
+}
+
+func defaultVerifyRequest() VerifyRequest {
+	return VerifyRequest{
+		MaxParallelDownloads: 4,
+		MaxRetryTime:         Duration(12 * time.Hour),
+		MaxBackoffTime:       Duration(30 * time.Minute),
+		Separator:            "|",
+	}
+}
+
+func (r *VerifyRequest) apply(j *files.JobInfo) {
+	j.Destinations = r.Destinations
+	j.MaxParallelUploads = r.MaxParallelDownloads
+	j.MaxRetryTime = time.Duration(r.MaxRetryTime)
+	j.MaxBackoffTime = time.Duration(r.MaxBackoffTime)
+	j.Separator = r.Separator
+}
+
+// Job is an operation started through the API.
+type Job struct {
+	ID        string
+	Operation string
+	Target    string
+	Status    string
+	StartTime time.Time
+	EndTime   *time.Time    `json:",omitempty"`
+	Result    interface{}   `json:",omitempty"`
+	Error     *report.Error `json:",omitempty"`
+
+	cancel context.CancelFunc
+	events *eventLog
+}
+
+// eventLog holds the events emitted by a job so they can be streamed to any number of clients,
+// each starting from the first event.
+type eventLog struct {
+	mu      sync.Mutex
+	lines   [][]byte
+	changed chan struct{}
+	closed  bool
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{changed: make(chan struct{})}
+}
+
+// Write implements io.Writer, each write is expected to be a single event.
+func (l *eventLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return len(p), nil
+	}
+	l.lines = append(l.lines, append([]byte(nil), p...))
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return len(p), nil
+}
+
+func (l *eventLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	close(l.changed)
+}
+
+// since will return the events written after the first n, a channel closed once more are written,
+// and whether the log was closed, in which case no more events will be written.
+func (l *eventLog) since(n int) (lines [][]byte, changed <-chan struct{}, closed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lines[n:], l.changed, l.closed
+}
@@ -0,0 +1,438 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package api serves an HTTP API to list backup sets and to start, follow, and cancel backups,
+// restores, and verifies so they can be orchestrated remotely.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/events"
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/report"
+)
+
+// maxFinishedJobs is the number of completed jobs to remember, the oldest are forgotten first.
+const maxFinishedJobs = 100
+
+// Engine runs the operations jobs are started for, it is implemented by *backup.Engine.
+type Engine interface {
+	ListBackups(ctx context.Context, j *files.JobInfo, startswith string, before, after time.Time) (map[string][]*files.JobInfo, error)
+	PrepareSend(ctx context.Context, j *files.JobInfo, target string) ([]*files.JobInfo, error)
+	BackupJobs(ctx context.Context, jobs []*files.JobInfo) error
+	PrepareReceive(ctx context.Context, j *files.JobInfo, target string) error
+	Receive(ctx context.Context, j *files.JobInfo) error
+	AutoRestore(ctx context.Context, j *files.JobInfo) error
+	PrepareVerify(ctx context.Context, j *files.JobInfo, target string) error
+	Verify(ctx context.Context, j *files.JobInfo) error
+}
+
+var _ Engine = (*backup.Engine)(nil)
+
+// Server runs the jobs requested through the API. Every job starts from a copy of the
+// template, which holds the options that apply to all jobs such as the encryption key.
+type Server struct {
+	// Token is the bearer token clients must provide, if set.
+	Token string
+
+	ctx      context.Context
+	engine   Engine
+	template files.JobInfo
+
+	mu     sync.Mutex
+	jobs   map[string]*Job
+	nextID int
+}
+
+// NewServer will return a Server that runs jobs on the engine with the context, which cancels every running job once done.
+func NewServer(ctx context.Context, engine Engine, template *files.JobInfo, token string) *Server {
+	return &Server{
+		Token:    token,
+		ctx:      ctx,
+		engine:   engine,
+		template: *template,
+		jobs:     make(map[string]*Job),
+	}
+}
+
+// Handler will return the http.Handler serving the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/backups", s.handleListBackups)
+	mux.HandleFunc("POST /v1/jobs/send", s.handleSend)
+	mux.HandleFunc("POST /v1/jobs/receive", s.handleReceive)
+	mux.HandleFunc("POST /v1/jobs/verify", s.handleVerify)
+	mux.HandleFunc("GET /v1/jobs", s.handleListJobs)
+	mux.HandleFunc("GET /v1/jobs/{id}", s.handleGetJob)
+	mux.HandleFunc("GET /v1/jobs/{id}/events", s.handleJobEvents)
+	mux.HandleFunc("POST /v1/jobs/{id}/cancel", s.handleCancelJob)
+	return s.authenticate(mux)
+}
+
+// ListenAndServe will serve the API on the given address until the server's context is done. Clients
+// must present a certificate signed by one of the tlsConfig's ClientCAs when it requires one.
+func (s *Server) ListenAndServe(addr string, tlsConfig *tls.Config) error {
+	server := &http.Server{Addr: addr, Handler: s.Handler(), TLSConfig: tlsConfig, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-s.ctx.Done()
+		if err := server.Close(); err != nil {
+			zap.S().Warnf("Could not close API listener due to error - %v", err)
+		}
+	}()
+
+	zap.S().Infof("Serving the API on %s", addr)
+	var err error
+	if tlsConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, &report.Error{Code: "unauthorized", Message: "a valid bearer token is required"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleListBackups(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	uri := query.Get("uri")
+	if uri == "" {
+		writeBackupError(w, fmt.Errorf("%w: the uri to list the backup sets of must be provided", backup.ErrInvalidInput))
+		return
+	}
+
+	var before, after time.Time
+	for _, filter := range []struct {
+		name string
+		t    *time.Time
+	}{{"before", &before}, {"after", &after}} {
+		if value := query.Get(filter.name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeBackupError(w, fmt.Errorf("%w: could not parse %s time '%s' - %v", backup.ErrInvalidInput, filter.name, value, err))
+				return
+			}
+			*filter.t = parsed
+		}
+	}
+
+	j := s.template
+	j.Destinations = []string{uri}
+	backups, err := s.engine.ListBackups(r.Context(), &j, query.Get("volumeName"), before, after)
+	if err != nil {
+		writeBackupError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, backups)
+}
+
+func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
+	req := defaultSendRequest()
+	if err := decodeRequest(r, &req); err != nil {
+		writeBackupError(w, err)
+		return
+	}
+
+	j := s.template
+	if err := req.apply(&j); err != nil {
+		writeBackupError(w, err)
+		return
+	}
+
+	job := s.start("send", req.Target, func(ctx context.Context) error {
+		jobs, err := s.engine.PrepareSend(ctx, &j, req.Target)
+		if err != nil {
+			return err
+		}
+		return s.engine.BackupJobs(ctx, jobs)
+	})
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleReceive(w http.ResponseWriter, r *http.Request) {
+	req := defaultReceiveRequest()
+	if err := decodeRequest(r, &req); err != nil {
+		writeBackupError(w, err)
+		return
+	}
+
+	j := s.template
+	req.apply(&j)
+
+	job := s.start("receive", req.Target, func(ctx context.Context) error {
+		if err := s.engine.PrepareReceive(ctx, &j, req.Target); err != nil {
+			return err
+		}
+		if j.AutoRestore {
+			return s.engine.AutoRestore(ctx, &j)
+		}
+		return s.engine.Receive(ctx, &j)
+	})
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	req := defaultVerifyRequest()
+	if err := decodeRequest(r, &req); err != nil {
+		writeBackupError(w, err)
+		return
+	}
+
+	j := s.template
+	req.apply(&j)
+
+	job := s.start("verify", req.Target, func(ctx context.Context) error {
+		if err := s.engine.PrepareVerify(ctx, &j, req.Target); err != nil {
+			return err
+		}
+		return s.engine.Verify(ctx, &j)
+	})
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].StartTime.Before(jobs[k].StartTime) })
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(r.PathValue("id"))
+	if !ok {
+		writeJobNotFound(w, r.PathValue("id"))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleJobEvents will stream the job's events as newline delimited JSON, from its first event
+// until the job completes or the client goes away.
+func (s *Server) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	job, ok := s.jobs[r.PathValue("id")]
+	var log *eventLog
+	if ok {
+		log = job.events
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeJobNotFound(w, r.PathValue("id"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	var sent int
+	for {
+		lines, changed, closed := log.since(sent)
+		for _, line := range lines {
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+		}
+		sent += len(lines)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if closed {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	job, ok := s.jobs[r.PathValue("id")]
+	if ok {
+		job.cancel()
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeJobNotFound(w, r.PathValue("id"))
+		return
+	}
+
+	zap.S().Infof("Cancelling job %s as requested.", job.ID)
+	updated, _ := s.job(job.ID)
+	writeJSON(w, http.StatusAccepted, updated)
+}
+
+// start will run the operation in the background with a context carrying the job's event
+// stream and results, returning the job as it was started.
+func (s *Server) start(operation, target string, run func(ctx context.Context) error) Job {
+	log := newEventLog()
+	recorder := new(report.Recorder)
+	ctx, cancel := context.WithCancel(s.ctx)
+	ctx = events.NewContext(report.NewContext(ctx, recorder), events.NewStream(log))
+
+	s.mu.Lock()
+	s.nextID++
+	job := &Job{
+		ID:        strconv.Itoa(s.nextID),
+		Operation: operation,
+		Target:    target,
+		Status:    StatusRunning,
+		StartTime: time.Now(),
+		cancel:    cancel,
+		events:    log,
+	}
+	s.jobs[job.ID] = job
+	started := *job
+	s.mu.Unlock()
+
+	zap.S().Infof("Starting %s job %s for %s.", operation, job.ID, target)
+	go func() {
+		defer cancel()
+		err := run(ctx)
+		if err != nil {
+			zap.S().Errorf("The %s job %s for %s failed - %v", operation, job.ID, target, err)
+		} else {
+			zap.S().Infof("The %s job %s for %s completed.", operation, job.ID, target)
+		}
+
+		s.mu.Lock()
+		end := time.Now()
+		job.EndTime = &end
+		job.Status = backup.Status(err)
+		job.Error = backup.ReportError(err)
+		job.Result = recorder.Result()
+		s.forgetFinishedJobs()
+		s.mu.Unlock()
+		log.close()
+	}()
+
+	return started
+}
+
+// forgetFinishedJobs will drop the oldest completed jobs once there are more than maxFinishedJobs of them.
+// The caller must hold the lock.
+func (s *Server) forgetFinishedJobs() {
+	var finished []*Job
+	for _, job := range s.jobs {
+		if job.EndTime != nil {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+
+	sort.Slice(finished, func(i, k int) bool { return finished[i].EndTime.Before(*finished[k].EndTime) })
+	for _, job := range finished[:len(finished)-maxFinishedJobs] {
+		delete(s.jobs, job.ID)
+	}
+}
+
+// job will return a copy of the job so it can be read without holding the lock.
+func (s *Server) job(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+func decodeRequest(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: could not decode request - %v", backup.ErrInvalidInput, err)
+	}
+	return nil
+}
+
+// writeBackupError will respond with the error as it is reported in JSON output, using the
+// HTTP status that best matches its code.
+func writeBackupError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch backup.ErrorCode(err) {
+	case "invalid_input":
+		status = http.StatusBadRequest
+	case "snapshot_not_found":
+		status = http.StatusNotFound
+	case "locked":
+		status = http.StatusConflict
+	case "backend_unreachable":
+		status = http.StatusBadGateway
+	}
+	writeError(w, status, backup.ReportError(err))
+}
+
+func writeJobNotFound(w http.ResponseWriter, id string) {
+	writeError(w, http.StatusNotFound, &report.Error{Code: "job_not_found", Message: fmt.Sprintf("no job found with the id %s", id)})
+}
+
+func writeError(w http.ResponseWriter, status int, e *report.Error) {
+	writeJSON(w, status, struct{ Error *report.Error }{e})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zap.S().Warnf("Could not write API response due to error - %v", err)
+	}
+}
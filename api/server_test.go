@@ -0,0 +1,300 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/events"
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/report"
+)
+
+// fakeEngine runs the functions set for the operations a test expects, and fails the others.
+type fakeEngine struct {
+	listBackups    func(ctx context.Context, j *files.JobInfo, startswith string, before, after time.Time) (map[string][]*files.JobInfo, error)
+	prepareSend    func(ctx context.Context, j *files.JobInfo, target string) ([]*files.JobInfo, error)
+	backupJobs     func(ctx context.Context, jobs []*files.JobInfo) error
+	prepareReceive func(ctx context.Context, j *files.JobInfo, target string) error
+	autoRestore    func(ctx context.Context, j *files.JobInfo) error
+	prepareVerify  func(ctx context.Context, j *files.JobInfo, target string) error
+	verify         func(ctx context.Context, j *files.JobInfo) error
+}
+
+func (f *fakeEngine) ListBackups(
+	ctx context.Context, j *files.JobInfo, startswith string, before, after time.Time,
+) (map[string][]*files.JobInfo, error) {
+	return f.listBackups(ctx, j, startswith, before, after)
+}
+
+func (f *fakeEngine) PrepareSend(ctx context.Context, j *files.JobInfo, target string) ([]*files.JobInfo, error) {
+	return f.prepareSend(ctx, j, target)
+}
+
+func (f *fakeEngine) BackupJobs(ctx context.Context, jobs []*files.JobInfo) error {
+	return f.backupJobs(ctx, jobs)
+}
+
+func (f *fakeEngine) PrepareReceive(ctx context.Context, j *files.JobInfo, target string) error {
+	return f.prepareReceive(ctx, j, target)
+}
+
+func (f *fakeEngine) Receive(context.Context, *files.JobInfo) error {
+	return errors.New("unexpected receive")
+}
+
+func (f *fakeEngine) AutoRestore(ctx context.Context, j *files.JobInfo) error {
+	return f.autoRestore(ctx, j)
+}
+
+func (f *fakeEngine) PrepareVerify(ctx context.Context, j *files.JobInfo, target string) error {
+	return f.prepareVerify(ctx, j, target)
+}
+
+func (f *fakeEngine) Verify(ctx context.Context, j *files.JobInfo) error {
+	return f.verify(ctx, j)
+}
+
+func newTestServer(t *testing.T, engine *fakeEngine) (*Server, *httptest.Server) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	s := NewServer(ctx, engine, &files.JobInfo{ManifestPrefix: "manifests"}, "secret")
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func doRequest(t *testing.T, method, url, token string, body interface{}) *http.Response {
+	t.Helper()
+	var b bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&b).Encode(body))
+	}
+	req, err := http.NewRequest(method, url, &b)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func decodeResponse(t *testing.T, resp *http.Response, v interface{}) {
+	t.Helper()
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+}
+
+func waitForJob(t *testing.T, ts *httptest.Server, id string) Job {
+	t.Helper()
+	var job Job
+	require.Eventually(t, func() bool {
+		resp := doRequest(t, http.MethodGet, ts.URL+"/v1/jobs/"+id, "secret", nil)
+		decodeResponse(t, resp, &job)
+		return job.Status != StatusRunning
+	}, 5*time.Second, 10*time.Millisecond)
+	return job
+}
+
+func TestAuthentication(t *testing.T) {
+	_, ts := newTestServer(t, &fakeEngine{})
+
+	testCases := []struct {
+		token  string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{"secret", http.StatusOK},
+	}
+	for _, tc := range testCases {
+		resp := doRequest(t, http.MethodGet, ts.URL+"/v1/jobs", tc.token, nil)
+		assert.Equal(t, tc.status, resp.StatusCode, "token %q", tc.token)
+	}
+}
+
+func TestListBackups(t *testing.T) {
+	engine := &fakeEngine{}
+	_, ts := newTestServer(t, engine)
+
+	engine.listBackups = func(_ context.Context, j *files.JobInfo, startswith string, before, after time.Time) (map[string][]*files.JobInfo, error) {
+		assert.Equal(t, []string{"file:///backups"}, j.Destinations)
+		assert.Equal(t, "manifests", j.ManifestPrefix)
+		assert.Equal(t, "tank/data", startswith)
+		assert.True(t, before.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)))
+		assert.True(t, after.IsZero())
+		return map[string][]*files.JobInfo{"tank/data": {{VolumeName: "tank/data"}}}, nil
+	}
+
+	resp := doRequest(t, http.MethodGet, ts.URL+"/v1/backups?uri=file:///backups&volumeName=tank/data&before=2020-01-02T03:04:05Z", "secret", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var backups map[string][]*files.JobInfo
+	decodeResponse(t, resp, &backups)
+	require.Len(t, backups["tank/data"], 1)
+
+	resp = doRequest(t, http.MethodGet, ts.URL+"/v1/backups", "secret", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	var errResp struct{ Error *report.Error }
+	decodeResponse(t, resp, &errResp)
+	assert.Equal(t, "invalid_input", errResp.Error.Code)
+}
+
+func TestSendJob(t *testing.T) {
+	engine := &fakeEngine{}
+	_, ts := newTestServer(t, engine)
+
+	engine.prepareSend = func(_ context.Context, j *files.JobInfo, target string) ([]*files.JobInfo, error) {
+		assert.Equal(t, "tank/data", target)
+		assert.True(t, j.Full)
+		assert.Equal(t, 1, j.RequiredDestinations)
+		assert.Equal(t, 30*time.Minute, j.MaxRetryTime)
+		assert.Equal(t, 4, j.MaxParallelUploads, "defaults should apply to fields not in the request")
+		return []*files.JobInfo{j}, nil
+	}
+	engine.backupJobs = func(ctx context.Context, jobs []*files.JobInfo) error {
+		events.FromContext(ctx).Emit(&events.Event{Type: events.VolumeUploaded, VolumeName: jobs[0].VolumeName})
+		report.FromContext(ctx).Record(map[string]string{"Status": "done"})
+		return nil
+	}
+
+	resp := doRequest(t, http.MethodPost, ts.URL+"/v1/jobs/send", "secret", map[string]interface{}{
+		"Target":       "tank/data",
+		"Destinations": []string{"file:///backups"},
+		"Full":         true,
+		"Require":      "any",
+		"MaxRetryTime": "30m",
+	})
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var started Job
+	decodeResponse(t, resp, &started)
+	assert.Equal(t, "send", started.Operation)
+
+	job := waitForJob(t, ts, started.ID)
+	assert.Equal(t, report.StatusSuccess, job.Status)
+	assert.Nil(t, job.Error)
+	assert.Equal(t, map[string]interface{}{"Status": "done"}, job.Result)
+
+	resp = doRequest(t, http.MethodGet, ts.URL+"/v1/jobs/"+started.ID+"/events", "secret", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var streamed []events.Event
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var event events.Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		streamed = append(streamed, event)
+	}
+	require.Len(t, streamed, 1)
+	assert.Equal(t, events.VolumeUploaded, streamed[0].Type)
+}
+
+func TestSendJobFailure(t *testing.T) {
+	engine := &fakeEngine{}
+	_, ts := newTestServer(t, engine)
+
+	engine.prepareSend = func(context.Context, *files.JobInfo, string) ([]*files.JobInfo, error) {
+		return nil, backup.ErrOutOfSync
+	}
+
+	resp := doRequest(t, http.MethodPost, ts.URL+"/v1/jobs/send", "secret", map[string]interface{}{"Target": "tank/data"})
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var started Job
+	decodeResponse(t, resp, &started)
+
+	job := waitForJob(t, ts, started.ID)
+	assert.Equal(t, report.StatusFailure, job.Status)
+	require.NotNil(t, job.Error)
+	assert.Equal(t, "out_of_sync", job.Error.Code)
+
+	for _, body := range []string{`{"Unknown": true}`, `{"MaxRetryTime": 5}`, `{"UploadChunkSize": 1}`, `{"Require": "none"}`} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/jobs/send", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+	}
+}
+
+func TestReceiveJobCancel(t *testing.T) {
+	engine := &fakeEngine{}
+	_, ts := newTestServer(t, engine)
+
+	engine.prepareReceive = func(_ context.Context, j *files.JobInfo, target string) error {
+		assert.Equal(t, "tank/restored", j.LocalVolume)
+		return nil
+	}
+	running := make(chan struct{})
+	engine.autoRestore = func(ctx context.Context, _ *files.JobInfo) error {
+		close(running)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	resp := doRequest(t, http.MethodPost, ts.URL+"/v1/jobs/receive", "secret", map[string]interface{}{
+		"Target":       "tank/data",
+		"Destinations": []string{"file:///backups"},
+		"LocalVolume":  "tank/restored",
+		"AutoRestore":  true,
+	})
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var started Job
+	decodeResponse(t, resp, &started)
+	<-running
+
+	resp = doRequest(t, http.MethodPost, ts.URL+"/v1/jobs/"+started.ID+"/cancel", "secret", nil)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	job := waitForJob(t, ts, started.ID)
+	assert.Equal(t, report.StatusFailure, job.Status)
+	require.NotNil(t, job.Error)
+	assert.Equal(t, "canceled", job.Error.Code)
+
+	resp = doRequest(t, http.MethodPost, ts.URL+"/v1/jobs/unknown/cancel", "secret", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestVerifyJob(t *testing.T) {
+	engine := &fakeEngine{}
+	_, ts := newTestServer(t, engine)
+
+	engine.prepareVerify = func(_ context.Context, j *files.JobInfo, target string) error {
+		assert.Equal(t, "tank/data@auto-a", target)
+		assert.Equal(t, []string{"file:///backups"}, j.Destinations)
+		assert.Equal(t, 2, j.MaxParallelUploads)
+		assert.Equal(t, 30*time.Minute, j.MaxBackoffTime, "defaults should apply to fields not in the request")
+		return nil
+	}
+	engine.verify = func(context.Context, *files.JobInfo) error {
+		return backup.ErrHashMismatch
+	}
+
+	resp := doRequest(t, http.MethodPost, ts.URL+"/v1/jobs/verify", "secret", map[string]interface{}{
+		"Target":               "tank/data@auto-a",
+		"Destinations":         []string{"file:///backups"},
+		"MaxParallelDownloads": 2,
+	})
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var started Job
+	decodeResponse(t, resp, &started)
+	assert.Equal(t, "verify", started.Operation)
+
+	job := waitForJob(t, ts, started.ID)
+	assert.Equal(t, report.StatusFailure, job.Status)
+	require.NotNil(t, job.Error)
+	assert.Equal(t, "hash_mismatch", job.Error.Code)
+}
@@ -0,0 +1,39 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backends
+
+import "context"
+
+// AppendOnlyBackend wraps another Backend and refuses to delete anything through it, so a compromised
+// host cannot remove the backups it made even with credentials that allow it.
+type AppendOnlyBackend struct {
+	Backend
+}
+
+// NewAppendOnlyBackend will return a Backend that works like b except it never deletes objects.
+func NewAppendOnlyBackend(b Backend) *AppendOnlyBackend {
+	return &AppendOnlyBackend{Backend: b}
+}
+
+// Delete will return ErrAppendOnly without deleting the object.
+func (a *AppendOnlyBackend) Delete(ctx context.Context, filename string) error {
+	return ErrAppendOnly
+}
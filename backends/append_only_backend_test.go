@@ -0,0 +1,68 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backends
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAppendOnlyBackend(t *testing.T) {
+	ctx := context.Background()
+	b := NewAppendOnlyBackend(&FileBackend{})
+	conf := &BackendConfig{TargetURI: FileBackendPrefix + "://" + t.TempDir(), MaxParallelUploadBuffer: make(chan bool, 1)}
+	if err := b.Init(ctx, conf); err != nil {
+		t.Fatalf("Issue initializing the wrapped backend: %v", err)
+	}
+
+	_, goodVol, _, err := prepareTestVols()
+	if err != nil {
+		t.Fatalf("error preparing volume for testing - %v", err)
+	}
+	defer goodVol.DeleteVolume() //nolint:errcheck // Best effort cleanup
+	if err = goodVol.OpenVolume(); err != nil {
+		t.Fatalf("could not open volume - %v", err)
+	}
+	if err = b.Upload(ctx, goodVol); err != nil {
+		t.Fatalf("Issue uploading through the wrapped backend: %v", err)
+	}
+	goodVol.Close()
+
+	if err = b.Delete(ctx, goodVol.ObjectName); !errors.Is(err, ErrAppendOnly) {
+		t.Errorf("Expected ErrAppendOnly deleting in append-only mode, got %v instead", err)
+	}
+	if objects, lerr := b.List(ctx, ""); lerr != nil || len(objects) != 1 {
+		t.Errorf("Expected the object to still be in the backend, got %v (%v)", objects, lerr)
+	}
+}
+
+func TestLockUnsupported(t *testing.T) {
+	conf := &BackendConfig{TargetURI: FileBackendPrefix + "://" + t.TempDir(), LockUntil: time.Now().Add(time.Hour)}
+	if err := (&FileBackend{}).Init(context.Background(), conf); !errors.Is(err, ErrLockUnsupported) {
+		t.Errorf("Expected ErrLockUnsupported locking objects in the file backend, got %v instead", err)
+	}
+	conf = &BackendConfig{TargetURI: B2BackendPrefix + "://bucket", LockUntil: time.Now().Add(time.Hour)}
+	if err := (&B2Backend{}).Init(context.Background(), conf); !errors.Is(err, ErrLockUnsupported) {
+		t.Errorf("Expected ErrLockUnsupported locking objects in the b2 backend, got %v instead", err)
+	}
+}
@@ -22,55 +22,103 @@ package backends
 
 import (
 	"context"
-	"crypto/md5" //nolint:gosec // MD5 not used cryptographically here
+	"crypto/md5" //nolint:gosec // MD5 is what S3 uses to check the SSE-C key
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/request"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3iface"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/logging"
+	"github.com/aws/smithy-go/middleware"
 	"go.uber.org/zap"
 
 	"github.com/someone1/zfsbackup-go/files"
 )
 
-var SleepFunc = time.Sleep
-
 // AWSS3BackendPrefix is the URI prefix used for the AWSS3Backend.
 const AWSS3BackendPrefix = "s3"
 
-// AWSS3Backend integrates with Amazon Web Services' S3.
+// AWSS3Backend integrates with Amazon Web Services' S3. Options for the objects it uploads can be given
+// as query parameters of the URI, e.g. s3://bucket/prefix?storageClass=DEEP_ARCHIVE&sse=aws:kms:
+//
+//   - profile: the shared config profile to load credentials and settings from, AWS_PROFILE if not set.
+//   - region: the region of the bucket, the profile's or AWS_REGION if not set.
+//   - endpoint: the URL of an S3 compatible service to use instead of AWS, AWS_S3_CUSTOM_ENDPOINT if not set.
+//   - storageClass: the storage class of volumes, AWS_S3_STORAGE_CLASS if not set.
+//   - manifestStorageClass: the storage class of manifests, the same as volumes unless that is an
+//     archive class, GLACIER or DEEP_ARCHIVE, in which case STANDARD so they can be synced quickly.
+//   - sse: the server-side encryption to use, AES256 for SSE-S3 or aws:kms for SSE-KMS.
+//   - sseKmsKeyId: the KMS key to use with aws:kms, the account's default key if not set.
+//   - sseCustomerKey: the base64 encoded 256-bit key to use for SSE-C, AWS_S3_SSE_CUSTOMER_KEY if not set.
+//   - tag: a key=value tag to add to every object, can be repeated.
+//   - lockMode: the Object Lock retention mode, GOVERNANCE or COMPLIANCE, for buckets with Object Lock enabled.
+//   - lockDays or lockUntil: how many days from the upload, or the RFC 3339 time, to retain objects until,
+//     instead of the LockUntil of the BackendConfig. Objects locked by the BackendConfig alone use the
+//     COMPLIANCE mode for a compliance lock and GOVERNANCE otherwise, unless lockMode is given.
+//   - restoreTier: the tier to restore archived objects with, Expedited, Standard or Bulk, where the archive
+//     supports it. Otherwise Bulk for GLACIER, or AWS_S3_GLACIER_RESTORE_TIER if set, and DEEP_ARCHIVE,
+//     and Standard for the archive access tiers of INTELLIGENT_TIERING as those restores are free.
+//   - restoreDays: how many days restored copies of GLACIER and DEEP_ARCHIVE objects are kept for, 3 if not set.
+//
+// Uploads carry a SHA256 checksum S3 verifies, the one computed while writing the volume where it is
+// uploaded in a single request, and one computed for each part otherwise.
 type AWSS3Backend struct {
 	conf       *BackendConfig
 	mutex      sync.Mutex
-	client     s3iface.S3API
-	uploader   s3manageriface.UploaderAPI
+	client     S3API
+	uploader   S3Uploader
 	prefix     string
 	bucketName string
-}
 
-// Authenticate https://godoc.org/github.com/aws/aws-sdk-go/aws/session#hdr-Environment_Variables
+	profile              string
+	region               string
+	endpoint             string
+	storageClass         types.StorageClass
+	manifestStorageClass types.StorageClass
+	sse                  types.ServerSideEncryption
+	sseKMSKeyID          *string
+	sseCustomerKey       *string
+	sseCustomerKeyMD5    *string
+	tagging              *string
+	lockMode             types.ObjectLockMode
+	lockDays             int
+	lockUntil            time.Time
+	restoreTierOverride  types.Tier
+	restoreDays          int32
+}
 
-type logger struct{}
+// S3API is the part of the S3 client the AWSS3Backend uses, so it can be swapped out for testing.
+type S3API interface {
+	manager.UploadAPIClient
+	ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, opts ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	HeadObject(ctx context.Context, in *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetObject(ctx context.Context, in *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, opts ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	RestoreObject(ctx context.Context, in *s3.RestoreObjectInput, opts ...func(*s3.Options)) (*s3.RestoreObjectOutput, error)
+}
 
-func (l logger) Log(args ...interface{}) {
-	zap.S().Debugf("s3 backend: %v", args...)
+// S3Uploader is the part of the S3 upload manager the AWSS3Backend uses, so it can be swapped out for testing.
+type S3Uploader interface {
+	//nolint:staticcheck // The transfermanager replacing it is not stable yet
+	Upload(ctx context.Context, in *s3.PutObjectInput, opts ...func(*manager.Uploader)) (*manager.UploadOutput, error)
 }
 
-type withS3Client struct{ client s3iface.S3API }
+// Authenticate https://docs.aws.amazon.com/sdkref/latest/guide/standardized-credentials.html
+
+type withS3Client struct{ client S3API }
 
 func (w withS3Client) Apply(b Backend) {
 	if v, ok := b.(*AWSS3Backend); ok {
@@ -80,11 +128,11 @@ func (w withS3Client) Apply(b Backend) {
 
 // WithS3Client will override an S3 backend's underlying API client with the one provided.
 // Primarily used to inject mock clients for testing.
-func WithS3Client(c s3iface.S3API) Option {
+func WithS3Client(c S3API) Option {
 	return withS3Client{c}
 }
 
-type withS3Uploader struct{ uploader s3manageriface.UploaderAPI }
+type withS3Uploader struct{ uploader S3Uploader }
 
 func (w withS3Uploader) Apply(b Backend) {
 	if v, ok := b.(*AWSS3Backend); ok {
@@ -94,7 +142,7 @@ func (w withS3Uploader) Apply(b Backend) {
 
 // WithS3Uploader will override an S3 backend's underlying uploader client with the one provided.
 // Primarily used to inject mock clients for testing.
-func WithS3Uploader(c s3manageriface.UploaderAPI) Option {
+func WithS3Uploader(c S3Uploader) Option {
 	return withS3Uploader{c}
 }
 
@@ -102,9 +150,12 @@ func WithS3Uploader(c s3manageriface.UploaderAPI) Option {
 func (a *AWSS3Backend) Init(ctx context.Context, conf *BackendConfig, opts ...Option) error {
 	a.conf = conf
 
-	cleanPrefix := strings.TrimPrefix(a.conf.TargetURI, AWSS3BackendPrefix+"://")
-	if cleanPrefix == a.conf.TargetURI {
-		return ErrInvalidURI
+	cleanPrefix, options, err := targetOptions(a.conf, AWSS3BackendPrefix)
+	if err != nil {
+		return err
+	}
+	if err = a.parseOptions(options); err != nil {
+		return fmt.Errorf("%w: s3 backend: %v", ErrInvalidURI, err)
 	}
 
 	uriParts := strings.Split(cleanPrefix, "/")
@@ -119,79 +170,227 @@ func (a *AWSS3Backend) Init(ctx context.Context, conf *BackendConfig, opts ...Op
 	}
 
 	if a.client == nil {
-		awsconf := aws.NewConfig().
-			WithS3ForcePathStyle(true).
-			WithEndpoint(os.Getenv("AWS_S3_CUSTOM_ENDPOINT"))
-		if enableDebug, _ := strconv.ParseBool(os.Getenv("AWS_S3_ENABLE_DEBUG")); enableDebug {
-			awsconf = awsconf.WithLogger(logger{}).
-				WithLogLevel(aws.LogDebugWithRequestRetries | aws.LogDebugWithRequestErrors)
-		}
-
-		sess, err := session.NewSession(awsconf)
+		client, err := a.newClient(ctx)
 		if err != nil {
 			return err
 		}
-
-		a.client = s3.New(sess)
+		a.client = client
 	}
 
 	if a.uploader == nil {
-		a.uploader = s3manager.NewUploaderWithClient(a.client, func(u *s3manager.Uploader) {
+		//nolint:staticcheck // The transfermanager replacing it is not stable yet
+		a.uploader = manager.NewUploader(a.client, func(u *manager.Uploader) {
 			u.Concurrency = conf.MaxParallelUploads
-		}, func(u *s3manager.Uploader) {
 			u.PartSize = int64(conf.UploadChunkSize)
 		})
 	}
 
+	if conf.WriteOnly {
+		return nil
+	}
+
 	listReq := &s3.ListObjectsV2Input{
 		Bucket:  aws.String(a.bucketName),
-		MaxKeys: aws.Int64(0),
+		MaxKeys: aws.Int32(0),
 	}
 
-	_, err := a.client.ListObjectsV2WithContext(ctx, listReq)
+	_, err = a.client.ListObjectsV2(ctx, listReq)
 	return err
 }
 
-func withContentMD5Header(md5sum string) request.Option {
-	return func(ro *request.Request) {
-		if md5sum != "" {
-			ro.Handlers.Build.PushBack(func(r *request.Request) {
-				r.HTTPRequest.Header.Set("Content-MD5", md5sum)
-			})
-		}
+// newClient will create an S3 client from the default credential chain, which covers environment
+// variables, shared config and SSO profiles, web identities and the EC2 and ECS metadata services.
+func (a *AWSS3Backend) newClient(ctx context.Context) (*s3.Client, error) {
+	var loadOpts []func(*config.LoadOptions) error
+	if a.profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(a.profile))
+	}
+	if a.region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(a.region))
+	}
+	if enableDebug, _ := strconv.ParseBool(os.Getenv("AWS_S3_ENABLE_DEBUG")); enableDebug {
+		loadOpts = append(loadOpts,
+			config.WithLogger(logging.LoggerFunc(func(_ logging.Classification, format string, v ...interface{}) {
+				zap.S().Debugf("s3 backend: "+format, v...)
+			})),
+			config.WithClientLogMode(aws.LogRetries|aws.LogRequest|aws.LogResponse),
+		)
 	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = true
+		if a.endpoint != "" {
+			o.BaseEndpoint = aws.String(a.endpoint)
+		}
+	}), nil
 }
 
-func withRequestLimiter(buffer chan bool) request.Option {
-	return func(ro *request.Request) {
-		ro.Handlers.Send.PushFront(func(r *request.Request) {
-			buffer <- true
-		})
+// parseOptions will set the options of the destination, falling back to the env variables for those not given.
+// nolint:gocyclo // A switch over the options
+func (a *AWSS3Backend) parseOptions(options url.Values) (err error) {
+	a.profile, a.region, a.endpoint = "", "", os.Getenv("AWS_S3_CUSTOM_ENDPOINT")
+	a.storageClass, a.manifestStorageClass = types.StorageClass(os.Getenv("AWS_S3_STORAGE_CLASS")), ""
+	a.sse, a.sseKMSKeyID = "", nil
+	a.sseCustomerKey, a.sseCustomerKeyMD5 = getS3EnvironmentOverride("AWS_S3_SSE_CUSTOMER_KEY"), nil
+	a.tagging, a.lockMode, a.lockDays, a.lockUntil = nil, "", 0, time.Time{}
+	a.restoreTierOverride, a.restoreDays = "", 3
+	tags := make(url.Values)
+	for name, values := range options {
+		value := values[len(values)-1]
+		switch name {
+		case "profile":
+			a.profile = value
+		case "region":
+			a.region = value
+		case "endpoint":
+			if u, perr := url.Parse(value); perr != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("endpoint must be a URL, got %s", value)
+			}
+			a.endpoint = value
+		case "storageClass":
+			a.storageClass = types.StorageClass(value)
+		case "manifestStorageClass":
+			a.manifestStorageClass = types.StorageClass(value)
+		case "sse":
+			a.sse = types.ServerSideEncryption(value)
+		case "sseKmsKeyId":
+			a.sseKMSKeyID = aws.String(value)
+		case "sseCustomerKey":
+			a.sseCustomerKey = aws.String(value)
+		case "tag":
+			for _, tag := range values {
+				key, tagValue, ok := strings.Cut(tag, "=")
+				if !ok || key == "" {
+					return fmt.Errorf("tags must be given as key=value, got %s", tag)
+				}
+				tags.Add(key, tagValue)
+			}
+		case "lockMode":
+			a.lockMode = types.ObjectLockMode(value)
+		case "lockDays":
+			if a.lockDays, err = strconv.Atoi(value); err != nil || a.lockDays < 1 {
+				return fmt.Errorf("lockDays must be a number greater than 0, got %s", value)
+			}
+		case "lockUntil":
+			if a.lockUntil, err = time.Parse(time.RFC3339, value); err != nil {
+				return fmt.Errorf("lockUntil must be an RFC 3339 time, got %s", value)
+			}
+		case "restoreTier":
+			a.restoreTierOverride = types.Tier(value)
+		case "restoreDays":
+			days, perr := strconv.ParseInt(value, 10, 32)
+			if perr != nil || days < 1 {
+				return fmt.Errorf("restoreDays must be a number greater than 0, got %s", value)
+			}
+			a.restoreDays = int32(days)
+		default:
+			return fmt.Errorf("unknown option %s", name)
+		}
+	}
 
-		ro.Handlers.Send.PushBack(func(r *request.Request) {
-			<-buffer
-		})
+	if a.storageClass != "" && !slices.Contains(a.storageClass.Values(), a.storageClass) {
+		return fmt.Errorf("unsupported storage class %s", a.storageClass)
+	}
+	if a.manifestStorageClass == "" {
+		a.manifestStorageClass = a.storageClass
+		if a.storageClass == types.StorageClassGlacier || a.storageClass == types.StorageClassDeepArchive {
+			a.manifestStorageClass = types.StorageClassStandard
+		}
+	}
+	if a.manifestStorageClass != "" && !slices.Contains(a.manifestStorageClass.Values(), a.manifestStorageClass) {
+		return fmt.Errorf("unsupported manifest storage class %s", a.manifestStorageClass)
 	}
-}
 
-func withComputeMD5HashHandler(ro *request.Request) {
-	ro.Handlers.Build.PushBack(func(r *request.Request) {
-		reader := r.GetBody()
-		if reader == nil {
-			return
+	if a.sse != "" && !slices.Contains(a.sse.Values(), a.sse) {
+		return fmt.Errorf("unsupported server-side encryption %s", a.sse)
+	}
+	if a.sseKMSKeyID != nil && !strings.HasPrefix(string(a.sse), string(types.ServerSideEncryptionAwsKms)) {
+		return errors.New("sseKmsKeyId can only be used with aws:kms server-side encryption")
+	}
+	if a.sseCustomerKey != nil {
+		key, kerr := base64.StdEncoding.DecodeString(*a.sseCustomerKey)
+		if kerr != nil || len(key) != 32 {
+			return errors.New("the SSE-C key must be a base64 encoded 256-bit key")
 		}
+		if a.sse != "" {
+			return errors.New("SSE-C cannot be used with another server-side encryption")
+		}
+		keyMD5 := md5.Sum(key) //nolint:gosec // MD5 is what S3 uses to check the SSE-C key
+		a.sseCustomerKeyMD5 = aws.String(base64.StdEncoding.EncodeToString(keyMD5[:]))
+	}
 
-		//nolint:gosec // MD5 not used cryptographically here
-		md5Raw := md5.New()
-		_, err := io.Copy(md5Raw, reader)
-		if err != nil {
-			r.Error = err
+	if len(tags) > 0 {
+		a.tagging = aws.String(tags.Encode())
+	}
+
+	if a.lockMode != "" && !slices.Contains(a.lockMode.Values(), a.lockMode) {
+		return fmt.Errorf("unsupported Object Lock mode %s", a.lockMode)
+	}
+	hasRetention := a.lockDays > 0 || !a.lockUntil.IsZero()
+	switch {
+	case a.lockDays > 0 && !a.lockUntil.IsZero():
+		return errors.New("only one of lockDays and lockUntil can be given")
+	case a.lockMode != "" && !hasRetention && a.conf.LockUntil.IsZero():
+		return errors.New("an Object Lock mode needs lockDays or lockUntil to retain objects until")
+	case a.lockMode == "" && hasRetention:
+		return errors.New("lockDays and lockUntil need an Object Lock mode")
+	case a.lockMode == "" && !a.conf.LockUntil.IsZero():
+		a.lockMode = types.ObjectLockModeGovernance
+		if a.conf.LockCompliance {
+			a.lockMode = types.ObjectLockModeCompliance
+		}
+	}
+	if a.restoreTierOverride != "" && !slices.Contains(a.restoreTierOverride.Values(), a.restoreTierOverride) {
+		return fmt.Errorf("unsupported restore tier %s", a.restoreTierOverride)
+	}
+	return nil
+}
+
+// retainUntil will return the time an object uploaded now must be retained until, if using Object Lock.
+func (a *AWSS3Backend) retainUntil() *time.Time {
+	switch {
+	case a.lockDays > 0:
+		return aws.Time(time.Now().Add(time.Duration(a.lockDays) * 24 * time.Hour))
+	case !a.lockUntil.IsZero():
+		return aws.Time(a.lockUntil)
+	case !a.conf.LockUntil.IsZero():
+		return aws.Time(a.conf.LockUntil)
+	}
+	return nil
+}
+
+// sseCustomerAlgorithm will return the algorithm to pass with the SSE-C key, if one is used.
+func (a *AWSS3Backend) sseCustomerAlgorithm() *string {
+	if a.sseCustomerKey == nil {
+		return nil
+	}
+	return aws.String(string(types.ServerSideEncryptionAes256))
+}
+
+// withRequestLimiter will hold a slot in the buffer for every request sent, including each retry.
+func withRequestLimiter(buffer chan bool) func(*s3.Options) {
+	return func(o *s3.Options) {
+		if buffer == nil {
 			return
 		}
-		_, r.Error = reader.Seek(0, io.SeekStart)
-		b64md5 := base64.StdEncoding.EncodeToString(md5Raw.Sum(nil))
-		r.HTTPRequest.Header.Set("Content-MD5", b64md5)
-	})
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc(
+				"RequestLimiter",
+				func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (
+					middleware.FinalizeOutput, middleware.Metadata, error,
+				) {
+					buffer <- true
+					defer func() { <-buffer }()
+					return next.HandleFinalize(ctx, in)
+				},
+			), middleware.After)
+		})
+	}
 }
 
 type reader struct {
@@ -204,7 +403,7 @@ func (r *reader) Read(p []byte) (int, error) {
 
 // Upload will upload the provided volume to this AWSS3Backend's configured bucket+prefix
 // It utilizes multipart uploads to upload a single file in chunks concurrently. Impartial
-// uploads are cleaned up by the s3manager provided by the AWS Go SDK, but users can also
+// uploads are cleaned up by the upload manager provided by the AWS Go SDK, but users can also
 // implement lifecycle rules, see:
 // https://docs.aws.amazon.com/AmazonS3/latest/dev/mpuoverview.html#mpu-abort-incomplete-mpu-lifecycle-config
 func (a *AWSS3Backend) Upload(ctx context.Context, vol *files.VolumeInfo) error {
@@ -214,35 +413,41 @@ func (a *AWSS3Backend) Upload(ctx context.Context, vol *files.VolumeInfo) error
 	defer a.mutex.Unlock()
 
 	key := a.prefix + vol.ObjectName
-	var options []request.Option
-	options = append(options, withRequestLimiter(a.conf.MaxParallelUploadBuffer))
-	var r io.Reader
+	storageClass := a.storageClass
+	if vol.IsManifest {
+		storageClass = a.manifestStorageClass
+	}
+	input := &s3.PutObjectInput{
+		Bucket:                    aws.String(a.bucketName),
+		Key:                       aws.String(key),
+		StorageClass:              storageClass,
+		ServerSideEncryption:      a.sse,
+		SSEKMSKeyId:               a.sseKMSKeyID,
+		SSECustomerAlgorithm:      a.sseCustomerAlgorithm(),
+		SSECustomerKey:            a.sseCustomerKey,
+		SSECustomerKeyMD5:         a.sseCustomerKeyMD5,
+		Tagging:                   a.tagging,
+		ObjectLockMode:            a.lockMode,
+		ObjectLockRetainUntilDate: a.retainUntil(),
+		ChecksumAlgorithm:         types.ChecksumAlgorithmSha256,
+	}
 
 	if !vol.IsUsingPipe() {
-		r = vol
-		if vol.Size < uint64(s3manager.MinUploadPartSize) {
-			// It will not chunk the upload so we already know the md5 of the content
-			md5Raw, merr := hex.DecodeString(vol.MD5Sum)
-			if merr != nil {
-				return merr
+		input.Body = vol
+		if vol.Size < uint64(manager.MinUploadPartSize) {
+			// It will not chunk the upload so we already know the checksum of the content
+			sha256Raw, err := hex.DecodeString(vol.SHA256Sum)
+			if err != nil {
+				return err
 			}
-			b64md5 := base64.StdEncoding.EncodeToString(md5Raw)
-			options = append(options, withContentMD5Header(b64md5))
-		} else {
-			options = append(options, withComputeMD5HashHandler)
+			input.ChecksumSHA256 = aws.String(base64.StdEncoding.EncodeToString(sha256Raw))
 		}
 	} else {
-		r = &reader{vol} // Remove the Seek interface since we are using a Pipe
+		input.Body = &reader{vol} // Remove the Seek interface since we are using a Pipe
 	}
 
-	// Do a MultiPart Upload - force the s3manager to compute each chunks md5 hash
-	_, err := a.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
-		Bucket:       aws.String(a.bucketName),
-		Key:          aws.String(key),
-		Body:         r,
-		StorageClass: getS3EnvironmentOverride("AWS_S3_STORAGE_CLASS"),
-	}, s3manager.WithUploaderRequestOptions(options...))
-
+	// Do a MultiPart Upload - the upload manager computes the checksum of each part
+	_, err := a.uploader.Upload(ctx, input, manager.WithUploaderRequestOptions(withRequestLimiter(a.conf.MaxParallelUploadBuffer)))
 	if err != nil {
 		zap.S().Debugf("s3 backend: Error while uploading volume %s - %v", vol.ObjectName, err)
 	}
@@ -251,7 +456,7 @@ func (a *AWSS3Backend) Upload(ctx context.Context, vol *files.VolumeInfo) error
 
 // Delete will delete the given object from the configured bucket
 func (a *AWSS3Backend) Delete(ctx context.Context, key string) error {
-	_, err := a.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+	_, err := a.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(a.bucketName),
 		Key:    aws.String(a.prefix + key),
 	})
@@ -259,86 +464,14 @@ func (a *AWSS3Backend) Delete(ctx context.Context, key string) error {
 	return err
 }
 
-// PreDownload will restore objects from Glacier as required.
-func (a *AWSS3Backend) PreDownload(ctx context.Context, keys []string) error {
-	// First Let's check if any objects are on the GLACIER storage class
-	toRestore := make([]string, 0, len(keys))
-	restoreTier := os.Getenv("AWS_S3_GLACIER_RESTORE_TIER")
-	if restoreTier == "" {
-		restoreTier = s3.TierBulk
-	}
-	var bytesToRestore int64
-	zap.S().Debugf("s3 backend: will use the %s restore tier when trying to restore from Glacier.", restoreTier)
-	for _, key := range keys {
-		key = a.prefix + key
-		resp, err := a.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
-			Bucket: aws.String(a.bucketName),
-			Key:    aws.String(key),
-		})
-		if err != nil {
-			return err
-		}
-		if resp.StorageClass != nil && *resp.StorageClass == s3.ObjectStorageClassGlacier {
-			zap.S().Debugf("s3 backend: key %s will be restored from the Glacier storage class.", key)
-			bytesToRestore += *resp.ContentLength
-			// Let's Start a restore
-			toRestore = append(toRestore, key)
-			_, rerr := a.client.RestoreObjectWithContext(ctx, &s3.RestoreObjectInput{
-				Bucket: aws.String(a.bucketName),
-				Key:    aws.String(key),
-				RestoreRequest: &s3.RestoreRequest{
-					Days: aws.Int64(3),
-					GlacierJobParameters: &s3.GlacierJobParameters{
-						Tier: aws.String(restoreTier),
-					},
-				},
-			})
-			if rerr != nil {
-				var aerr awserr.Error
-				if errors.As(rerr, &aerr) && aerr.Code() != "RestoreAlreadyInProgress" {
-					zap.S().Debugf("s3 backend: error trying to restore key %s - %s: %s", key, aerr.Code(), aerr.Message())
-					return rerr
-				}
-			}
-		}
-	}
-	if len(toRestore) > 0 {
-		zap.S().Infof(
-			"s3 backend: waiting for %d objects to restore from Glacier totaling %d bytes (this could take several hours)",
-			len(toRestore), bytesToRestore,
-		)
-		// Now wait for the objects to be restored
-		backoffCount := 1
-		for idx := 0; idx < len(toRestore); idx++ {
-			key := toRestore[idx]
-			resp, err := a.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
-				Bucket: aws.String(a.bucketName),
-				Key:    aws.String(key),
-			})
-			if err != nil {
-				return err
-			}
-			if *resp.Restore == "ongoing-request=\"true\"" {
-				SleepFunc(time.Duration(backoffCount) * time.Minute)
-				idx--
-				backoffCount++
-				if backoffCount > 10 {
-					backoffCount = 10
-				}
-			} else {
-				backoffCount = 1
-				zap.S().Debugf("s3 backend: key %s restored.", key)
-			}
-		}
-	}
-	return nil
-}
-
 // Download will download the requseted object which can be read from the returned io.ReadCloser
 func (a *AWSS3Backend) Download(ctx context.Context, key string) (io.ReadCloser, error) {
-	resp, err := a.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(a.bucketName),
-		Key:    aws.String(a.prefix + key),
+	resp, err := a.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:               aws.String(a.bucketName),
+		Key:                  aws.String(a.prefix + key),
+		SSECustomerAlgorithm: a.sseCustomerAlgorithm(),
+		SSECustomerKey:       a.sseCustomerKey,
+		SSECustomerKeyMD5:    a.sseCustomerKeyMD5,
 	})
 	if err != nil {
 		return nil, err
@@ -356,9 +489,9 @@ func (a *AWSS3Backend) Close() error {
 // List will iterate through all objects in the configured AWS S3 bucket and return
 // a list of keys, filtering by the provided prefix.
 func (a *AWSS3Backend) List(ctx context.Context, prefix string) ([]string, error) {
-	resp, err := a.client.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+	resp, err := a.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
 		Bucket:  aws.String(a.bucketName),
-		MaxKeys: aws.Int64(1000),
+		MaxKeys: aws.Int32(1000),
 		Prefix:  aws.String(a.prefix + prefix),
 	})
 	if err != nil {
@@ -368,17 +501,17 @@ func (a *AWSS3Backend) List(ctx context.Context, prefix string) ([]string, error
 	l := make([]string, 0, 1000)
 	for {
 		for _, obj := range resp.Contents {
-			l = append(l, strings.TrimPrefix(*obj.Key, a.prefix))
+			l = append(l, strings.TrimPrefix(aws.ToString(obj.Key), a.prefix))
 		}
 
-		if !*resp.IsTruncated {
+		if !aws.ToBool(resp.IsTruncated) {
 			break
 		}
 
-		resp, err = a.client.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+		resp, err = a.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
 			Bucket:            aws.String(a.bucketName),
-			MaxKeys:           aws.Int64(1000),
-			Prefix:            aws.String(prefix),
+			MaxKeys:           aws.Int32(1000),
+			Prefix:            aws.String(a.prefix + prefix),
 			ContinuationToken: resp.NextContinuationToken,
 		})
 		if err != nil {
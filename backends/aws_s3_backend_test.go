@@ -24,33 +24,44 @@ package backends
 // Expectation is that environment variables will be set properly to run tests with
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/request"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3iface"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 
 	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/s3fake"
 )
 
 type mockS3Client struct {
-	s3iface.S3API
+	S3API
 
 	headcallcount int
 }
 
 type mockS3Uploader struct {
-	s3manageriface.UploaderAPI
+	S3Uploader
 }
 
 var (
@@ -63,11 +74,7 @@ const (
 	alreadyRestoring = "alreadyrestoring"
 )
 
-func (m *mockS3Client) DeleteObjectWithContext(
-	ctx aws.Context,
-	in *s3.DeleteObjectInput,
-	_ ...request.Option,
-) (*s3.DeleteObjectOutput, error) {
+func (m *mockS3Client) DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
 	if *in.Key == s3BadKey {
 		return nil, errTest
 	}
@@ -75,7 +82,7 @@ func (m *mockS3Client) DeleteObjectWithContext(
 	return nil, nil
 }
 
-func (m *mockS3Client) GetObjectWithContext(ctx aws.Context, in *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+func (m *mockS3Client) GetObject(ctx context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
 	if *in.Key == s3BadKey {
 		return nil, errTest
 	}
@@ -83,10 +90,10 @@ func (m *mockS3Client) GetObjectWithContext(ctx aws.Context, in *s3.GetObjectInp
 	return &s3.GetObjectOutput{}, nil
 }
 
-func (m *mockS3Client) ListObjectsV2WithContext(
-	ctx aws.Context,
+func (m *mockS3Client) ListObjectsV2(
+	ctx context.Context,
 	in *s3.ListObjectsV2Input,
-	_ ...request.Option,
+	_ ...func(*s3.Options),
 ) (*s3.ListObjectsV2Output, error) {
 	if *in.Bucket == s3BadBucket || (in.Prefix != nil && *in.Prefix == s3BadKey) {
 		return nil, errTest
@@ -96,7 +103,7 @@ func (m *mockS3Client) ListObjectsV2WithContext(
 	responses[""] = &s3.ListObjectsV2Output{
 		IsTruncated:           aws.Bool(true),
 		NextContinuationToken: aws.String("call2"),
-		Contents: []*s3.Object{
+		Contents: []types.Object{
 			{
 				Key: aws.String("random"),
 			},
@@ -111,7 +118,7 @@ func (m *mockS3Client) ListObjectsV2WithContext(
 
 	responses["call2"] = &s3.ListObjectsV2Output{
 		IsTruncated: aws.Bool(false),
-		Contents: []*s3.Object{
+		Contents: []types.Object{
 			{
 				Key: aws.String("random"),
 			},
@@ -128,7 +135,7 @@ func (m *mockS3Client) ListObjectsV2WithContext(
 	return nil, errTest
 }
 
-func (m *mockS3Client) HeadObjectWithContext(ctx aws.Context, in *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+func (m *mockS3Client) HeadObject(ctx context.Context, in *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
 	switch *in.Key {
 	case s3BadKey:
 		return nil, errTest
@@ -136,46 +143,46 @@ func (m *mockS3Client) HeadObjectWithContext(ctx aws.Context, in *s3.HeadObjectI
 		m.headcallcount++
 		restoreString := "ongoing-request=\"true\""
 		if m.headcallcount >= 3 {
-			restoreString = ""
+			restoreString = "ongoing-request=\"false\", expiry-date=\"Wed, 07 Nov 2012 00:00:00 GMT\""
 		}
 		return &s3.HeadObjectOutput{
-			StorageClass:  aws.String(s3.ObjectStorageClassGlacier),
+			StorageClass:  types.StorageClassGlacier,
 			ContentLength: aws.Int64(50),
 			Restore:       aws.String(restoreString),
 		}, nil
 	case "needsrestore":
 		return &s3.HeadObjectOutput{
-			StorageClass:  aws.String(s3.ObjectStorageClassGlacier),
+			StorageClass:  types.StorageClassGlacier,
 			ContentLength: aws.Int64(50),
 			Restore:       aws.String("ongoing-request=\"false\", expiry-date=\"Wed, 07 Nov 2012 00:00:00 GMT\""),
 		}, nil
 	default:
 		return &s3.HeadObjectOutput{
-			StorageClass:  aws.String(s3.ObjectStorageClassStandard),
+			StorageClass:  types.StorageClassStandard,
 			ContentLength: aws.Int64(50),
 		}, nil
 	}
 }
 
-func (m *mockS3Client) RestoreObjectWithContext(
-	ctx aws.Context,
+func (m *mockS3Client) RestoreObject(
+	ctx context.Context,
 	in *s3.RestoreObjectInput,
-	_ ...request.Option,
+	_ ...func(*s3.Options),
 ) (*s3.RestoreObjectOutput, error) {
 	switch *in.Key {
 	case s3BadKey:
 		return nil, errTest
 	case alreadyRestoring:
-		return nil, awserr.New("RestoreAlreadyInProgress", "", errTest)
+		return nil, &smithy.GenericAPIError{Code: "RestoreAlreadyInProgress"}
 	}
 	return nil, nil
 }
 
-func (m *mockS3Uploader) UploadWithContext(
-	ctx aws.Context,
-	in *s3manager.UploadInput,
-	_ ...func(*s3manager.Uploader),
-) (*s3manager.UploadOutput, error) {
+func (m *mockS3Uploader) Upload(
+	ctx context.Context,
+	in *s3.PutObjectInput,
+	_ ...func(*manager.Uploader),
+) (*manager.UploadOutput, error) {
 	if *in.Key == s3BadKey {
 		return nil, errTest
 	}
@@ -351,8 +358,8 @@ func TestS3Upload(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error preparing volume for testing - %v", err)
 	}
-	md5mismatchvol.MD5Sum = "thisisn'thexdecodeable"
-	md5mismatchvol.Size = uint64(s3manager.MinUploadPartSize - 1)
+	md5mismatchvol.SHA256Sum = "thisisn'thexdecodeable"
+	md5mismatchvol.Size = uint64(manager.MinUploadPartSize - 1)
 
 	testCases := []struct {
 		conf    *BackendConfig
@@ -472,7 +479,9 @@ func TestS3PreDownload(t *testing.T) {
 	for idx, c := range testCases {
 		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
 			b := &AWSS3Backend{}
-			SleepFunc = func(d time.Duration) {}
+			wait := WaitFunc
+			WaitFunc = func(context.Context, time.Duration) error { return nil }
+			defer func() { WaitFunc = wait }()
 			if err := b.Init(t.Context(), c.conf, getOptions()...); err != nil {
 				t.Errorf("%d: Did not get expected nil error on Init, got %v instead", idx, err)
 			}
@@ -498,28 +507,24 @@ func TestS3Backend(t *testing.T) {
 	ctx, cancel := context.WithCancel(t.Context())
 	defer cancel()
 
-	awsconf := aws.NewConfig().
-		WithS3ForcePathStyle(true).
-		WithEndpoint(os.Getenv("AWS_S3_CUSTOM_ENDPOINT"))
-
-	sess, err := session.NewSession(awsconf)
+	awsconf, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
-		t.Fatalf("could not create AWS client due to error: %v", err)
+		t.Fatalf("could not load AWS config due to error: %v", err)
 	}
-	client := s3.New(sess)
-	_, err = client.CreateBucket(&s3.CreateBucketInput{
+	client := s3.NewFromConfig(awsconf, func(o *s3.Options) {
+		o.UsePathStyle = true
+		o.BaseEndpoint = aws.String(os.Getenv("AWS_S3_CUSTOM_ENDPOINT"))
+	})
+	_, err = client.CreateBucket(ctx, &s3.CreateBucketInput{
 		Bucket: aws.String(s3TestBucketName),
 	})
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			if aerr.Code() != "BucketAlreadyOwnedByYou" {
-				t.Fatalf("could not create S3 bucket due to error: %v", err)
-			}
-		}
+	var owned *types.BucketAlreadyOwnedByYou
+	if err != nil && !errors.As(err, &owned) {
+		t.Fatalf("could not create S3 bucket due to error: %v", err)
 	}
 
 	defer func() {
-		if _, err := client.DeleteBucket(&s3.DeleteBucketInput{
+		if _, err := client.DeleteBucket(context.Background(), &s3.DeleteBucketInput{
 			Bucket: aws.String(s3TestBucketName),
 		}); err != nil {
 			t.Errorf("could not delete bucket - %v", err)
@@ -528,3 +533,586 @@ func TestS3Backend(t *testing.T) {
 
 	BackendTest(ctx, AWSS3BackendPrefix, s3TestBucketName, false, b)(t)
 }
+
+// newFakeS3 will start an s3fake server with the bucket and point the s3 backend's environment at it.
+func newFakeS3(t *testing.T, bucket string) *s3fake.Server {
+	t.Helper()
+	server := s3fake.NewServer(bucket)
+	ts := httptest.NewTLSServer(server)
+	t.Cleanup(ts.Close)
+
+	// Served over TLS since the SDK refuses to send SSE-C keys otherwise
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caBundle, cert, 0o600); err != nil {
+		t.Fatalf("could not write CA bundle - %v", err)
+	}
+	t.Setenv("AWS_CA_BUNDLE", caBundle)
+	t.Setenv("AWS_S3_CUSTOM_ENDPOINT", ts.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "s3fake")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "s3fake")
+	t.Setenv("AWS_S3_STORAGE_CLASS", "")
+	t.Setenv("AWS_S3_SSE_CUSTOMER_KEY", "")
+	return server
+}
+
+func newFakeS3Backend(t *testing.T, uri string) *AWSS3Backend {
+	t.Helper()
+	b := &AWSS3Backend{}
+	conf := &BackendConfig{
+		TargetURI:               AWSS3BackendPrefix + "://" + uri,
+		UploadChunkSize:         5 * 1024 * 1024,
+		MaxParallelUploads:      5,
+		MaxParallelUploadBuffer: make(chan bool, 5),
+	}
+	if err := b.Init(t.Context(), conf); err != nil {
+		t.Fatalf("Issue initializing backend: %v", err)
+	}
+	return b
+}
+
+func TestS3BackendFake(t *testing.T) {
+	server := newFakeS3(t, s3TestBucketName)
+
+	BackendTest(t.Context(), AWSS3BackendPrefix, s3TestBucketName, false, &AWSS3Backend{})(t)
+
+	if server.Calls(s3fake.CompleteMultipartUpload) != 1 {
+		t.Errorf("Expected the volume to be uploaded in parts, but it was not.")
+	}
+	if server.Uploads() != 0 {
+		t.Errorf("Expected no multipart uploads to be left behind, got %d", server.Uploads())
+	}
+}
+
+func TestS3FakeGlacierRestore(t *testing.T) {
+	server := newFakeS3(t, s3TestBucketName)
+	t.Setenv("AWS_S3_STORAGE_CLASS", string(types.StorageClassGlacier))
+	now := time.Now()
+	server.Now = func() time.Time { return now }
+	server.RestoreDelay = 5 * time.Hour
+	sleeps := 0
+	wait := WaitFunc
+	WaitFunc = func(_ context.Context, d time.Duration) error {
+		sleeps++
+		now = now.Add(d)
+		return nil
+	}
+	defer func() { WaitFunc = wait }()
+
+	b := newFakeS3Backend(t, s3TestBucketName)
+	payload, goodVol, _, err := prepareTestVols()
+	if err != nil {
+		t.Fatalf("error preparing volume for testing - %v", err)
+	}
+	defer goodVol.DeleteVolume() //nolint:errcheck // Best effort cleanup
+	if err = goodVol.OpenVolume(); err != nil {
+		t.Fatalf("could not open volume - %v", err)
+	}
+	if err = b.Upload(t.Context(), goodVol); err != nil {
+		t.Fatalf("Issue uploading volume: %v", err)
+	}
+	goodVol.Close()
+
+	if _, class, _ := server.Get(s3TestBucketName, goodVol.ObjectName); class != string(types.StorageClassGlacier) {
+		t.Fatalf("Expected the volume to be stored in %s, got %s", types.StorageClassGlacier, class)
+	}
+	if _, err = b.Download(t.Context(), goodVol.ObjectName); err == nil {
+		t.Fatalf("Expected an error downloading an object that has not been restored, got nil instead")
+	}
+
+	if err = b.PreDownload(t.Context(), []string{goodVol.ObjectName}); err != nil {
+		t.Fatalf("Issue calling PreDownload: %v", err)
+	}
+	if sleeps == 0 || server.Calls(s3fake.RestoreObject) != 1 {
+		t.Errorf("Expected to wait on a single restore, slept %d times for %d restores", sleeps, server.Calls(s3fake.RestoreObject))
+	}
+
+	r, err := b.Download(t.Context(), goodVol.ObjectName)
+	if err != nil {
+		t.Fatalf("Issue downloading the restored object: %v", err)
+	}
+	defer r.Close()
+	downloaded, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(payload, downloaded) {
+		t.Errorf("downloaded object does not equal expected payload")
+	}
+}
+
+func TestS3FakeArchiveRestore(t *testing.T) {
+	testCases := []struct {
+		storageClass  types.StorageClass
+		archiveStatus types.ArchiveStatus
+		uri           string
+	}{
+		{storageClass: types.StorageClassDeepArchive},
+		// Expedited is not available for DEEP_ARCHIVE, so the default tier is used instead
+		{storageClass: types.StorageClassDeepArchive, uri: "?restoreTier=Expedited&restoreDays=1"},
+		{storageClass: types.StorageClassIntelligentTiering, archiveStatus: types.ArchiveStatusArchiveAccess},
+		{storageClass: types.StorageClassIntelligentTiering, archiveStatus: types.ArchiveStatusDeepArchiveAccess, uri: "?restoreTier=Bulk"},
+	}
+
+	for idx, c := range testCases {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			server := newFakeS3(t, s3TestBucketName)
+			now := time.Now()
+			server.Now = func() time.Time { return now }
+			server.RestoreDelay = 2 * time.Hour
+			wait := WaitFunc
+			WaitFunc = func(_ context.Context, d time.Duration) error {
+				now = now.Add(d)
+				return nil
+			}
+			defer func() { WaitFunc = wait }()
+
+			keys := []string{"vol1", "vol2", "vol3"}
+			for _, key := range keys {
+				server.Put(s3TestBucketName, key, string(c.storageClass), []byte(key))
+				if c.archiveStatus != "" {
+					server.Archive(s3TestBucketName, key, string(c.archiveStatus))
+				}
+			}
+			server.Put(s3TestBucketName, "manifest", "", []byte("manifest"))
+
+			b := newFakeS3Backend(t, s3TestBucketName+c.uri)
+			if _, err := b.Download(t.Context(), keys[0]); err == nil {
+				t.Fatalf("Expected an error downloading an object that has not been restored, got nil instead")
+			}
+			if err := b.PreDownload(t.Context(), append(keys, "manifest")); err != nil {
+				t.Fatalf("Issue calling PreDownload: %v", err)
+			}
+			if server.Calls(s3fake.RestoreObject) != len(keys) {
+				t.Errorf("Expected %d restores, got %d", len(keys), server.Calls(s3fake.RestoreObject))
+			}
+			for _, key := range keys {
+				r, err := b.Download(t.Context(), key)
+				if err != nil {
+					t.Fatalf("Issue downloading the restored object %s: %v", key, err)
+				}
+				r.Close()
+			}
+		})
+	}
+}
+
+func TestS3FakeRestoreState(t *testing.T) {
+	server := newFakeS3(t, s3TestBucketName)
+	now := time.Now()
+	server.Now = func() time.Time { return now }
+	server.RestoreDelay = 5 * time.Hour
+	server.Put(s3TestBucketName, "prefix/vol1", string(types.StorageClassDeepArchive), []byte("data"))
+	stateDir := t.TempDir()
+	statePath := filepath.Join(stateDir, s3RestoresFile)
+
+	// The first run is stopped while waiting on the restore, without waiting out the backoff
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	wait := WaitFunc
+	WaitFunc = func(ctx context.Context, d time.Duration) error {
+		time.AfterFunc(10*time.Millisecond, cancel)
+		return waitContext(ctx, d)
+	}
+	defer func() { WaitFunc = wait }()
+	b := newFakeS3Backend(t, s3TestBucketName+"/prefix/")
+	b.conf.StateDir = stateDir
+	start := time.Now()
+	if err := b.PreDownload(ctx, []string{"vol1"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the canceled context to stop PreDownload, got %v instead", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("Expected the canceled context to stop waiting on the restore, took %v", elapsed)
+	}
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("Expected the restore to be saved, got %v", err)
+	}
+	restores := make(map[string]*s3Restore)
+	if err = json.Unmarshal(data, &restores); err != nil {
+		t.Fatalf("could not read the restores saved - %v", err)
+	}
+	restore, ok := restores["prefix/vol1"]
+	if !ok || restore.Archive != string(types.StorageClassDeepArchive) || restore.Tier != types.TierBulk {
+		t.Fatalf("Expected a Bulk restore from DEEP_ARCHIVE to be saved, got %+v", restores)
+	}
+
+	// A restarted run waits on the same restore rather than requesting another
+	WaitFunc = func(_ context.Context, d time.Duration) error {
+		now = now.Add(d)
+		return nil
+	}
+	b = newFakeS3Backend(t, s3TestBucketName+"/prefix/")
+	b.conf.StateDir = stateDir
+	if err = b.PreDownload(t.Context(), []string{"vol1"}); err != nil {
+		t.Fatalf("Issue calling PreDownload: %v", err)
+	}
+	if server.Calls(s3fake.RestoreObject) != 1 {
+		t.Errorf("Expected a single restore request, got %d", server.Calls(s3fake.RestoreObject))
+	}
+	if data, err = os.ReadFile(statePath); err != nil || string(data) != "{}" {
+		t.Errorf("Expected the completed restore to be removed from the saved state, got %s (%v)", data, err)
+	}
+}
+
+func TestS3FakeListPagination(t *testing.T) {
+	server := newFakeS3(t, s3TestBucketName)
+	server.MaxKeys = 2
+	for i := 0; i < 5; i++ {
+		server.Put(s3TestBucketName, fmt.Sprintf("prefix/vol%d", i), "", []byte("data"))
+	}
+	server.Put(s3TestBucketName, "vol5", "", []byte("data"))
+	server.Put(s3TestBucketName, "prefix/other", "", []byte("data"))
+
+	b := newFakeS3Backend(t, s3TestBucketName+"/prefix/")
+	names, err := b.List(t.Context(), "vol")
+	if err != nil {
+		t.Fatalf("Issue listing backend: %v", err)
+	}
+	expected := []string{"vol0", "vol1", "vol2", "vol3", "vol4"}
+	if !reflect.DeepEqual(expected, names) {
+		t.Errorf("Expected %v from list, got %v instead", expected, names)
+	}
+	if calls := server.Calls(s3fake.ListObjectsV2); calls != 4 {
+		t.Errorf("Expected the list to take 3 pages after Init, took %d calls", calls)
+	}
+}
+
+func TestS3FakeFaults(t *testing.T) {
+	server := newFakeS3(t, s3TestBucketName)
+	b := newFakeS3Backend(t, s3TestBucketName)
+	_, goodVol, _, err := prepareTestVols()
+	if err != nil {
+		t.Fatalf("error preparing volume for testing - %v", err)
+	}
+	defer goodVol.DeleteVolume() //nolint:errcheck // Best effort cleanup
+
+	// A throttled part is retried by the SDK
+	server.InjectFault(s3fake.Fault{Operation: s3fake.UploadPart, Status: http.StatusServiceUnavailable, Code: "SlowDown", Times: 1})
+	if err = goodVol.OpenVolume(); err != nil {
+		t.Fatalf("could not open volume - %v", err)
+	}
+	if err = b.Upload(t.Context(), goodVol); err != nil {
+		t.Errorf("Expected the throttled upload to be retried, got %v instead", err)
+	}
+	goodVol.Close()
+
+	// Access errors are not
+	server.InjectFault(s3fake.Fault{Operation: s3fake.GetObject, Status: http.StatusForbidden, Code: "AccessDenied"})
+	var aerr smithy.APIError
+	if _, err = b.Download(t.Context(), goodVol.ObjectName); !errors.As(err, &aerr) || aerr.ErrorCode() != "AccessDenied" {
+		t.Errorf("Expected an AccessDenied error, got %v instead", err)
+	}
+
+	server.InjectFault(s3fake.Fault{Operation: s3fake.ListObjectsV2, Status: http.StatusForbidden, Code: "AccessDenied"})
+	if err = (&AWSS3Backend{}).Init(t.Context(), &BackendConfig{TargetURI: AWSS3BackendPrefix + "://" + s3TestBucketName}); err == nil {
+		t.Errorf("Expected an error initializing the backend, got nil instead")
+	}
+}
+
+func TestS3Options(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	testCases := []struct {
+		query   string
+		errTest errTestFunc
+	}{
+		{"storageClass=DEEP_ARCHIVE&manifestStorageClass=STANDARD_IA", nilErrTest},
+		{"storageClass=GLACIER_IR&sse=AES256&tag=env=prod&tag=team=ops", nilErrTest},
+		{"sse=aws:kms&sseKmsKeyId=alias/backups", nilErrTest},
+		{"sseCustomerKey=" + url.QueryEscape(key), nilErrTest},
+		{"lockMode=COMPLIANCE&lockDays=30", nilErrTest},
+		{"lockMode=GOVERNANCE&lockUntil=2030-01-01T00:00:00Z", nilErrTest},
+		{"profile=backups&region=eu-west-1&endpoint=" + url.QueryEscape("https://s3.example.com"), nilErrTest},
+		{"endpoint=s3.example.com", errInvalidURIErrTest},
+		{"storageClass=COLD", errInvalidURIErrTest},
+		{"sse=rot13", errInvalidURIErrTest},
+		{"sse=AES256&sseKmsKeyId=alias/backups", errInvalidURIErrTest},
+		{"sseCustomerKey=short", errInvalidURIErrTest},
+		{"sse=AES256&sseCustomerKey=" + url.QueryEscape(key), errInvalidURIErrTest},
+		{"tag=novalue", errInvalidURIErrTest},
+		{"lockMode=FOREVER&lockDays=1", errInvalidURIErrTest},
+		{"lockMode=COMPLIANCE", errInvalidURIErrTest},
+		{"lockDays=1", errInvalidURIErrTest},
+		{"lockMode=COMPLIANCE&lockDays=1&lockUntil=2030-01-01T00:00:00Z", errInvalidURIErrTest},
+		{"unknown=1", errInvalidURIErrTest},
+	}
+
+	for idx, c := range testCases {
+		b := &AWSS3Backend{}
+		conf := &BackendConfig{TargetURI: AWSS3BackendPrefix + "://goodbucket/prefix?" + c.query}
+		if err := b.Init(t.Context(), conf, getOptions()...); !c.errTest(err) {
+			t.Errorf("%d: Did not get expected error, got %v instead", idx, err)
+		} else if err == nil && b.prefix != "prefix" {
+			t.Errorf("%d: Expected prefix to be prefix, got %s", idx, b.prefix)
+		}
+	}
+}
+
+func TestS3FakeObjectOptions(t *testing.T) {
+	server := newFakeS3(t, s3TestBucketName)
+	b := newFakeS3Backend(
+		t,
+		s3TestBucketName+"?storageClass=DEEP_ARCHIVE&sse=aws:kms&sseKmsKeyId=backups&tag=env=prod&tag=team=ops&lockMode=GOVERNANCE&lockDays=1",
+	)
+	_, goodVol, _, err := prepareTestVols()
+	if err != nil {
+		t.Fatalf("error preparing volume for testing - %v", err)
+	}
+	defer goodVol.DeleteVolume() //nolint:errcheck // Best effort cleanup
+
+	// A manifest streamed through a pipe still sends the checksum Object Lock requires
+	manifest, err := files.CreateSimpleVolume(t.Context(), true, files.VolumeOptions{})
+	if err != nil {
+		t.Fatalf("error preparing manifest for testing - %v", err)
+	}
+	manifest.ObjectName = "manifest"
+	manifest.IsManifest = true
+	go func() {
+		_, _ = manifest.Write([]byte("manifest"))
+		_ = manifest.Close()
+	}()
+	if err = b.Upload(t.Context(), manifest); err != nil {
+		t.Fatalf("Issue uploading manifest: %v", err)
+	}
+
+	if err = goodVol.OpenVolume(); err != nil {
+		t.Fatalf("could not open volume - %v", err)
+	}
+	if err = b.Upload(t.Context(), goodVol); err != nil {
+		t.Fatalf("Issue uploading volume: %v", err)
+	}
+	goodVol.Close()
+
+	for key, class := range map[string]types.StorageClass{goodVol.ObjectName: types.StorageClassDeepArchive, "manifest": types.StorageClassStandard} {
+		if _, got, _ := server.Get(s3TestBucketName, key); got != string(class) {
+			t.Errorf("Expected %s to be stored in %s, got %s", key, class, got)
+		}
+		metadata := server.Metadata(s3TestBucketName, key)
+		expected := map[string]string{
+			"X-Amz-Server-Side-Encryption":                "aws:kms",
+			"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "backups",
+			"X-Amz-Tagging":                               "env=prod&team=ops",
+			"X-Amz-Object-Lock-Mode":                      "GOVERNANCE",
+		}
+		for header, value := range expected {
+			if metadata.Get(header) != value {
+				t.Errorf("Expected %s to have %s of %s, got %s", key, header, value, metadata.Get(header))
+			}
+		}
+		until, perr := time.Parse(time.RFC3339, metadata.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+		if perr != nil || until.Before(time.Now().Add(23*time.Hour)) {
+			t.Errorf("Expected %s to be retained for a day, got %s", key, metadata.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+		}
+	}
+
+	var aerr smithy.APIError
+	if err = b.Delete(t.Context(), goodVol.ObjectName); !errors.As(err, &aerr) || aerr.ErrorCode() != "AccessDenied" {
+		t.Errorf("Expected the locked object to be protected from deletion, got %v instead", err)
+	}
+}
+
+func TestS3FakeConfigLock(t *testing.T) {
+	server := newFakeS3(t, s3TestBucketName)
+	lockUntil := time.Now().Add(48 * time.Hour).Truncate(time.Second).UTC()
+	testCases := []struct {
+		query      string
+		compliance bool
+		mode       types.ObjectLockMode
+	}{
+		{compliance: false, mode: types.ObjectLockModeGovernance},
+		{compliance: true, mode: types.ObjectLockModeCompliance},
+		// The mode given in the URI wins
+		{query: "?lockMode=GOVERNANCE", compliance: true, mode: types.ObjectLockModeGovernance},
+	}
+
+	for idx, c := range testCases {
+		b := &AWSS3Backend{}
+		conf := &BackendConfig{
+			TargetURI:               AWSS3BackendPrefix + "://" + s3TestBucketName + c.query,
+			UploadChunkSize:         5 * 1024 * 1024,
+			MaxParallelUploads:      5,
+			MaxParallelUploadBuffer: make(chan bool, 5),
+			LockUntil:               lockUntil,
+			LockCompliance:          c.compliance,
+		}
+		if err := b.Init(t.Context(), conf); err != nil {
+			t.Fatalf("%d: Issue initializing backend: %v", idx, err)
+		}
+		_, goodVol, _, err := prepareTestVols()
+		if err != nil {
+			t.Fatalf("error preparing volume for testing - %v", err)
+		}
+		defer goodVol.DeleteVolume() //nolint:errcheck // Best effort cleanup
+		goodVol.ObjectName = fmt.Sprintf("locked%d", idx)
+		if err = goodVol.OpenVolume(); err != nil {
+			t.Fatalf("could not open volume - %v", err)
+		}
+		if err = b.Upload(t.Context(), goodVol); err != nil {
+			t.Fatalf("%d: Issue uploading volume: %v", idx, err)
+		}
+		goodVol.Close()
+
+		metadata := server.Metadata(s3TestBucketName, goodVol.ObjectName)
+		if metadata.Get("X-Amz-Object-Lock-Mode") != string(c.mode) {
+			t.Errorf("%d: Expected the %s lock mode, got %s", idx, c.mode, metadata.Get("X-Amz-Object-Lock-Mode"))
+		}
+		if until, perr := time.Parse(time.RFC3339, metadata.Get("X-Amz-Object-Lock-Retain-Until-Date")); perr != nil || !until.Equal(lockUntil) {
+			t.Errorf("%d: Expected the object to be retained until %v, got %s", idx, lockUntil, metadata.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+		}
+	}
+}
+
+func TestS3FakeCustomerKey(t *testing.T) {
+	newFakeS3(t, s3TestBucketName)
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	b := newFakeS3Backend(t, s3TestBucketName+"?sseCustomerKey="+url.QueryEscape(key))
+	payload, goodVol, _, err := prepareTestVols()
+	if err != nil {
+		t.Fatalf("error preparing volume for testing - %v", err)
+	}
+	defer goodVol.DeleteVolume() //nolint:errcheck // Best effort cleanup
+
+	if err = goodVol.OpenVolume(); err != nil {
+		t.Fatalf("could not open volume - %v", err)
+	}
+	if err = b.Upload(t.Context(), goodVol); err != nil {
+		t.Fatalf("Issue uploading volume: %v", err)
+	}
+	goodVol.Close()
+
+	if err = b.PreDownload(t.Context(), []string{goodVol.ObjectName}); err != nil {
+		t.Errorf("Issue calling PreDownload: %v", err)
+	}
+	r, err := b.Download(t.Context(), goodVol.ObjectName)
+	if err != nil {
+		t.Fatalf("Issue downloading: %v", err)
+	}
+	defer r.Close()
+	if downloaded, rerr := io.ReadAll(r); rerr != nil || !bytes.Equal(payload, downloaded) {
+		t.Errorf("downloaded object does not equal expected payload - %v", rerr)
+	}
+
+	// The key is needed to read the object back
+	t.Setenv("AWS_S3_SSE_CUSTOMER_KEY", "")
+	if _, err = newFakeS3Backend(t, s3TestBucketName).Download(t.Context(), goodVol.ObjectName); err == nil {
+		t.Errorf("Expected an error downloading without the key, got nil instead")
+	}
+}
+
+func TestS3FakeChecksum(t *testing.T) {
+	server := newFakeS3(t, s3TestBucketName)
+	b := newFakeS3Backend(t, s3TestBucketName)
+	vol, err := files.CreateSimpleVolume(t.Context(), false, files.VolumeOptions{})
+	if err != nil {
+		t.Fatalf("error preparing volume for testing - %v", err)
+	}
+	defer vol.DeleteVolume() //nolint:errcheck // Best effort cleanup
+	if _, err = vol.Write([]byte("small volume")); err != nil {
+		t.Fatalf("could not write volume - %v", err)
+	}
+	if err = vol.Close(); err != nil {
+		t.Fatalf("could not close volume - %v", err)
+	}
+	vol.ObjectName = "small"
+
+	if err = vol.OpenVolume(); err != nil {
+		t.Fatalf("could not open volume - %v", err)
+	}
+	if err = b.Upload(t.Context(), vol); err != nil {
+		t.Fatalf("Issue uploading volume: %v", err)
+	}
+	vol.Close()
+
+	// Volumes uploaded in one request send the SHA256 computed when they were written
+	sum, _ := hex.DecodeString(vol.SHA256Sum)
+	if got := server.Metadata(s3TestBucketName, "small").Get("X-Amz-Checksum-Sha256"); got != base64.StdEncoding.EncodeToString(sum) {
+		t.Errorf("Expected the volume's SHA256 checksum to be sent, got %q", got)
+	}
+}
+
+func TestS3FakeEndpointOption(t *testing.T) {
+	envServer := newFakeS3(t, s3TestBucketName)
+	uriServer := s3fake.NewServer(s3TestBucketName)
+	uriTS := httptest.NewServer(uriServer)
+	defer uriTS.Close()
+
+	// Each destination can use its own endpoint, whatever AWS_S3_CUSTOM_ENDPOINT is set to
+	for _, conf := range []*BackendConfig{
+		{TargetURI: AWSS3BackendPrefix + "://" + s3TestBucketName + "?endpoint=" + url.QueryEscape(uriTS.URL)},
+	} {
+		conf.UploadChunkSize = 5 * 1024 * 1024
+		conf.MaxParallelUploads = 5
+		conf.MaxParallelUploadBuffer = make(chan bool, 5)
+		b := &AWSS3Backend{}
+		if err := b.Init(t.Context(), conf); err != nil {
+			t.Fatalf("Issue initializing backend: %v", err)
+		}
+		vol, err := files.CreateSimpleVolume(t.Context(), true, files.VolumeOptions{})
+		if err != nil {
+			t.Fatalf("error preparing volume for testing - %v", err)
+		}
+		vol.ObjectName = "object"
+		go func() {
+			_, _ = vol.Write([]byte("object"))
+			_ = vol.Close()
+		}()
+		if err = b.Upload(t.Context(), vol); err != nil {
+			t.Fatalf("Issue uploading volume: %v", err)
+		}
+	}
+
+	for server, expected := range map[*s3fake.Server][]string{envServer: {}, uriServer: {"object"}} {
+		if keys := server.Keys(s3TestBucketName); !reflect.DeepEqual(keys, expected) {
+			t.Errorf("Expected keys %v, got %v", expected, keys)
+		}
+	}
+}
+
+func TestS3FakeWriteOnly(t *testing.T) {
+	server := newFakeS3(t, s3TestBucketName)
+	server.InjectFault(s3fake.Fault{Operation: s3fake.ListObjectsV2, Status: http.StatusForbidden, Code: "AccessDenied"})
+	conf := &BackendConfig{
+		TargetURI:               AWSS3BackendPrefix + "://" + s3TestBucketName,
+		UploadChunkSize:         5 * 1024 * 1024,
+		MaxParallelUploads:      5,
+		MaxParallelUploadBuffer: make(chan bool, 5),
+	}
+	if err := (&AWSS3Backend{}).Init(t.Context(), conf); err == nil {
+		t.Fatalf("Expected an error initializing without being allowed to list, got nil instead")
+	}
+
+	b := NewWriteOnlyBackend(&AWSS3Backend{})
+	if err := b.Init(t.Context(), conf); err != nil {
+		t.Fatalf("Issue initializing a write-only backend: %v", err)
+	}
+	if server.Calls(s3fake.ListObjectsV2) != 1 {
+		t.Errorf("Expected the write-only backend not to list the bucket, got %d listings", server.Calls(s3fake.ListObjectsV2))
+	}
+
+	_, goodVol, _, err := prepareTestVols()
+	if err != nil {
+		t.Fatalf("error preparing volume for testing - %v", err)
+	}
+	defer goodVol.DeleteVolume() //nolint:errcheck // Best effort cleanup
+	if err = goodVol.OpenVolume(); err != nil {
+		t.Fatalf("could not open volume - %v", err)
+	}
+	if err = b.Upload(t.Context(), goodVol); err != nil {
+		t.Fatalf("Issue uploading through a write-only backend: %v", err)
+	}
+	goodVol.Close()
+
+	if _, err = b.List(t.Context(), ""); !errors.Is(err, ErrWriteOnly) {
+		t.Errorf("Expected ErrWriteOnly listing in write-only mode, got %v instead", err)
+	}
+	if _, err = b.Download(t.Context(), goodVol.ObjectName); !errors.Is(err, ErrWriteOnly) {
+		t.Errorf("Expected ErrWriteOnly downloading in write-only mode, got %v instead", err)
+	}
+	if err = b.Delete(t.Context(), goodVol.ObjectName); !errors.Is(err, ErrWriteOnly) {
+		t.Errorf("Expected ErrWriteOnly deleting in write-only mode, got %v instead", err)
+	}
+	if keys := server.Keys(s3TestBucketName); len(keys) != 1 || keys[0] != goodVol.ObjectName {
+		t.Errorf("Expected only %s in the bucket, got %v", goodVol.ObjectName, keys)
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// s3RestoresFile is the file in the backend's state directory that restores requested by PreDownload are
+// kept in, so a restarted restore waits on them instead of requesting them again.
+const s3RestoresFile = "s3-restores.json"
+
+// s3RestoreTiers are the tiers AWS documents for restoring from each archive, along with how long the
+// restores take, used to estimate when a restore will complete. Archives are given by storage class,
+// or by the archive access tier of INTELLIGENT_TIERING objects.
+var s3RestoreTiers = map[string]map[types.Tier]time.Duration{
+	string(types.StorageClassGlacier): {
+		types.TierExpedited: 5 * time.Minute,
+		types.TierStandard:  5 * time.Hour,
+		types.TierBulk:      12 * time.Hour,
+	},
+	string(types.StorageClassDeepArchive): {
+		types.TierStandard: 12 * time.Hour,
+		types.TierBulk:     48 * time.Hour,
+	},
+	string(types.ArchiveStatusArchiveAccess): {
+		types.TierStandard: 5 * time.Hour,
+		types.TierBulk:     12 * time.Hour,
+	},
+	string(types.ArchiveStatusDeepArchiveAccess): {
+		types.TierStandard: 12 * time.Hour,
+		types.TierBulk:     48 * time.Hour,
+	},
+}
+
+// s3DefaultRestoreTiers are the tiers used when none is configured. Bulk is the cheapest way to restore
+// from GLACIER and DEEP_ARCHIVE, while INTELLIGENT_TIERING charges nothing for either tier.
+var s3DefaultRestoreTiers = map[string]types.Tier{
+	string(types.StorageClassGlacier):            types.TierBulk,
+	string(types.StorageClassDeepArchive):        types.TierBulk,
+	string(types.ArchiveStatusArchiveAccess):     types.TierStandard,
+	string(types.ArchiveStatusDeepArchiveAccess): types.TierStandard,
+}
+
+// s3Restore is a restore requested for an object.
+type s3Restore struct {
+	ETag        string
+	Archive     string
+	Tier        types.Tier
+	Size        int64
+	RequestedAt time.Time
+}
+
+// expectedBy will return when the restore should complete.
+func (r *s3Restore) expectedBy() time.Time {
+	return r.RequestedAt.Add(s3RestoreTiers[r.Archive][r.Tier])
+}
+
+// s3Restores tracks the restores PreDownload is waiting on.
+type s3Restores struct {
+	mu       sync.Mutex
+	path     string
+	restores map[string]*s3Restore
+	pending  map[string]bool
+}
+
+// loadRestores will read the restores requested by earlier runs from the backend's state directory.
+func (a *AWSS3Backend) loadRestores() *s3Restores {
+	r := &s3Restores{restores: make(map[string]*s3Restore), pending: make(map[string]bool)}
+	if a.conf.StateDir == "" {
+		return r
+	}
+	r.path = filepath.Join(a.conf.StateDir, s3RestoresFile)
+	data, err := os.ReadFile(r.path)
+	if err == nil {
+		err = json.Unmarshal(data, &r.restores)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		zap.S().Warnf("s3 backend: could not read the restores requested previously from %s, ignoring - %v", r.path, err)
+	}
+	return r
+}
+
+// save will write the restores to the backend's state directory.
+func (r *s3Restores) save() error {
+	if r.path == "" {
+		return nil
+	}
+	r.mu.Lock()
+	data, err := json.Marshal(r.restores)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// s3ArchiveOf will return the archive the object must be restored from, or an empty string if it can be read.
+func s3ArchiveOf(resp *s3.HeadObjectOutput) string {
+	switch resp.StorageClass {
+	case types.StorageClassGlacier, types.StorageClassDeepArchive:
+		if strings.Contains(aws.ToString(resp.Restore), `ongoing-request="false"`) {
+			return ""
+		}
+		return string(resp.StorageClass)
+	case types.StorageClassIntelligentTiering:
+		return string(resp.ArchiveStatus)
+	default:
+		return ""
+	}
+}
+
+// restoreTier will return the tier to restore objects from the archive with.
+func (a *AWSS3Backend) restoreTier(archive string) types.Tier {
+	if a.restoreTierOverride != "" {
+		if _, ok := s3RestoreTiers[archive][a.restoreTierOverride]; ok {
+			return a.restoreTierOverride
+		}
+		zap.S().Debugf("s3 backend: the %s restore tier is not available for %s, using the default.", a.restoreTierOverride, archive)
+	}
+	if archive == string(types.StorageClassGlacier) {
+		if tier := os.Getenv("AWS_S3_GLACIER_RESTORE_TIER"); tier != "" {
+			return types.Tier(tier)
+		}
+	}
+	return s3DefaultRestoreTiers[archive]
+}
+
+// forEachKey will call fn for every key, running as many calls at once as the backend may upload in parallel.
+func (a *AWSS3Backend) forEachKey(ctx context.Context, keys []string, fn func(ctx context.Context, key string) error) error {
+	group, ctx := errgroup.WithContext(ctx)
+	group.SetLimit(max(a.conf.MaxParallelUploads, 1))
+	for _, key := range keys {
+		group.Go(func() error {
+			return fn(ctx, key)
+		})
+	}
+	return group.Wait()
+}
+
+// checkRestore will request a restore for the object if it is archived and nothing is restoring it yet, and
+// track it until it can be read.
+func (a *AWSS3Backend) checkRestore(ctx context.Context, restores *s3Restores, key string) error {
+	resp, err := a.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:               aws.String(a.bucketName),
+		Key:                  aws.String(key),
+		SSECustomerAlgorithm: a.sseCustomerAlgorithm(),
+		SSECustomerKey:       a.sseCustomerKey,
+		SSECustomerKeyMD5:    a.sseCustomerKeyMD5,
+	})
+	if err != nil {
+		return err
+	}
+
+	restores.mu.Lock()
+	defer restores.mu.Unlock()
+	archive := s3ArchiveOf(resp)
+	if archive == "" {
+		if restores.pending[key] {
+			zap.S().Debugf("s3 backend: key %s restored.", key)
+		}
+		delete(restores.restores, key)
+		delete(restores.pending, key)
+		return nil
+	}
+	restores.pending[key] = true
+
+	now := time.Now()
+	restore, ok := restores.restores[key]
+	if ok && restore.ETag != aws.ToString(resp.ETag) {
+		// The object was replaced since the restore was requested
+		ok = false
+	}
+	if strings.Contains(aws.ToString(resp.Restore), `ongoing-request="true"`) {
+		if !ok {
+			// Requested by someone else, or our record of it was lost
+			restores.restores[key] = &s3Restore{
+				ETag: aws.ToString(resp.ETag), Archive: archive, Tier: a.restoreTier(archive),
+				Size: aws.ToInt64(resp.ContentLength), RequestedAt: now,
+			}
+		}
+		return nil
+	}
+	if ok && now.Before(restore.expectedBy()) {
+		zap.S().Debugf("s3 backend: key %s was already requested to be restored at %v.", key, restore.RequestedAt)
+		return nil
+	}
+
+	restore = &s3Restore{
+		ETag: aws.ToString(resp.ETag), Archive: archive, Tier: a.restoreTier(archive),
+		Size: aws.ToInt64(resp.ContentLength), RequestedAt: now,
+	}
+	request := &types.RestoreRequest{GlacierJobParameters: &types.GlacierJobParameters{Tier: restore.Tier}}
+	if archive == string(types.StorageClassGlacier) || archive == string(types.StorageClassDeepArchive) {
+		// Restored INTELLIGENT_TIERING objects move back to the frequent access tier instead of expiring
+		request.Days = aws.Int32(a.restoreDays)
+	}
+	zap.S().Debugf("s3 backend: key %s will be restored from %s with the %s tier.", key, archive, restore.Tier)
+	_, err = a.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:         aws.String(a.bucketName),
+		Key:            aws.String(key),
+		RestoreRequest: request,
+	})
+	if err != nil {
+		var aerr smithy.APIError
+		if !errors.As(err, &aerr) || aerr.ErrorCode() != "RestoreAlreadyInProgress" {
+			zap.S().Debugf("s3 backend: error trying to restore key %s - %v", key, err)
+			return err
+		}
+	}
+	restores.restores[key] = restore
+	return nil
+}
+
+// logRestoreProgress will log how many of the objects have been restored and when the rest should be.
+func (r *s3Restores) logRestoreProgress(total int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var bytesToRestore int64
+	var expectedBy time.Time
+	for key := range r.pending {
+		restore := r.restores[key]
+		bytesToRestore += restore.Size
+		if restore.expectedBy().After(expectedBy) {
+			expectedBy = restore.expectedBy()
+		}
+	}
+	eta := "any moment now"
+	if remaining := time.Until(expectedBy); remaining > time.Minute {
+		eta = "in about " + remaining.Round(time.Minute).String()
+	}
+	zap.S().Infof(
+		"s3 backend: %d of %d objects ready, waiting on %d objects totaling %d bytes to restore which should be done %s",
+		total-len(r.pending), total, len(r.pending), bytesToRestore, eta,
+	)
+}
+
+// PreDownload will restore any of the objects that are archived, in GLACIER or DEEP_ARCHIVE or an archive
+// access tier of INTELLIGENT_TIERING, and wait for them to be restored. Restores are requested with the
+// restoreTier URI option, falling back to the tier documented on s3DefaultRestoreTiers.
+func (a *AWSS3Backend) PreDownload(ctx context.Context, keys []string) error {
+	restores := a.loadRestores()
+	toCheck := make([]string, len(keys))
+	for idx, key := range keys {
+		toCheck[idx] = a.prefix + key
+	}
+	check := func(ctx context.Context, key string) error {
+		return a.checkRestore(ctx, restores, key)
+	}
+
+	backoffCount := 1
+	for {
+		err := a.forEachKey(ctx, toCheck, check)
+		if serr := restores.save(); serr != nil {
+			zap.S().Warnf("s3 backend: could not save the restores requested to %s - %v", restores.path, serr)
+		}
+		if err != nil {
+			return err
+		}
+		if len(restores.pending) == 0 {
+			return nil
+		}
+		if len(restores.pending) < len(toCheck) {
+			backoffCount = 1
+		}
+
+		restores.logRestoreProgress(len(keys))
+		toCheck = toCheck[:0]
+		for key := range restores.pending {
+			toCheck = append(toCheck, key)
+		}
+		if err = WaitFunc(ctx, time.Duration(backoffCount)*time.Minute); err != nil {
+			return err
+		}
+		backoffCount = min(backoffCount+1, 10)
+	}
+}
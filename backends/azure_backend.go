@@ -27,15 +27,23 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
-	"os"
+	"slices"
 	"strings"
 	"sync"
-
-	"github.com/Azure/azure-storage-blob-go/azblob"
-	"github.com/pkg/errors"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 
@@ -50,7 +58,20 @@ const (
 
 var errContainerMismatch = errors.New("container name in SAS URI is different than destination container provided")
 
-// AzureBackend integrates with Microsoft's Azure Storage Services.
+// AzureBackend integrates with Microsoft's Azure Storage Services. The account can be given as query
+// parameters of the URI, e.g. azure://container/prefix?accountName=backups:
+//
+//   - accountName and accountKey: the storage account and its shared key, AZURE_ACCOUNT_NAME and
+//     AZURE_ACCOUNT_KEY if not set. Without a key, Azure AD credentials are used: a service principal
+//     or workload identity from the AZURE_* env variables, a managed identity, or the Azure CLI's login.
+//   - sasURI: a SAS URI for the container to use instead of the account's key, AZURE_SAS_URI if not set.
+//   - endpoint: the URL of the blob service, AZURE_CUSTOM_ENDPOINT if not set, or the account's otherwise.
+//   - accessTier: the access tier of volumes, Hot, Cool, Cold or Archive, the account's default if not set.
+//   - manifestAccessTier: the access tier of manifests, the same as volumes unless that is Archive, in
+//     which case the account's default so they can be synced without rehydrating them.
+//   - rehydrateTier: the tier archived blobs are moved to before they are downloaded, Hot, Cool or Cold,
+//     Hot if not set. Blobs stay in that tier once rehydrated.
+//   - rehydratePriority: the priority to rehydrate blobs with, Standard or High, Standard if not set.
 type AzureBackend struct {
 	conf          *BackendConfig
 	mutex         sync.Mutex
@@ -60,25 +81,68 @@ type AzureBackend struct {
 	azureURL      string
 	prefix        string
 	containerName string
-	containerSvc  azblob.ContainerURL
+	containerSvc  *container.Client
+
+	credential         azcore.TokenCredential
+	clientOptions      azcore.ClientOptions
+	accessTier         *blob.AccessTier
+	manifestAccessTier *blob.AccessTier
+	rehydrateTier      blob.AccessTier
+	rehydratePriority  blob.RehydratePriority
+}
+
+type withAzureCredential struct{ credential azcore.TokenCredential }
+
+func (w withAzureCredential) Apply(b Backend) {
+	if v, ok := b.(*AzureBackend); ok {
+		v.credential = w.credential
+	}
+}
+
+// WithAzureCredential will have an Azure backend authenticate with the Azure AD credential provided instead
+// of the default chain, when it is not given a SAS URI or an account key.
+func WithAzureCredential(c azcore.TokenCredential) Option {
+	return withAzureCredential{c}
+}
+
+type withAzureClientOptions struct{ options azcore.ClientOptions }
+
+func (w withAzureClientOptions) Apply(b Backend) {
+	if v, ok := b.(*AzureBackend); ok {
+		v.clientOptions = w.options
+	}
+}
+
+// WithAzureClientOptions will override the options, such as the retry policy or transport, an Azure backend's
+// underlying API client is created with.
+func WithAzureClientOptions(o azcore.ClientOptions) Option {
+	return withAzureClientOptions{o}
 }
 
 // Init will initialize the AzureBackend and verify the provided URI is valid/exists.
 func (a *AzureBackend) Init(ctx context.Context, conf *BackendConfig, opts ...Option) error {
 	a.conf = conf
 
-	cleanPrefix := strings.TrimPrefix(a.conf.TargetURI, AzureBackendPrefix+"://")
-	if cleanPrefix == a.conf.TargetURI {
-		return ErrInvalidURI
+	cleanPrefix, options, err := targetOptions(a.conf, AzureBackendPrefix,
+		"accountName", "accountKey", "sasURI", "endpoint", "accessTier", "manifestAccessTier", "rehydrateTier", "rehydratePriority",
+	)
+	if err != nil {
+		return err
 	}
 
-	a.accountName = os.Getenv("AZURE_ACCOUNT_NAME")
-	a.accountKey = os.Getenv("AZURE_ACCOUNT_KEY")
-	a.containersas = os.Getenv("AZURE_SAS_URI")
-	a.azureURL = os.Getenv("AZURE_CUSTOM_ENDPOINT")
+	a.accountName = optionOrEnv(options, "accountName", "AZURE_ACCOUNT_NAME")
+	a.accountKey = optionOrEnv(options, "accountKey", "AZURE_ACCOUNT_KEY")
+	a.containersas = optionOrEnv(options, "sasURI", "AZURE_SAS_URI")
+	a.azureURL = optionOrEnv(options, "endpoint", "AZURE_CUSTOM_ENDPOINT")
 	if a.azureURL == "" {
+		if a.accountName == "" && a.containersas == "" {
+			return fmt.Errorf("%w: azure backend: an account name, endpoint or SAS URI is required", ErrInvalidURI)
+		}
 		a.azureURL = fmt.Sprintf("https://%s.%s", a.accountName, blobAPIURL)
 	}
+	if err = a.parseTierOptions(options); err != nil {
+		return fmt.Errorf("%w: azure backend: %v", ErrInvalidURI, err)
+	}
 
 	uriParts := strings.Split(cleanPrefix, "/")
 
@@ -91,41 +155,93 @@ func (a *AzureBackend) Init(ctx context.Context, conf *BackendConfig, opts ...Op
 		opt.Apply(a)
 	}
 
-	// No need for this logging
-	pipelineOpts := azblob.PipelineOptions{
-		RequestLog: azblob.RequestLogOptions{
-			LogWarningIfTryOverThreshold: -1,
-		},
-	}
-	if a.containersas != "" {
-		parsedsas, err := url.Parse(a.containersas)
-		if err != nil {
-			return errors.Wrap(err, "failed to parse SAS URI")
+	clientOpts := &container.ClientOptions{ClientOptions: a.clientOptions}
+	switch {
+	case a.containersas != "":
+		sasParts, perr := azblob.ParseURL(a.containersas)
+		if perr != nil {
+			return fmt.Errorf("failed to parse SAS URI: %w", perr)
 		}
-		pipeline := azblob.NewPipeline(azblob.NewAnonymousCredential(), pipelineOpts)
-		sasParts := azblob.NewBlobURLParts(*parsedsas)
 		if sasParts.ContainerName != a.containerName {
 			return errContainerMismatch
 		}
-		a.containerSvc = azblob.NewContainerURL(*parsedsas, pipeline)
-	} else {
-		credential, err := azblob.NewSharedKeyCredential(a.accountName, a.accountKey)
-		if err != nil {
-			return errors.Wrap(err, "failed to initilze Azure credential")
+		a.containerSvc, err = container.NewClientWithNoCredential(a.containersas, clientOpts)
+	case a.accountKey != "":
+		credential, cerr := azblob.NewSharedKeyCredential(a.accountName, a.accountKey)
+		if cerr != nil {
+			return fmt.Errorf("failed to initilze Azure credential: %w", cerr)
 		}
-		destURL, err := url.Parse(a.azureURL)
-		if err != nil {
-			return errors.Wrap(err, "failed to construct Azure API URL")
+		a.containerSvc, err = container.NewClientWithSharedKeyCredential(a.containerURL(), credential, clientOpts)
+	default:
+		if a.credential == nil {
+			if a.credential, err = azidentity.NewDefaultAzureCredential(nil); err != nil {
+				return fmt.Errorf("failed to initialize Azure AD credential: %w", err)
+			}
 		}
-		pipeline := azblob.NewPipeline(credential, pipelineOpts)
-		svcURL := azblob.NewServiceURL(*destURL, pipeline)
-		a.containerSvc = svcURL.NewContainerURL(a.containerName)
+		a.containerSvc, err = container.NewClient(a.containerURL(), a.credential, clientOpts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to construct Azure client: %w", err)
 	}
 
-	_, err := a.containerSvc.ListBlobsFlatSegment(ctx, azblob.Marker{}, azblob.ListBlobsSegmentOptions{MaxResults: 0})
+	if conf.WriteOnly {
+		return nil
+	}
+
+	_, err = a.containerSvc.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{MaxResults: to.Ptr(int32(1))}).NextPage(ctx)
 	return err
 }
 
+// containerURL will return the URL of the container on the configured endpoint.
+func (a *AzureBackend) containerURL() string {
+	return strings.TrimSuffix(a.azureURL, "/") + "/" + url.PathEscape(a.containerName)
+}
+
+// parseTierOptions will set the access tiers to upload and rehydrate blobs with from the options given.
+func (a *AzureBackend) parseTierOptions(options url.Values) error {
+	parseTier := func(name string, allowed ...blob.AccessTier) (*blob.AccessTier, error) {
+		value := lastOption(options, name)
+		if value == "" {
+			return nil, nil
+		}
+		tier := blob.AccessTier(value)
+		if !slices.Contains(allowed, tier) {
+			return nil, fmt.Errorf("%s must be one of %v, got %s", name, allowed, value)
+		}
+		return &tier, nil
+	}
+
+	var err error
+	uploadTiers := []blob.AccessTier{blob.AccessTierHot, blob.AccessTierCool, blob.AccessTierCold, blob.AccessTierArchive}
+	if a.accessTier, err = parseTier("accessTier", uploadTiers...); err != nil {
+		return err
+	}
+	if a.manifestAccessTier, err = parseTier("manifestAccessTier", uploadTiers...); err != nil {
+		return err
+	}
+	if a.manifestAccessTier == nil && (a.accessTier == nil || *a.accessTier != blob.AccessTierArchive) {
+		a.manifestAccessTier = a.accessTier
+	}
+
+	rehydrateTier, err := parseTier("rehydrateTier", blob.AccessTierHot, blob.AccessTierCool, blob.AccessTierCold)
+	if err != nil {
+		return err
+	}
+	a.rehydrateTier = blob.AccessTierHot
+	if rehydrateTier != nil {
+		a.rehydrateTier = *rehydrateTier
+	}
+
+	a.rehydratePriority = blob.RehydratePriorityStandard
+	if value := lastOption(options, "rehydratePriority"); value != "" {
+		a.rehydratePriority = blob.RehydratePriority(value)
+		if !slices.Contains(blob.PossibleRehydratePriorityValues(), a.rehydratePriority) {
+			return fmt.Errorf("rehydratePriority must be one of %v, got %s", blob.PossibleRehydratePriorityValues(), value)
+		}
+	}
+	return nil
+}
+
 // Upload will upload the provided volume to this AzureBackend's configured container+prefix
 // It utilizes Azure Block Blob uploads to upload chunks of a single file concurrently. Partial
 // uploads are automatically garbage collected after one week. See:
@@ -137,12 +253,10 @@ func (a *AzureBackend) Upload(ctx context.Context, vol *files.VolumeInfo) error
 	defer a.mutex.Unlock()
 
 	name := a.prefix + vol.ObjectName
-	blobURL := a.containerSvc.NewBlockBlobURL(name)
+	blobClient := a.containerSvc.NewBlockBlobClient(name)
 
 	// We will PutBlock for chunks of UploadChunkSize and then finalize the block with a PutBlockList call
-	// Staging blocks does not allow MD5 checksums: https://github.com/Azure/azure-storage-blob-go/issues/56
 
-	// https://godoc.org/github.com/Azure/azure-storage-blob-go/2018-03-28/azblob#NewBlockBlobURL
 	// These helper functions convert a binary block ID to a base-64 string and vice versa
 	// NOTE: The blockID must be <= 64 bytes and ALL blockIDs for the block must be the same length
 	blockIDBinaryToBase64 := func(blockID []byte) string { return base64.StdEncoding.EncodeToString(blockID) }
@@ -189,7 +303,9 @@ func (a *AzureBackend) Upload(ctx context.Context, vol *files.VolumeInfo) error
 			case a.conf.MaxParallelUploadBuffer <- true:
 				errg.Go(func() error {
 					defer func() { <-a.conf.MaxParallelUploadBuffer }()
-					_, err := blobURL.StageBlock(ctx, blockID, bytes.NewReader(buf[:n]), azblob.LeaseAccessConditions{}, md5sum[:], azblob.ClientProvidedKeyOptions{})
+					_, err := blobClient.StageBlock(ctx, blockID, streaming.NopCloser(bytes.NewReader(buf[:n])), &blockblob.StageBlockOptions{
+						TransactionalValidation: blob.TransferValidationTypeMD5(md5sum[:]),
+					})
 					return err
 				})
 			}
@@ -212,9 +328,23 @@ func (a *AzureBackend) Upload(ctx context.Context, vol *files.VolumeInfo) error
 	}
 
 	// Finally, finalize the storage blob by giving Azure the block list order
-	_, err = blobURL.CommitBlockList(
-		ctx, blockIDs, azblob.BlobHTTPHeaders{ContentMD5: md5Raw}, azblob.Metadata{}, azblob.BlobAccessConditions{}, azblob.DefaultAccessTier, azblob.BlobTagsMap{}, azblob.ClientProvidedKeyOptions{}, azblob.ImmutabilityPolicyOptions{},
-	)
+	commitOpts := &blockblob.CommitBlockListOptions{
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentMD5: md5Raw},
+		Tier:        a.accessTier,
+	}
+	if vol.IsManifest {
+		commitOpts.Tier = a.manifestAccessTier
+	}
+	if !a.conf.LockUntil.IsZero() {
+		// Needs a container with version-level immutability support enabled
+		mode := blob.ImmutabilityPolicySettingUnlocked
+		if a.conf.LockCompliance {
+			mode = blob.ImmutabilityPolicySettingLocked
+		}
+		lockUntil := a.conf.LockUntil
+		commitOpts.ImmutabilityPolicyMode, commitOpts.ImmutabilityPolicyExpiryTime = &mode, &lockUntil
+	}
+	_, err = blobClient.CommitBlockList(ctx, blockIDs, commitOpts)
 	if err != nil {
 		zap.S().Debugf("azure backend: Error while finalizing volume %s - %v", vol.ObjectName, err)
 	}
@@ -223,24 +353,91 @@ func (a *AzureBackend) Upload(ctx context.Context, vol *files.VolumeInfo) error
 
 // Delete will delete the given object from the configured container
 func (a *AzureBackend) Delete(ctx context.Context, name string) error {
-	blobURL := a.containerSvc.NewBlobURL(a.prefix + name)
-	_, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+	_, err := a.containerSvc.NewBlobClient(a.prefix+name).Delete(ctx, nil)
 	return err
 }
 
-// PreDownload will do nothing for this backend.
+// checkRehydrate will start rehydrating the blob if it is in the Archive tier and is not being rehydrated
+// already, returning its size if it cannot be downloaded yet.
+func (a *AzureBackend) checkRehydrate(ctx context.Context, name string) (int64, bool, error) {
+	blobClient := a.containerSvc.NewBlobClient(name)
+	props, err := blobClient.GetProperties(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	if props.AccessTier == nil || blob.AccessTier(*props.AccessTier) != blob.AccessTierArchive {
+		return 0, false, nil
+	}
+
+	size := *props.ContentLength
+	if props.ArchiveStatus != nil && strings.HasPrefix(*props.ArchiveStatus, "rehydrate-pending-to-") {
+		return size, true, nil
+	}
+	zap.S().Debugf("azure backend: blob %s will be rehydrated to the %s tier with %s priority.", name, a.rehydrateTier, a.rehydratePriority)
+	_, err = blobClient.SetTier(ctx, a.rehydrateTier, &blob.SetTierOptions{RehydratePriority: &a.rehydratePriority})
+	if err != nil {
+		zap.S().Debugf("azure backend: error trying to rehydrate blob %s - %v", name, err)
+		return 0, false, err
+	}
+	return size, true, nil
+}
+
+// PreDownload will rehydrate any of the blobs that are in the Archive tier, and wait for them to be rehydrated.
 func (a *AzureBackend) PreDownload(ctx context.Context, keys []string) error {
-	return nil
+	toCheck := make([]string, len(keys))
+	for idx, key := range keys {
+		toCheck[idx] = a.prefix + key
+	}
+
+	backoffCount := 1
+	for {
+		var (
+			mu      sync.Mutex
+			pending []string
+			size    int64
+		)
+		group, gctx := errgroup.WithContext(ctx)
+		group.SetLimit(max(a.conf.MaxParallelUploads, 1))
+		for _, name := range toCheck {
+			group.Go(func() error {
+				blobSize, archived, err := a.checkRehydrate(gctx, name)
+				if archived {
+					mu.Lock()
+					pending, size = append(pending, name), size+blobSize
+					mu.Unlock()
+				}
+				return err
+			})
+		}
+		if err := group.Wait(); err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		if len(pending) < len(toCheck) {
+			backoffCount = 1
+		}
+
+		zap.S().Infof(
+			"azure backend: %d of %d blobs ready, waiting on %d blobs totaling %d bytes to rehydrate from the Archive tier",
+			len(keys)-len(pending), len(keys), len(pending), size,
+		)
+		toCheck = pending
+		if err := WaitFunc(ctx, time.Duration(backoffCount)*time.Minute); err != nil {
+			return err
+		}
+		backoffCount = min(backoffCount+1, 10)
+	}
 }
 
 // Download will download the requseted object which can be read from the returned io.ReadCloser
 func (a *AzureBackend) Download(ctx context.Context, name string) (io.ReadCloser, error) {
-	blobURL := a.containerSvc.NewBlobURL(a.prefix + name)
-	resp, err := blobURL.Download(ctx, 0, 0, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	resp, err := a.containerSvc.NewBlobClient(a.prefix+name).DownloadStream(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
-	return resp.Body(azblob.RetryReaderOptions{}), nil
+	return resp.NewRetryReader(ctx, &blob.RetryReaderOptions{}), nil
 }
 
 // Close will release any resources used by the Azure backend.
@@ -253,20 +450,19 @@ func (a *AzureBackend) Close() error {
 func (a *AzureBackend) List(ctx context.Context, prefix string) ([]string, error) {
 	l := make([]string, 0, 5000)
 
-	for marker := (azblob.Marker{}); marker.NotDone(); {
-		resp, err := a.containerSvc.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{
-			Prefix:     a.prefix + prefix,
-			MaxResults: 5000,
-		})
+	pager := a.containerSvc.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:     to.Ptr(a.prefix + prefix),
+		MaxResults: to.Ptr(int32(5000)),
+	})
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
 		if err != nil {
-			return nil, errors.Wrap(err, "error while listing blobs from container")
+			return nil, fmt.Errorf("error while listing blobs from container: %w", err)
 		}
 
-		for idx := range resp.Segment.BlobItems {
-			l = append(l, strings.TrimPrefix(resp.Segment.BlobItems[idx].Name, a.prefix))
+		for _, item := range resp.Segment.BlobItems {
+			l = append(l, strings.TrimPrefix(*item.Name, a.prefix))
 		}
-
-		marker = resp.NextMarker
 	}
 
 	return l, nil
@@ -22,13 +22,32 @@ package backends
 
 import (
 	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/storage"
-	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+
+	"github.com/someone1/zfsbackup-go/files"
+)
+
+// The well known account and key of the Azure storage emulator, Azurite.
+const (
+	azureEmulatorAccountName = "devstoreaccount1"
+	azureEmulatorAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
 )
 
 func TestAzureGetBackendForURI(t *testing.T) {
@@ -51,11 +70,11 @@ func TestAzureBackend(t *testing.T) {
 	if os.Getenv("AZURE_CUSTOM_ENDPOINT") == "" {
 		t.Skip("No custom Azure Endpoint provided to test against")
 	}
-	err := os.Setenv("AZURE_ACCOUNT_NAME", storage.StorageEmulatorAccountName)
+	err := os.Setenv("AZURE_ACCOUNT_NAME", azureEmulatorAccountName)
 	if err != nil {
 		t.Fatalf("could not set environmental variable due to error: %v", err)
 	}
-	err = os.Setenv("AZURE_ACCOUNT_KEY", storage.StorageEmulatorAccountKey)
+	err = os.Setenv("AZURE_ACCOUNT_KEY", azureEmulatorAccountKey)
 	if err != nil {
 		t.Fatalf("could not set environmental variable due to error: %v", err)
 	}
@@ -63,23 +82,21 @@ func TestAzureBackend(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	credential, err := azblob.NewSharedKeyCredential(storage.StorageEmulatorAccountName, storage.StorageEmulatorAccountKey)
+	credential, err := container.NewSharedKeyCredential(azureEmulatorAccountName, azureEmulatorAccountKey)
 	if err != nil {
 		t.Fatalf("failed to parse SAS key: %v", err)
 	}
-	destURL, err := url.Parse(os.Getenv("AZURE_CUSTOM_ENDPOINT"))
+	containerURL := strings.TrimSuffix(os.Getenv("AZURE_CUSTOM_ENDPOINT"), "/") + "/" + azureTestBucketName
+	containerSvc, err := container.NewClientWithSharedKeyCredential(containerURL, credential, nil)
 	if err != nil {
 		t.Fatalf("failed to construct Azure API URL: %v", err)
 	}
-	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{})
-	svcURL := azblob.NewServiceURL(*destURL, pipeline)
-	containerSvc := svcURL.NewContainerURL(azureTestBucketName)
-	if _, err = containerSvc.Create(ctx, azblob.Metadata{}, azblob.PublicAccessNone); err != nil {
+	if _, err = containerSvc.Create(ctx, nil); err != nil {
 		t.Fatalf("error while creating bucket: %v", err)
 	}
 
 	defer func() {
-		if _, err = containerSvc.Delete(ctx, azblob.ContainerAccessConditions{}); err != nil {
+		if _, err = containerSvc.Delete(ctx, nil); err != nil {
 			t.Logf("could not delete container: %v", err)
 		}
 	}()
@@ -104,7 +121,7 @@ func TestAzureBackend(t *testing.T) {
 			t.Fatalf("Error while trying to get backend: %v", err)
 		}
 
-		sasURI := generateSASURI(t, containerSvc.String(), azureTestBucketName, storage.StorageEmulatorAccountName, storage.StorageEmulatorAccountKey, 3600)
+		sasURI := generateSASURI(t, containerSvc.URL(), azureTestBucketName, azureEmulatorAccountName, azureEmulatorAccountKey, 3600)
 
 		if err = os.Setenv("AZURE_SAS_URI", sasURI); err != nil {
 			t.Fatalf("could not set environmental variable due to error: %v", err)
@@ -131,29 +148,313 @@ func TestAzureBackend(t *testing.T) {
 	})
 }
 
-func generateSASURI(t *testing.T, svcURL, container, accountName, key string, expireSecs int) string {
+func generateSASURI(t *testing.T, containerURL, containerName, accountName, key string, expireSecs int) string {
 	t.Helper()
 
-	perms := azblob.ContainerSASPermissions{Add: true, Create: true, Delete: true, List: true, Read: true, Write: true}
-	sigValues := azblob.BlobSASSignatureValues{
-		Version:       "2019-07-07",
-		Protocol:      azblob.SASProtocolHTTPSandHTTP,
+	perms := sas.ContainerPermissions{Add: true, Create: true, Delete: true, List: true, Read: true, Write: true}
+	sigValues := sas.BlobSignatureValues{
+		Protocol:      sas.ProtocolHTTPSandHTTP,
 		Permissions:   perms.String(),
 		ExpiryTime:    time.Now().Add(time.Second * time.Duration(expireSecs)).UTC(),
-		ContainerName: container,
+		ContainerName: containerName,
 	}
-	creds, err := azblob.NewSharedKeyCredential(accountName, key)
+	creds, err := container.NewSharedKeyCredential(accountName, key)
 	if err != nil {
 		t.Fatalf("could not generate creds: %v", err)
 	}
 
-	params, err := sigValues.NewSASQueryParameters(creds)
+	params, err := sigValues.SignWithSharedKey(creds)
 	if err != nil {
 		t.Fatalf("could not generate params: %v", err)
 	}
 
-	u, _ := url.Parse(svcURL)
+	u, _ := url.Parse(containerURL)
 	u.RawQuery = params.Encode()
 
 	return u.String()
 }
+
+// fakeAzureBlob is a blob kept by fakeAzure.
+type fakeAzureBlob struct {
+	data          []byte
+	tier          string
+	archiveStatus string
+}
+
+// fakeAzure serves the parts of the Blob service API the AzureBackend uses for a single container. Blobs
+// rehydrating from the Archive tier are done when rehydrate is called.
+type fakeAzure struct {
+	mu            sync.Mutex
+	blobs         map[string]*fakeAzureBlob
+	blocks        map[string][]byte
+	authorization []string
+	setTiers      []string
+}
+
+func newFakeAzure(t *testing.T) (*fakeAzure, string) {
+	t.Helper()
+	f := &fakeAzure{blobs: make(map[string]*fakeAzureBlob), blocks: make(map[string][]byte)}
+	ts := httptest.NewServer(f)
+	t.Cleanup(ts.Close)
+	return f, ts.URL + "/" + azureEmulatorAccountName
+}
+
+func (f *fakeAzure) rehydrate() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, b := range f.blobs {
+		if tier, ok := strings.CutPrefix(b.archiveStatus, "rehydrate-pending-to-"); ok {
+			b.tier, b.archiveStatus = strings.ToUpper(tier[:1])+tier[1:], ""
+		}
+	}
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.authorization = append(f.authorization, r.Header.Get("Authorization"))
+
+	// Paths are /account/container[/blob]
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	query := r.URL.Query()
+	if len(parts) < 3 {
+		if r.Method != http.MethodGet || query.Get("comp") != "list" {
+			http.Error(w, "unsupported container operation", http.StatusBadRequest)
+			return
+		}
+		var names []string
+		for name := range f.blobs {
+			if strings.HasPrefix(name, query.Get("prefix")) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+		for _, name := range names {
+			fmt.Fprintf(w, "<Blob><Name>%s</Name><Properties></Properties></Blob>", name)
+		}
+		fmt.Fprint(w, `</Blobs><NextMarker/></EnumerationResults>`)
+		return
+	}
+
+	name := parts[2]
+	b := f.blobs[name]
+	switch {
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		data, _ := io.ReadAll(r.Body)
+		f.blocks[name+"/"+query.Get("blockid")] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		var blockList struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&blockList); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b = &fakeAzureBlob{tier: r.Header.Get("X-Ms-Access-Tier")}
+		for _, id := range blockList.Latest {
+			b.data = append(b.data, f.blocks[name+"/"+id]...)
+		}
+		f.blobs[name] = b
+		w.WriteHeader(http.StatusCreated)
+	case b == nil:
+		w.Header().Set("X-Ms-Error-Code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodPut && query.Get("comp") == "tier":
+		tier := r.Header.Get("X-Ms-Access-Tier")
+		f.setTiers = append(f.setTiers, name+":"+tier+":"+r.Header.Get("X-Ms-Rehydrate-Priority"))
+		if b.tier == "Archive" {
+			b.archiveStatus = "rehydrate-pending-to-" + strings.ToLower(tier)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		b.tier = tier
+	case r.Method == http.MethodHead:
+		w.Header().Set("Content-Length", fmt.Sprint(len(b.data)))
+		w.Header().Set("X-Ms-Blob-Type", "BlockBlob")
+		if b.tier != "" {
+			w.Header().Set("X-Ms-Access-Tier", b.tier)
+		}
+		if b.archiveStatus != "" {
+			w.Header().Set("X-Ms-Archive-Status", b.archiveStatus)
+		}
+	case r.Method == http.MethodGet:
+		if b.tier == "Archive" {
+			w.Header().Set("X-Ms-Error-Code", "BlobArchived")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(b.data)))
+		_, _ = w.Write(b.data)
+	case r.Method == http.MethodDelete:
+		delete(f.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "unsupported blob operation", http.StatusBadRequest)
+	}
+}
+
+func newFakeAzureBackend(t *testing.T, query string, opts ...Option) *AzureBackend {
+	t.Helper()
+	b := &AzureBackend{}
+	conf := &BackendConfig{
+		TargetURI:               AzureBackendPrefix + "://" + azureTestBucketName + "?" + query,
+		UploadChunkSize:         5 * 1024 * 1024,
+		MaxParallelUploads:      5,
+		MaxParallelUploadBuffer: make(chan bool, 5),
+	}
+	if err := b.Init(t.Context(), conf, opts...); err != nil {
+		t.Fatalf("Issue initializing backend: %v", err)
+	}
+	return b
+}
+
+func uploadAzureTestVolume(t *testing.T, b *AzureBackend, name string, manifest bool) {
+	t.Helper()
+	vol, err := files.CreateSimpleVolume(t.Context(), false, files.VolumeOptions{})
+	if err != nil {
+		t.Fatalf("error preparing volume for testing - %v", err)
+	}
+	defer vol.DeleteVolume() //nolint:errcheck // Best effort cleanup
+	if _, err = vol.Write([]byte(name)); err != nil {
+		t.Fatalf("could not write volume - %v", err)
+	}
+	if err = vol.Close(); err != nil {
+		t.Fatalf("could not close volume - %v", err)
+	}
+	vol.ObjectName, vol.IsManifest = name, manifest
+	if err = vol.OpenVolume(); err != nil {
+		t.Fatalf("could not open volume - %v", err)
+	}
+	defer vol.Close()
+	if err = b.Upload(t.Context(), vol); err != nil {
+		t.Fatalf("Issue uploading volume: %v", err)
+	}
+}
+
+func TestAzureOptions(t *testing.T) {
+	t.Setenv("AZURE_ACCOUNT_NAME", "")
+	t.Setenv("AZURE_ACCOUNT_KEY", "")
+	t.Setenv("AZURE_SAS_URI", "")
+	t.Setenv("AZURE_CUSTOM_ENDPOINT", "")
+	testCases := []struct {
+		query   string
+		errTest errTestFunc
+	}{
+		{"accountName=backups&accountKey=" + url.QueryEscape(azureEmulatorAccountKey), nilErrTest},
+		{"accountName=backups&accessTier=Archive&rehydrateTier=Cool&rehydratePriority=High", nilErrTest},
+		{"endpoint=" + url.QueryEscape("https://blob.example.com/backups") + "&accessTier=Cold&manifestAccessTier=Hot", nilErrTest},
+		{"", errInvalidURIErrTest},
+		{"accountName=backups&accessTier=Frozen", errInvalidURIErrTest},
+		{"accountName=backups&manifestAccessTier=Premium", errInvalidURIErrTest},
+		{"accountName=backups&rehydrateTier=Archive", errInvalidURIErrTest},
+		{"accountName=backups&rehydratePriority=Urgent", errInvalidURIErrTest},
+		{"accountName=backups&unknown=1", errInvalidURIErrTest},
+	}
+
+	for idx, c := range testCases {
+		b := &AzureBackend{}
+		conf := &BackendConfig{TargetURI: AzureBackendPrefix + "://container/prefix?" + c.query, WriteOnly: true}
+		if err := b.Init(t.Context(), conf); !c.errTest(err) {
+			t.Errorf("%d: Did not get expected error, got %v instead", idx, err)
+		} else if err == nil && b.prefix != "prefix" {
+			t.Errorf("%d: Expected prefix to be prefix, got %s", idx, b.prefix)
+		}
+	}
+}
+
+func TestAzureFakeAccessTiers(t *testing.T) {
+	server, endpoint := newFakeAzure(t)
+	b := newFakeAzureBackend(
+		t, "accountName="+azureEmulatorAccountName+"&accountKey="+url.QueryEscape(azureEmulatorAccountKey)+
+			"&endpoint="+url.QueryEscape(endpoint)+"&accessTier=Archive&rehydratePriority=High",
+	)
+	uploadAzureTestVolume(t, b, "volume", false)
+	uploadAzureTestVolume(t, b, "manifest", true)
+
+	// Manifests stay out of the Archive tier so they can be synced
+	if tier := server.blobs["volume"].tier; tier != "Archive" {
+		t.Errorf("Expected the volume to be uploaded to the Archive tier, got %q", tier)
+	}
+	if tier := server.blobs["manifest"].tier; tier != "" {
+		t.Errorf("Expected the manifest to be uploaded to the account's default tier, got %q", tier)
+	}
+	if _, err := b.Download(t.Context(), "volume"); err == nil {
+		t.Errorf("Expected an error downloading an archived blob, got nil instead")
+	}
+
+	// A canceled context stops waiting on the rehydration without waiting out the backoff
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	wait := WaitFunc
+	defer func() { WaitFunc = wait }()
+	WaitFunc = func(ctx context.Context, d time.Duration) error {
+		time.AfterFunc(10*time.Millisecond, cancel)
+		return waitContext(ctx, d)
+	}
+	start := time.Now()
+	if err := b.PreDownload(ctx, []string{"volume", "manifest"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the canceled context to stop PreDownload, got %v instead", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("Expected the canceled context to stop waiting on the rehydration, took %v", elapsed)
+	}
+
+	var sleeps int
+	WaitFunc = func(context.Context, time.Duration) error {
+		sleeps++
+		server.rehydrate()
+		return nil
+	}
+	if err := b.PreDownload(t.Context(), []string{"volume", "manifest"}); err != nil {
+		t.Fatalf("Issue calling PreDownload: %v", err)
+	}
+	if sleeps != 1 {
+		t.Errorf("Expected to wait once for the volume to be rehydrated, waited %d times", sleeps)
+	}
+	if expected := []string{"volume:Hot:High"}; fmt.Sprint(server.setTiers) != fmt.Sprint(expected) {
+		t.Errorf("Expected the blobs to be rehydrated with %v, got %v", expected, server.setTiers)
+	}
+
+	r, err := b.Download(t.Context(), "volume")
+	if err != nil {
+		t.Fatalf("Issue downloading: %v", err)
+	}
+	defer r.Close()
+	if data, rerr := io.ReadAll(r); rerr != nil || string(data) != "volume" {
+		t.Errorf("downloaded blob does not equal expected payload %q - %v", data, rerr)
+	}
+}
+
+type fakeAzureCredential struct{}
+
+func (fakeAzureCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if len(options.Scopes) == 0 || !strings.HasPrefix(options.Scopes[0], "https://storage.azure.com/") {
+		return azcore.AccessToken{}, errors.New("unexpected token scopes")
+	}
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestAzureFakeCredential(t *testing.T) {
+	server, endpoint := newFakeAzure(t)
+	t.Setenv("AZURE_ACCOUNT_KEY", "")
+	t.Setenv("AZURE_SAS_URI", "")
+
+	// Without a key or SAS URI the Azure AD credential is used
+	b := newFakeAzureBackend(
+		t, "endpoint="+url.QueryEscape(endpoint),
+		WithAzureCredential(fakeAzureCredential{}),
+		WithAzureClientOptions(azcore.ClientOptions{InsecureAllowCredentialWithHTTP: true}),
+	)
+	uploadAzureTestVolume(t, b, "volume", false)
+	if keys, err := b.List(t.Context(), ""); err != nil || fmt.Sprint(keys) != "[volume]" {
+		t.Errorf("Expected to list the volume, got %v - %v", keys, err)
+	}
+
+	for _, authorization := range server.authorization {
+		if authorization != "Bearer token" {
+			t.Errorf("Expected requests to be authorized with the Azure AD token, got %q", authorization)
+		}
+	}
+}
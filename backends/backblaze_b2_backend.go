@@ -24,7 +24,6 @@ import (
 	"context"
 	"io"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
 
@@ -37,7 +36,8 @@ import (
 // B2BackendPrefix is the URI prefix used for the B2Backend.
 const B2BackendPrefix = "b2"
 
-// B2Backend integrates with BackBlaze's B2 storage service.
+// B2Backend integrates with BackBlaze's B2 storage service. The application key to use can be given as
+// the accountId and accountKey query parameters of the URI, B2_ACCOUNT_ID and B2_ACCOUNT_KEY if not set.
 type B2Backend struct {
 	conf       *BackendConfig
 	bucketCli  *b2.Bucket
@@ -60,13 +60,17 @@ func (b bufferedRT) RoundTrip(r *http.Request) (*http.Response, error) {
 func (b *B2Backend) Init(ctx context.Context, conf *BackendConfig, opts ...Option) error {
 	b.conf = conf
 
-	cleanPrefix := strings.TrimPrefix(b.conf.TargetURI, B2BackendPrefix+"://")
-	if cleanPrefix == b.conf.TargetURI {
-		return ErrInvalidURI
+	cleanPrefix, options, err := targetOptions(b.conf, B2BackendPrefix, "accountId", "accountKey")
+	if err != nil {
+		return err
+	}
+	if !conf.LockUntil.IsZero() {
+		// The client has no support for B2's file lock, so the objects would be uploaded unlocked
+		return ErrLockUnsupported
 	}
 
-	accountID := os.Getenv("B2_ACCOUNT_ID")
-	accountKey := os.Getenv("B2_ACCOUNT_KEY")
+	accountID := optionOrEnv(options, "accountId", "B2_ACCOUNT_ID")
+	accountKey := optionOrEnv(options, "accountKey", "B2_ACCOUNT_KEY")
 
 	uriParts := strings.Split(cleanPrefix, "/")
 
@@ -89,8 +93,9 @@ func (b *B2Backend) Init(ctx context.Context, conf *BackendConfig, opts ...Optio
 		return err
 	}
 
+	// Looking up the bucket lists the buckets, which even a key that may only write files needs to be allowed to do
 	b.bucketCli, err = client.Bucket(ctx, b.bucketName)
-	if err != nil {
+	if err != nil || conf.WriteOnly {
 		return err
 	}
 
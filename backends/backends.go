@@ -23,7 +23,12 @@ package backends
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -55,6 +60,17 @@ type BackendConfig struct {
 	MaxRetryTime            time.Duration
 	TargetURI               string
 	UploadChunkSize         int
+	// StateDir is a local directory the backend can keep state in between runs, if set.
+	StateDir string
+	// LockUntil is the time uploaded objects must be kept until, if set, using the backend's object lock.
+	// Backends that cannot lock objects fail to Init with ErrLockUnsupported.
+	LockUntil time.Time
+	// LockCompliance makes the lock one nobody can remove or shorten, rather than one that can be
+	// with the right permissions.
+	LockCompliance bool
+	// WriteOnly is set when the credentials may only upload objects, so Init must not list, read or
+	// delete anything to check the destination is reachable.
+	WriteOnly bool
 }
 
 var (
@@ -62,8 +78,31 @@ var (
 	ErrInvalidURI = errors.New("backends: invalid URI provided to backend")
 	// ErrInvalidPrefix is returned when a backend destination is provided with a URI prefix that isn't registered.
 	ErrInvalidPrefix = errors.New("backends: the provided prefix does not exist")
+	// ErrUnreachable is returned when a backend could not be initialized or could not list its objects.
+	ErrUnreachable = errors.New("backends: could not reach the backend")
+	// ErrLockUnsupported is returned when a backend is asked to lock the objects it uploads but cannot.
+	ErrLockUnsupported = errors.New("backends: the backend cannot lock objects")
+	// ErrAppendOnly is returned when deleting an object through a backend that only allows new objects to be added.
+	ErrAppendOnly = errors.New("backends: objects cannot be deleted in append-only mode")
+	// ErrWriteOnly is returned when listing, reading or deleting objects through a backend that may only upload them.
+	ErrWriteOnly = errors.New("backends: objects cannot be listed, read or deleted in write-only mode")
 )
 
+// WaitFunc is used by the backends to wait between polling for their objects to be ready. Tests can replace it.
+var WaitFunc = waitContext
+
+// waitContext will wait for the duration given, returning early with the context's error if it is done first.
+func waitContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // GetBackendForURI will try and parse the URI for a matching backend to use.
 func GetBackendForURI(uri string) (Backend, error) {
 	prefix := strings.Split(uri, "://")
@@ -88,7 +127,67 @@ func GetBackendForURI(uri string) (Backend, error) {
 		return &B2Backend{}, nil
 	case SSHBackendPrefix:
 		return &SSHBackend{}, nil
+	case ChaosBackendPrefix:
+		return &ChaosBackend{}, nil
 	default:
 		return nil, ErrInvalidPrefix
 	}
 }
+
+// secretOptions are the options of the backends that hold credentials, along with the signature of an
+// Azure SAS URI given without escaping it.
+var secretOptions = []string{"accountKey", "sasURI", "sseCustomerKey", "sig"}
+
+var (
+	uriPassword     = regexp.MustCompile(`(://[^/?@:]*):[^/?@]*@`)
+	uriSecretOption = regexp.MustCompile(`([?&](?:` + strings.Join(secretOptions, "|") + `))=[^&?]*`)
+)
+
+// RedactURI will return the destination URI with its password and the values of its options that hold
+// credentials replaced, so it can be exported as a metric label or sent in events, notifications and reports
+// without giving them away. The other options are kept, as they tell destinations apart, e.g. their endpoint.
+// It works on wrapped URIs too, e.g. chaos://azure://container?accountKey=.
+func RedactURI(uri string) string {
+	uri = uriPassword.ReplaceAllString(uri, "$1:xxxxx@")
+	return uriSecretOption.ReplaceAllString(uri, "$1=xxxxx")
+}
+
+// targetOptions will strip the prefix and query parameters off the conf's TargetURI, returning the rest and the
+// options of the destination given as the query, e.g. s3://bucket/prefix?profile=backups. When the names of
+// the options the backend knows are given, any others are rejected with ErrInvalidURI.
+func targetOptions(conf *BackendConfig, prefix string, known ...string) (string, url.Values, error) {
+	target := strings.TrimPrefix(conf.TargetURI, prefix+"://")
+	if target == conf.TargetURI {
+		return "", nil, ErrInvalidURI
+	}
+
+	target, rawQuery, _ := strings.Cut(target, "?")
+	options, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %s backend: %v", ErrInvalidURI, prefix, err)
+	}
+	if known != nil {
+		for name := range options {
+			if !slices.Contains(known, name) {
+				return "", nil, fmt.Errorf("%w: %s backend: unknown option %s", ErrInvalidURI, prefix, name)
+			}
+		}
+	}
+	return target, options, nil
+}
+
+// lastOption will return the last value given for the option, or an empty string if it was not given.
+func lastOption(options url.Values, name string) string {
+	if values := options[name]; len(values) > 0 {
+		return values[len(values)-1]
+	}
+	return ""
+}
+
+// optionOrEnv will return the last value given for the option, or the env variable's if it was not given.
+func optionOrEnv(options url.Values, name, envVar string) string {
+	if _, ok := options[name]; ok {
+		return lastOption(options, name)
+	}
+	return os.Getenv(envVar)
+}
@@ -0,0 +1,368 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/juju/ratelimit"
+	"go.uber.org/zap"
+
+	"github.com/someone1/zfsbackup-go/files"
+)
+
+// ChaosBackendPrefix is the URI prefix used for the ChaosBackend.
+const ChaosBackendPrefix = "chaos"
+
+// ErrChaos is returned for the failures a ChaosBackend injects.
+var ErrChaos = errors.New("backends: chaos backend injected a failure")
+
+// ChaosBackend wraps another backend and injects failures into its operations to test how they are
+// handled. The backend to wrap and the failures to inject are given in the URI, with the options
+// after the last "?", e.g. chaos://file:///mnt/backups?failUpload=0.2&corrupt=0.1&seed=1. Options:
+//
+//   - failUpload, failDownload, failList, failDelete, failPreDownload: the rate, from 0 to 1, the
+//     operation fails at without reaching the wrapped backend.
+//   - latency: how long to wait before every operation, e.g. 100ms.
+//   - truncate: the rate uploads are cut short at, while still reporting success.
+//   - corrupt: the rate downloads have a byte flipped at.
+//   - listDrop: the rate each key is left out of a listing at.
+//   - listStale: the rate each key deleted through a ChaosBackend wrapping the same backend is still listed at,
+//     so listings stay stale across operations as they can with eventually consistent object stores.
+//   - slowRead: the bytes per second downloads are read at, e.g. 1MiB.
+//   - skip: the prefix of the objects to leave alone, e.g. the manifest prefix.
+//   - seed: the seed for the random failures, random if not set. Failures depend only on the seed, the
+//     operation, the object and its attempt, so runs can be repeated however operations are ordered.
+type ChaosBackend struct {
+	backend Backend
+
+	failUpload      float64
+	failDownload    float64
+	failList        float64
+	failDelete      float64
+	failPreDownload float64
+	latency         time.Duration
+	truncate        float64
+	corrupt         float64
+	listDrop        float64
+	listStale       float64
+	slowRead        int64
+	skip            string
+
+	mutex    sync.Mutex
+	seed     int64
+	attempts map[string]uint64
+	deleted  map[string]bool
+}
+
+// chaosDeleted holds the keys deleted through every ChaosBackend by the URI of the backend they wrap.
+var chaosDeleted = struct {
+	sync.Mutex
+	targets map[string]map[string]bool
+}{targets: make(map[string]map[string]bool)}
+
+// splitChaosURI will return the URI of the backend the chaos URI wraps and the options after its last "?".
+func splitChaosURI(uri string) (target, options string, ok bool) {
+	target = strings.TrimPrefix(uri, ChaosBackendPrefix+"://")
+	if target == uri {
+		return "", "", false
+	}
+	if idx := strings.LastIndex(target, "?"); idx != -1 {
+		return target[:idx], target[idx+1:], true
+	}
+	return target, "", true
+}
+
+// ChaosTarget will return the URI of the backend the chaos URI wraps, without the failures to inject, or the
+// URI as given if it is not a chaos one.
+func ChaosTarget(uri string) string {
+	if target, _, ok := splitChaosURI(uri); ok {
+		return target
+	}
+	return uri
+}
+
+// Init will initialize the backend it wraps with the URI it was given after parsing the options to inject failures with.
+// nolint:gocyclo // A switch over the options
+func (c *ChaosBackend) Init(ctx context.Context, conf *BackendConfig, opts ...Option) error {
+	target, rawOptions, ok := splitChaosURI(conf.TargetURI)
+	if !ok {
+		return ErrInvalidURI
+	}
+
+	options, err := url.ParseQuery(rawOptions)
+	if err != nil {
+		return fmt.Errorf("%w: chaos backend: %v", ErrInvalidURI, err)
+	}
+
+	seed := time.Now().UnixNano()
+	for name, values := range options {
+		value := values[len(values)-1]
+		var err error
+		switch name {
+		case "failUpload":
+			c.failUpload, err = parseRate(value)
+		case "failDownload":
+			c.failDownload, err = parseRate(value)
+		case "failList":
+			c.failList, err = parseRate(value)
+		case "failDelete":
+			c.failDelete, err = parseRate(value)
+		case "failPreDownload":
+			c.failPreDownload, err = parseRate(value)
+		case "truncate":
+			c.truncate, err = parseRate(value)
+		case "corrupt":
+			c.corrupt, err = parseRate(value)
+		case "listDrop":
+			c.listDrop, err = parseRate(value)
+		case "listStale":
+			c.listStale, err = parseRate(value)
+		case "latency":
+			c.latency, err = time.ParseDuration(value)
+		case "slowRead":
+			var rate uint64
+			rate, err = humanize.ParseBytes(value)
+			c.slowRead = int64(rate)
+		case "skip":
+			c.skip = value
+		case "seed":
+			seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			err = errors.New("unknown option")
+		}
+		if err != nil {
+			return fmt.Errorf("%w: chaos backend: invalid value %q for %s - %v", ErrInvalidURI, value, name, err)
+		}
+	}
+	c.seed = seed
+	c.attempts = make(map[string]uint64)
+	chaosDeleted.Lock()
+	if chaosDeleted.targets[target] == nil {
+		chaosDeleted.targets[target] = make(map[string]bool)
+	}
+	c.deleted = chaosDeleted.targets[target]
+	chaosDeleted.Unlock()
+
+	backend, err := GetBackendForURI(target)
+	if err != nil {
+		return err
+	}
+	c.backend = backend
+
+	innerConf := *conf
+	innerConf.TargetURI = target
+	zap.S().Debugf("chaos backend: wrapping %s with seed %d", target, seed)
+	return c.backend.Init(ctx, &innerConf, opts...)
+}
+
+func parseRate(value string) (float64, error) {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err == nil && (rate < 0 || rate > 1) {
+		err = errors.New("must be between 0 and 1")
+	}
+	return rate, err
+}
+
+// random will return a number from 0 up to 1 for the attempt at the operation on the object. The numbers
+// depend only on the seed, the operation, the object and how many times it was attempted before, so a
+// seed gives the same failures however concurrent operations are ordered.
+func (c *ChaosBackend) random(operation, name string) float64 {
+	c.mutex.Lock()
+	id := operation + "\x00" + name
+	attempt := c.attempts[id]
+	c.attempts[id]++
+	c.mutex.Unlock()
+
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%d\x00%s\x00%d", c.seed, id, attempt)
+	// Mix the bits since FNV's high bits barely change when only the last bytes differ
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11) / (1 << 53)
+}
+
+// chance will return true at the rate given, unless the object is skipped.
+func (c *ChaosBackend) chance(rate float64, operation, name string) bool {
+	if rate <= 0 || (c.skip != "" && strings.HasPrefix(name, c.skip)) {
+		return false
+	}
+	return c.random(operation, name) < rate
+}
+
+// inject will wait out the latency and then fail the operation at the rate given.
+func (c *ChaosBackend) inject(ctx context.Context, operation, name string, rate float64) error {
+	if c.latency > 0 {
+		select {
+		case <-time.After(c.latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if c.chance(rate, operation, name) {
+		zap.S().Debugf("chaos backend: failing %s of %s", operation, name)
+		return fmt.Errorf("%w: %s of %s", ErrChaos, operation, name)
+	}
+	return nil
+}
+
+// Upload will upload the volume to the wrapped backend, unless it fails, cutting it short at the truncate rate.
+func (c *ChaosBackend) Upload(ctx context.Context, vol *files.VolumeInfo) error {
+	if err := c.inject(ctx, "upload", vol.ObjectName, c.failUpload); err != nil {
+		return err
+	}
+	if vol.Size > 0 && c.chance(c.truncate, "truncate", vol.ObjectName) {
+		limit := int64(c.random("truncate at", vol.ObjectName) * float64(vol.Size))
+		zap.S().Debugf("chaos backend: truncating upload of %s to %d bytes", vol.ObjectName, limit)
+		vol.WrapReader(func(r io.Reader) io.Reader { return io.LimitReader(r, limit) })
+	}
+	if err := c.backend.Upload(ctx, vol); err != nil {
+		return err
+	}
+	chaosDeleted.Lock()
+	delete(c.deleted, vol.ObjectName)
+	chaosDeleted.Unlock()
+	return nil
+}
+
+// Delete will delete the object from the wrapped backend, unless it fails.
+func (c *ChaosBackend) Delete(ctx context.Context, filename string) error {
+	if err := c.inject(ctx, "delete", filename, c.failDelete); err != nil {
+		return err
+	}
+	if err := c.backend.Delete(ctx, filename); err != nil {
+		return err
+	}
+	chaosDeleted.Lock()
+	c.deleted[filename] = true
+	chaosDeleted.Unlock()
+	return nil
+}
+
+// PreDownload will prepare the objects with the wrapped backend, unless it fails.
+func (c *ChaosBackend) PreDownload(ctx context.Context, objects []string) error {
+	if err := c.inject(ctx, "predownload", strings.Join(objects, ", "), c.failPreDownload); err != nil {
+		return err
+	}
+	return c.backend.PreDownload(ctx, objects)
+}
+
+// Download will download the object from the wrapped backend, unless it fails, reading it slowly
+// if asked to and flipping one of its bytes at the corrupt rate.
+func (c *ChaosBackend) Download(ctx context.Context, filename string) (io.ReadCloser, error) {
+	if err := c.inject(ctx, "download", filename, c.failDownload); err != nil {
+		return nil, err
+	}
+	rc, err := c.backend.Download(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = rc
+	if c.chance(c.corrupt, "corrupt", filename) {
+		zap.S().Debugf("chaos backend: corrupting download of %s", filename)
+		r = &corruptReader{r: r, at: int64(c.random("corrupt at", filename) * 1024)}
+	}
+	if c.slowRead > 0 {
+		r = ratelimit.Reader(r, ratelimit.NewBucketWithRate(float64(c.slowRead), c.slowRead))
+	}
+	return &chaosReadCloser{Reader: r, Closer: rc}, nil
+}
+
+type chaosReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// corruptReader flips the byte at the offset given, failing as if the connection dropped if the stream is shorter.
+type corruptReader struct {
+	r    io.Reader
+	at   int64
+	read int64
+	done bool
+}
+
+func (c *corruptReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if !c.done && c.at < c.read+int64(n) {
+		p[c.at-c.read] ^= 0xff
+		c.done = true
+	}
+	c.read += int64(n)
+	if errors.Is(err, io.EOF) && !c.done {
+		c.done = true
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Close will close the wrapped backend.
+func (c *ChaosBackend) Close() error {
+	if c.backend == nil {
+		return nil
+	}
+	return c.backend.Close()
+}
+
+// List will list the objects in the wrapped backend, unless it fails, leaving keys out at the listDrop
+// rate and listing deleted keys at the listStale rate.
+func (c *ChaosBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	if err := c.inject(ctx, "list", prefix, c.failList); err != nil {
+		return nil, err
+	}
+	keys, err := c.backend.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !c.chance(c.listDrop, "list drop", key) {
+			listed = append(listed, key)
+		}
+	}
+	chaosDeleted.Lock()
+	var deleted []string
+	for key := range c.deleted {
+		if strings.HasPrefix(key, prefix) {
+			deleted = append(deleted, key)
+		}
+	}
+	chaosDeleted.Unlock()
+	for _, key := range deleted {
+		if c.chance(c.listStale, "list stale", key) {
+			listed = append(listed, key)
+		}
+	}
+	return listed, nil
+}
@@ -0,0 +1,214 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backends
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChaosGetBackendForURI(t *testing.T) {
+	b, err := GetBackendForURI(ChaosBackendPrefix + "://file:///tmp")
+	if err != nil {
+		t.Errorf("Error while trying to get backend: %v", err)
+	}
+	if _, ok := b.(*ChaosBackend); !ok {
+		t.Errorf("Expected to get a backend of type ChaosBackend, but did not.")
+	}
+}
+
+func TestChaosInit(t *testing.T) {
+	tempPath := t.TempDir()
+	testCases := []struct {
+		uri     string
+		errTest errTestFunc
+	}{
+		{
+			uri:     "chaos://file://" + tempPath,
+			errTest: nilErrTest,
+		},
+		{
+			uri:     "chaos://file://" + tempPath + "?failUpload=0.5&latency=10ms&slowRead=1MiB&seed=1",
+			errTest: nilErrTest,
+		},
+		{
+			uri:     "chaos://file://" + tempPath + "?failUpload=2",
+			errTest: errInvalidURIErrTest,
+		},
+		{
+			uri:     "chaos://file://" + tempPath + "?unknown=1",
+			errTest: errInvalidURIErrTest,
+		},
+		{
+			uri:     "chaos://notvalid://" + tempPath,
+			errTest: func(err error) bool { return errors.Is(err, ErrInvalidPrefix) },
+		},
+		{
+			uri:     "notvalid://file://" + tempPath,
+			errTest: errInvalidURIErrTest,
+		},
+	}
+	for idx, testCase := range testCases {
+		b := &ChaosBackend{}
+		conf := &BackendConfig{TargetURI: testCase.uri, MaxParallelUploadBuffer: make(chan bool, 1)}
+		if err := b.Init(context.Background(), conf); !testCase.errTest(err) {
+			t.Errorf("%d: Unexpected error, got %v", idx, err)
+		}
+	}
+}
+
+func TestChaosBackend(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b, err := GetBackendForURI(ChaosBackendPrefix + "://file://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("Error while trying to get backend: %v", err)
+	}
+
+	BackendTest(ctx, ChaosBackendPrefix, "file://"+t.TempDir(), true, b)(t)
+}
+
+// newChaosBackend will return a ChaosBackend wrapping a file backend in a temporary directory with the options given.
+func newChaosBackend(t *testing.T, options string) (*ChaosBackend, string) {
+	t.Helper()
+	tempPath := t.TempDir()
+	b := &ChaosBackend{}
+	conf := &BackendConfig{
+		TargetURI:               ChaosBackendPrefix + "://file://" + tempPath + "?" + options,
+		MaxParallelUploadBuffer: make(chan bool, 1),
+	}
+	if err := b.Init(context.Background(), conf); err != nil {
+		t.Fatalf("Issue initializing backend: %v", err)
+	}
+	return b, tempPath
+}
+
+func TestChaosFailures(t *testing.T) {
+	ctx := context.Background()
+	payload, goodVol, _, err := prepareTestVols()
+	if err != nil {
+		t.Fatalf("Error while creating test volumes: %v", err)
+	}
+	defer goodVol.DeleteVolume() //nolint:errcheck // Best effort cleanup
+
+	upload := func(b Backend) error {
+		t.Helper()
+		if err := goodVol.OpenVolume(); err != nil {
+			t.Fatalf("could not open volume - %v", err)
+		}
+		defer goodVol.Close()
+		return b.Upload(ctx, goodVol)
+	}
+	download := func(b Backend) ([]byte, error) {
+		t.Helper()
+		r, err := b.Download(ctx, goodVol.ObjectName)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		b, _ := newChaosBackend(t, "failUpload=1&failDownload=1&failList=1&failDelete=1&failPreDownload=1")
+		if err := upload(b); !errors.Is(err, ErrChaos) {
+			t.Errorf("Expected a chaos error uploading, got %v instead", err)
+		}
+		if _, err := b.Download(ctx, goodVol.ObjectName); !errors.Is(err, ErrChaos) {
+			t.Errorf("Expected a chaos error downloading, got %v instead", err)
+		}
+		if _, err := b.List(ctx, ""); !errors.Is(err, ErrChaos) {
+			t.Errorf("Expected a chaos error listing, got %v instead", err)
+		}
+		if err := b.Delete(ctx, goodVol.ObjectName); !errors.Is(err, ErrChaos) {
+			t.Errorf("Expected a chaos error deleting, got %v instead", err)
+		}
+		if err := b.PreDownload(ctx, []string{goodVol.ObjectName}); !errors.Is(err, ErrChaos) {
+			t.Errorf("Expected a chaos error preparing downloads, got %v instead", err)
+		}
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		b, _ := newChaosBackend(t, "latency=1h")
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := b.List(cctx, ""); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the wait to be canceled, got %v instead", err)
+		}
+	})
+
+	t.Run("Truncate", func(t *testing.T) {
+		b, tempPath := newChaosBackend(t, "truncate=1&seed=1")
+		if err := upload(b); err != nil {
+			t.Fatalf("Expected a truncated upload to succeed, got %v instead", err)
+		}
+		info, err := os.Stat(filepath.Join(tempPath, goodVol.ObjectName))
+		if err != nil {
+			t.Fatalf("could not stat uploaded file - %v", err)
+		}
+		if info.Size() >= int64(len(payload)) {
+			t.Errorf("Expected the upload to be truncated, got all %d bytes", info.Size())
+		}
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		b, _ := newChaosBackend(t, "corrupt=1&slowRead=1GiB")
+		if err := upload(b); err != nil {
+			t.Fatalf("Issue uploading: %v", err)
+		}
+		downloaded, err := download(b)
+		if err != nil {
+			t.Fatalf("Issue downloading: %v", err)
+		}
+		if len(downloaded) != len(payload) || bytes.Equal(downloaded, payload) {
+			t.Errorf("Expected the download to be corrupted without changing its length")
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		b, _ := newChaosBackend(t, "listStale=1")
+		if err := upload(b); err != nil {
+			t.Fatalf("Issue uploading: %v", err)
+		}
+		if err := b.Delete(ctx, goodVol.ObjectName); err != nil {
+			t.Fatalf("Issue deleting: %v", err)
+		}
+		if names, err := b.List(ctx, ""); err != nil || len(names) != 1 {
+			t.Errorf("Expected the deleted object to still be listed, got %v, %v", names, err)
+		}
+
+		b, _ = newChaosBackend(t, "listDrop=1")
+		if err := upload(b); err != nil {
+			t.Fatalf("Issue uploading: %v", err)
+		}
+		if names, err := b.List(ctx, ""); err != nil || len(names) != 0 {
+			t.Errorf("Expected the object to be left out of the listing, got %v, %v", names, err)
+		}
+	})
+}
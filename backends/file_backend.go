@@ -49,6 +49,9 @@ func (f *FileBackend) Init(ctx context.Context, conf *BackendConfig, opts ...Opt
 	if cleanPrefix == f.conf.TargetURI {
 		return ErrInvalidURI
 	}
+	if !f.conf.LockUntil.IsZero() {
+		return ErrLockUnsupported
+	}
 
 	absLocalPath, err := filepath.Abs(cleanPrefix)
 	if err != nil {
@@ -107,9 +110,13 @@ func (f *FileBackend) Upload(ctx context.Context, vol *files.VolumeInfo) error {
 	return w.Close()
 }
 
-// Delete will delete the given object from the provided path
+// Delete will delete the given object from the provided path, succeeding if it is already gone as
+// deleting a missing key does with object stores.
 func (f *FileBackend) Delete(ctx context.Context, filename string) error {
-	return os.Remove(filepath.Join(f.localPath, filename))
+	if err := os.Remove(filepath.Join(f.localPath, filename)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 // PreDownload does nothing on this backend.
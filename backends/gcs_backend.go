@@ -40,7 +40,8 @@ const GoogleCloudStorageBackendPrefix = "gs"
 
 // Authenticate: https://developers.google.com/identity/protocols/application-default-credentials
 
-// GoogleCloudStorageBackend integrates with Google Cloud Storage.
+// GoogleCloudStorageBackend integrates with Google Cloud Storage. The credentials file to use can be given as
+// the credentialsFile query parameter of the URI, the application default credentials are used if not set.
 type GoogleCloudStorageBackend struct {
 	conf       *BackendConfig
 	client     *storage.Client
@@ -65,9 +66,9 @@ func WithGoogleCloudStorageClient(c *storage.Client) Option {
 func (g *GoogleCloudStorageBackend) Init(ctx context.Context, conf *BackendConfig, opts ...Option) error {
 	g.conf = conf
 
-	cleanPrefix := strings.TrimPrefix(g.conf.TargetURI, "gs://")
-	if cleanPrefix == g.conf.TargetURI {
-		return ErrInvalidURI
+	cleanPrefix, options, err := targetOptions(g.conf, GoogleCloudStorageBackendPrefix, "credentialsFile")
+	if err != nil {
+		return err
 	}
 
 	uriParts := strings.Split(cleanPrefix, "/")
@@ -82,13 +83,21 @@ func (g *GoogleCloudStorageBackend) Init(ctx context.Context, conf *BackendConfi
 	}
 
 	if g.client == nil {
-		client, err := storage.NewClient(ctx, option.WithScopes(storage.ScopeReadWrite))
+		clientOpts := []option.ClientOption{option.WithScopes(storage.ScopeReadWrite)}
+		if credentialsFile := lastOption(options, "credentialsFile"); credentialsFile != "" {
+			clientOpts = append(clientOpts, option.WithCredentialsFile(credentialsFile))
+		}
+		client, err := storage.NewClient(ctx, clientOpts...)
 		if err != nil {
 			return err
 		}
 		g.client = client
 	}
 
+	if conf.WriteOnly {
+		return nil
+	}
+
 	if _, err := g.client.Bucket(g.bucketName).Attrs(ctx); err != nil {
 		return err
 	}
@@ -114,6 +123,13 @@ func (g *GoogleCloudStorageBackend) Upload(ctx context.Context, vol *files.Volum
 	w.CRC32C = vol.CRC32CSum32
 	w.SendCRC32C = true
 	w.ChunkSize = g.conf.UploadChunkSize
+	if !g.conf.LockUntil.IsZero() {
+		// Needs a bucket with object retention enabled
+		w.Retention = &storage.ObjectRetention{Mode: "Unlocked", RetainUntil: g.conf.LockUntil}
+		if g.conf.LockCompliance {
+			w.Retention.Mode = "Locked"
+		}
+	}
 
 	if _, err := io.Copy(w, vol); err != nil {
 		zap.S().Debugf("gs backend: Error while uploading volume %s - %v", vol.ObjectName, err)
@@ -40,6 +40,7 @@ const MockBackendPrefix = "Mock"
 // MockBackend is a special Backend used to Mock the files after they've been uploaded
 type MockBackend struct {
 	DeleteMock func(ctx context.Context, filename string) error
+	UploadMock func(ctx context.Context, vol *files.VolumeInfo) error
 
 	inMemoryStore sync.Map
 }
@@ -86,6 +87,11 @@ func (d *MockBackend) List(ctx context.Context, prefix string) ([]string, error)
 // Upload will Mock the provided volume, usually found in a temporary folder
 func (d *MockBackend) Upload(ctx context.Context, vol *files.VolumeInfo) error {
 	zap.S().Infof("Mock backend: Upload volume info: %v", vol.ObjectName)
+	if d.UploadMock != nil {
+		if err := d.UploadMock(ctx, vol); err != nil {
+			return err
+		}
+	}
 	buffer := new(bytes.Buffer)
 	n, err := io.Copy(buffer, vol)
 	if err != nil {
@@ -4,27 +4,24 @@ import (
 	"context"
 	"errors"
 	"io"
-	"net"
 	"net/url"
 	"os"
-	"os/user"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/pkg/sftp"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
-	"golang.org/x/crypto/ssh/knownhosts"
 
 	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/sshutil"
 )
 
 // SSHBackendPrefix is the URI prefix used for the SSHBackend.
 const SSHBackendPrefix = "ssh"
 
-// SSHBackend provides a ssh/sftp storage option.
+// SSHBackend provides a ssh/sftp storage option. The private key and known hosts files to use can be given as
+// the keyFile and knownHosts query parameters of the URI, SSH_KEY_FILE and SSH_KNOWN_HOSTS if not set.
 type SSHBackend struct {
 	conf       *BackendConfig
 	sshClient  *ssh.Client
@@ -32,99 +29,16 @@ type SSHBackend struct {
 	remotePath string
 }
 
-// buildSshSigner reads the private key file at privateKeyPath and transforms it into a ssh.Signer,
-// using password to decrypt the key if required.
-func buildSshSigner(privateKeyPath string, password string) (ssh.Signer, error) {
-	privateKey, err := os.ReadFile(privateKeyPath)
-	if err != nil {
-		return nil, err
-	}
-
-	signer, err := ssh.ParsePrivateKey(privateKey)
-	_, isMissingPassword := err.(*ssh.PassphraseMissingError)
-	if isMissingPassword && password != "" {
-		signer, err = ssh.ParsePrivateKeyWithPassphrase(privateKey, []byte(password))
-	}
-
-	return signer, err
-}
-
-// buildAuthMethods builds ssh auth methods based on the provided password and private keys in the the users home directory.
-// To use a specific key instead of the default files set the env variable SSH_KEY_FILE.
-// buildAuthMethods also adds ssh-agent auth if the env variable SSH_AUTH_SOCK exists.
-func buildAuthMethods(userHomeDir string, password string) (sshAuths []ssh.AuthMethod, err error) {
-	sshAuthSock := os.Getenv("SSH_AUTH_SOCK")
-	if sshAuthSock != "" {
-		sshAgent, err := net.Dial("unix", sshAuthSock)
-		if err != nil {
-			return nil, err
-		}
-		sshAuths = append(sshAuths, ssh.PublicKeysCallback(agent.NewClient(sshAgent).Signers))
-	}
-
-	sshKeyFile := os.Getenv("SSH_KEY_FILE")
-	if sshKeyFile != "" {
-		signer, err := buildSshSigner(sshKeyFile, password)
-		if err != nil {
-			return nil, err
-		}
-		sshAuths = append(sshAuths, ssh.PublicKeys(signer))
-	} else {
-		signers := make([]ssh.Signer, 0)
-
-		defaultKeys := []string{
-			filepath.Join(userHomeDir, ".ssh/id_rsa"),
-			filepath.Join(userHomeDir, ".ssh/id_cdsa"),
-			filepath.Join(userHomeDir, ".ssh/id_ecdsa_sk"),
-			filepath.Join(userHomeDir, ".ssh/id_ed25519"),
-			filepath.Join(userHomeDir, ".ssh/id_ed25519_sk"),
-			filepath.Join(userHomeDir, ".ssh/id_dsa"),
-		}
-
-		for _, keyPath := range defaultKeys {
-			signer, err := buildSshSigner(keyPath, password)
-			if err != nil {
-				if !errors.Is(err, os.ErrNotExist) {
-					zap.S().Warnf("ssh backend: Failed to use ssh key at %s - %v", keyPath, err)
-				}
-				continue
-			}
-			signers = append(signers, signer)
-		}
-		if len(signers) > 0 {
-			sshAuths = append(sshAuths, ssh.PublicKeys(signers...))
-		}
-	}
-
-	if password != "" {
-		sshAuths = append(sshAuths, ssh.Password(password))
-	}
-
-	return sshAuths, nil
-}
-
-// buildHostKeyCallback builds a ssh.HostKeyCallback that uses the known_hosts file from the users home directory.
-// Or from a custom file specified by the SSH_KNOWN_HOSTS env variable.
-// If SSH_KNOWN_HOSTS is set to "ignore" host key checking is disabled.
-func buildHostKeyCallback(userHomeDir string) (callback ssh.HostKeyCallback, err error) {
-	knownHostsFile := os.Getenv("SSH_KNOWN_HOSTS")
-	if knownHostsFile == "" {
-		knownHostsFile = filepath.Join(userHomeDir, ".ssh/known_hosts")
-	}
-	if knownHostsFile == "ignore" {
-		callback = ssh.InsecureIgnoreHostKey()
-	} else {
-		callback, err = knownhosts.New(knownHostsFile)
-	}
-	return callback, err
-}
-
 // Init will initialize the SSHBackend and verify the provided URI is valid and the target directory exists.
 func (s *SSHBackend) Init(ctx context.Context, conf *BackendConfig, opts ...Option) (err error) {
 	s.conf = conf
 
-	if !strings.HasPrefix(s.conf.TargetURI, SSHBackendPrefix+"://") {
-		return ErrInvalidURI
+	_, options, err := targetOptions(s.conf, SSHBackendPrefix, "keyFile", "knownHosts")
+	if err != nil {
+		return err
+	}
+	if !s.conf.LockUntil.IsZero() {
+		return ErrLockUnsupported
 	}
 
 	targetUrl, err := url.Parse(s.conf.TargetURI)
@@ -139,49 +53,8 @@ func (s *SSHBackend) Init(ctx context.Context, conf *BackendConfig, opts ...Opti
 		return ErrInvalidURI
 	}
 
-	username := os.Getenv("SSH_USERNAME")
-	password := os.Getenv("SSH_PASSWORD")
-	if targetUrl.User != nil {
-		urlUsername := targetUrl.User.Username()
-		if urlUsername != "" {
-			username = urlUsername
-		}
-		urlPassword, _ := targetUrl.User.Password()
-		if urlPassword != "" {
-			password = urlPassword
-		}
-	}
-
-	userInfo, err := user.Current()
-	if err != nil {
-		return err
-	}
-	if username == "" {
-		username = userInfo.Username
-	}
-
-	sshAuths, err := buildAuthMethods(userInfo.HomeDir, password)
-	if err != nil {
-		return err
-	}
-
-	hostKeyCallback, err := buildHostKeyCallback(userInfo.HomeDir)
-	if err != nil {
-		return err
-	}
-
-	sshConfig := &ssh.ClientConfig{
-		User:            username,
-		Auth:            sshAuths,
-		HostKeyCallback: hostKeyCallback,
-		Timeout:         30 * time.Second,
-	}
-
-	hostname := targetUrl.Host
-	if !strings.Contains(hostname, ":") {
-		hostname = hostname + ":22"
-	}
-	s.sshClient, err = ssh.Dial("tcp", hostname, sshConfig)
+	targetUrl.RawQuery = options.Encode()
+	s.sshClient, err = sshutil.Dial(targetUrl)
 	if err != nil {
 		return err
 	}
@@ -286,9 +159,12 @@ func (s *SSHBackend) Download(ctx context.Context, filename string) (io.ReadClos
 	return s.sftpClient.Open(filepath.Join(s.remotePath, filename))
 }
 
-// Delete will delete the given object from the provided path.
+// Delete will delete the given object from the provided path, succeeding if it is already gone.
 func (s *SSHBackend) Delete(ctx context.Context, filename string) error {
-	return s.sftpClient.Remove(filepath.Join(s.remotePath, filename))
+	if err := s.sftpClient.Remove(filepath.Join(s.remotePath, filename)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
 }
 
 var _ Backend = (*SSHBackend)(nil)
@@ -0,0 +1,64 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backends
+
+import (
+	"context"
+	"io"
+)
+
+// WriteOnlyBackend wraps another Backend configured with credentials that may only upload objects, refusing
+// everything else up front rather than letting the destination deny it.
+type WriteOnlyBackend struct {
+	Backend
+}
+
+// NewWriteOnlyBackend will return a Backend that works like b except it only uploads objects.
+func NewWriteOnlyBackend(b Backend) *WriteOnlyBackend {
+	return &WriteOnlyBackend{Backend: b}
+}
+
+// Init will initialize the wrapped backend without any of the list-based probes it would otherwise make.
+func (w *WriteOnlyBackend) Init(ctx context.Context, conf *BackendConfig, opts ...Option) error {
+	writeOnlyConf := *conf
+	writeOnlyConf.WriteOnly = true
+	return w.Backend.Init(ctx, &writeOnlyConf, opts...)
+}
+
+// List will return ErrWriteOnly without listing the objects.
+func (w *WriteOnlyBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	return nil, ErrWriteOnly
+}
+
+// PreDownload will return ErrWriteOnly without preparing the objects.
+func (w *WriteOnlyBackend) PreDownload(ctx context.Context, objects []string) error {
+	return ErrWriteOnly
+}
+
+// Download will return ErrWriteOnly without downloading the object.
+func (w *WriteOnlyBackend) Download(ctx context.Context, filename string) (io.ReadCloser, error) {
+	return nil, ErrWriteOnly
+}
+
+// Delete will return ErrWriteOnly without deleting the object.
+func (w *WriteOnlyBackend) Delete(ctx context.Context, filename string) error {
+	return ErrWriteOnly
+}
@@ -32,6 +32,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff"
@@ -39,149 +40,194 @@ import (
 	"github.com/miolini/datacounter"
 	"github.com/nightlyone/lockfile"
 	"github.com/schollz/progressbar/v3"
-	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/someone1/zfsbackup-go/backends"
 	"github.com/someone1/zfsbackup-go/config"
+	"github.com/someone1/zfsbackup-go/events"
 	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/metrics"
 	"github.com/someone1/zfsbackup-go/zfs"
 )
 
-var (
-	ErrNoOp       = errors.New("nothing new to sync")
-	manifestmutex sync.Mutex
-)
-
-// ProcessSmartOptions will compute the snapshots to use
+// ProcessSmartOptions will compute the snapshots to use for each destination from that destination's own
+// manifests. Destinations that share the same incremental base are grouped into a single job so that the
+// zfs send runs once per distinct base, and destinations that are already up to date are left out. The
+// jobs returned are copies of the provided JobInfo, ErrNoOp is returned if no destination needs a backup.
 // nolint:funlen,gocyclo // Difficult to break this up
-func ProcessSmartOptions(ctx context.Context, jobInfo *files.JobInfo) error {
-	snapshots, err := zfs.GetSnapshotsAndBookmarks(context.Background(), jobInfo.VolumeName)
+func (e *Engine) ProcessSmartOptions(ctx context.Context, jobInfo *files.JobInfo) ([]*files.JobInfo, error) {
+	snapshots, err := e.zfs.GetSnapshotsAndBookmarks(context.Background(), jobInfo.VolumeName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Base Snapshots cannot be a bookmark
 	for i := range snapshots {
-		zap.S().Debugf("Considering snapshot %s", snapshots[i].Name)
+		e.logger.Debugf("Considering snapshot %s", snapshots[i].Name)
 		if !snapshots[i].Bookmark {
 			if jobInfo.SnapshotPrefix == "" || strings.HasPrefix(snapshots[i].Name, jobInfo.SnapshotPrefix) {
-				zap.S().Debugf("Matched snapshot: %s", snapshots[i].Name)
+				e.logger.Debugf("Matched snapshot: %s", snapshots[i].Name)
 				jobInfo.BaseSnapshot = snapshots[i]
 				break
 			}
 		}
 	}
 	if jobInfo.BaseSnapshot.Name == "" {
-		return fmt.Errorf("no snapshots found")
+		return nil, fmt.Errorf("%w: no snapshots found for %s", ErrSnapshotNotFound, jobInfo.VolumeName)
 	}
 	if jobInfo.Full {
 		// TODO: Check if we already have a full backup for this snapshot in the destination(s)
-		return nil
+		e.eventStream(ctx).Emit(&events.Event{
+			Type:       events.SnapshotSelected,
+			Operation:  operationSend,
+			VolumeName: jobInfo.VolumeName,
+			Snapshot:   jobInfo.BaseSnapshot.Name,
+		})
+		return []*files.JobInfo{jobInfo}, nil
 	}
-	lastComparableSnapshots := make([]*files.SnapshotInfo, len(jobInfo.Destinations))
-	lastBackup := make([]*files.SnapshotInfo, len(jobInfo.Destinations))
-	for idx := range jobInfo.Destinations {
-		destBackups, derr := getBackupsForTarget(ctx, jobInfo.VolumeName, jobInfo.Destinations[idx], jobInfo)
-		if derr != nil {
-			return derr
-		}
-		if len(destBackups) == 0 {
+
+	var jobs []*files.JobInfo
+	for _, destination := range jobInfo.Destinations {
+		incremental, derr := e.smartIncrementalForTarget(ctx, jobInfo, destination, snapshots)
+		if errors.Is(derr, ErrNoOp) {
+			e.logger.Infof("Destination %s is already up to date, skipping.", destination)
 			continue
+		} else if derr != nil {
+			return nil, derr
+		}
+
+		var job *files.JobInfo
+		for _, j := range jobs {
+			if j.IncrementalSnapshot.Equal(&incremental) {
+				job = j
+				break
+			}
+		}
+		if job == nil {
+			job = new(files.JobInfo)
+			*job = *jobInfo
+			job.Destinations = nil
+			job.IncrementalSnapshot = incremental
+			jobs = append(jobs, job)
 		}
-		lastBackup[idx] = &destBackups[0].BaseSnapshot
+		job.Destinations = append(job.Destinations, destination)
+		e.eventStream(ctx).Emit(&events.Event{
+			Type:        events.SnapshotSelected,
+			Operation:   operationSend,
+			VolumeName:  job.VolumeName,
+			Snapshot:    job.BaseSnapshot.Name,
+			Incremental: job.IncrementalSnapshot.Name,
+			Destination: backends.RedactURI(destination),
+		})
+	}
+
+	if len(jobs) == 0 {
+		return nil, ErrNoOp
+	}
+	if len(jobs) > 1 {
+		e.logger.Infof("Destinations are out of sync, the backup will be sent %d times, once per incremental base.", len(jobs))
+	}
+
+	return jobs, nil
+}
+
+// smartIncrementalForTarget will return the snapshot a backup to the given destination should be incremental
+// from, an empty SnapshotInfo if a full backup is required, or ErrNoOp if the destination is already up to date.
+// nolint:gocritic,gocyclo // Don't need to name the results, difficult to break this up
+func (e *Engine) smartIncrementalForTarget(
+	ctx context.Context,
+	jobInfo *files.JobInfo,
+	destination string,
+	snapshots []files.SnapshotInfo,
+) (files.SnapshotInfo, error) {
+	destBackups, derr := e.getBackupsForTarget(ctx, jobInfo.VolumeName, destination, jobInfo)
+	if derr != nil {
+		return files.SnapshotInfo{}, derr
+	}
+
+	var lastComparableSnapshot, lastBackup *files.SnapshotInfo
+	if len(destBackups) > 0 {
+		lastBackup = &destBackups[0].BaseSnapshot
 		if jobInfo.Incremental {
-			lastComparableSnapshots[idx] = &destBackups[0].BaseSnapshot
+			lastComparableSnapshot = &destBackups[0].BaseSnapshot
 		}
 		if jobInfo.FullIfOlderThan != -1*time.Minute {
 			for _, bkp := range destBackups {
 				if bkp.IncrementalSnapshot.Name == "" {
-					lastComparableSnapshots[idx] = &bkp.BaseSnapshot
+					lastComparableSnapshot = &bkp.BaseSnapshot
 					break
 				}
 			}
 		}
 	}
 
-	var lastNotEqual bool
-	// Verify that all "comparable" snapshots are the same across destinations
-	for i := 1; i < len(lastComparableSnapshots); i++ {
-		if !lastComparableSnapshots[i-1].Equal(lastComparableSnapshots[i]) {
-			return fmt.Errorf("destinations are out of sync, cannot continue with smart option")
-		}
-
-		if !lastNotEqual && !lastBackup[i-1].Equal(lastBackup[i]) {
-			lastNotEqual = true
-		}
-	}
-
 	// Now select the proper job options and continue
 	if jobInfo.Incremental {
-		if lastComparableSnapshots[0] == nil {
-			return fmt.Errorf("no snapshot to increment from - try doing a full backup instead")
+		if lastComparableSnapshot == nil {
+			return files.SnapshotInfo{}, fmt.Errorf("%w: no snapshot to increment from in %s - try doing a full backup instead", ErrOutOfSync, destination)
 		}
-		if lastComparableSnapshots[0].Equal(&snapshots[0]) {
-			return ErrNoOp
+		if lastComparableSnapshot.Equal(&snapshots[0]) {
+			return files.SnapshotInfo{}, ErrNoOp
 		}
-		jobInfo.IncrementalSnapshot = *lastComparableSnapshots[0]
+		return *lastComparableSnapshot, nil
 	}
 
 	if jobInfo.FullIfOlderThan != -1*time.Minute {
-		if lastComparableSnapshots[0] == nil {
+		if lastComparableSnapshot == nil {
 			// No previous full backup, so do one
-			zap.S().Infof("No previous full backup found, performing full backup.")
-			return nil
+			e.logger.Infof("No previous full backup found in %s, performing full backup.", destination)
+			return files.SnapshotInfo{}, nil
 		}
 
-		if snapshots[0].CreationTime.Sub(lastComparableSnapshots[0].CreationTime) > jobInfo.FullIfOlderThan {
+		if snapshots[0].CreationTime.Sub(lastComparableSnapshot.CreationTime) > jobInfo.FullIfOlderThan {
 			// Been more than the allotted time, do a full backup
-			zap.S().Infof(
-				"Last Full backup was %v and is more than %v before the most recent snapshot, performing full backup.",
-				lastComparableSnapshots[0].CreationTime, jobInfo.FullIfOlderThan,
+			e.logger.Infof(
+				"Last Full backup in %s was %v and is more than %v before the most recent snapshot, performing full backup.",
+				destination, lastComparableSnapshot.CreationTime, jobInfo.FullIfOlderThan,
 			)
-			return nil
+			return files.SnapshotInfo{}, nil
 		}
 
-		if lastNotEqual {
-			return fmt.Errorf("want to do an incremental backup but last incremental backup at destinations do not match")
-		}
-		if lastBackup[0].Equal(&snapshots[0]) {
-			return ErrNoOp
+		if lastBackup.Equal(&snapshots[0]) {
+			return files.SnapshotInfo{}, ErrNoOp
 		}
 
-		if ok, verr := validateSnapShotExists(ctx, lastComparableSnapshots[0], jobInfo.VolumeName, true); verr != nil {
-			return verr
-		} else if !ok {
-			zap.S().Infof(
-				"Last Full backup was done on %v but is no longer found in the local target, performing full backup.",
-				lastComparableSnapshots[0].CreationTime, jobInfo.FullIfOlderThan,
+		if !validateSnapShotExistsFromSnaps(lastComparableSnapshot, snapshots, true) {
+			e.logger.Infof(
+				"Last Full backup in %s was done on %v but is no longer found in the local target, performing full backup.",
+				destination, lastComparableSnapshot.CreationTime,
 			)
-			return nil
+			return files.SnapshotInfo{}, nil
 		}
-		jobInfo.IncrementalSnapshot = *lastBackup[0]
+		return *lastBackup, nil
 	}
-	return nil
+
+	return files.SnapshotInfo{}, nil
 }
 
 // Will list all backups found in the target destination
-func getBackupsForTarget(ctx context.Context, volume, target string, jobInfo *files.JobInfo) ([]*files.JobInfo, error) {
+func (e *Engine) getBackupsForTarget(ctx context.Context, volume, target string, jobInfo *files.JobInfo) ([]*files.JobInfo, error) {
+	if isReplicationTarget(target) {
+		return e.getReplicasForTarget(ctx, volume, target, jobInfo)
+	}
+
 	// Prepare the backend client
-	backend, berr := prepareBackend(ctx, jobInfo, target, nil)
+	backend, berr := e.prepareBackend(ctx, jobInfo, target, nil)
 	if berr != nil {
-		zap.S().Errorf("Could not initialize backend due to error - %v.", berr)
+		e.logger.Errorf("Could not initialize backend due to error - %v.", berr)
 		return nil, berr
 	}
 
 	// Get the local cache dir
-	localCachePath, cerr := getCacheDir(target)
+	localCachePath, cerr := e.getCacheDir(target)
 	if cerr != nil {
-		zap.S().Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
+		e.logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
 		return nil, cerr
 	}
 
 	// Sync the local cache
-	safeManifests, _, serr := syncCache(ctx, jobInfo, localCachePath, backend)
+	safeManifests, _, serr := e.syncCache(ctx, jobInfo, localCachePath, backend)
 	if serr != nil {
-		zap.S().Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
+		e.logger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
 		return nil, serr
 	}
 
@@ -205,37 +251,212 @@ func getBackupsForTarget(ctx context.Context, volume, target string, jobInfo *fi
 }
 
 // Backup will initiate a backup with the provided configuration.
+func (e *Engine) Backup(ctx context.Context, jobInfo *files.JobInfo) error {
+	return e.BackupJobs(ctx, []*files.JobInfo{jobInfo})
+}
+
+// BackupJobs will run each of the provided backup jobs, such as those returned by ProcessSmartOptions,
+// one after another and output a single summary of the backups once they have all completed.
+// Destinations that fail are left incomplete as long as the number of destinations required by
+// RequiredDestinations still succeed, in which case ErrDegraded is returned after the summary.
+func (e *Engine) BackupJobs(ctx context.Context, jobs []*files.JobInfo) error {
+	var total int
+	for _, jobInfo := range jobs {
+		total += len(jobInfo.Destinations)
+	}
+	required := jobs[0].RequiredDestinations
+	if required <= 0 || required > total {
+		required = total
+	}
+
+	e.notifyStart(ctx, operationSend, jobs[0], jobs...)
+
+	var failed int
+	for _, jobInfo := range jobs {
+		if err := e.backup(ctx, jobInfo, total-required-failed); err != nil {
+			e.notifyDone(ctx, operationSend, err, nil, jobs[0], jobs...)
+			return err
+		}
+		failed += len(jobInfo.FailedDestinations)
+	}
+
+	summary := summarizeBackups(jobs)
+	e.recordResult(ctx, summary)
+	e.printBackupSummary(summary)
+
+	var err error
+	if failed > 0 {
+		err = ErrDegraded
+	}
+	e.notifyDone(ctx, operationSend, err, summary, jobs[0], jobs...)
+	return err
+}
+
+// failureTracker records the destinations that failed during a backup and decides whether
+// the backup can carry on without them.
+type failureTracker struct {
+	mu      sync.Mutex
+	allowed int
+	failed  map[string]error
+}
+
+func newFailureTracker(allowed int) *failureTracker {
+	return &failureTracker{allowed: allowed, failed: make(map[string]error)}
+}
+
+// fail will mark the destination as failed, returning an error if the backup cannot continue.
+func (f *failureTracker) fail(destination string, err error) error {
+	if destination == backends.DeleteBackendPrefix+"://" {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.failed[destination]; !ok {
+		f.failed[destination] = err
+	}
+	if len(f.failed) > f.allowed {
+		return fmt.Errorf("too many destinations failed (%d), last error: %w", len(f.failed), err)
+	}
+	return nil
+}
+
+func (f *failureTracker) hasFailed(destination string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.failed[destination]
+	return ok
+}
+
+// Status values reported in the backup summary.
+const (
+	statusComplete   = "complete"
+	statusIncomplete = "incomplete"
+	statusSuccess    = "success"
+	statusDegraded   = "degraded"
+)
+
+type destinationSummary struct {
+	Destination         string
+	Status              string
+	BaseSnapshot        files.SnapshotInfo
+	IncrementalSnapshot *files.SnapshotInfo `json:",omitempty"`
+}
+
+// backupSummary is output once backups complete and included in notifications.
+type backupSummary struct {
+	Status             string
+	TotalZFSBytes      uint64
+	TotalBackupBytes   uint64
+	ElapsedTime        time.Duration
+	FilesUploaded      int
+	Destinations       []destinationSummary
+	FailedDestinations []string `json:",omitempty"`
+}
+
+func summarizeBackups(jobs []*files.JobInfo) *backupSummary {
+	summary := &backupSummary{Status: statusSuccess}
+	var startTime time.Time
+	for _, jobInfo := range jobs {
+		summary.TotalZFSBytes += jobInfo.ZFSStreamBytes
+		summary.TotalBackupBytes += jobInfo.TotalBytesWritten()
+		if hasVolumeDestinations(jobInfo.Destinations) {
+			summary.FilesUploaded += len(jobInfo.Volumes) + 1
+		}
+		if startTime.IsZero() || jobInfo.StartTime.Before(startTime) {
+			startTime = jobInfo.StartTime
+		}
+		for _, destination := range jobInfo.Destinations {
+			if destination == backends.DeleteBackendPrefix+"://" {
+				continue
+			}
+			destSummary := destinationSummary{
+				Destination:  backends.RedactURI(destination),
+				Status:       statusComplete,
+				BaseSnapshot: jobInfo.BaseSnapshot,
+			}
+			for _, failed := range jobInfo.FailedDestinations {
+				if failed == destination {
+					destSummary.Status = statusIncomplete
+					summary.FailedDestinations = append(summary.FailedDestinations, destSummary.Destination)
+				}
+			}
+			if jobInfo.IncrementalSnapshot.Name != "" {
+				incremental := jobInfo.IncrementalSnapshot
+				destSummary.IncrementalSnapshot = &incremental
+			}
+			summary.Destinations = append(summary.Destinations, destSummary)
+		}
+	}
+	summary.ElapsedTime = time.Since(startTime)
+
+	if len(summary.FailedDestinations) > 0 {
+		summary.Status = statusDegraded
+	}
+	return summary
+}
+
+func (e *Engine) printBackupSummary(summary *backupSummary) {
+	if e.jsonOutput {
+		return
+	}
+
+	fmt.Fprintf(
+		e.stdout,
+		"Done.\n\tTotal ZFS Stream Bytes: %d (%s)\n\tTotal Bytes Written: %d (%s)\n\tElapsed Time: %v\n\tTotal Files Uploaded: %d\n",
+		summary.TotalZFSBytes,
+		humanize.IBytes(summary.TotalZFSBytes),
+		summary.TotalBackupBytes,
+		humanize.IBytes(summary.TotalBackupBytes),
+		summary.ElapsedTime,
+		summary.FilesUploaded,
+	)
+	for _, dest := range summary.Destinations {
+		if dest.Status == statusIncomplete {
+			fmt.Fprintf(e.stdout, "\t%s: %s incomplete\n", dest.Destination, dest.BaseSnapshot.Name)
+		} else if dest.IncrementalSnapshot != nil {
+			fmt.Fprintf(e.stdout, "\t%s: %s incremental from %s\n", dest.Destination, dest.BaseSnapshot.Name, dest.IncrementalSnapshot.Name)
+		} else {
+			fmt.Fprintf(e.stdout, "\t%s: %s full\n", dest.Destination, dest.BaseSnapshot.Name)
+		}
+	}
+}
+
 // nolint:funlen,gocyclo // Difficult to break this up
-func Backup(pctx context.Context, jobInfo *files.JobInfo) error {
+func (e *Engine) backup(pctx context.Context, jobInfo *files.JobInfo, allowedFailures int) error {
 	ctx, cancel := context.WithCancel(pctx)
 	defer cancel()
 
 	if jobInfo.Resume {
-		if err := tryResume(ctx, jobInfo); err != nil {
+		if err := e.tryResume(ctx, jobInfo); err != nil {
 			return err
 		}
 	}
+	if jobInfo.LockDuration > 0 {
+		// Volumes uploaded before the backup was resumed were locked until the same time
+		jobInfo.LockUntil = jobInfo.StartTime.Add(jobInfo.LockDuration)
+	}
 
 	// Make sure nobody else is working on the same volume/dataset we are!
 	// nolint:gosec // MD5 not used for cryptographic purposes
 	lockFilePath := filepath.Join(os.TempDir(), fmt.Sprintf("zfsbackup.%x.lck", md5.Sum([]byte(jobInfo.VolumeName))))
 	lock, lferr := lockfile.New(lockFilePath)
 	if lferr != nil {
-		zap.S().Errorf("Cannot init lock. reason: %v", lferr)
+		e.logger.Errorf("Cannot init lock. reason: %v", lferr)
 		return lferr
 	}
 	lferr = lock.TryLock()
 
 	if lferr != nil {
-		zap.S().Errorf(
+		e.logger.Errorf(
 			"Cannot lock %q, reason: %v. If no other execution of %s is working on %s, you may forcefully remove the lock file located %s.",
 			lock, lferr, config.ProgramName, jobInfo.VolumeName, lockFilePath,
 		)
-		return lferr
+		return fmt.Errorf("%w: %v", ErrLocked, lferr)
 	}
 	defer func() {
 		if err := lock.Unlock(); err != nil {
-			zap.S().Warnf("Could not release lock %s: %v", lockFilePath, err)
+			e.logger.Warnf("Could not release lock %s: %v", lockFilePath, err)
 		}
 	}()
 
@@ -245,33 +466,59 @@ func Backup(pctx context.Context, jobInfo *files.JobInfo) error {
 	}
 
 	// Validate the snapshots we want to use exist
-	if ok, verr := validateSnapShotExists(ctx, &jobInfo.BaseSnapshot, jobInfo.VolumeName, false); verr != nil {
-		zap.S().Errorf("Cannot validate if selected base snapshot exists due to error - %v", verr)
+	if ok, verr := e.validateSnapShotExists(ctx, &jobInfo.BaseSnapshot, jobInfo.VolumeName, false); verr != nil {
+		e.logger.Errorf("Cannot validate if selected base snapshot exists due to error - %v", verr)
 		return verr
 	} else if !ok {
-		zap.S().Errorf("Selected base snapshot does not exist!")
-		return fmt.Errorf("selected base snapshot does not exist")
+		e.logger.Errorf("Selected base snapshot does not exist!")
+		return fmt.Errorf("%w: selected base snapshot does not exist", ErrSnapshotNotFound)
 	}
 
 	if jobInfo.IncrementalSnapshot.Name != "" {
-		if ok, verr := validateSnapShotExists(ctx, &jobInfo.IncrementalSnapshot, jobInfo.VolumeName, true); verr != nil {
-			zap.S().Errorf("Cannot validate if selected incremental snapshot exists due to error - %v", verr)
+		if ok, verr := e.validateSnapShotExists(ctx, &jobInfo.IncrementalSnapshot, jobInfo.VolumeName, true); verr != nil {
+			e.logger.Errorf("Cannot validate if selected incremental snapshot exists due to error - %v", verr)
 			return verr
 		} else if !ok {
-			zap.S().Errorf("Selected incremental snapshot does not exist!")
-			return fmt.Errorf("selected incremental snapshot does not exist")
+			e.logger.Errorf("Selected incremental snapshot does not exist!")
+			return fmt.Errorf("%w: selected incremental snapshot does not exist", ErrSnapshotNotFound)
 		}
 	}
 
+	failures := newFailureTracker(allowedFailures)
+
+	// Replicate to the zfs targets first, they need nothing from the volume pipeline
+	var replicationTargets []string
+	for _, destination := range jobInfo.Destinations {
+		if isReplicationTarget(destination) {
+			replicationTargets = append(replicationTargets, destination)
+		}
+	}
+	replicationErrors := e.replicate(ctx, jobInfo, replicationTargets)
+	for _, destination := range replicationTargets {
+		if err := replicationErrors[destination]; err != nil {
+			e.logger.Errorf("Could not replicate to %s due to error - %v", destination, err)
+			if ctx.Err() != nil {
+				return err
+			}
+			e.emitError(ctx, operationSend, jobInfo, destination, err)
+			if ferr := failures.fail(destination, err); ferr != nil {
+				return ferr
+			}
+		}
+	}
+
+	if !hasVolumeDestinations(jobInfo.Destinations) {
+		jobInfo.EndTime = time.Now()
+		e.finishDestinations(jobInfo, failures)
+		return nil
+	}
+
 	startCh := make(chan *files.VolumeInfo, fileBufferSize) // Sent to ZFS command and meant to be closed when done
 	stepCh := make(chan *files.VolumeInfo, fileBufferSize)  // Used as input to first backend, closed when final manifest is sent through
 
 	var maniwg sync.WaitGroup
 	maniwg.Add(1)
 
-	uploadBuffer := make(chan bool, jobInfo.MaxParallelUploads)
-	defer close(uploadBuffer)
-
 	fileBuffer := make(chan bool, fileBufferSize)
 	for i := 0; i < fileBufferSize; i++ {
 		fileBuffer <- true
@@ -279,6 +526,10 @@ func Backup(pctx context.Context, jobInfo *files.JobInfo) error {
 
 	group, ctx := errgroup.WithContext(ctx)
 
+	// Volumes that never make it through the pipeline, e.g. as the backup failed, are taken off the gauge once it stops
+	inFlight := new(volumesInFlight)
+	defer inFlight.release()
+
 	// Used to prevent closing the upload pipeline after the ZFS command is done
 	// so we can send the manifest file up after all volumes have made it to the backends.
 	go func() {
@@ -305,31 +556,52 @@ func Backup(pctx context.Context, jobInfo *files.JobInfo) error {
 
 	// Start the ZFS send stream
 	group.Go(func() error {
-		return sendStream(ctx, jobInfo, startCh, fileBuffer)
+		return e.sendStream(ctx, jobInfo, startCh, fileBuffer, inFlight)
 	})
 
 	var usedBackends []backends.Backend
-	var channels []<-chan *files.VolumeInfo
-	channels = append(channels, stepCh)
+	var inputs []chan<- *files.VolumeInfo
+	var outputs []<-chan *files.VolumeInfo
 
-	if jobInfo.MaxFileBuffer != 0 {
-		jobInfo.Destinations = append(jobInfo.Destinations, backends.DeleteBackendPrefix+"://")
-	}
-
-	// Prepare backends and setup plumbing
+	// Prepare backends and setup plumbing, each destination gets its own upload workers and concurrency limit
 	for _, destination := range jobInfo.Destinations {
-		backend, berr := prepareBackend(ctx, jobInfo, destination, uploadBuffer)
+		if isReplicationTarget(destination) {
+			continue
+		}
+		uploadBuffer := make(chan bool, jobInfo.MaxParallelUploads)
+		defer close(uploadBuffer)
+
+		backend, berr := e.prepareBackend(ctx, jobInfo, destination, uploadBuffer)
 		if berr != nil {
-			zap.S().Errorf("Could not initialize backend due to error - %v.", berr)
+			e.logger.Errorf("Could not initialize backend due to error - %v.", berr)
 			return berr
 		}
-		_, cerr := getCacheDir(destination)
+		_, cerr := e.getCacheDir(destination)
 		if cerr != nil {
-			zap.S().Errorf("Could not create cache for destination %s due to error - %v.", destination, cerr)
+			e.logger.Errorf("Could not create cache for destination %s due to error - %v.", destination, cerr)
 			return cerr
 		}
-		out, waitgroup := retryUploadChainer(ctx, channels[len(channels)-1], backend, jobInfo, destination)
-		channels = append(channels, out)
+		in := make(chan *files.VolumeInfo, fileBufferSize)
+		out, waitgroup := e.retryUploadChainer(ctx, in, backend, jobInfo, destination, failures)
+		inputs = append(inputs, in)
+		outputs = append(outputs, out)
+		usedBackends = append(usedBackends, backend)
+		group.Go(waitgroup.Wait)
+	}
+
+	lastChan := e.fanOutVolumes(ctx, group, stepCh, inputs, outputs)
+
+	// Volumes are only deleted once every destination is done with them
+	if jobInfo.MaxFileBuffer != 0 {
+		jobInfo.Destinations = append(jobInfo.Destinations, backends.DeleteBackendPrefix+"://")
+		destination := jobInfo.Destinations[len(jobInfo.Destinations)-1]
+		backend, berr := e.prepareBackend(ctx, jobInfo, destination, nil)
+		if berr != nil {
+			e.logger.Errorf("Could not initialize backend due to error - %v.", berr)
+			return berr
+		}
+		out, waitgroup := e.retryUploadChainer(ctx, lastChan, backend, jobInfo, destination, failures)
+		lastChan = out
 		usedBackends = append(usedBackends, backend)
 		group.Go(waitgroup.Wait)
 	}
@@ -337,23 +609,28 @@ func Backup(pctx context.Context, jobInfo *files.JobInfo) error {
 	// Create and copy a copy of the manifest during the backup procedure for future retry requests
 	group.Go(func() error {
 		defer close(fileBuffer)
-		lastChan := channels[len(channels)-1]
 		for {
 			select {
-			case vol := <-lastChan:
+			case vol, ok := <-lastChan:
+				if !ok {
+					// The pipeline stopped before the manifest made it, leave it to its error to stop the backup
+					<-ctx.Done()
+					return ctx.Err()
+				}
 				if !vol.IsManifest {
-					zap.S().Debugf("Volume %s has finished the entire pipeline.", vol.ObjectName)
-					zap.S().Debugf("Adding %s to the manifest volume list.", vol.ObjectName)
-					manifestmutex.Lock()
+					e.logger.Debugf("Volume %s has finished the entire pipeline.", vol.ObjectName)
+					inFlight.done()
+					e.logger.Debugf("Adding %s to the manifest volume list.", vol.ObjectName)
+					e.manifestMutex.Lock()
 					jobInfo.Volumes = append(jobInfo.Volumes, vol)
-					manifestmutex.Unlock()
+					e.manifestMutex.Unlock()
 					// Write a manifest file and save it locally in order to resume later
-					manifestVol, err := saveManifest(ctx, jobInfo, false)
+					manifestVol, err := e.saveManifest(ctx, jobInfo, false)
 					if err != nil {
 						return err
 					}
 					if err = manifestVol.DeleteVolume(); err != nil {
-						zap.S().Warnf("Error deleting temporary manifest file  - %v", err)
+						e.logger.Warnf("Error deleting temporary manifest file  - %v", err)
 					}
 					maniwg.Done()
 				} else {
@@ -375,13 +652,22 @@ func Backup(pctx context.Context, jobInfo *files.JobInfo) error {
 
 	// Final Manifest Creation
 	group.Go(func() error {
-		// TODO: How to incorporate contexts in this go routine?
-		maniwg.Wait() // Wait until the ZFS send command has completed and all volumes have been uploaded to all backends.
-		zap.S().Infof("All volumes dispatched in pipeline, finalizing manifest file.")
-		manifestmutex.Lock()
+		// Wait until the ZFS send command has completed and all volumes have been uploaded to all backends.
+		dispatched := make(chan struct{})
+		go func() {
+			maniwg.Wait()
+			close(dispatched)
+		}()
+		select {
+		case <-dispatched:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		e.logger.Infof("All volumes dispatched in pipeline, finalizing manifest file.")
+		e.manifestMutex.Lock()
 		jobInfo.EndTime = time.Now()
-		manifestmutex.Unlock()
-		manifestVol, err := saveManifest(ctx, jobInfo, true)
+		e.manifestMutex.Unlock()
+		manifestVol, err := e.saveManifest(ctx, jobInfo, true)
 		if err != nil {
 			return err
 		}
@@ -395,52 +681,71 @@ func Backup(pctx context.Context, jobInfo *files.JobInfo) error {
 		return err
 	}
 
-	totalWrittenBytes := jobInfo.TotalBytesWritten()
-	if config.JSONOutput {
-		var doneOutput = struct {
-			TotalZFSBytes    uint64
-			TotalBackupBytes uint64
-			ElapsedTime      time.Duration
-			FilesUploaded    int
-		}{jobInfo.ZFSStreamBytes, totalWrittenBytes, time.Since(jobInfo.StartTime), len(jobInfo.Volumes) + 1}
-		if j, jerr := json.Marshal(doneOutput); jerr != nil {
-			zap.S().Errorf("could not output json due to error - %v", jerr)
-		} else {
-			fmt.Fprintf(config.Stdout, "%s", string(j))
-		}
-	} else {
-		fmt.Fprintf(
-			config.Stdout,
-			"Done.\n\tTotal ZFS Stream Bytes: %d (%s)\n\tTotal Bytes Written: %d (%s)\n\tElapsed Time: %v\n\tTotal Files Uploaded: %d\n",
-			jobInfo.ZFSStreamBytes,
-			humanize.IBytes(jobInfo.ZFSStreamBytes),
-			totalWrittenBytes,
-			humanize.IBytes(totalWrittenBytes),
-			time.Since(jobInfo.StartTime),
-			len(jobInfo.Volumes)+1,
-		)
-	}
+	e.finishDestinations(jobInfo, failures)
 
-	zap.S().Debugf("Cleaning up resources...")
+	e.logger.Debugf("Cleaning up resources...")
 
 	for _, backend := range usedBackends {
 		if err = backend.Close(); err != nil {
-			zap.S().Warnf("Could not properly close backend due to error - %v", err)
+			e.logger.Warnf("Could not properly close backend due to error - %v", err)
 		}
 	}
 
 	return nil
 }
 
-func saveManifest(ctx context.Context, j *files.JobInfo, final bool) (*files.VolumeInfo, error) {
-	manifestmutex.Lock()
-	defer manifestmutex.Unlock()
+// volumesInFlight counts the volumes of a backup on the VolumesInFlight gauge, so the ones still in flight
+// when the backup stops can be taken off it.
+type volumesInFlight struct {
+	count atomic.Int64
+}
+
+func (v *volumesInFlight) add() {
+	v.count.Add(1)
+	metrics.VolumesInFlight.Inc()
+}
+
+func (v *volumesInFlight) done() {
+	v.count.Add(-1)
+	metrics.VolumesInFlight.Dec()
+}
+
+// release will take the volumes still in flight off the gauge.
+func (v *volumesInFlight) release() {
+	metrics.VolumesInFlight.Sub(float64(v.count.Swap(0)))
+}
+
+// finishDestinations will record the job's failed destinations, marking the backups to them incomplete.
+func (e *Engine) finishDestinations(jobInfo *files.JobInfo, failures *failureTracker) {
+	for _, destination := range jobInfo.Destinations {
+		if !failures.hasFailed(destination) {
+			if destination != backends.DeleteBackendPrefix+"://" {
+				metrics.LastSuccess.WithLabelValues(jobInfo.VolumeName, backends.RedactURI(destination)).SetToCurrentTime()
+			}
+			continue
+		}
+		if isReplicationTarget(destination) {
+			e.logger.Warnf("Replication to %s failed, it can be completed with a later backup.", destination)
+			jobInfo.FailedDestinations = append(jobInfo.FailedDestinations, destination)
+			continue
+		}
+		e.logger.Warnf("Backup to %s is incomplete, it can be completed with a later backup or the copy command.", destination)
+		jobInfo.FailedDestinations = append(jobInfo.FailedDestinations, destination)
+		if err := e.markIncomplete(jobInfo, destination); err != nil {
+			e.logger.Warnf("Could not mark backup as incomplete in the local cache for %s due to error - %v", destination, err)
+		}
+	}
+}
+
+func (e *Engine) saveManifest(ctx context.Context, j *files.JobInfo, final bool) (*files.VolumeInfo, error) {
+	e.manifestMutex.Lock()
+	defer e.manifestMutex.Unlock()
 	sort.Sort(files.ByVolumeNumber(j.Volumes))
 
 	// Setup Manifest File
-	manifest, err := files.CreateManifestVolume(ctx, j)
+	manifest, err := files.CreateManifestVolume(ctx, j, e.volumeOptions())
 	if err != nil {
-		zap.S().Errorf("Error trying to create manifest volume - %v", err)
+		e.logger.Errorf("Error trying to create manifest volume - %v", err)
 		return nil, err
 	}
 	// nolint:gosec // MD5 not used for cryptographic purposes here
@@ -448,38 +753,35 @@ func saveManifest(ctx context.Context, j *files.JobInfo, final bool) (*files.Vol
 	manifest.IsFinalManifest = final
 	jsonEnc := json.NewEncoder(manifest)
 	if err = jsonEnc.Encode(j); err != nil {
-		zap.S().Errorf("Could not JSON Encode job information due to error - %v", err)
+		e.logger.Errorf("Could not JSON Encode job information due to error - %v", err)
 		return nil, err
 	}
 	if err = manifest.Close(); err != nil {
-		zap.S().Errorf("Could not close manifest volume due to error - %v", err)
+		e.logger.Errorf("Could not close manifest volume due to error - %v", err)
 		return nil, err
 	}
 
 	for _, destination := range j.Destinations {
-		if destination == backends.DeleteBackendPrefix+"://" {
+		if destination == backends.DeleteBackendPrefix+"://" || isReplicationTarget(destination) {
 			continue
 		}
-		// nolint:gosec // MD5 not used for cryptographic purposes here
-		safeFolder := fmt.Sprintf("%x", md5.Sum([]byte(destination)))
-		dest := filepath.Join(config.WorkingDir, "cache", safeFolder, safeManifestFile)
+		dest := filepath.Join(e.cachePath(destination), safeManifestFile)
 		if err = manifest.CopyTo(dest); err != nil {
-			zap.S().Warnf("Could not write manifest volume due to error - %v", err)
+			e.logger.Warnf("Could not write manifest volume due to error - %v", err)
 			return nil, err
 		}
-		zap.S().Debugf("Copied manifest to local cache for destination %s.", destination)
+		e.logger.Debugf("Copied manifest to local cache for destination %s.", destination)
 	}
 	return manifest, nil
 }
 
 // nolint:funlen,gocyclo // Difficult to break this apart
-func sendStream(ctx context.Context, j *files.JobInfo, c chan<- *files.VolumeInfo, buffer <-chan bool) error {
+func (e *Engine) sendStream(
+	ctx context.Context, j *files.JobInfo, c chan<- *files.VolumeInfo, buffer <-chan bool, inFlight *volumesInFlight,
+) error {
 	group, ctx := errgroup.WithContext(ctx)
 
-	cmd := zfs.GetZFSSendCommand(ctx, j)
 	cin, cout := io.Pipe()
-	cmd.Stdout = cout
-	cmd.Stderr = os.Stderr
 	counter := datacounter.NewReaderCounter(cin)
 	usingPipe := false
 	if j.MaxFileBuffer == 0 {
@@ -491,48 +793,52 @@ func sendStream(ctx context.Context, j *files.JobInfo, c chan<- *files.VolumeInf
 		bar = progressbar.DefaultBytes(-1, "uploading")
 	}
 
-	group.Go(func() error {
+	group.Go(func() (err error) {
 		var lastTotalBytes uint64
 		defer close(c)
-		var err error
+		// Stop the send if we can no longer read from it
+		defer func() { cin.CloseWithError(err) }()
 		var volume *files.VolumeInfo
 		skipBytes, volNum := j.TotalBytesStreamedAndVols()
 		lastTotalBytes = skipBytes
 		for {
 			// Skip bytes if we are resuming
 			if skipBytes > 0 {
-				zap.S().Debugf("Want to skip %d bytes.", skipBytes)
+				e.logger.Debugf("Want to skip %d bytes.", skipBytes)
 				written, serr := io.CopyN(io.Discard, counter, int64(skipBytes))
 				if serr != nil && serr != io.EOF {
-					zap.S().Errorf("Error while trying to read from the zfs stream to skip %d bytes - %v", skipBytes, serr)
+					e.logger.Errorf("Error while trying to read from the zfs stream to skip %d bytes - %v", skipBytes, serr)
 					return serr
 				}
 				skipBytes -= uint64(written)
-				zap.S().Debugf("Skipped %d bytes of the ZFS send stream.", written)
+				e.logger.Debugf("Skipped %d bytes of the ZFS send stream.", written)
 				continue
 			}
 
 			// Setup next Volume
 			if volume == nil || volume.Counter() >= (j.VolumeSize*humanize.MiByte)-50*humanize.KiByte {
 				if volume != nil {
-					zap.S().Debugf("Finished creating volume %s", volume.ObjectName)
+					e.logger.Debugf("Finished creating volume %s", volume.ObjectName)
 					volume.ZFSStreamBytes = counter.Count() - lastTotalBytes
+					metrics.ZFSStreamBytes.WithLabelValues(j.VolumeName).Add(float64(volume.ZFSStreamBytes))
 					lastTotalBytes = counter.Count()
 					if err = volume.Close(); err != nil {
-						zap.S().Errorf("Error while trying to close volume %s - %v", volume.ObjectName, err)
+						e.logger.Errorf("Error while trying to close volume %s - %v", volume.ObjectName, err)
 						return err
 					}
+					e.emitVolumeCreated(ctx, j, volume)
 					if !usingPipe {
 						c <- volume
 					}
 				}
 				<-buffer
-				volume, err = files.CreateBackupVolume(ctx, j, volNum)
+				volume, err = files.CreateBackupVolume(ctx, j, volNum, e.volumeOptions())
 				if err != nil {
-					zap.S().Errorf("Error while creating volume %d - %v", volNum, err)
+					e.logger.Errorf("Error while creating volume %d - %v", volNum, err)
 					return err
 				}
-				zap.S().Debugf("Starting volume %s", volume.ObjectName)
+				e.logger.Debugf("Starting volume %s", volume.ObjectName)
+				inFlight.add()
 				volNum++
 				if usingPipe {
 					c <- volume
@@ -543,120 +849,111 @@ func sendStream(ctx context.Context, j *files.JobInfo, c chan<- *files.VolumeInf
 			_, ierr := io.CopyN(io.MultiWriter(volume, bar), counter, files.BufferSize*2)
 			if errors.Is(ierr, io.EOF) {
 				// We are done!
-				zap.S().Debugf("Finished creating volume %s", volume.ObjectName)
+				e.logger.Debugf("Finished creating volume %s", volume.ObjectName)
 				volume.ZFSStreamBytes = counter.Count() - lastTotalBytes
+				metrics.ZFSStreamBytes.WithLabelValues(j.VolumeName).Add(float64(volume.ZFSStreamBytes))
 				if err = volume.Close(); err != nil {
-					zap.S().Errorf("Error while trying to close volume %s - %v", volume.ObjectName, err)
+					e.logger.Errorf("Error while trying to close volume %s - %v", volume.ObjectName, err)
 					return err
 				}
+				e.emitVolumeCreated(ctx, j, volume)
 				if !usingPipe {
 					c <- volume
 				}
 				return nil
 			} else if ierr != nil {
-				zap.S().Errorf("Error while trying to read from the zfs stream for volume %s - %v", volume.ObjectName, ierr)
+				e.logger.Errorf("Error while trying to read from the zfs stream for volume %s - %v", volume.ObjectName, ierr)
 				return ierr
 			}
 		}
 	})
 
-	// Start the zfs send command
-	zap.S().Infof("Starting zfs send command: %s", strings.Join(cmd.Args, " "))
-	if err := cmd.Start(); err != nil {
-		zap.S().Errorf("Error starting zfs command - %v", err)
-		return err
-	}
-
+	// Run the zfs send
 	group.Go(func() error {
-		defer cout.Close()
-		return cmd.Wait()
+		err := e.zfs.Send(ctx, j, cout)
+		cout.CloseWithError(err)
+		return err
 	})
 
-	defer func() {
-		if cmd.ProcessState == nil || !cmd.ProcessState.Exited() {
-			if err := cmd.Process.Kill(); err != nil {
-				zap.S().Errorf("Could not kill zfs send command due to error - %v", err)
-				return
-			}
-
-			if err := cmd.Process.Release(); err != nil {
-				zap.S().Errorf("Could not release resources from zfs send command due to error - %v", err)
-				return
-			}
-		}
-	}()
-
-	manifestmutex.Lock()
-	j.ZFSCommandLine = strings.Join(cmd.Args, " ")
-	manifestmutex.Unlock()
+	e.manifestMutex.Lock()
+	j.ZFSCommandLine = zfs.SendCommandLine(j)
+	e.manifestMutex.Unlock()
 	// Wait for the command to finish
 
 	if err := group.Wait(); err != nil {
-		zap.S().Errorf("Error waiting for zfs command to finish - %v", err)
+		e.logger.Errorf("Error waiting for zfs command to finish - %v", err)
 		return err
 	}
-	zap.S().Infof("zfs send completed without error")
-	manifestmutex.Lock()
+	e.logger.Infof("zfs send completed without error")
+	e.manifestMutex.Lock()
 	j.ZFSStreamBytes = counter.Count()
-	manifestmutex.Unlock()
+	e.manifestMutex.Unlock()
 	return nil
 }
 
-func tryResume(ctx context.Context, j *files.JobInfo) error {
+func (e *Engine) tryResume(ctx context.Context, j *files.JobInfo) error {
 	// Temproary Final Manifest File
-	manifest, merr := files.CreateManifestVolume(ctx, j)
+	manifest, merr := files.CreateManifestVolume(ctx, j, e.volumeOptions())
 	if merr != nil {
-		zap.S().Errorf("Error trying to create manifest volume - %v", merr)
+		e.logger.Errorf("Error trying to create manifest volume - %v", merr)
 		return merr
 	}
 	defer func() {
 		if err := manifest.Close(); err != nil {
-			zap.S().Warnf("Could not close the temporary manifest volume - %v", err)
+			e.logger.Warnf("Could not close the temporary manifest volume - %v", err)
 		}
 		if err := manifest.DeleteVolume(); err != nil {
-			zap.S().Warnf("Could not delete the temporary manifest volume - %v", err)
+			e.logger.Warnf("Could not delete the temporary manifest volume - %v", err)
 		}
 	}()
 
 	// nolint:gosec // MD5 not used for cryptographic purposes here
 	safeManifestFile := fmt.Sprintf("%x", md5.Sum([]byte(manifest.ObjectName)))
 
-	destination := j.Destinations[0]
-	safeFolder := fmt.Sprintf("%x", md5.Sum([]byte(destination))) // nolint:gosec // MD5 not used for cryptographic purposes here
-	origManiPath := filepath.Join(config.WorkingDir, "cache", safeFolder, safeManifestFile)
+	localCachePath, err := e.getCacheDir(j.Destinations[0])
+	if err != nil {
+		return err
+	}
+	origManiPath := filepath.Join(localCachePath, safeManifestFile)
 
 	switch originalManifest, oerr := readManifest(ctx, origManiPath, j); {
 	case os.IsNotExist(oerr):
-		zap.S().Info("No previous manifest file exists, nothing to resume")
+		e.logger.Info("No previous manifest file exists, nothing to resume")
 	case oerr != nil:
-		zap.S().Errorf("Could not open previous manifest file %s due to error: %v", origManiPath, oerr)
+		e.logger.Errorf("Could not open previous manifest file %s due to error: %v", origManiPath, oerr)
 		return oerr
 	default:
-		currentCMD := zfs.GetZFSSendCommand(ctx, j)
-		oldCMD := zfs.GetZFSSendCommand(ctx, originalManifest)
-		oldCMDLine := strings.Join(currentCMD.Args, " ")
-		currentCMDLine := strings.Join(oldCMD.Args, " ")
+		oldCMDLine := zfs.SendCommandLine(originalManifest)
+		currentCMDLine := zfs.SendCommandLine(j)
 		if strings.Compare(oldCMDLine, currentCMDLine) != 0 {
-			zap.S().Errorf(
+			e.logger.Errorf(
 				"Cannot resume backup, different options given for zfs send command: `%s` != current `%s`",
 				oldCMDLine, currentCMDLine,
 			)
 			return fmt.Errorf("option mismatch")
 		}
 
-		manifestmutex.Lock()
+		e.manifestMutex.Lock()
 		j.Volumes = originalManifest.Volumes
 		j.StartTime = originalManifest.StartTime
-		manifestmutex.Unlock()
-		zap.S().Infof("Will be resuming previous backup attempt.")
+		e.manifestMutex.Unlock()
+		e.logger.Infof("Will be resuming previous backup attempt.")
 	}
 	return nil
 }
 
-func retryUploadChainer(ctx context.Context, in <-chan *files.VolumeInfo, b backends.Backend, j *files.JobInfo, dest string) (<-chan *files.VolumeInfo, *errgroup.Group) {
+func (e *Engine) retryUploadChainer(
+	ctx context.Context,
+	in <-chan *files.VolumeInfo,
+	b backends.Backend,
+	j *files.JobInfo,
+	dest string,
+	failures *failureTracker,
+) (<-chan *files.VolumeInfo, *errgroup.Group) {
 	out := make(chan *files.VolumeInfo)
 	parts := strings.Split(dest, "://")
 	prefix := parts[0]
+	isDelete := dest == backends.DeleteBackendPrefix+"://"
 	gwg, ctx := errgroup.WithContext(ctx)
 
 	var wg sync.WaitGroup
@@ -664,35 +961,72 @@ func retryUploadChainer(ctx context.Context, in <-chan *files.VolumeInfo, b back
 	for i := 0; i < j.MaxParallelUploads; i++ {
 		gwg.Go(func() error {
 			defer wg.Done()
-			for vol := range in {
+			for {
 				select {
 				case <-ctx.Done():
 					return ctx.Err()
-				default:
-					zap.S().Debugf("%s backend: Processing volume %s", prefix, vol.ObjectName)
+				case vol, ok := <-in:
+					if !ok {
+						return nil
+					}
+					if failures.hasFailed(dest) {
+						e.logger.Debugf("%s backend: Skipping volume %s for incomplete destination", prefix, vol.ObjectName)
+						out <- vol
+						continue
+					}
+					e.logger.Debugf("%s backend: Processing volume %s", prefix, vol.ObjectName)
 					// Prepare the backoff retryer (forces the user configured retry options across all backends)
 					be := backoff.NewExponentialBackOff()
 					be.MaxInterval = j.MaxBackoffTime
 					be.MaxElapsedTime = j.MaxRetryTime
 					retryconf := backoff.WithContext(be, ctx)
 
-					operation := volUploadWrapper(ctx, b, vol, prefix)
-					if err := backoff.Retry(operation, retryconf); err != nil {
-						zap.S().Errorf("%s backend: Failed to upload volume %s due to error: %v", prefix, vol.ObjectName, err)
-						return err
+					vol.SetRateLimiter(j.UploadLimiters[dest])
+					operation := e.volUploadWrapper(ctx, b, vol, prefix)
+					onRetry := func(err error, next time.Duration) {
+						e.logger.Debugf("%s backend: Retrying upload of volume %s in %v due to error: %v", prefix, vol.ObjectName, next, err)
+						if !isDelete {
+							metrics.UploadRetries.WithLabelValues(backends.RedactURI(dest)).Inc()
+							e.emitRetry(ctx, j, dest, vol, next, err)
+						}
+					}
+					start := time.Now()
+					if err := backoff.RetryNotify(operation, retryconf, onRetry); err != nil {
+						e.logger.Errorf("%s backend: Failed to upload volume %s due to error: %v", prefix, vol.ObjectName, err)
+						if ctx.Err() != nil {
+							return err
+						}
+						e.emitError(ctx, operationSend, j, dest, err)
+						if ferr := failures.fail(dest, err); ferr != nil {
+							return ferr
+						}
+						e.logger.Warnf("%s backend: Continuing without destination %s", prefix, dest)
+					} else {
+						e.logger.Debugf("%s backend: Processed volume %s", prefix, vol.ObjectName)
+						if !isDelete {
+							metrics.UploadDuration.WithLabelValues(backends.RedactURI(dest)).Observe(time.Since(start).Seconds())
+							metrics.UploadedBytes.WithLabelValues(backends.RedactURI(dest)).Add(float64(vol.Size))
+							e.eventStream(ctx).Emit(&events.Event{
+								Type:        events.VolumeUploaded,
+								VolumeName:  j.VolumeName,
+								Snapshot:    j.BaseSnapshot.Name,
+								Destination: backends.RedactURI(dest),
+								ObjectName:  vol.ObjectName,
+								Volume:      vol.VolumeNumber,
+								Bytes:       vol.Size,
+							})
+						}
 					}
-					zap.S().Debugf("%s backend: Processed volume %s", prefix, vol.ObjectName)
 
 					out <- vol
 				}
 			}
-			return nil
 		})
 	}
 
 	gwg.Go(func() error {
 		wg.Wait()
-		zap.S().Debugf("%s backend: closing out channel.", prefix)
+		e.logger.Debugf("%s backend: closing out channel.", prefix)
 		close(out)
 		return nil
 	})
@@ -700,17 +1034,109 @@ func retryUploadChainer(ctx context.Context, in <-chan *files.VolumeInfo, b back
 	return out, gwg
 }
 
-func volUploadWrapper(ctx context.Context, b backends.Backend, vol *files.VolumeInfo, prefix string) func() error {
+// fanOutVolumes will send every volume received from in to each of the destination inputs at once, and
+// pass the original volume along on the returned channel once every destination output has returned it.
+func (e *Engine) fanOutVolumes(
+	ctx context.Context,
+	group *errgroup.Group,
+	in <-chan *files.VolumeInfo,
+	inputs []chan<- *files.VolumeInfo,
+	outputs []<-chan *files.VolumeInfo,
+) <-chan *files.VolumeInfo {
+	out := make(chan *files.VolumeInfo)
+
+	var pendingMutex sync.Mutex
+	pending := make(map[string]*files.VolumeInfo)
+	remaining := make(map[string]int)
+
+	group.Go(func() error {
+		defer func() {
+			for _, input := range inputs {
+				close(input)
+			}
+		}()
+		for {
+			var vol *files.VolumeInfo
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return nil
+				}
+				vol = v
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			pendingMutex.Lock()
+			pending[vol.ObjectName] = vol
+			remaining[vol.ObjectName] = len(inputs)
+			pendingMutex.Unlock()
+
+			for _, input := range inputs {
+				// Each destination reads and closes its own copy of the volume
+				dup := vol
+				if len(inputs) > 1 {
+					dup = vol.Duplicate()
+				}
+				select {
+				case input <- dup:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(len(outputs))
+	for _, output := range outputs {
+		group.Go(func() error {
+			defer wg.Done()
+			for dup := range output {
+				pendingMutex.Lock()
+				remaining[dup.ObjectName]--
+				done := remaining[dup.ObjectName] == 0
+				vol := pending[dup.ObjectName]
+				if done {
+					delete(pending, dup.ObjectName)
+					delete(remaining, dup.ObjectName)
+				}
+				pendingMutex.Unlock()
+
+				if !done {
+					continue
+				}
+				e.logger.Debugf("Volume %s has been processed by all destinations.", vol.ObjectName)
+				select {
+				case out <- vol:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+	}
+
+	group.Go(func() error {
+		wg.Wait()
+		close(out)
+		return nil
+	})
+
+	return out
+}
+
+func (e *Engine) volUploadWrapper(ctx context.Context, b backends.Backend, vol *files.VolumeInfo, prefix string) func() error {
 	return func() error {
 		if err := vol.OpenVolume(); err != nil {
-			zap.S().Debugf("%s: Error while opening volume %s - %v", prefix, vol.ObjectName, err)
+			e.logger.Debugf("%s: Error while opening volume %s - %v", prefix, vol.ObjectName, err)
 			return err
 		}
 		defer vol.Close()
 
 		err := b.Upload(ctx, vol)
 		if err != nil {
-			zap.S().Debugf("%s: Error while uploading volume %s - %v", prefix, vol.ObjectName, err)
+			e.logger.Debugf("%s: Error while uploading volume %s - %v", prefix, vol.ObjectName, err)
 		}
 		return err
 	}
@@ -21,79 +21,82 @@
 package backup
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"os"
-	"os/exec"
+	"net/http"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/someone1/zfsbackup-go/backends"
 	"github.com/someone1/zfsbackup-go/compencrypt"
-	"github.com/someone1/zfsbackup-go/config"
+	"github.com/someone1/zfsbackup-go/events"
 	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/metrics"
+	"github.com/someone1/zfsbackup-go/notify"
+	"github.com/someone1/zfsbackup-go/report"
+	"github.com/someone1/zfsbackup-go/s3fake"
 	"github.com/someone1/zfsbackup-go/zfs"
 )
 
-func fakeZfsSendCommand(ctx context.Context, _ *files.JobInfo) *exec.Cmd {
-	cs := []string{"run", "./mock_zfs/mock.go"}
-	cmd := exec.CommandContext(ctx, "go", cs...)
-	cmd.Env = append(os.Environ(), "MODE=SEND")
-	return cmd
-}
-
-func fakeZfsReceiveCommand(ctx context.Context, _ *files.JobInfo) *exec.Cmd {
-	cs := []string{"run", "./mock_zfs/mock.go"}
-	cmd := exec.CommandContext(ctx, "go", cs...)
-	cmd.Env = append(os.Environ(), "MODE=RECEIVE")
-	return cmd
+// newTestPool will return a fake pool with the dataset, and a snapshot of it for each name given.
+// Every dataset and snapshot created in the pool is a minute newer than the last, starting an hour ago.
+func newTestPool(t *testing.T, dataset string, snapshots ...string) *zfs.FakePool {
+	t.Helper()
+	now := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	pool := zfs.NewFakePool()
+	pool.Now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	pool.CreateDataset(dataset, nil)
+	for _, name := range snapshots {
+		mustSnapshot(t, pool, dataset, name)
+	}
+	return pool
 }
 
-func SetupMocks(info files.SnapshotInfo) func() {
-	l, _ := zap.NewDevelopment()
-	zap.ReplaceGlobals(l)
-
-	origSendCommand := zfs.GetZFSSendCommand
-	zfs.GetZFSSendCommand = fakeZfsSendCommand
-
-	origReceiveCommand := zfs.GetZFSReceiveCommand
-	zfs.GetZFSReceiveCommand = fakeZfsReceiveCommand
-
-	origsSnapshotCommand := zfs.GetSnapshotsAndBookmarks
-	zfs.GetSnapshotsAndBookmarks = func(_ context.Context, _ string) ([]files.SnapshotInfo, error) {
-		return []files.SnapshotInfo{info}, nil
+// mustSnapshot will create the snapshot and return its SnapshotInfo.
+func mustSnapshot(t *testing.T, pool *zfs.FakePool, dataset, name string) files.SnapshotInfo {
+	t.Helper()
+	if err := pool.Snapshot(context.Background(), fmt.Sprintf("%s@%s", dataset, name)); err != nil {
+		t.Fatal(err)
 	}
-
-	origGetCreationDate := zfs.GetCreationDate
-	zfs.GetCreationDate = func(ctx context.Context, target string) (time.Time, error) {
-		return info.CreationTime, nil
+	snapshots, err := pool.GetSnapshotsAndBookmarks(context.Background(), fmt.Sprintf("%s@%s", dataset, name))
+	if err != nil {
+		t.Fatal(err)
 	}
+	return snapshots[0]
+}
 
-	return func() {
-		zfs.GetZFSSendCommand = origSendCommand
-		zfs.GetZFSReceiveCommand = origReceiveCommand
-		zfs.GetSnapshotsAndBookmarks = origsSnapshotCommand
-		zfs.GetCreationDate = origGetCreationDate
+// newTestEngine will return an Engine working in a temporary directory that runs zfs commands with the runner.
+func newTestEngine(t *testing.T, runner zfs.Runner, opts ...Option) *Engine {
+	t.Helper()
+	l, _ := zap.NewDevelopment()
+	e, err := NewEngine(t.TempDir(), append([]Option{WithLogger(l.Sugar()), WithZFS(runner)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
 	}
+	return e
 }
 
 func TestBackup(t *testing.T) {
-	baseSnapshot := files.SnapshotInfo{Name: "tank/test@snap1", CreationTime: time.Now()}
+	pool := newTestPool(t, "tank/test")
+	pool.StreamSize = 5 * 1024 * 1024
+	baseSnapshot := mustSnapshot(t, pool, "tank/test", "snap1")
 
-	undo := SetupMocks(baseSnapshot)
-	defer undo()
+	e := newTestEngine(t, pool)
 	defer backends.MockBackendImpl.Reset()
 
-	tempDir, _ := os.MkdirTemp("", "backup")
-	defer os.RemoveAll(tempDir)
-
-	config.WorkingDir = tempDir
-
 	// Create a job info for testing
 	jobInfo := &files.JobInfo{
 		VolumeName:         "tank/test",
@@ -109,14 +112,14 @@ func TestBackup(t *testing.T) {
 		ProgressBar:        true,
 	}
 
-	err := Backup(t.Context(), jobInfo)
+	err := e.Backup(t.Context(), jobInfo)
 	assert.NoError(t, err)
 
 	assert.NotZero(t, len(jobInfo.Volumes), "Expected at least one volume to be created")
 	assert.NotZero(t, jobInfo.ZFSStreamBytes, "Expected ZFS stream bytes to be recorded")
 	assert.True(t, jobInfo.EndTime.After(jobInfo.StartTime), "Expected end time to be after start time")
 
-	file, _ := backends.MockBackendImpl.Download(t.Context(), "tank/testtank/test@snap1.manifest.gz.bin")
+	file, _ := backends.MockBackendImpl.Download(t.Context(), jobInfo.ManifestObjectName())
 	r, err := compencrypt.NewDecryptAndDecompressReader(file, []byte(jobInfo.AesEncryptionKey))
 	assert.NoError(t, err)
 
@@ -145,6 +148,381 @@ func TestBackup(t *testing.T) {
 		t.Logf("Read %s: %d", fileName, len(content))
 	}
 
-	err = Receive(t.Context(), jobInfo)
+	jobInfo.LocalVolume = "tank/restored"
+	err = e.Receive(t.Context(), jobInfo)
+	assert.NoError(t, err)
+
+	sent, err := pool.GetZFSProperty(t.Context(), "guid", "tank/test@snap1")
 	assert.NoError(t, err)
+	received, err := pool.GetZFSProperty(t.Context(), "guid", "tank/restored@snap1")
+	assert.NoError(t, err)
+	assert.Equal(t, sent, received)
+}
+
+func TestBackupMissingSnapshot(t *testing.T) {
+	pool := newTestPool(t, "tank/test")
+	baseSnapshot := mustSnapshot(t, pool, "tank/test", "snap1")
+
+	e := newTestEngine(t, pool)
+	defer backends.MockBackendImpl.Reset()
+
+	jobInfo := &files.JobInfo{
+		VolumeName:         "tank/test",
+		VolumeSize:         1, // 1 MiB
+		UploadChunkSize:    1,
+		Destinations:       []string{fmt.Sprintf("%s://test", backends.MockBackendPrefix)},
+		BaseSnapshot:       files.SnapshotInfo{Name: "missing"},
+		MaxParallelUploads: 5,
+		MaxBackoffTime:     5 * time.Millisecond,
+		MaxRetryTime:       1 * time.Second,
+		StartTime:          time.Now(),
+	}
+	assert.ErrorIs(t, e.Backup(t.Context(), jobInfo), ErrSnapshotNotFound)
+
+	jobInfo.BaseSnapshot = baseSnapshot
+	jobInfo.IncrementalSnapshot = files.SnapshotInfo{Name: "missing"}
+	assert.ErrorIs(t, e.Backup(t.Context(), jobInfo), ErrSnapshotNotFound)
+}
+
+func TestProcessSmartOptions(t *testing.T) {
+	pool := newTestPool(t, "tank/test")
+	snap1 := mustSnapshot(t, pool, "tank/test", "snap1")
+
+	recorder := new(report.Recorder)
+	e := newTestEngine(t, pool, WithJSONOutput(recorder))
+	destA := fmt.Sprintf("%s://%s", backends.FileBackendPrefix, t.TempDir())
+	destB := fmt.Sprintf("%s://%s", backends.FileBackendPrefix, t.TempDir())
+	destC := fmt.Sprintf("%s://%s", backends.FileBackendPrefix, t.TempDir())
+
+	newJob := func(destinations ...string) *files.JobInfo {
+		return &files.JobInfo{
+			VolumeName:         "tank/test",
+			VolumeSize:         1,
+			UploadChunkSize:    1,
+			Destinations:       destinations,
+			MaxParallelUploads: 5,
+			MaxFileBuffer:      5,
+			MaxBackoffTime:     5 * time.Millisecond,
+			MaxRetryTime:       1 * time.Second,
+			StartTime:          time.Now(),
+			ManifestPrefix:     "manifests",
+			Separator:          "|",
+			FullIfOlderThan:    24 * time.Hour,
+		}
+	}
+
+	// Only the first destination gets the initial full backup
+	jobs, err := e.ProcessSmartOptions(context.Background(), newJob(destA))
+	if !assert.NoError(t, err) || !assert.Len(t, jobs, 1) {
+		return
+	}
+	assert.Empty(t, jobs[0].IncrementalSnapshot.Name)
+	if !assert.NoError(t, e.BackupJobs(context.Background(), jobs)) {
+		return
+	}
+
+	snap2 := mustSnapshot(t, pool, "tank/test", "snap2")
+
+	// The destinations are now out of sync, each should get its own base with a single send per base
+	jobs, err = e.ProcessSmartOptions(context.Background(), newJob(destA, destB, destC))
+	if !assert.NoError(t, err) || !assert.Len(t, jobs, 2) {
+		return
+	}
+	assert.Equal(t, []string{destA}, jobs[0].Destinations)
+	assert.Equal(t, snap1.Name, jobs[0].IncrementalSnapshot.Name)
+	assert.Equal(t, []string{destB, destC}, jobs[1].Destinations)
+	assert.Empty(t, jobs[1].IncrementalSnapshot.Name)
+	for _, job := range jobs {
+		assert.Equal(t, snap2.Name, job.BaseSnapshot.Name)
+	}
+
+	if !assert.NoError(t, e.BackupJobs(context.Background(), jobs)) {
+		return
+	}
+
+	var summary struct {
+		Destinations []destinationSummary
+	}
+	if assert.NoError(t, decodeLastResult(recorder, &summary)) && assert.Len(t, summary.Destinations, 3) {
+		assert.Equal(t, destA, summary.Destinations[0].Destination)
+		if assert.NotNil(t, summary.Destinations[0].IncrementalSnapshot) {
+			assert.Equal(t, snap1.Name, summary.Destinations[0].IncrementalSnapshot.Name)
+		}
+		assert.Nil(t, summary.Destinations[1].IncrementalSnapshot)
+		assert.Nil(t, summary.Destinations[2].IncrementalSnapshot)
+	}
+
+	// Every destination is now up to date
+	_, err = e.ProcessSmartOptions(context.Background(), newJob(destA, destB, destC))
+	assert.ErrorIs(t, err, ErrNoOp)
+}
+
+func TestBackupWriteOnly(t *testing.T) {
+	pool := newTestPool(t, "tank/data")
+	snapshot := mustSnapshot(t, pool, "tank/data", "a")
+	e := newTestEngine(t, pool, WithWriteOnly(), WithOutput(new(bytes.Buffer)))
+
+	// The credentials may only upload objects
+	server := newS3TestServer(t)
+	for _, operation := range []string{s3fake.ListObjectsV2, s3fake.GetObject, s3fake.HeadObject, s3fake.DeleteObject} {
+		server.InjectFault(s3fake.Fault{Operation: operation, Status: http.StatusForbidden, Code: "AccessDenied"})
+	}
+
+	destination := backends.AWSS3BackendPrefix + "://bucket"
+	newJob := func() *files.JobInfo {
+		job := newCleanTestJob(files.SnapshotInfo{}, destination)
+		job.FullIfOlderThan = 24 * time.Hour
+		return job
+	}
+	jobs, err := e.ProcessSmartOptions(context.Background(), newJob())
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, snapshot.Name, jobs[0].BaseSnapshot.Name)
+	require.NoError(t, e.BackupJobs(context.Background(), jobs))
+	uploaded := len(server.Keys("bucket"))
+
+	// The next backup builds on the last one found in the local cache
+	mustSnapshot(t, pool, "tank/data", "b")
+	jobs, err = e.ProcessSmartOptions(context.Background(), newJob())
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, snapshot.Name, jobs[0].IncrementalSnapshot.Name)
+	require.NoError(t, e.BackupJobs(context.Background(), jobs))
+	assert.Greater(t, len(server.Keys("bucket")), uploaded)
+
+	// Cleaning needs credentials that may list and delete
+	assert.Equal(t, "invalid_input", ErrorCode(e.Clean(context.Background(), newJob(), false, "")))
+	for _, operation := range []string{s3fake.ListObjectsV2, s3fake.GetObject, s3fake.HeadObject, s3fake.DeleteObject} {
+		assert.Zero(t, server.Calls(operation), operation)
+	}
+}
+
+func TestBackupDegraded(t *testing.T) {
+	pool := newTestPool(t, "tank/test")
+	snapshot := mustSnapshot(t, pool, "tank/test", "snap1")
+
+	defer backends.MockBackendImpl.Reset()
+
+	backends.MockBackendImpl.UploadMock = func(context.Context, *files.VolumeInfo) error {
+		return errors.New("destination unavailable")
+	}
+	defer func() { backends.MockBackendImpl.UploadMock = nil }()
+
+	hook := new(recordingHook)
+	stream := new(bytes.Buffer)
+	recorder := new(report.Recorder)
+	e := newTestEngine(
+		t,
+		pool,
+		WithJSONOutput(recorder),
+		WithNotifier(notify.NewNotifier([]notify.Hook{hook}, nil)),
+		WithEvents(events.NewStream(stream)),
+	)
+	good := fmt.Sprintf("%s://%s", backends.FileBackendPrefix, t.TempDir())
+	// The destination's options may hold credentials, which must not be exported
+	bad := fmt.Sprintf("%s://test?accountKey=secret", backends.MockBackendPrefix)
+	redactedBad := fmt.Sprintf("%s://test?accountKey=xxxxx", backends.MockBackendPrefix)
+
+	newJob := func(required int) *files.JobInfo {
+		return &files.JobInfo{
+			VolumeName:           "tank/test",
+			VolumeSize:           1,
+			UploadChunkSize:      1,
+			Destinations:         []string{bad, good},
+			BaseSnapshot:         snapshot,
+			MaxParallelUploads:   2,
+			MaxFileBuffer:        5,
+			MaxBackoffTime:       5 * time.Millisecond,
+			MaxRetryTime:         50 * time.Millisecond,
+			StartTime:            time.Now(),
+			ManifestPrefix:       "manifests",
+			Separator:            "|",
+			RequiredDestinations: required,
+		}
+	}
+
+	// Every destination is required by default
+	inFlight := testutil.ToFloat64(metrics.VolumesInFlight)
+	err := e.Backup(context.Background(), newJob(0))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrDegraded)
+	assert.Equal(t, inFlight, testutil.ToFloat64(metrics.VolumesInFlight), "volumes left in flight by the failed backup")
+	if assert.Len(t, hook.events, 2) {
+		assert.Equal(t, notify.Start, hook.events[0].Event)
+		assert.Equal(t, notify.Failure, hook.events[1].Event)
+		assert.Equal(t, err.Error(), hook.events[1].Error)
+	}
+
+	hook.events, hook.payloads = nil, nil
+	recorder.Reset()
+	stream.Reset()
+	jobInfo := newJob(1)
+	if err = e.Backup(context.Background(), jobInfo); !assert.ErrorIs(t, err, ErrDegraded) {
+		return
+	}
+	assert.Equal(t, []string{bad}, jobInfo.FailedDestinations)
+	assert.Equal(t, inFlight, testutil.ToFloat64(metrics.VolumesInFlight), "volumes left in flight by the degraded backup")
+	if assert.Len(t, hook.events, 2) {
+		event := hook.events[1]
+		assert.Equal(t, notify.Degraded, event.Event)
+		assert.Equal(t, "send", event.Operation)
+		assert.Equal(t, []string{redactedBad, good}, event.Destinations)
+		assert.Equal(t, []notify.Link{{VolumeName: "tank/test", Snapshot: "snap1", CreationTime: snapshot.CreationTime}}, event.Chain)
+
+		var payload map[string]interface{}
+		if assert.NoError(t, json.Unmarshal(hook.payloads[1], &payload)) {
+			assert.Equal(t, "degraded", payload["Event"])
+			assert.Equal(t, statusDegraded, payload["Status"])
+			assert.Equal(t, []interface{}{redactedBad}, payload["FailedDestinations"])
+		}
+	}
+
+	var summary struct {
+		Status             string
+		FailedDestinations []string
+		Destinations       []destinationSummary
+	}
+	if assert.NoError(t, decodeLastResult(recorder, &summary)) {
+		assert.Equal(t, statusDegraded, summary.Status)
+		assert.Equal(t, []string{redactedBad}, summary.FailedDestinations)
+		if assert.Len(t, summary.Destinations, 2) {
+			assert.Equal(t, statusIncomplete, summary.Destinations[0].Status)
+			assert.Equal(t, statusComplete, summary.Destinations[1].Status)
+		}
+	}
+
+	assert.NotContains(t, stream.String(), "secret")
+	assert.NotZero(t, testutil.ToFloat64(metrics.UploadRetries.WithLabelValues(redactedBad)))
+
+	seen := make(map[events.Type][]*events.Event)
+	decoder := json.NewDecoder(stream)
+	for decoder.More() {
+		event := new(events.Event)
+		if !assert.NoError(t, decoder.Decode(event)) {
+			break
+		}
+		seen[event.Type] = append(seen[event.Type], event)
+	}
+	assert.NotEmpty(t, seen[events.VolumeCreated])
+	assert.NotEmpty(t, seen[events.RetryScheduled])
+	for _, event := range seen[events.VolumeUploaded] {
+		assert.Equal(t, good, event.Destination)
+	}
+	assert.NotEmpty(t, seen[events.Error])
+	for _, event := range seen[events.Error] {
+		assert.Equal(t, redactedBad, event.Destination)
+		assert.NotEmpty(t, event.Code)
+	}
+
+	// The failed destination's manifest should only be found in its incomplete cache
+	badCache, err := e.getCacheDir(bad)
+	if !assert.NoError(t, err) {
+		return
+	}
+	incomplete, err := getIncompleteManifests(badCache)
+	assert.NoError(t, err)
+	assert.Len(t, incomplete, 1)
+
+	goodCache, err := e.getCacheDir(good)
+	if !assert.NoError(t, err) {
+		return
+	}
+	safeManifests, localOnly, err := e.syncCache(context.Background(), jobInfo, goodCache, mustBackend(t, e, jobInfo, good))
+	assert.NoError(t, err)
+	assert.Len(t, safeManifests, 1)
+	assert.Empty(t, localOnly)
+}
+
+// decodeLastResult will decode the last result recorded to the recorder into v.
+func decodeLastResult(recorder *report.Recorder, v interface{}) error {
+	results := recorder.Results()
+	if len(results) == 0 {
+		return errors.New("no results recorded")
+	}
+	j, err := json.Marshal(results[len(results)-1])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(j, v)
+}
+
+type recordingHook struct {
+	events   []*notify.Event
+	payloads [][]byte
+}
+
+func (r *recordingHook) Notify(_ context.Context, event *notify.Event, payload []byte) error {
+	r.events = append(r.events, event)
+	r.payloads = append(r.payloads, payload)
+	return nil
+}
+
+func (r *recordingHook) String() string {
+	return "recorder"
+}
+
+func mustBackend(t *testing.T, e *Engine, jobInfo *files.JobInfo, destination string) backends.Backend {
+	t.Helper()
+	backend, err := e.prepareBackend(context.Background(), jobInfo, destination, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func TestFanOutVolumes(t *testing.T) {
+	group, ctx := errgroup.WithContext(context.Background())
+
+	in := make(chan *files.VolumeInfo)
+	fastIn, slowIn := make(chan *files.VolumeInfo, 2), make(chan *files.VolumeInfo, 2)
+	fastOut, slowOut := make(chan *files.VolumeInfo), make(chan *files.VolumeInfo)
+	release := make(chan struct{})
+
+	// The fast destination is done with every volume right away while the slow one waits to be released
+	go func() {
+		defer close(fastOut)
+		for vol := range fastIn {
+			fastOut <- vol
+		}
+	}()
+	go func() {
+		defer close(slowOut)
+		for vol := range slowIn {
+			<-release
+			slowOut <- vol
+		}
+	}()
+
+	out := newTestEngine(t, zfs.NewFakePool()).fanOutVolumes(
+		ctx, group, in,
+		[]chan<- *files.VolumeInfo{fastIn, slowIn},
+		[]<-chan *files.VolumeInfo{fastOut, slowOut},
+	)
+
+	vols := []*files.VolumeInfo{{ObjectName: "vol1"}, {ObjectName: "vol2"}}
+	for _, vol := range vols {
+		in <- vol
+	}
+	close(in)
+
+	select {
+	case vol := <-out:
+		t.Fatalf("volume %s was released before every destination was done with it", vol.ObjectName)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	for _, vol := range vols {
+		release <- struct{}{}
+		select {
+		case done := <-out:
+			// The original volume is passed along, not one of the duplicates
+			assert.Same(t, vol, done)
+		case <-time.After(time.Second):
+			t.Fatalf("volume %s was not released", vol.ObjectName)
+		}
+	}
+
+	_, ok := <-out
+	assert.False(t, ok)
+	assert.NoError(t, group.Wait())
 }
@@ -0,0 +1,245 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/events"
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/report"
+)
+
+// retriedErrors will return the errors of the retry events in the stream.
+func retriedErrors(t *testing.T, stream *bytes.Buffer) []string {
+	t.Helper()
+	var retried []string
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		event := new(events.Event)
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), event))
+		if event.Type == events.RetryScheduled {
+			retried = append(retried, event.Error)
+		}
+	}
+	return retried
+}
+
+func countContaining(values []string, substr string) int {
+	count := 0
+	for _, value := range values {
+		if strings.Contains(value, substr) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestChaosBackupRestore(t *testing.T) {
+	source := newTestPool(t, "tank/data")
+	source.StreamSize = 4 * 1024 * 1024
+	snapshot := mustSnapshot(t, source, "tank/data", "a")
+	target := newTestPool(t, "backup")
+	dir := t.TempDir()
+	chaos := func(options string) string {
+		return fmt.Sprintf("%s://%s://%s?%s", backends.ChaosBackendPrefix, backends.FileBackendPrefix, dir, options)
+	}
+
+	// Failed uploads are retried until every volume makes it
+	stream := new(bytes.Buffer)
+	sender := newTestEngine(t, source, WithEvents(events.NewStream(stream)))
+	err := sender.Backup(context.Background(), &files.JobInfo{
+		VolumeName:         "tank/data",
+		BaseSnapshot:       snapshot,
+		VolumeSize:         1,
+		UploadChunkSize:    1,
+		Destinations:       []string{chaos("failUpload=0.5&seed=2")},
+		MaxParallelUploads: 2,
+		MaxFileBuffer:      5,
+		MaxBackoffTime:     5 * time.Millisecond,
+		MaxRetryTime:       5 * time.Second,
+		StartTime:          time.Now(),
+		ManifestPrefix:     "manifests",
+		Separator:          "|",
+	})
+	require.NoError(t, err)
+	assert.NotZero(t, countContaining(retriedErrors(t, stream), backends.ErrChaos.Error()))
+
+	// Corrupted and failed downloads are retried until every volume matches its hash
+	stream.Reset()
+	receiver := newTestEngine(t, target, WithEvents(events.NewStream(stream)))
+	err = receiver.AutoRestore(context.Background(), &files.JobInfo{
+		VolumeName:     "tank/data",
+		LocalVolume:    "backup/data",
+		Destinations:   []string{chaos("corrupt=0.5&failDownload=0.2&skip=manifests&seed=2")},
+		MaxFileBuffer:  5,
+		MaxBackoffTime: 5 * time.Millisecond,
+		MaxRetryTime:   5 * time.Second,
+		ManifestPrefix: "manifests",
+		Separator:      "|",
+		AutoRestore:    true,
+	})
+	require.NoError(t, err)
+	retried := retriedErrors(t, stream)
+	assert.NotZero(t, countContaining(retried, ErrHashMismatch.Error()))
+	assert.NotZero(t, countContaining(retried, backends.ErrChaos.Error()))
+	assert.Equal(t, []string{"a"}, snapshotNames(t, target, "backup/data"))
+}
+
+// uploadedObjects will return the names of the objects uploaded according to the events in the stream.
+func uploadedObjects(t *testing.T, stream *bytes.Buffer) []string {
+	t.Helper()
+	var uploaded []string
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		event := new(events.Event)
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), event))
+		if event.Type == events.VolumeUploaded {
+			uploaded = append(uploaded, event.ObjectName)
+		}
+	}
+	return uploaded
+}
+
+// restoreFrom will restore the backup of tank/data in the destination to a new pool.
+func restoreFrom(t *testing.T, destination string) error {
+	t.Helper()
+	return newTestEngine(t, newTestPool(t, "backup")).AutoRestore(context.Background(), &files.JobInfo{
+		VolumeName:     "tank/data",
+		LocalVolume:    "backup/data",
+		Destinations:   []string{destination},
+		MaxFileBuffer:  5,
+		MaxBackoffTime: 5 * time.Millisecond,
+		MaxRetryTime:   100 * time.Millisecond,
+		ManifestPrefix: "manifests",
+		Separator:      "|",
+		AutoRestore:    true,
+	})
+}
+
+func TestChaosResume(t *testing.T) {
+	source := newTestPool(t, "tank/data")
+	source.StreamSize = 4 * 1024 * 1024
+	snapshot := mustSnapshot(t, source, "tank/data", "a")
+	dir := t.TempDir()
+	chaos := func(options string) string {
+		return fmt.Sprintf("%s://%s://%s?%s", backends.ChaosBackendPrefix, backends.FileBackendPrefix, dir, options)
+	}
+	newJob := func(destination string) *files.JobInfo {
+		job := newCleanTestJob(snapshot, destination)
+		job.MaxParallelUploads = 1
+		job.MaxRetryTime = 100 * time.Millisecond
+		job.Resume = true
+		return job
+	}
+
+	// Every volume but the first fails to upload for good
+	stream := new(bytes.Buffer)
+	e := newTestEngine(t, source, WithEvents(events.NewStream(stream)), WithOutput(new(bytes.Buffer)))
+	first := newJob("").BackupVolumeObjectName(1)
+	require.ErrorIs(t, e.Backup(context.Background(), newJob(chaos("failUpload=1&skip="+url.QueryEscape(first)))), backends.ErrChaos)
+	assert.Equal(t, []string{first}, uploadedObjects(t, stream))
+	require.Error(t, restoreFrom(t, backends.FileBackendPrefix+"://"+dir))
+
+	// Resuming picks up after the volumes that made it, with the failures gone
+	stream.Reset()
+	job := newJob(chaos("seed=1"))
+	require.NoError(t, e.Backup(context.Background(), job))
+	uploaded := uploadedObjects(t, stream)
+	assert.Len(t, uploaded, len(job.Volumes)) // The rest of the volumes and the manifest
+	assert.NotContains(t, uploaded, first)
+	require.NoError(t, restoreFrom(t, backends.FileBackendPrefix+"://"+dir))
+}
+
+func TestChaosTruncatedUpload(t *testing.T) {
+	source := newTestPool(t, "tank/data")
+	source.StreamSize = 2 * 1024 * 1024
+	snapshot := mustSnapshot(t, source, "tank/data", "a")
+	dir := t.TempDir()
+	destination := fmt.Sprintf("%s://%s://%s?truncate=1&skip=manifests&seed=1", backends.ChaosBackendPrefix, backends.FileBackendPrefix, dir)
+
+	// The truncated uploads report success, so the backup does too
+	e := newTestEngine(t, source, WithOutput(new(bytes.Buffer)))
+	require.NoError(t, e.Backup(context.Background(), newCleanTestJob(snapshot, destination)))
+
+	// The volumes do not match the hashes in the manifest, so they are caught instead of being restored
+	require.ErrorIs(t, restoreFrom(t, backends.FileBackendPrefix+"://"+dir), ErrHashMismatch)
+}
+
+func TestChaosCleanInconsistentListing(t *testing.T) {
+	source := newTestPool(t, "tank/data")
+	source.StreamSize = 4 * 1024 * 1024
+	snapshot := mustSnapshot(t, source, "tank/data", "a")
+	dir := t.TempDir()
+	chaos := func(options string) string {
+		return fmt.Sprintf("%s://%s://%s?%s", backends.ChaosBackendPrefix, backends.FileBackendPrefix, dir, options)
+	}
+	recorder := new(report.Recorder)
+	e := newTestEngine(t, source, WithJSONOutput(recorder))
+
+	job := newCleanTestJob(snapshot, backends.FileBackendPrefix+"://"+dir)
+	require.NoError(t, e.Backup(context.Background(), job))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "orphan"), []byte("orphan"), 0600))
+	volumes := make([]string, 0, len(job.Volumes)+1)
+	for _, vol := range job.Volumes {
+		volumes = append(volumes, vol.ObjectName)
+	}
+	volumes = append(volumes, job.ManifestObjectName())
+
+	// Volumes left out of the listing make the backup set look broken, but nothing of it is deleted
+	require.NoError(t, e.Clean(context.Background(), newCleanTestJob(snapshot, chaos("listDrop=0.5&seed=1")), false, ""))
+	var summary cleanSummary
+	require.NoError(t, decodeLastResult(recorder, &summary))
+	assert.NotEmpty(t, summary.BrokenSets)
+	for _, set := range summary.BrokenSets {
+		assert.False(t, set.Removed)
+	}
+	for _, object := range volumes {
+		assert.NotContains(t, summary.DeletedObjects, object)
+		assert.FileExists(t, filepath.Join(dir, object))
+	}
+
+	// Objects that were already deleted can still be listed and deleted again
+	require.NoError(t, e.Clean(context.Background(), newCleanTestJob(snapshot, chaos("seed=1")), false, ""))
+	assert.NoFileExists(t, filepath.Join(dir, "orphan"))
+
+	recorder.Reset()
+	require.NoError(t, e.Clean(context.Background(), newCleanTestJob(snapshot, chaos("listStale=1&seed=1")), false, ""))
+	require.NoError(t, decodeLastResult(recorder, &summary))
+	assert.Equal(t, []string{"orphan"}, summary.DeletedObjects)
+	assert.Empty(t, summary.BrokenSets)
+
+	require.NoError(t, restoreFrom(t, backends.FileBackendPrefix+"://"+dir))
+}
@@ -23,47 +23,106 @@ package backup
 import (
 	"context"
 	"crypto/md5" // nolint:gosec // MD5 not used for cryptographic purposes here
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
-	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/someone1/zfsbackup-go/backends"
 	"github.com/someone1/zfsbackup-go/files"
 )
 
+// cleanSummary is output once a clean completes.
+type cleanSummary struct {
+	DeletedObjects        []string
+	DeletedLocalManifests []string
+	LockedObjects         []string
+	BrokenSets            []brokenSet
+	ExpiredSets           []expiredSet
+	Plan                  *CleanPlan `json:",omitempty"`
+	PlanPath              string     `json:",omitempty"`
+}
+
+// CleanPlan lists the objects a clean would delete from a destination, so someone with credentials that
+// allow deleting can apply it with ApplyCleanPlan.
+type CleanPlan struct {
+	Destination string
+	Created     time.Time
+	Objects     []string
+	// Locked are the objects that were still locked when the plan was created, and until when. They are
+	// skipped rather than deleted if the plan is applied before then.
+	Locked map[string]time.Time `json:",omitempty"`
+}
+
+// expiredSet describes a backup set that is older than the retention recorded for its volume.
+type expiredSet struct {
+	VolumeName          string
+	BaseSnapshot        string
+	IncrementalSnapshot string `json:",omitempty"`
+	Removed             bool
+}
+
+// brokenSet describes a backup set that is missing one of its volumes in the destination.
+type brokenSet struct {
+	VolumeName          string
+	BaseSnapshot        string
+	IncrementalSnapshot string `json:",omitempty"`
+	MissingVolume       string
+	Removed             bool
+}
+
 // Clean will remove files found in the desination that are not found in any of the manifests found locally or in the destination.
 // If cleanLocal is true, then local manifests not found in the destination are ignored and deleted. This function will optionally
 // delete broken backup sets in the destination if the --force flag is provided.
+// If planPath is given, or the Engine is append-only, nothing is deleted from the destination and the objects that would
+// have been are written to planPath as a CleanPlan instead, if given, as well as output.
+// Objects of backup sets whose manifest records they are locked until later are not deleted until then.
+// Backup sets older than the retention recorded in the latest manifest of their volume are deleted too.
 // nolint:funlen,gocyclo // Difficult to break this up
-func Clean(pctx context.Context, jobInfo *files.JobInfo, cleanLocal bool) error {
+func (e *Engine) Clean(pctx context.Context, jobInfo *files.JobInfo, cleanLocal bool, planPath string) (err error) {
+	summary := newCleanSummary()
+	e.notifyStart(pctx, operationClean, jobInfo)
+	defer func() {
+		var result interface{}
+		if err == nil {
+			result = summary
+		}
+		e.notifyDone(pctx, operationClean, err, result, jobInfo)
+	}()
+
 	ctx, cancel := context.WithCancel(pctx)
 	defer cancel()
+	planOnly := e.appendOnly || planPath != ""
 
 	// Prepare the backend client
 	target := jobInfo.Destinations[0]
-	backend, berr := prepareBackend(ctx, jobInfo, target, nil)
+	backend, berr := e.prepareBackend(ctx, jobInfo, target, nil)
 	if berr != nil {
-		zap.S().Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
+		e.logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
 		return berr
 	}
 	defer backend.Close()
 
 	// Get the local cache dir
-	localCachePath, cerr := getCacheDir(target)
+	localCachePath, cerr := e.getCacheDir(target)
 	if cerr != nil {
-		zap.S().Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
+		e.logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
 		return cerr
 	}
 
 	// Sync the local cache
-	safeManifests, localOnlyFiles, serr := syncCache(ctx, jobInfo, localCachePath, backend)
+	safeManifests, localOnlyFiles, serr := e.syncCache(ctx, jobInfo, localCachePath, backend)
 	if serr != nil {
-		zap.S().Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
+		e.logger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
 		return serr
 	}
 
@@ -73,16 +132,27 @@ func Clean(pctx context.Context, jobInfo *files.JobInfo, cleanLocal bool) error
 		manifestPath := filepath.Join(localCachePath, manifest)
 		decodedManifest, oerr := readManifest(ctx, manifestPath, jobInfo)
 		if oerr != nil {
-			zap.S().Errorf("Could not read manifest %s due to error - %v", manifestPath, oerr)
+			e.logger.Errorf("Could not read manifest %s due to error - %v", manifestPath, oerr)
 			return oerr
 		}
 		decodedManifests = append(decodedManifests, decodedManifest)
 	}
+	recordChainMetrics(target, decodedManifests)
+
+	// Objects locked until later cannot be deleted until then
+	now := time.Now()
+	locks := make(map[string]time.Time)
+
+	// Backup sets that have expired are left out so their volumes are deleted like any other object
+	expired := expiredSets(decodedManifests, now)
+	decodedManifests = slices.DeleteFunc(decodedManifests, func(manifest *files.JobInfo) bool {
+		return slices.Contains(expired, manifest)
+	})
 
 	if !cleanLocal {
 		if len(localOnlyFiles) > 0 {
 			// nolint:lll // Long log message
-			zap.S().Debugf(
+			e.logger.Debugf(
 				"There are %d local manifests not found in the destination, use --cleanLocal to delete these locally and any of their volumes found in the destination.",
 				len(localOnlyFiles),
 			)
@@ -90,7 +160,7 @@ func Clean(pctx context.Context, jobInfo *files.JobInfo, cleanLocal bool) error
 				manifestPath := filepath.Join(localCachePath, manifest)
 				decodedManifest, oerr := readManifest(ctx, manifestPath, jobInfo)
 				if oerr != nil {
-					zap.S().Errorf("Could not read manifest %s due to error - %v", manifestPath, oerr)
+					e.logger.Errorf("Could not read manifest %s due to error - %v", manifestPath, oerr)
 					return oerr
 				}
 				decodedManifests = append(decodedManifests, decodedManifest)
@@ -99,19 +169,26 @@ func Clean(pctx context.Context, jobInfo *files.JobInfo, cleanLocal bool) error
 	} else {
 		for _, manifest := range localOnlyFiles {
 			manifestPath := filepath.Join(localCachePath, manifest)
+			// The manifest never made it to the destination, but the volumes that did may still be locked
+			if decodedManifest, oerr := readManifest(ctx, manifestPath, jobInfo); oerr != nil {
+				e.logger.Warnf("Could not read manifest %s due to error - %v. Continuing.", manifestPath, oerr)
+			} else {
+				addLocks(locks, decodedManifest)
+			}
 			err := os.Remove(manifestPath)
 			if err != nil {
-				zap.S().Errorf("Could not delete local manifest %s due to error - %v", manifestPath, err)
+				e.logger.Errorf("Could not delete local manifest %s due to error - %v", manifestPath, err)
 				return err
 			}
-			zap.S().Debugf("Deleted %s.", manifestPath)
+			summary.DeletedLocalManifests = append(summary.DeletedLocalManifests, manifest)
+			e.logger.Debugf("Deleted %s.", manifestPath)
 		}
 	}
 
 	// TODO: The following can be done in a much more efficient way (probably)
 	allObjects, err := backend.List(ctx, "")
 	if err != nil {
-		zap.S().Errorf("Could not list objects in backend %s due to error - %v", target, err)
+		e.logger.Errorf("Could not list objects in backend %s due to error - %v", target, err)
 		return err
 	}
 
@@ -123,7 +200,30 @@ func Clean(pctx context.Context, jobInfo *files.JobInfo, cleanLocal bool) error
 		}
 	}
 
+	for _, manifest := range expired {
+		locked := manifest.LockUntil.After(now)
+		summary.ExpiredSets = append(summary.ExpiredSets, expiredSet{
+			VolumeName:          manifest.VolumeName,
+			BaseSnapshot:        manifest.BaseSnapshot.Name,
+			IncrementalSnapshot: manifest.IncrementalSnapshot.Name,
+			Removed:             !planOnly && !locked,
+		})
+		e.logger.Infof("The following backup set has expired, removing it:\n\n%s", manifest.String())
+		addLocks(locks, manifest)
+		manifestObject, merr := e.dropManifest(ctx, manifest, jobInfo, localCachePath, planOnly || locked)
+		if merr != nil {
+			return merr
+		}
+		allObjects = append(allObjects, manifestObject)
+		if !manifest.LockUntil.IsZero() {
+			locks[manifestObject] = manifest.LockUntil
+		}
+	}
+
 	// Go through all manifests and remove from the allObjects list what we know should exist
+	for _, manifest := range decodedManifests {
+		addLocks(locks, manifest)
+	}
 	for _, manifest := range decodedManifests {
 		for vidx, vol := range manifest.Volumes {
 			found := false
@@ -137,32 +237,28 @@ func Clean(pctx context.Context, jobInfo *files.JobInfo, cleanLocal bool) error
 
 			if !found {
 				// Broken backup set! inform the user!
+				summary.BrokenSets = append(summary.BrokenSets, brokenSet{
+					VolumeName:          manifest.VolumeName,
+					BaseSnapshot:        manifest.BaseSnapshot.Name,
+					IncrementalSnapshot: manifest.IncrementalSnapshot.Name,
+					MissingVolume:       vol.ObjectName,
+					Removed:             jobInfo.Force,
+				})
 				if jobInfo.Force {
-					zap.S().Warnf(
+					locked := manifest.LockUntil.After(now)
+					summary.BrokenSets[len(summary.BrokenSets)-1].Removed = !planOnly && !locked
+					e.logger.Warnf(
 						"The following backup set is missing volume %s. Removing entire backupset:\n\n%s",
 						vol.ObjectName, manifest.String(),
 					)
 
-					// Compute the manifest object name and cache name to delete
-					manifest.ManifestPrefix = jobInfo.ManifestPrefix
-					manifest.AesEncryptionKey = jobInfo.AesEncryptionKey
-					tempManifest, terr := files.CreateManifestVolume(ctx, manifest)
-					if terr != nil {
-						zap.S().Errorf("Could not compute manifest path due to error - %v.", terr)
-						return terr
+					manifestObject, merr := e.dropManifest(ctx, manifest, jobInfo, localCachePath, planOnly || locked)
+					if merr != nil {
+						return merr
 					}
-					allObjects = append(allObjects, tempManifest.ObjectName)
-					if err = tempManifest.Close(); err != nil {
-						zap.S().Warnf("Could not close temporary manifest %v", err)
-					}
-					if err = tempManifest.DeleteVolume(); err != nil {
-						zap.S().Warnf("Could not delete temporary manifest %v", err)
-					}
-					// nolint:gosec // MD5 not used for cryptographic purposes here
-					manifestPath := filepath.Join(localCachePath, fmt.Sprintf("%x", md5.Sum([]byte(tempManifest.ObjectName))))
-					err = os.Remove(manifestPath)
-					if err != nil {
-						zap.S().Errorf("Could not delete local manifest %s due to error - %v. Continuing.", manifestPath, err)
+					allObjects = append(allObjects, manifestObject)
+					if !manifest.LockUntil.IsZero() {
+						locks[manifestObject] = manifest.LockUntil
 					}
 
 					// Delete all volumes already processed in the manifest
@@ -171,7 +267,7 @@ func Clean(pctx context.Context, jobInfo *files.JobInfo, cleanLocal bool) error
 					}
 					break
 				} else {
-					zap.S().Warnf(
+					e.logger.Warnf(
 						"The following backup set is missing volume %s:\n\n%s\n\nPass the --force flag to delete this backup set.",
 						vol.ObjectName, manifest.String(),
 					)
@@ -180,18 +276,294 @@ func Clean(pctx context.Context, jobInfo *files.JobInfo, cleanLocal bool) error
 		}
 	}
 
-	zap.S().Debugf("Starting to delete %d objects in destination.", len(allObjects))
+	locked := lockedObjects(allObjects, locks, now)
+	if planOnly {
+		sort.Strings(allObjects)
+		plan := &CleanPlan{Destination: target, Created: now, Objects: allObjects, Locked: locked}
+		if planPath != "" {
+			if err = writeCleanPlan(planPath, plan); err != nil {
+				e.logger.Errorf("Could not write the clean plan to %s due to error - %v", planPath, err)
+				return err
+			}
+			summary.PlanPath = planPath
+		}
+		// Only the plan file needs the credentials the destination may hold to apply it
+		summary.Plan = &CleanPlan{
+			Destination: backends.RedactURI(target), Created: plan.Created, Objects: plan.Objects, Locked: plan.Locked,
+		}
+		e.logger.Infof("Not deleting %d objects from %s, apply the clean plan to delete them.", len(allObjects), target)
+	} else {
+		summary.DeletedObjects, err = e.deleteObjects(ctx, backend, target, e.skipLocked(summary, allObjects, locked))
+		if err != nil {
+			e.logger.Errorf("Could not finish clean operation due to error, aborting: %v", err)
+			return err
+		}
+	}
+
+	sort.Strings(summary.DeletedObjects)
+	sort.Strings(summary.LockedObjects)
+	e.recordResult(ctx, summary)
+	if !e.jsonOutput {
+		var removed, expiredRemoved int
+		for _, set := range summary.BrokenSets {
+			if set.Removed {
+				removed++
+			}
+		}
+		for _, set := range summary.ExpiredSets {
+			if set.Removed {
+				expiredRemoved++
+			}
+		}
+		fmt.Fprintf(
+			e.stdout,
+			"Done.\n\tObjects Deleted: %d\n\tLocked Objects Skipped: %d\n\tLocal Manifests Deleted: %d\n\tBroken Backup Sets: %d (%d removed)\n"+
+				"\tExpired Backup Sets: %d (%d removed)\n",
+			len(summary.DeletedObjects),
+			len(summary.LockedObjects),
+			len(summary.DeletedLocalManifests),
+			len(summary.BrokenSets),
+			removed,
+			len(summary.ExpiredSets),
+			expiredRemoved,
+		)
+		if summary.Plan != nil {
+			e.printCleanPlan(summary.Plan, planPath)
+		}
+	}
+
+	return nil
+}
+
+// printCleanPlan will output the objects a clean plan deletes, unless they were written to planPath.
+func (e *Engine) printCleanPlan(plan *CleanPlan, planPath string) {
+	if planPath != "" {
+		fmt.Fprintf(e.stdout, "\tObjects To Delete: %d (plan written to %s)\n", len(plan.Objects), planPath)
+		return
+	}
+	fmt.Fprintf(e.stdout, "\tObjects To Delete: %d\n", len(plan.Objects))
+	for _, object := range plan.Objects {
+		if until, ok := plan.Locked[object]; ok {
+			fmt.Fprintf(e.stdout, "\t\t%s (locked until %v)\n", object, until)
+			continue
+		}
+		fmt.Fprintf(e.stdout, "\t\t%s\n", object)
+	}
+}
+
+func newCleanSummary() *cleanSummary {
+	return &cleanSummary{
+		DeletedObjects:        []string{},
+		DeletedLocalManifests: []string{},
+		LockedObjects:         []string{},
+		BrokenSets:            []brokenSet{},
+		ExpiredSets:           []expiredSet{},
+	}
+}
+
+// dropManifest will return the name of the backup set's manifest in the destination so it can be deleted, removing
+// it from the local cache too unless keepCached is set, e.g. as it stays in the destination until a plan is applied.
+func (e *Engine) dropManifest(
+	ctx context.Context, manifest, jobInfo *files.JobInfo, localCachePath string, keepCached bool,
+) (string, error) {
+	manifest.ManifestPrefix = jobInfo.ManifestPrefix
+	manifest.AesEncryptionKey = jobInfo.AesEncryptionKey
+	tempManifest, err := files.CreateManifestVolume(ctx, manifest, e.volumeOptions())
+	if err != nil {
+		e.logger.Errorf("Could not compute manifest path due to error - %v.", err)
+		return "", err
+	}
+	if err = tempManifest.Close(); err != nil {
+		e.logger.Warnf("Could not close temporary manifest %v", err)
+	}
+	if err = tempManifest.DeleteVolume(); err != nil {
+		e.logger.Warnf("Could not delete temporary manifest %v", err)
+	}
+	if !keepCached {
+		// nolint:gosec // MD5 not used for cryptographic purposes here
+		manifestPath := filepath.Join(localCachePath, fmt.Sprintf("%x", md5.Sum([]byte(tempManifest.ObjectName))))
+		if err = os.Remove(manifestPath); err != nil {
+			e.logger.Errorf("Could not delete local manifest %s due to error - %v. Continuing.", manifestPath, err)
+		}
+	}
+	return tempManifest.ObjectName, nil
+}
+
+// expiredSets will return the backup sets that are older than the retention recorded in the latest backup set of
+// their volume. The latest backup set, and those the backup sets kept are incremental from, are never expired.
+func expiredSets(manifests []*files.JobInfo, now time.Time) []*files.JobInfo {
+	volumes := make(map[string][]*files.JobInfo)
+	for _, manifest := range manifests {
+		volumes[manifest.VolumeName] = append(volumes[manifest.VolumeName], manifest)
+	}
+
+	var expired []*files.JobInfo
+	for _, sets := range volumes {
+		sort.SliceStable(sets, func(i, j int) bool {
+			return sets[i].BaseSnapshot.CreationTime.Before(sets[j].BaseSnapshot.CreationTime)
+		})
+		latest := sets[len(sets)-1]
+		if latest.Retention <= 0 {
+			continue
+		}
+		cutoff := now.Add(-latest.Retention)
+
+		// Parents are older than the backup sets incremental from them, so walk back from the latest
+		needed := make(map[string]bool)
+		snapshotKey := func(snapshot files.SnapshotInfo) string {
+			return fmt.Sprintf("%s%v", snapshot.Name, snapshot.CreationTime.UnixNano())
+		}
+		for idx := len(sets) - 1; idx >= 0; idx-- {
+			set := sets[idx]
+			if set == latest || !set.BaseSnapshot.CreationTime.Before(cutoff) || needed[snapshotKey(set.BaseSnapshot)] {
+				if set.IncrementalSnapshot.Name != "" {
+					needed[snapshotKey(set.IncrementalSnapshot)] = true
+				}
+				continue
+			}
+			expired = append(expired, set)
+		}
+	}
+	return expired
+}
+
+// addLocks will record the time the volumes of the backup set are locked until, if they are.
+func addLocks(locks map[string]time.Time, manifest *files.JobInfo) {
+	if manifest.LockUntil.IsZero() {
+		return
+	}
+	for _, vol := range manifest.Volumes {
+		locks[vol.ObjectName] = manifest.LockUntil
+	}
+}
+
+// lockedObjects will return the objects that are still locked at the time given, and until when.
+func lockedObjects(objects []string, locks map[string]time.Time, now time.Time) map[string]time.Time {
+	locked := make(map[string]time.Time)
+	for _, object := range objects {
+		if until, ok := locks[object]; ok && until.After(now) {
+			locked[object] = until
+		}
+	}
+	return locked
+}
+
+// skipLocked will return the objects that can be deleted, adding the ones that are locked to the summary instead.
+func (e *Engine) skipLocked(summary *cleanSummary, objects []string, locked map[string]time.Time) []string {
+	unlocked := make([]string, 0, len(objects))
+	for _, object := range objects {
+		if _, ok := locked[object]; ok {
+			summary.LockedObjects = append(summary.LockedObjects, object)
+			continue
+		}
+		unlocked = append(unlocked, object)
+	}
+	if len(summary.LockedObjects) > 0 {
+		e.logger.Warnf("Not deleting %d objects that are still locked, clean again once their locks expire.", len(summary.LockedObjects))
+	}
+	return unlocked
+}
+
+func writeCleanPlan(path string, plan *CleanPlan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// ReadCleanPlan will read a plan written by Clean.
+func ReadCleanPlan(path string) (*CleanPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plan := new(CleanPlan)
+	if err = json.Unmarshal(data, plan); err != nil {
+		return nil, fmt.Errorf("%w: could not read the clean plan %s - %v", ErrInvalidInput, path, err)
+	}
+	if plan.Destination == "" {
+		return nil, fmt.Errorf("%w: the clean plan %s has no destination", ErrInvalidInput, path)
+	}
+	return plan, nil
+}
+
+// ApplyCleanPlan will delete the objects in the plan from its destination. It cannot be used by an
+// append-only Engine, as the plan is meant to be applied by someone with credentials that allow deleting.
+func (e *Engine) ApplyCleanPlan(pctx context.Context, jobInfo *files.JobInfo, plan *CleanPlan) (err error) {
+	summary := newCleanSummary()
+	jobInfo.Destinations = []string{plan.Destination}
+	e.notifyStart(pctx, operationClean, jobInfo)
+	defer func() {
+		var result interface{}
+		if err == nil {
+			result = summary
+		}
+		e.notifyDone(pctx, operationClean, err, result, jobInfo)
+	}()
+
+	if e.appendOnly {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, backends.ErrAppendOnly)
+	}
+
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	backend, err := e.prepareBackend(ctx, jobInfo, plan.Destination, nil)
+	if err != nil {
+		e.logger.Errorf("Could not initialize backend for target %s due to error - %v.", plan.Destination, err)
+		return err
+	}
+	defer backend.Close()
 
-	// Whatever is left in allObjects was not found in any manifest, delete 'em
-	var group *errgroup.Group
-	group, ctx = errgroup.WithContext(ctx)
+	objects := e.skipLocked(summary, plan.Objects, lockedObjects(plan.Objects, plan.Locked, time.Now()))
+	summary.DeletedObjects, err = e.deleteObjects(ctx, backend, plan.Destination, objects)
+	if err != nil {
+		e.logger.Errorf("Could not finish applying the clean plan due to error, aborting: %v", err)
+		return err
+	}
 
-	deleteChan := make(chan string, len(allObjects))
-	for _, obj := range allObjects {
+	// Drop the cached manifests of the backup sets that were deleted
+	localCachePath, err := e.getCacheDir(plan.Destination)
+	if err != nil {
+		return err
+	}
+	for _, object := range summary.DeletedObjects {
+		if !strings.HasPrefix(object, jobInfo.ManifestPrefix) {
+			continue
+		}
+		// nolint:gosec // MD5 not used for cryptographic purposes here
+		manifestPath := filepath.Join(localCachePath, fmt.Sprintf("%x", md5.Sum([]byte(object))))
+		if rerr := os.Remove(manifestPath); rerr != nil && !os.IsNotExist(rerr) {
+			e.logger.Warnf("Could not delete local manifest %s due to error - %v", manifestPath, rerr)
+		}
+	}
+
+	sort.Strings(summary.DeletedObjects)
+	sort.Strings(summary.LockedObjects)
+	e.recordResult(ctx, summary)
+	if !e.jsonOutput {
+		fmt.Fprintf(
+			e.stdout, "Done.\n\tObjects Deleted: %d\n\tLocked Objects Skipped: %d\n", len(summary.DeletedObjects), len(summary.LockedObjects),
+		)
+	}
+	return nil
+}
+
+// deleteObjects will delete the objects from the backend, retrying failures, and return the ones deleted.
+func (e *Engine) deleteObjects(ctx context.Context, backend backends.Backend, target string, objects []string) ([]string, error) {
+	e.logger.Debugf("Starting to delete %d objects in destination.", len(objects))
+	deleted := []string{}
+	group, ctx := errgroup.WithContext(ctx)
+
+	deleteChan := make(chan string, len(objects))
+	for _, obj := range objects {
 		deleteChan <- obj
 	}
 	close(deleteChan)
 
+	var deletedMutex sync.Mutex
+
 	// Let's not slam the endpoint with a lot of concurrent requests, pick a sensible default and stick to it
 	for i := 0; i < 5; i++ {
 		group.Go(func() error {
@@ -210,27 +582,28 @@ func Clean(pctx context.Context, jobInfo *files.JobInfo, cleanLocal bool) error
 					retryconf := backoff.WithContext(be, ctx)
 
 					operation := func() error {
-						return backend.Delete(ctx, objectPath)
+						err := backend.Delete(ctx, objectPath)
+						if errors.Is(err, backends.ErrAppendOnly) {
+							return backoff.Permanent(err)
+						}
+						return err
 					}
 
 					if berr := backoff.Retry(operation, retryconf); berr != nil {
-						zap.S().Errorf("Could not delete object %s in due to error - %v", objectPath, berr)
+						e.logger.Errorf("Could not delete object %s in due to error - %v", objectPath, berr)
 						return berr
 					}
 
-					zap.S().Debugf("Deleted %s.", filepath.Join(target, objectPath))
+					e.logger.Debugf("Deleted %s.", filepath.Join(target, objectPath))
+					deletedMutex.Lock()
+					deleted = append(deleted, objectPath)
+					deletedMutex.Unlock()
 				}
 			}
 		})
 	}
 
-	zap.S().Debugf("Waiting to delete %d objects in destination.", len(allObjects))
-	err = group.Wait()
-	if err != nil {
-		zap.S().Errorf("Could not finish clean operation due to error, aborting: %v", err)
-		return err
-	}
-
-	zap.S().Debugf("Done.")
-	return nil
+	e.logger.Debugf("Waiting to delete %d objects in destination.", len(objects))
+	err := group.Wait()
+	return deleted, err
 }
@@ -0,0 +1,274 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" // nolint:gosec // MD5 not used for cryptographic purposes here
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/report"
+	"github.com/someone1/zfsbackup-go/s3fake"
+)
+
+func newCleanTestJob(snapshot files.SnapshotInfo, destination string) *files.JobInfo {
+	return &files.JobInfo{
+		VolumeName:         "tank/data",
+		BaseSnapshot:       snapshot,
+		VolumeSize:         1,
+		UploadChunkSize:    5,
+		Destinations:       []string{destination},
+		MaxParallelUploads: 2,
+		MaxFileBuffer:      5,
+		MaxBackoffTime:     5 * time.Millisecond,
+		MaxRetryTime:       time.Second,
+		StartTime:          time.Now(),
+		ManifestPrefix:     "manifests",
+		Separator:          "|",
+	}
+}
+
+// newS3TestServer will serve a fake S3 bucket named bucket that the s3 backend uses for the test.
+func newS3TestServer(t *testing.T) *s3fake.Server {
+	server := s3fake.NewServer("bucket")
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	t.Setenv("AWS_S3_CUSTOM_ENDPOINT", ts.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "s3fake")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "s3fake")
+	t.Setenv("AWS_S3_STORAGE_CLASS", "")
+	t.Setenv("AWS_S3_SSE_CUSTOMER_KEY", "")
+	return server
+}
+
+func TestCleanPlan(t *testing.T) {
+	pool := newTestPool(t, "tank/data")
+	snapshot := mustSnapshot(t, pool, "tank/data", "a")
+	dir := t.TempDir()
+	destination := backends.FileBackendPrefix + "://" + dir
+	orphan := filepath.Join(dir, "orphan")
+
+	host := newTestEngine(t, pool, WithAppendOnly(), WithOutput(new(bytes.Buffer)))
+	require.NoError(t, host.Backup(context.Background(), newCleanTestJob(snapshot, destination)))
+	require.NoError(t, os.WriteFile(orphan, []byte("orphan"), 0600))
+	uploaded, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	// An append-only clean only plans the deletion
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	require.NoError(t, host.Clean(context.Background(), newCleanTestJob(snapshot, destination), false, planPath))
+	assert.FileExists(t, orphan)
+	plan, err := ReadCleanPlan(planPath)
+	require.NoError(t, err)
+	assert.Equal(t, destination, plan.Destination)
+	assert.Equal(t, []string{"orphan"}, plan.Objects)
+
+	err = host.ApplyCleanPlan(context.Background(), newCleanTestJob(snapshot, ""), plan)
+	require.ErrorIs(t, err, ErrInvalidInput)
+	assert.FileExists(t, orphan)
+
+	// Someone who may delete applies it
+	operator := newTestEngine(t, pool, WithOutput(new(bytes.Buffer)))
+	require.NoError(t, operator.ApplyCleanPlan(context.Background(), newCleanTestJob(snapshot, ""), plan))
+	assert.NoFileExists(t, orphan)
+	remaining, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, remaining, len(uploaded)-1)
+}
+
+func TestBackupLock(t *testing.T) {
+	pool := newTestPool(t, "tank/data")
+	snapshot := mustSnapshot(t, pool, "tank/data", "a")
+	e := newTestEngine(t, pool, WithOutput(new(bytes.Buffer)))
+
+	// Destinations that cannot lock objects are rejected
+	job := newCleanTestJob(snapshot, backends.FileBackendPrefix+"://"+t.TempDir())
+	job.LockDuration = 24 * time.Hour
+	assert.Equal(t, "invalid_input", ErrorCode(e.Backup(context.Background(), job)))
+	job = newCleanTestJob(snapshot, ReplicationPrefix+"://backup")
+	job.LockDuration = 24 * time.Hour
+	require.ErrorIs(t, ValidateDestinations(job), ErrInvalidInput)
+
+	server := newS3TestServer(t)
+	destination := backends.AWSS3BackendPrefix + "://bucket"
+	job = newCleanTestJob(snapshot, destination)
+	job.LockDuration = 24 * time.Hour
+	job.LockCompliance = true
+	require.NoError(t, e.Backup(context.Background(), job))
+	lockUntil := job.StartTime.Add(24 * time.Hour)
+
+	// Every object is locked, and the manifest records until when
+	for _, key := range server.Keys("bucket") {
+		metadata := server.Metadata("bucket", key)
+		assert.Equal(t, "COMPLIANCE", metadata.Get("X-Amz-Object-Lock-Mode"), key)
+		until, err := time.Parse(time.RFC3339, metadata.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+		require.NoError(t, err, key)
+		assert.WithinDuration(t, lockUntil, until, time.Second, key)
+	}
+	cacheDir, err := e.getCacheDir(destination)
+	require.NoError(t, err)
+	// nolint:gosec // MD5 not used for cryptographic purposes here
+	manifestPath := filepath.Join(cacheDir, fmt.Sprintf("%x", md5.Sum([]byte(job.ManifestObjectName()))))
+	manifest, err := readManifest(context.Background(), manifestPath, job)
+	require.NoError(t, err)
+	assert.True(t, lockUntil.Equal(manifest.LockUntil))
+}
+
+func TestCleanLocked(t *testing.T) {
+	pool := newTestPool(t, "tank/data")
+	pool.StreamSize = 4 * 1024 * 1024
+	snapshot := mustSnapshot(t, pool, "tank/data", "a")
+	server := newS3TestServer(t)
+	destination := backends.AWSS3BackendPrefix + "://bucket"
+	recorder := new(report.Recorder)
+	e := newTestEngine(t, pool, WithJSONOutput(recorder))
+
+	job := newCleanTestJob(snapshot, destination)
+	job.LockDuration = 24 * time.Hour
+	require.NoError(t, e.Backup(context.Background(), job))
+	lockUntil := job.StartTime.Add(24 * time.Hour)
+
+	// Break the backup set, and leave an orphan that is not locked
+	require.Greater(t, len(job.Volumes), 1)
+	server.Remove("bucket", job.Volumes[0].ObjectName)
+	server.Put("bucket", "orphan", "", []byte("orphan"))
+	locked := server.Keys("bucket")
+	locked = slices.DeleteFunc(locked, func(key string) bool { return key == "orphan" })
+
+	// The plan marks the objects still locked
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	force := newCleanTestJob(snapshot, destination)
+	force.Force = true
+	require.NoError(t, e.Clean(context.Background(), force, false, planPath))
+	plan, err := ReadCleanPlan(planPath)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, append([]string{"orphan"}, locked...), plan.Objects)
+	assert.Len(t, plan.Locked, len(locked))
+	for _, key := range locked {
+		assert.True(t, lockUntil.Equal(plan.Locked[key]), key)
+	}
+
+	// Applying it, or cleaning, skips them rather than failing to delete them
+	require.NoError(t, e.ApplyCleanPlan(context.Background(), newCleanTestJob(snapshot, ""), plan))
+	var summary cleanSummary
+	require.NoError(t, decodeLastResult(recorder, &summary))
+	assert.Equal(t, []string{"orphan"}, summary.DeletedObjects)
+	assert.ElementsMatch(t, locked, summary.LockedObjects)
+	assert.ElementsMatch(t, locked, server.Keys("bucket"))
+
+	require.NoError(t, e.Clean(context.Background(), force, false, ""))
+	require.NoError(t, decodeLastResult(recorder, &summary))
+	assert.Empty(t, summary.DeletedObjects)
+	assert.ElementsMatch(t, locked, summary.LockedObjects)
+	require.Len(t, summary.BrokenSets, 1)
+	assert.False(t, summary.BrokenSets[0].Removed)
+	assert.ElementsMatch(t, locked, server.Keys("bucket"))
+
+	// Once the locks expire, the plan deletes them
+	server.Now = func() time.Time { return lockUntil.Add(time.Hour) }
+	for key := range plan.Locked {
+		plan.Locked[key] = time.Now().Add(-time.Hour)
+	}
+	require.NoError(t, e.ApplyCleanPlan(context.Background(), newCleanTestJob(snapshot, ""), plan))
+	assert.Empty(t, server.Keys("bucket"))
+}
+
+func TestCleanRetention(t *testing.T) {
+	pool := newTestPool(t, "tank/data")
+	now := time.Now()
+	snapshot := func(name string, age time.Duration) files.SnapshotInfo {
+		pool.Now = func() time.Time { return now.Add(-age) }
+		return mustSnapshot(t, pool, "tank/data", name)
+	}
+	dir := t.TempDir()
+	destination := backends.FileBackendPrefix + "://" + dir
+	recorder := new(report.Recorder)
+	e := newTestEngine(t, pool, WithJSONOutput(recorder))
+
+	// Two chains, where only the latest backup set is within the retention
+	day := 24 * time.Hour
+	backup := func(snapshot files.SnapshotInfo, from *files.JobInfo) *files.JobInfo {
+		job := newCleanTestJob(snapshot, destination)
+		job.Retention = 3 * day
+		if from != nil {
+			job.IncrementalSnapshot = from.BaseSnapshot
+		}
+		require.NoError(t, e.Backup(context.Background(), job))
+		return job
+	}
+	first := backup(snapshot("a", 10*day), nil)
+	second := backup(snapshot("b", 9*day), first)
+	third := backup(snapshot("c", 5*day), nil)
+	fourth := backup(snapshot("d", day), third)
+
+	// The planned deletion leaves the backups in place
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	require.NoError(t, e.Clean(context.Background(), newCleanTestJob(files.SnapshotInfo{}, destination), false, planPath))
+	plan, err := ReadCleanPlan(planPath)
+	require.NoError(t, err)
+	expired := []string{first.ManifestObjectName(), second.ManifestObjectName()}
+	for _, job := range []*files.JobInfo{first, second} {
+		for _, vol := range job.Volumes {
+			expired = append(expired, vol.ObjectName)
+		}
+	}
+	assert.ElementsMatch(t, expired, plan.Objects)
+	var summary cleanSummary
+	require.NoError(t, decodeLastResult(recorder, &summary))
+	require.Len(t, summary.ExpiredSets, 2)
+	for _, set := range summary.ExpiredSets {
+		assert.False(t, set.Removed)
+	}
+
+	// The expired backup sets are deleted, while the one the latest is incremental from is kept however old
+	require.NoError(t, e.Clean(context.Background(), newCleanTestJob(files.SnapshotInfo{}, destination), false, ""))
+	require.NoError(t, decodeLastResult(recorder, &summary))
+	assert.ElementsMatch(t, expired, summary.DeletedObjects)
+	assert.ElementsMatch(t, []expiredSet{
+		{VolumeName: "tank/data", BaseSnapshot: "a", Removed: true},
+		{VolumeName: "tank/data", BaseSnapshot: "b", IncrementalSnapshot: "a", Removed: true},
+	}, summary.ExpiredSets)
+	for _, job := range []*files.JobInfo{third, fourth} {
+		assert.FileExists(t, filepath.Join(dir, job.ManifestObjectName()))
+		for _, vol := range job.Volumes {
+			assert.FileExists(t, filepath.Join(dir, vol.ObjectName))
+		}
+	}
+
+	// Nothing else expires until the latest backup set is replaced
+	require.NoError(t, e.Clean(context.Background(), newCleanTestJob(files.SnapshotInfo{}, destination), false, ""))
+	require.NoError(t, decodeLastResult(recorder, &summary))
+	assert.Empty(t, summary.DeletedObjects)
+	assert.Empty(t, summary.ExpiredSets)
+}
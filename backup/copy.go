@@ -0,0 +1,437 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"crypto/md5" // nolint:gosec // MD5 not used for cryptographic purposes here
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/dustin/go-humanize"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/events"
+	"github.com/someone1/zfsbackup-go/files"
+)
+
+type copyStats struct {
+	ManifestsCopied  int64
+	ManifestsSkipped int64
+	VolumesCopied    int64
+	BytesCopied      uint64
+}
+
+// Copy will replicate the backup sets found in the first destination of the provided JobInfo
+// to every other destination, transferring only the manifests and volumes missing from each
+// target. Every volume is verified against the SHA256 hash recorded in its manifest before it is
+// uploaded and manifests are uploaded last so a backup set only appears once it is complete. Volumes
+// already on a target without their manifest are left over from an interrupted copy or upload, so
+// they are read back, restoring them from an archive tier first if needed, and copied again only if
+// they do not match their recorded hash. A volume that cannot be read back fails the copy.
+// nolint:funlen,gocyclo // Difficult to break this up
+func (e *Engine) Copy(pctx context.Context, jobInfo *files.JobInfo, startswith string) (err error) {
+	var stats *copyStats
+	e.notifyStart(pctx, operationCopy, jobInfo)
+	defer func() {
+		var result interface{}
+		if stats != nil && err == nil {
+			result = stats
+		}
+		e.notifyDone(pctx, operationCopy, err, result, jobInfo)
+	}()
+
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	if len(jobInfo.Destinations) < 2 {
+		return fmt.Errorf("a source and at least one target destination are required")
+	}
+
+	// Prepare the source backend client
+	source := jobInfo.Destinations[0]
+	sourceBackend, berr := e.prepareBackend(ctx, jobInfo, source, nil)
+	if berr != nil {
+		e.logger.Errorf("Could not initialize backend for source %s due to error - %v.", source, berr)
+		return berr
+	}
+	defer sourceBackend.Close()
+
+	sourceCachePath, cerr := e.getCacheDir(source)
+	if cerr != nil {
+		e.logger.Errorf("Could not get cache dir for source %s due to error - %v.", source, cerr)
+		return cerr
+	}
+
+	safeManifests, _, serr := e.syncCache(ctx, jobInfo, sourceCachePath, sourceBackend)
+	if serr != nil {
+		e.logger.Errorf("Could not sync cache dir for source %s due to error - %v.", source, serr)
+		return serr
+	}
+
+	decodedManifests, derr := e.readAndSortManifests(ctx, sourceCachePath, safeManifests, jobInfo)
+	if derr != nil {
+		return derr
+	}
+
+	filteredManifests := decodedManifests[:0]
+	for _, manifest := range decodedManifests {
+		if matchesVolumeName(startswith, manifest.VolumeName) {
+			filteredManifests = append(filteredManifests, manifest)
+		}
+	}
+	decodedManifests = filteredManifests
+
+	stats = new(copyStats)
+	for _, target := range jobInfo.Destinations[1:] {
+		if err := e.copyToTarget(ctx, jobInfo, sourceBackend, sourceCachePath, target, decodedManifests, stats); err != nil {
+			return err
+		}
+	}
+
+	e.recordResult(ctx, stats)
+	if !e.jsonOutput {
+		fmt.Fprintf(
+			e.stdout,
+			"Done.\n\tBackup Sets Copied: %d\n\tBackup Sets Already Present: %d\n\tVolumes Copied: %d\n\tBytes Copied: %d (%s)\n",
+			stats.ManifestsCopied,
+			stats.ManifestsSkipped,
+			stats.VolumesCopied,
+			stats.BytesCopied,
+			humanize.IBytes(stats.BytesCopied),
+		)
+	}
+
+	return nil
+}
+
+// nolint:funlen,gocyclo // Difficult to break this up
+func (e *Engine) copyToTarget(
+	ctx context.Context,
+	jobInfo *files.JobInfo,
+	sourceBackend backends.Backend,
+	sourceCachePath, target string,
+	manifests []*files.JobInfo,
+	stats *copyStats,
+) error {
+	uploadBuffer := make(chan bool, jobInfo.MaxParallelUploads)
+	defer close(uploadBuffer)
+
+	targetBackend, berr := e.prepareBackend(ctx, jobInfo, target, uploadBuffer)
+	if berr != nil {
+		e.logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
+		return berr
+	}
+	defer targetBackend.Close()
+
+	targetCachePath, cerr := e.getCacheDir(target)
+	if cerr != nil {
+		e.logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
+		return cerr
+	}
+
+	existingObjects, lerr := targetBackend.List(ctx, "")
+	if lerr != nil {
+		e.logger.Errorf("Could not list objects in target %s due to error - %v", target, lerr)
+		return lerr
+	}
+	existing := make(map[string]bool, len(existingObjects))
+	for _, obj := range existingObjects {
+		existing[obj] = true
+	}
+
+	targetPrefix := strings.Split(target, "://")[0]
+	for _, manifest := range manifests {
+		manifest.ManifestPrefix = jobInfo.ManifestPrefix
+		manifest.AesEncryptionKey = jobInfo.AesEncryptionKey
+		manifestObjectName := manifest.ManifestObjectName()
+		if existing[manifestObjectName] {
+			e.logger.Debugf("Backup set %s already exists in %s, skipping.", manifestObjectName, target)
+			atomic.AddInt64(&stats.ManifestsSkipped, 1)
+			continue
+		}
+
+		var toCopy, toVerify []*files.VolumeInfo
+		for _, vol := range manifest.Volumes {
+			if existing[vol.ObjectName] {
+				toVerify = append(toVerify, vol)
+				continue
+			}
+			toCopy = append(toCopy, vol)
+		}
+		mismatched, verr := e.verifyVolumes(ctx, jobInfo, targetBackend, target, toVerify)
+		if verr != nil {
+			return verr
+		}
+		toCopy = append(toCopy, mismatched...)
+		e.logger.Infof("Copying %d of %d volumes for backup set %s to %s.", len(toCopy), len(manifest.Volumes), manifestObjectName, target)
+
+		objectNames := make([]string, len(toCopy))
+		for idx := range toCopy {
+			objectNames[idx] = toCopy[idx].ObjectName
+		}
+		if err := sourceBackend.PreDownload(ctx, objectNames); err != nil {
+			e.logger.Errorf("Error trying to pre download backup set volumes - %v", err)
+			return err
+		}
+
+		group, gctx := errgroup.WithContext(ctx)
+		copyChan := make(chan *files.VolumeInfo, len(toCopy))
+		for _, vol := range toCopy {
+			copyChan <- vol
+		}
+		close(copyChan)
+
+		for i := 0; i < jobInfo.MaxParallelUploads; i++ {
+			group.Go(func() error {
+				for vol := range copyChan {
+					size, err := e.copyVolume(gctx, jobInfo, sourceBackend, targetBackend, vol, target)
+					if err != nil {
+						e.logger.Errorf("Failed to copy volume %s to %s due to error: %v", vol.ObjectName, target, err)
+						return err
+					}
+					atomic.AddInt64(&stats.VolumesCopied, 1)
+					atomic.AddUint64(&stats.BytesCopied, size)
+				}
+				return nil
+			})
+		}
+
+		if err := group.Wait(); err != nil {
+			return err
+		}
+
+		// nolint:gosec // MD5 not used for cryptographic purposes here
+		safeManifestFile := fmt.Sprintf("%x", md5.Sum([]byte(manifestObjectName)))
+		if err := e.copyManifest(ctx, jobInfo, targetBackend, manifestObjectName, targetPrefix,
+			filepath.Join(sourceCachePath, safeManifestFile), filepath.Join(targetCachePath, safeManifestFile)); err != nil {
+			e.logger.Errorf("Failed to copy manifest %s to %s due to error: %v", manifestObjectName, target, err)
+			return err
+		}
+		if err := os.Remove(filepath.Join(targetCachePath, incompleteCacheDir, safeManifestFile)); err != nil && !os.IsNotExist(err) {
+			e.logger.Warnf("Could not clear incomplete marker for %s in %s due to error - %v", manifestObjectName, target, err)
+		}
+		atomic.AddInt64(&stats.ManifestsCopied, 1)
+		e.logger.Infof("Copied backup set %s to %s.", manifestObjectName, target)
+	}
+
+	return nil
+}
+
+// copyVolume will download the volume from the source, verifying its hash, and upload it to the target.
+func (e *Engine) copyVolume(
+	ctx context.Context,
+	jobInfo *files.JobInfo,
+	sourceBackend, targetBackend backends.Backend,
+	volume *files.VolumeInfo,
+	target string,
+) (uint64, error) {
+	targetPrefix := strings.Split(target, "://")[0]
+	be := backoff.NewExponentialBackOff()
+	be.MaxInterval = jobInfo.MaxBackoffTime
+	be.MaxElapsedTime = jobInfo.MaxRetryTime
+	retryconf := backoff.WithContext(be, ctx)
+
+	var size uint64
+	operation := func() error {
+		c := make(chan *files.VolumeInfo, 1)
+		if err := e.processSequence(ctx, downloadSequence{volume, c}, sourceBackend, false); err != nil {
+			return err
+		}
+		vol := <-c
+		defer func() {
+			if err := vol.DeleteVolume(); err != nil {
+				e.logger.Warnf("Could not delete temporary volume %s due to error - %v", vol.ObjectName, err)
+			}
+		}()
+
+		vol.SetRateLimiter(jobInfo.UploadLimiters[target])
+		if err := e.volUploadWrapper(ctx, targetBackend, vol, targetPrefix)(); err != nil {
+			return err
+		}
+		size = vol.Size
+		return nil
+	}
+
+	onRetry := func(err error, next time.Duration) {
+		e.emitRetry(ctx, jobInfo, target, volume, next, err)
+	}
+
+	e.logger.Debugf("Copying volume %s.", volume.ObjectName)
+	if err := backoff.RetryNotify(operation, retryconf, onRetry); err != nil {
+		return 0, err
+	}
+	e.logger.Debugf("Copied volume %s.", volume.ObjectName)
+	e.eventStream(ctx).Emit(&events.Event{
+		Type:        events.VolumeUploaded,
+		Operation:   operationCopy,
+		Destination: backends.RedactURI(target),
+		ObjectName:  volume.ObjectName,
+		Volume:      volume.VolumeNumber,
+		Bytes:       size,
+	})
+
+	return size, nil
+}
+
+// verifyVolumes will check the volumes already in the target against the hash recorded in their manifest, as many
+// at a time as there are parallel uploads, and return the ones that do not match.
+func (e *Engine) verifyVolumes(
+	ctx context.Context,
+	jobInfo *files.JobInfo,
+	targetBackend backends.Backend,
+	target string,
+	volumes []*files.VolumeInfo,
+) ([]*files.VolumeInfo, error) {
+	if len(volumes) == 0 {
+		return nil, nil
+	}
+
+	objectNames := make([]string, len(volumes))
+	for idx := range volumes {
+		objectNames[idx] = volumes[idx].ObjectName
+	}
+	if err := targetBackend.PreDownload(ctx, objectNames); err != nil {
+		e.logger.Errorf("Error trying to pre download the volumes already in %s - %v", target, err)
+		return nil, err
+	}
+
+	var (
+		mismatched []*files.VolumeInfo
+		mutex      sync.Mutex
+	)
+	group, gctx := errgroup.WithContext(ctx)
+	verifyChan := make(chan *files.VolumeInfo, len(volumes))
+	for _, vol := range volumes {
+		verifyChan <- vol
+	}
+	close(verifyChan)
+
+	for i := 0; i < jobInfo.MaxParallelUploads; i++ {
+		group.Go(func() error {
+			for vol := range verifyChan {
+				be := backoff.NewExponentialBackOff()
+				be.MaxInterval = jobInfo.MaxBackoffTime
+				be.MaxElapsedTime = jobInfo.MaxRetryTime
+				operation := func() error {
+					err := e.checkVolume(gctx, targetBackend, vol)
+					if errors.Is(err, ErrHashMismatch) {
+						return backoff.Permanent(err)
+					}
+					return err
+				}
+
+				err := backoff.Retry(operation, backoff.WithContext(be, gctx))
+				switch {
+				case errors.Is(err, ErrHashMismatch):
+					e.logger.Infof("Volume %s in %s does not match its hash - %v", vol.ObjectName, target, err)
+					mutex.Lock()
+					mismatched = append(mismatched, vol)
+					mutex.Unlock()
+				case err != nil:
+					e.logger.Errorf("Could not verify volume %s in %s due to error - %v", vol.ObjectName, target, err)
+					return err
+				default:
+					e.logger.Debugf("Volume %s in %s matches its hash.", vol.ObjectName, target)
+					e.eventStream(ctx).Emit(&events.Event{
+						Type:        events.VolumeVerified,
+						Destination: backends.RedactURI(target),
+						ObjectName:  vol.ObjectName,
+						Volume:      vol.VolumeNumber,
+						Bytes:       vol.Size,
+					})
+				}
+			}
+			return nil
+		})
+	}
+
+	err := group.Wait()
+	return mismatched, err
+}
+
+// copyManifest will upload the manifest found in the source's local cache to the target and
+// add it to the target's local cache.
+func (e *Engine) copyManifest(
+	ctx context.Context,
+	jobInfo *files.JobInfo,
+	targetBackend backends.Backend,
+	manifestObjectName, targetPrefix, sourcePath, targetPath string,
+) error {
+	vol, err := files.CreateSimpleVolume(ctx, false, e.volumeOptions())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if derr := vol.DeleteVolume(); derr != nil {
+			e.logger.Warnf("Could not delete temporary manifest %s due to error - %v", manifestObjectName, derr)
+		}
+	}()
+
+	in, err := os.Open(sourcePath)
+	if err != nil {
+		_ = vol.Close()
+		return err
+	}
+	defer in.Close()
+
+	if _, err = io.Copy(vol, in); err != nil {
+		_ = vol.Close()
+		return err
+	}
+	if err = vol.Close(); err != nil {
+		return err
+	}
+	vol.ObjectName = manifestObjectName
+	vol.IsManifest = true
+	vol.IsFinalManifest = true
+
+	be := backoff.NewExponentialBackOff()
+	be.MaxInterval = jobInfo.MaxBackoffTime
+	be.MaxElapsedTime = jobInfo.MaxRetryTime
+	retryconf := backoff.WithContext(be, ctx)
+	if err = backoff.Retry(e.volUploadWrapper(ctx, targetBackend, vol, targetPrefix), retryconf); err != nil {
+		return err
+	}
+
+	return vol.CopyTo(targetPath)
+}
+
+// matchesVolumeName will check the volume name against the provided filter, which
+// can end with a '*' to match as a prefix only.
+func matchesVolumeName(startswith, volumeName string) bool {
+	if startswith == "" {
+		return true
+	}
+	if startswith[len(startswith)-1:] == "*" {
+		return len(startswith) == 1 || strings.HasPrefix(volumeName, startswith[:len(startswith)-1])
+	}
+	return strings.Compare(startswith, volumeName) == 0
+}
@@ -0,0 +1,201 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/report"
+)
+
+func TestCopy(t *testing.T) {
+	pool := newTestPool(t, "tank/test")
+	baseSnapshot := mustSnapshot(t, pool, "tank/test", "snap1")
+
+	recorder := new(report.Recorder)
+	e := newTestEngine(t, pool, WithJSONOutput(recorder))
+
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+
+	source := fmt.Sprintf("%s://%s", backends.FileBackendPrefix, sourceDir)
+	target := fmt.Sprintf("%s://%s", backends.FileBackendPrefix, targetDir)
+
+	jobInfo := &files.JobInfo{
+		VolumeName:         "tank/test",
+		VolumeSize:         1, // 1 MiB
+		UploadChunkSize:    1,
+		Destinations:       []string{source},
+		BaseSnapshot:       baseSnapshot,
+		MaxParallelUploads: 5,
+		MaxFileBuffer:      5,
+		MaxBackoffTime:     5 * time.Millisecond,
+		MaxRetryTime:       1 * time.Second,
+		StartTime:          time.Now(),
+		AesEncryptionKey:   "test1234test1234",
+		ManifestPrefix:     "manifests",
+		Separator:          "|",
+	}
+	require.NoError(t, e.Backup(t.Context(), jobInfo))
+
+	copyJob := &files.JobInfo{
+		Destinations:       []string{source, target},
+		MaxParallelUploads: 2,
+		MaxBackoffTime:     5 * time.Millisecond,
+		MaxRetryTime:       1 * time.Second,
+		UploadChunkSize:    5,
+		AesEncryptionKey:   jobInfo.AesEncryptionKey,
+		ManifestPrefix:     jobInfo.ManifestPrefix,
+	}
+
+	require.NoError(t, e.Copy(t.Context(), copyJob, "tank/*"))
+
+	var stats copyStats
+	require.NoError(t, decodeLastResult(recorder, &stats))
+	assert.EqualValues(t, 1, stats.ManifestsCopied)
+	assert.EqualValues(t, len(jobInfo.Volumes), stats.VolumesCopied)
+
+	assert.Equal(t, listDir(t, sourceDir), listDir(t, targetDir))
+
+	// Nothing left to copy
+	recorder.Reset()
+	require.NoError(t, e.Copy(t.Context(), copyJob, ""))
+	require.NoError(t, decodeLastResult(recorder, &stats))
+	assert.EqualValues(t, 0, stats.ManifestsCopied)
+	assert.EqualValues(t, 1, stats.ManifestsSkipped)
+
+	// The copy should be restorable on its own
+	jobInfo.Destinations = []string{target}
+	jobInfo.LocalVolume = "tank/restored"
+	require.NoError(t, e.Receive(t.Context(), jobInfo))
+
+	var restored restoreSummary
+	require.NoError(t, decodeLastResult(recorder, &restored))
+	if assert.Len(t, restored.Restored, 1) {
+		assert.Equal(t, jobInfo.BaseSnapshot.Name, restored.Restored[0].Snapshot.Name)
+		assert.Nil(t, restored.Restored[0].IncrementalSnapshot)
+	}
+	assert.Equal(t, jobInfo.TotalBytesWritten(), restored.TotalBytes)
+}
+
+func TestCopyReplacesPartialVolumes(t *testing.T) {
+	pool := newTestPool(t, "tank/test")
+	baseSnapshot := mustSnapshot(t, pool, "tank/test", "snap1")
+
+	recorder := new(report.Recorder)
+	e := newTestEngine(t, pool, WithJSONOutput(recorder))
+
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+
+	source := fmt.Sprintf("%s://%s", backends.FileBackendPrefix, sourceDir)
+	target := fmt.Sprintf("%s://%s", backends.FileBackendPrefix, targetDir)
+
+	jobInfo := &files.JobInfo{
+		VolumeName:         "tank/test",
+		VolumeSize:         1, // 1 MiB
+		UploadChunkSize:    1,
+		Destinations:       []string{source},
+		BaseSnapshot:       baseSnapshot,
+		MaxParallelUploads: 5,
+		MaxFileBuffer:      5,
+		MaxBackoffTime:     5 * time.Millisecond,
+		MaxRetryTime:       1 * time.Second,
+		StartTime:          time.Now(),
+		ManifestPrefix:     "manifests",
+		Separator:          "|",
+	}
+	require.NoError(t, e.Backup(t.Context(), jobInfo))
+	require.NotEmpty(t, jobInfo.Volumes)
+
+	// Leave the volumes behind on the target as an interrupted copy would, with the first one truncated
+	for idx, vol := range jobInfo.Volumes {
+		data, err := os.ReadFile(filepath.Join(sourceDir, vol.ObjectName))
+		require.NoError(t, err)
+		if idx == 0 {
+			data = data[:len(data)/2]
+		}
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(targetDir, vol.ObjectName)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(targetDir, vol.ObjectName), data, 0o600))
+	}
+
+	copyJob := &files.JobInfo{
+		Destinations:       []string{source, target},
+		MaxParallelUploads: 2,
+		MaxBackoffTime:     5 * time.Millisecond,
+		MaxRetryTime:       1 * time.Second,
+		UploadChunkSize:    5,
+		ManifestPrefix:     jobInfo.ManifestPrefix,
+	}
+	require.NoError(t, e.Copy(t.Context(), copyJob, ""))
+
+	var stats copyStats
+	require.NoError(t, decodeLastResult(recorder, &stats))
+	assert.EqualValues(t, 1, stats.ManifestsCopied)
+	assert.EqualValues(t, 1, stats.VolumesCopied)
+
+	for _, vol := range jobInfo.Volumes {
+		want, err := os.ReadFile(filepath.Join(sourceDir, vol.ObjectName))
+		require.NoError(t, err)
+		got, err := os.ReadFile(filepath.Join(targetDir, vol.ObjectName))
+		require.NoError(t, err)
+		assert.Equal(t, want, got, vol.ObjectName)
+	}
+
+	// Volumes that cannot be read back from the target fail the copy rather than being uploaded again
+	for _, options := range []string{"failPreDownload=1", "failDownload=1"} {
+		otherDir := t.TempDir()
+		for _, vol := range jobInfo.Volumes {
+			data, err := os.ReadFile(filepath.Join(sourceDir, vol.ObjectName))
+			require.NoError(t, err)
+			require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(otherDir, vol.ObjectName)), 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(otherDir, vol.ObjectName), data, 0o600))
+		}
+		copyJob.Destinations = []string{
+			source, fmt.Sprintf("%s://%s://%s?%s", backends.ChaosBackendPrefix, backends.FileBackendPrefix, otherDir, options),
+		}
+		require.ErrorIs(t, e.Copy(t.Context(), copyJob, ""), backends.ErrChaos, options)
+		assert.Len(t, listDir(t, otherDir), len(jobInfo.Volumes), options)
+	}
+}
+
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	require.NoError(t, filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, rerr := filepath.Rel(dir, path)
+		names = append(names, rel)
+		return rerr
+	}))
+	return names
+}
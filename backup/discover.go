@@ -0,0 +1,145 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/files"
+)
+
+// ZFS user properties a dataset can set to describe its own backup policy.
+const (
+	PropertyEnabled        = "zfsbackup:enabled"
+	PropertyDestinations   = "zfsbackup:destinations"
+	PropertyRetention      = "zfsbackup:retention"
+	PropertySnapshotPrefix = "zfsbackup:snapshotprefix"
+)
+
+// DiscoverJobs will walk the root dataset and all of its descendants and return a backup job
+// for every dataset that has opted in via the zfsbackup:enabled user property. Each job is a copy
+// of the template provided with the volume name and any per-dataset overrides applied.
+// When the template uses the replication flag, only datasets that set zfsbackup:enabled locally
+// are selected since their descendants will be included in the replication stream. A dataset with
+// no destinations or an invalid retention is skipped with a warning rather than failing discovery for
+// every other dataset. The retention is recorded in the dataset's manifests for clean to delete old backups by.
+// nolint:gocyclo // Difficult to break this up
+func (e *Engine) DiscoverJobs(ctx context.Context, root string, template *files.JobInfo) ([]*files.JobInfo, error) {
+	datasets, err := e.zfs.GetDatasetProperties(
+		ctx, root, PropertyEnabled, PropertyDestinations, PropertyRetention, PropertySnapshotPrefix,
+	)
+	if err != nil {
+		e.logger.Errorf("Could not read backup properties for %s due to error - %v", root, err)
+		return nil, err
+	}
+
+	names := make([]string, 0, len(datasets))
+	for name := range datasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	jobs := make([]*files.JobInfo, 0, len(names))
+	for _, name := range names {
+		props := datasets[name]
+
+		enabledProp := props[PropertyEnabled]
+		if !enabledProp.IsSet() {
+			continue
+		}
+		enabled, perr := parsePropertyBool(enabledProp.Value)
+		if perr != nil {
+			e.logger.Warnf("Ignoring dataset %s, could not parse %s=%s - %v", name, PropertyEnabled, enabledProp.Value, perr)
+			continue
+		}
+		if !enabled {
+			e.logger.Debugf("Dataset %s has backups disabled, skipping.", name)
+			continue
+		}
+		if template.Replication && enabledProp.IsInherited() {
+			e.logger.Debugf("Dataset %s inherits %s and will be included in its parent's replication stream, skipping.", name, PropertyEnabled)
+			continue
+		}
+
+		job := *template
+		job.VolumeName = name
+		job.Volumes = nil
+		job.Destinations = append([]string(nil), template.Destinations...)
+
+		if prop := props[PropertyDestinations]; prop.IsSet() {
+			job.Destinations = nil
+			for _, destination := range strings.Split(prop.Value, ",") {
+				if destination = strings.TrimSpace(destination); destination != "" {
+					job.Destinations = append(job.Destinations, destination)
+				}
+			}
+		}
+		if len(job.Destinations) == 0 {
+			e.logger.Warnf("Ignoring dataset %s, no destinations provided, set %s or provide a default uri", name, PropertyDestinations)
+			continue
+		}
+
+		if prop := props[PropertySnapshotPrefix]; prop.IsSet() {
+			job.SnapshotPrefix = prop.Value
+		}
+
+		if prop := props[PropertyRetention]; prop.IsSet() {
+			retention, rerr := parseRetention(prop.Value)
+			if rerr != nil {
+				e.logger.Warnf("Ignoring dataset %s, could not parse %s=%s - %v", name, PropertyRetention, prop.Value, rerr)
+				continue
+			}
+			job.Retention = retention
+		}
+
+		e.logger.Infof("Discovered dataset %s for backup to %s", name, strings.Join(job.Destinations, ","))
+		jobs = append(jobs, &job)
+	}
+
+	return jobs, nil
+}
+
+func parsePropertyBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "yes":
+		return true, nil
+	case "off", "no":
+		return false, nil
+	default:
+		return strconv.ParseBool(value)
+	}
+}
+
+// parseRetention extends time.ParseDuration to support a day ("d") unit, e.g. "30d".
+func parseRetention(value string) (time.Duration, error) {
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,96 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/zfs"
+)
+
+func TestDiscoverJobs(t *testing.T) {
+	pool := zfs.NewFakePool()
+	pool.CreateDataset("tank", nil)
+	pool.CreateDataset("tank/data", map[string]string{
+		PropertyEnabled:        "true",
+		PropertyDestinations:   "file:///a, file:///b",
+		PropertyRetention:      "30d",
+		PropertySnapshotPrefix: "auto-",
+	})
+	pool.CreateDataset("tank/data/child", nil)
+	pool.CreateDataset("tank/data/child/skip", map[string]string{PropertyEnabled: "off"})
+	pool.CreateDataset("tank/other", map[string]string{
+		PropertyEnabled:   "on",
+		PropertyRetention: "12h",
+	})
+	e := newTestEngine(t, pool)
+
+	template := &files.JobInfo{Destinations: []string{"file:///default"}, Full: true}
+
+	jobs, err := e.DiscoverJobs(context.Background(), "tank", template)
+	require.NoError(t, err)
+	require.Len(t, jobs, 3)
+
+	assert.Equal(t, "tank/data", jobs[0].VolumeName)
+	assert.Equal(t, []string{"file:///a", "file:///b"}, jobs[0].Destinations)
+	assert.Equal(t, 30*24*time.Hour, jobs[0].Retention)
+	assert.Equal(t, "auto-", jobs[0].SnapshotPrefix)
+	assert.True(t, jobs[0].Full)
+
+	assert.Equal(t, "tank/data/child", jobs[1].VolumeName)
+
+	assert.Equal(t, "tank/other", jobs[2].VolumeName)
+	assert.Equal(t, []string{"file:///default"}, jobs[2].Destinations)
+	assert.Equal(t, 12*time.Hour, jobs[2].Retention)
+
+	// Jobs should not share the template's destination slice
+	jobs[2].Destinations = append(jobs[2].Destinations, "file:///extra")
+	assert.Len(t, template.Destinations, 1)
+
+	// Inherited datasets are part of their parent's replication stream
+	template.Replication = true
+	jobs, err = e.DiscoverJobs(context.Background(), "tank", template)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "tank/data", jobs[0].VolumeName)
+	assert.Equal(t, "tank/other", jobs[1].VolumeName)
+
+	// A dataset with a retention that cannot be parsed is skipped without affecting the others
+	pool.CreateDataset("tank/other", map[string]string{PropertyRetention: "a while"})
+	jobs, err = e.DiscoverJobs(context.Background(), "tank", template)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "tank/data", jobs[0].VolumeName)
+
+	// As is a dataset with no destinations at all
+	pool.CreateDataset("tank/other", map[string]string{PropertyRetention: "12h"})
+	template.Destinations = nil
+	jobs, err = e.DiscoverJobs(context.Background(), "tank", template)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "tank/data", jobs[0].VolumeName)
+}
@@ -1,2 +1,10 @@
 // Package backup handles all the logic around backing up and restoring snapshots
+//
+// Operations run on an Engine, which holds everything besides the JobInfo they need:
+//
+//	engine, err := backup.NewEngine("/var/lib/zfsbackup", backup.WithLogger(logger))
+//	if err != nil {
+//		return err
+//	}
+//	err = engine.Backup(ctx, jobInfo)
 package backup
@@ -0,0 +1,172 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/juju/ratelimit"
+	"go.uber.org/zap"
+
+	"github.com/someone1/zfsbackup-go/events"
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/notify"
+	"github.com/someone1/zfsbackup-go/report"
+	"github.com/someone1/zfsbackup-go/throttle"
+	"github.com/someone1/zfsbackup-go/zfs"
+)
+
+// Engine runs backups, restores, and the other operations on backup sets. Everything an operation
+// needs besides its JobInfo is provided to the Engine when it is created, so several Engines can
+// be used in the same process and an Engine can run several operations at once.
+type Engine struct {
+	workingDir      string
+	tempDir         string
+	logger          *zap.SugaredLogger
+	stdout          io.Writer
+	jsonOutput      bool
+	recorder        *report.Recorder
+	uploadBucket    *ratelimit.Bucket
+	downloadLimiter *throttle.Limiter
+	notifier        *notify.Notifier
+	events          *events.Stream
+	zfs             zfs.Runner
+	dialZFS         ZFSDialer
+	appendOnly      bool
+	writeOnly       bool
+
+	manifestMutex sync.Mutex
+}
+
+// Option configures an Engine.
+type Option func(*Engine)
+
+// WithTempDir will write volumes to the given directory before they are uploaded, and after they are
+// downloaded, instead of the temp directory in the working directory.
+func WithTempDir(dir string) Option {
+	return func(e *Engine) { e.tempDir = dir }
+}
+
+// WithLogger will log to the given logger instead of the global zap logger.
+func WithLogger(logger *zap.SugaredLogger) Option {
+	return func(e *Engine) { e.logger = logger }
+}
+
+// WithOutput will write the human readable output of operations to w instead of stdout.
+func WithOutput(w io.Writer) Option {
+	return func(e *Engine) { e.stdout = w }
+}
+
+// WithJSONOutput will record the results of operations to the recorder instead of writing them out
+// in a human readable form. Operations running with a context carrying a report.Recorder always
+// record their results to it.
+func WithJSONOutput(recorder *report.Recorder) Option {
+	return func(e *Engine) {
+		e.jsonOutput = true
+		e.recorder = recorder
+	}
+}
+
+// WithUploadLimit will limit the combined rate volumes are uploaded at to all destinations.
+func WithUploadLimit(bucket *ratelimit.Bucket) Option {
+	return func(e *Engine) { e.uploadBucket = bucket }
+}
+
+// WithDownloadLimiter will limit the rate volumes are downloaded at.
+func WithDownloadLimiter(limiter *throttle.Limiter) Option {
+	return func(e *Engine) { e.downloadLimiter = limiter }
+}
+
+// WithNotifier will notify the notifier's hooks when operations start and complete.
+func WithNotifier(notifier *notify.Notifier) Option {
+	return func(e *Engine) { e.notifier = notifier }
+}
+
+// WithEvents will emit the progress of operations to the stream. Operations running with a context
+// carrying a stream emit their events to it instead.
+func WithEvents(stream *events.Stream) Option {
+	return func(e *Engine) { e.events = stream }
+}
+
+// WithZFS will run zfs commands with the runner instead of the zfs binary found in the PATH.
+func WithZFS(runner zfs.Runner) Option {
+	return func(e *Engine) { e.zfs = runner }
+}
+
+// ZFSDialer connects to the host given, e.g. user@host:22, and returns a runner for the zfs binary
+// found at path on it, "zfs" if empty. The runner is closed once done with if it is an io.Closer.
+type ZFSDialer func(host, path string) (zfs.Runner, error)
+
+// WithZFSDialer will connect to the hosts of ssh+zfs:// replication targets with dial instead of over SSH.
+func WithZFSDialer(dial ZFSDialer) Option {
+	return func(e *Engine) { e.dialZFS = dial }
+}
+
+// WithAppendOnly will never delete objects from destinations. Clean writes out a plan of the deletions it
+// would make instead, for an operator with credentials that allow deleting to apply with ApplyCleanPlan.
+func WithAppendOnly() Option {
+	return func(e *Engine) { e.appendOnly = true }
+}
+
+// WithWriteOnly will only ever upload objects to destinations, so credentials that cannot list, read or delete
+// them can be used. The local cache becomes the index of the backups made, and restoring or cleaning needs an
+// Engine without this option using credentials that allow it.
+func WithWriteOnly() Option {
+	return func(e *Engine) { e.writeOnly = true }
+}
+
+// NewEngine will return an Engine that keeps its cache of manifests, and by default its temporary
+// files, in the working directory, creating the directories it needs if they do not exist.
+func NewEngine(workingDir string, opts ...Option) (*Engine, error) {
+	if workingDir == "" {
+		return nil, fmt.Errorf("%w: a working directory must be provided", ErrInvalidInput)
+	}
+
+	e := &Engine{
+		workingDir: workingDir,
+		tempDir:    filepath.Join(workingDir, "temp"),
+		logger:     zap.S(),
+		stdout:     os.Stdout,
+		zfs:        &zfs.Local{},
+		dialZFS: func(host, path string) (zfs.Runner, error) {
+			return zfs.DialRemote(host, path)
+		},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	for _, dir := range []string{filepath.Join(e.workingDir, "cache"), e.tempDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("could not create directory %s - %v", dir, err)
+		}
+	}
+	return e, nil
+}
+
+// volumeOptions will return the options to create volumes with.
+func (e *Engine) volumeOptions() files.VolumeOptions {
+	return files.VolumeOptions{TempDir: e.tempDir, UploadBucket: e.uploadBucket}
+}
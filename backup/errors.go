@@ -0,0 +1,121 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"errors"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/report"
+)
+
+var (
+	// ErrNoOp is returned when there is nothing new to backup.
+	ErrNoOp = errors.New("nothing new to sync")
+	// ErrDegraded is returned when a backup completed to enough destinations to satisfy the
+	// required number of destinations, but not to all of them.
+	ErrDegraded = errors.New("backup did not complete to every destination")
+	// ErrInvalidInput is returned when the options provided are invalid.
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrOutOfSync is returned when a destination has no backup that can be built upon with the local snapshots.
+	ErrOutOfSync = errors.New("destination is out of sync with the local snapshots")
+	// ErrLocked is returned when another process is already working on the same volume.
+	ErrLocked = errors.New("another process is working on this volume")
+	// ErrSnapshotNotFound is returned when a snapshot or backup set required by an operation could not be found.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	// ErrHashMismatch is returned when a downloaded volume does not match the hash recorded in its manifest.
+	ErrHashMismatch = errors.New("hash mismatch")
+)
+
+// errorKind maps an error to the code reported in JSON output and events, and to an exit code.
+type errorKind struct {
+	err      error
+	code     string
+	exitCode int
+}
+
+// Exit codes are documented in the README and must remain stable.
+var errorKinds = []errorKind{
+	{ErrInvalidInput, "invalid_input", 2},
+	{backends.ErrInvalidURI, "invalid_input", 2},
+	{backends.ErrInvalidPrefix, "invalid_input", 2},
+	{backends.ErrLockUnsupported, "invalid_input", 2},
+	{backends.ErrAppendOnly, "invalid_input", 2},
+	{backends.ErrWriteOnly, "invalid_input", 2},
+	{ErrDegraded, "degraded", 3},
+	{ErrNoOp, "no_op", 4},
+	{ErrOutOfSync, "out_of_sync", 5},
+	{ErrLocked, "locked", 6},
+	{files.ErrWrongKey, "wrong_key", 7},
+	{backends.ErrUnreachable, "backend_unreachable", 8},
+	{ErrSnapshotNotFound, "snapshot_not_found", 9},
+	{ErrHashMismatch, "hash_mismatch", 10},
+	{context.DeadlineExceeded, "timeout", 11},
+	{context.Canceled, "canceled", 130},
+}
+
+// ErrorCode will return a short, stable identifier for the error, or "unknown" if it is not one of the errors above.
+func ErrorCode(err error) string {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return kind.code
+		}
+	}
+	return "unknown"
+}
+
+// ExitCode will return the exit code to use for the error: 0 if there was no error,
+// or 1 if it is not one of the errors above.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return kind.exitCode
+		}
+	}
+	return 1
+}
+
+// Status will return the status to report for an operation that completed with the error.
+func Status(err error) string {
+	switch {
+	case err == nil:
+		return report.StatusSuccess
+	case errors.Is(err, ErrDegraded):
+		return report.StatusDegraded
+	case errors.Is(err, ErrNoOp):
+		return report.StatusNoOp
+	default:
+		return report.StatusFailure
+	}
+}
+
+// ReportError will return the error as it is reported in JSON output, or nil if there was no error.
+func ReportError(err error) *report.Error {
+	if err == nil {
+		return nil
+	}
+	return &report.Error{Code: ErrorCode(err), Message: err.Error(), ExitCode: ExitCode(err)}
+}
@@ -0,0 +1,60 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/files"
+)
+
+func TestErrorCodes(t *testing.T) {
+	testCases := []struct {
+		err      error
+		code     string
+		exitCode int
+	}{
+		{nil, "unknown", 0},
+		{errors.New("something else"), "unknown", 1},
+		{ErrInvalidInput, "invalid_input", 2},
+		{fmt.Errorf("tank/data: %w", backends.ErrInvalidPrefix), "invalid_input", 2},
+		{ErrDegraded, "degraded", 3},
+		{ErrNoOp, "no_op", 4},
+		{fmt.Errorf("%w: no snapshot to increment from", ErrOutOfSync), "out_of_sync", 5},
+		{fmt.Errorf("%w: locked", ErrLocked), "locked", 6},
+		{fmt.Errorf("%w - gzip: invalid header", files.ErrWrongKey), "wrong_key", 7},
+		{fmt.Errorf("%w: dial tcp", backends.ErrUnreachable), "backend_unreachable", 8},
+		{errors.Join(errors.New("other"), ErrSnapshotNotFound), "snapshot_not_found", 9},
+		{ErrHashMismatch, "hash_mismatch", 10},
+		{context.Canceled, "canceled", 130},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.code, ErrorCode(tc.err), "%v", tc.err)
+		assert.Equal(t, tc.exitCode, ExitCode(tc.err), "%v", tc.err)
+	}
+}
@@ -32,58 +32,118 @@ import (
 
 	"go.uber.org/zap"
 
-	"github.com/someone1/zfsbackup-go/config"
+	"github.com/someone1/zfsbackup-go/backends"
 	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/metrics"
 )
 
 // List will sync the manifests found in the target destination to the local cache
 // and then read and output the manifest information describing the backup sets
 // found in the target destination.
 // TODO: Group by volume name?
-// nolint:gocyclo // Difficult to break this up
-func List(pctx context.Context, jobInfo *files.JobInfo, startswith string, before, after time.Time) error {
+func (e *Engine) List(pctx context.Context, jobInfo *files.JobInfo, startswith string, before, after time.Time) error {
 	ctx, cancel := context.WithCancel(pctx)
 	defer cancel()
 
+	decodedManifests, localCachePath, localOnlyFiles, err := e.listManifests(ctx, jobInfo, startswith, before, after)
+	if err != nil {
+		return err
+	}
+
+	if !e.jsonOutput {
+		var output []string
+
+		output = append(output, fmt.Sprintf("Found %d backup sets:\n", len(decodedManifests)))
+		for _, manifest := range decodedManifests {
+			output = append(output, manifest.String())
+		}
+
+		if incomplete, ierr := getIncompleteManifests(localCachePath); ierr != nil {
+			e.logger.Warnf("Could not read incomplete manifests from the local cache due to error %v", ierr)
+		} else if len(incomplete) > 0 {
+			output = append(output, fmt.Sprintf(
+				"There are %d backup sets that did not finish uploading to the target destination, they can be completed using the copy command.",
+				len(incomplete),
+			))
+		}
+
+		if len(localOnlyFiles) > 0 {
+			output = append(output, fmt.Sprintf("There are %d manifests found locally that are not on the target destination.", len(localOnlyFiles)))
+			localOnlyOuput := []string{"The following manifests were found locally and can be removed using the clean command."}
+			for _, filename := range localOnlyFiles {
+				manifestPath := filepath.Join(localCachePath, filename)
+				decodedManifest, derr := readManifest(ctx, manifestPath, jobInfo)
+				if derr != nil {
+					e.logger.Warnf("Could not read local only manifest %s due to error %v", manifestPath, derr)
+					continue
+				}
+				localOnlyOuput = append(localOnlyOuput, decodedManifest.String())
+			}
+			e.logger.Infof(strings.Join(localOnlyOuput, "\n"))
+		}
+		fmt.Fprintln(e.stdout, strings.Join(output, "\n"))
+	} else {
+		e.recordResult(ctx, linkManifests(decodedManifests))
+	}
+
+	return nil
+}
+
+// ListBackups will sync the manifests found in the target destination to the local cache and return
+// the backup sets found in the target destination, grouped by volume name.
+func (e *Engine) ListBackups(
+	pctx context.Context, jobInfo *files.JobInfo, startswith string, before, after time.Time,
+) (map[string][]*files.JobInfo, error) {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	decodedManifests, _, _, err := e.listManifests(ctx, jobInfo, startswith, before, after)
+	if err != nil {
+		return nil, err
+	}
+	return linkManifests(decodedManifests), nil
+}
+
+// listManifests will sync the local cache with the target destination and return the manifests matching
+// the filters provided, along with the local cache path and the manifests only found in the local cache.
+func (e *Engine) listManifests(
+	ctx context.Context, jobInfo *files.JobInfo, startswith string, before, after time.Time,
+) ([]*files.JobInfo, string, []string, error) {
 	// Prepare the backend client
 	target := jobInfo.Destinations[0]
-	backend, berr := prepareBackend(ctx, jobInfo, target, nil)
+	backend, berr := e.prepareBackend(ctx, jobInfo, target, nil)
 	if berr != nil {
-		zap.S().Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
-		return berr
+		e.logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
+		return nil, "", nil, berr
 	}
 	defer backend.Close()
 
 	// Get the local cache dir
-	localCachePath, cerr := getCacheDir(jobInfo.Destinations[0])
+	localCachePath, cerr := e.getCacheDir(jobInfo.Destinations[0])
 	if cerr != nil {
-		zap.S().Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
-		return cerr
+		e.logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
+		return nil, "", nil, cerr
 	}
 
 	// Sync the local cache
-	safeManifests, localOnlyFiles, serr := syncCache(ctx, jobInfo, localCachePath, backend)
+	safeManifests, localOnlyFiles, serr := e.syncCache(ctx, jobInfo, localCachePath, backend)
 	if serr != nil {
-		zap.S().Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
-		return serr
+		e.logger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
+		return nil, "", nil, serr
 	}
 
-	decodedManifests, derr := readAndSortManifests(ctx, localCachePath, safeManifests, jobInfo)
+	decodedManifests, derr := e.readAndSortManifests(ctx, localCachePath, safeManifests, jobInfo)
 	if derr != nil {
-		return derr
+		return nil, "", nil, derr
 	}
 
+	recordChainMetrics(target, decodedManifests)
+
 	// Filter Manifests to only results we care about
 	filteredResults := decodedManifests[:0]
 	for _, manifest := range decodedManifests {
-		if startswith != "" {
-			if startswith[len(startswith)-1:] == "*" {
-				if len(startswith) != 1 && !strings.HasPrefix(manifest.VolumeName, startswith[:len(startswith)-1]) {
-					continue
-				}
-			} else if strings.Compare(startswith, manifest.VolumeName) != 0 {
-				continue
-			}
+		if !matchesVolumeName(startswith, manifest.VolumeName) {
+			continue
 		}
 
 		if !before.IsZero() && !manifest.BaseSnapshot.CreationTime.Before(before) {
@@ -97,46 +157,10 @@ func List(pctx context.Context, jobInfo *files.JobInfo, startswith string, befor
 		filteredResults = append(filteredResults, manifest)
 	}
 
-	decodedManifests = filteredResults
-
-	if !config.JSONOutput {
-		var output []string
-
-		output = append(output, fmt.Sprintf("Found %d backup sets:\n", len(decodedManifests)))
-		for _, manifest := range decodedManifests {
-			output = append(output, manifest.String())
-		}
-
-		if len(localOnlyFiles) > 0 {
-			output = append(output, fmt.Sprintf("There are %d manifests found locally that are not on the target destination.", len(localOnlyFiles)))
-			localOnlyOuput := []string{"The following manifests were found locally and can be removed using the clean command."}
-			for _, filename := range localOnlyFiles {
-				manifestPath := filepath.Join(localCachePath, filename)
-				decodedManifest, derr := readManifest(ctx, manifestPath, jobInfo)
-				if derr != nil {
-					zap.S().Warnf("Could not read local only manifest %s due to error %v", manifestPath, derr)
-					continue
-				}
-				localOnlyOuput = append(localOnlyOuput, decodedManifest.String())
-			}
-			zap.S().Infof(strings.Join(localOnlyOuput, "\n"))
-		}
-		fmt.Fprintln(config.Stdout, strings.Join(output, "\n"))
-	} else {
-		organizedManifests := linkManifests(decodedManifests)
-		j, jerr := json.Marshal(organizedManifests)
-		if jerr != nil {
-			zap.S().Errorf("could not marshal results to JSON - %v", jerr)
-			return jerr
-		}
-
-		fmt.Fprintln(config.Stdout, string(j))
-	}
-
-	return nil
+	return filteredResults, localCachePath, localOnlyFiles, nil
 }
 
-func readAndSortManifests(
+func (e *Engine) readAndSortManifests(
 	ctx context.Context,
 	localCachePath string,
 	manifests []string,
@@ -148,7 +172,7 @@ func readAndSortManifests(
 		manifestPath := filepath.Join(localCachePath, manifest)
 		decodedManifest, oerr := readManifest(ctx, manifestPath, jobInfo)
 		if oerr != nil {
-			zap.S().Errorf("Could not read manifest %s due to error - %v", manifestPath, oerr)
+			e.logger.Errorf("Could not read manifest %s due to error - %v", manifestPath, oerr)
 			return nil, oerr
 		}
 		decodedManifests = append(decodedManifests, decodedManifest)
@@ -213,6 +237,36 @@ func linkManifests(manifests []*files.JobInfo) map[string][]*files.JobInfo {
 	return manifestTree
 }
 
+// recordChainMetrics will update the chain length and stored bytes metrics for every volume
+// found in the provided manifests. The chain length is computed by walking back from the most
+// recent backup set to the full backup it depends on.
+func recordChainMetrics(destination string, manifests []*files.JobInfo) {
+	latest := make(map[string]*files.JobInfo)
+	stored := make(map[string]uint64)
+	for _, manifest := range manifests {
+		stored[manifest.VolumeName] += manifest.TotalBytesWritten()
+		if l, ok := latest[manifest.VolumeName]; !ok || manifest.BaseSnapshot.CreationTime.After(l.BaseSnapshot.CreationTime) {
+			latest[manifest.VolumeName] = manifest
+		}
+	}
+
+	linkManifests(manifests)
+	label := backends.RedactURI(destination)
+	for volumeName, manifest := range latest {
+		length := 0
+		for snap := manifest; snap != nil && length <= len(manifests); snap = snap.ParentSnap {
+			length++
+		}
+		metrics.ChainLength.WithLabelValues(volumeName, label).Set(float64(length))
+		metrics.StoredBytes.WithLabelValues(volumeName, label).Set(float64(stored[volumeName]))
+	}
+
+	// Leave the manifests as we found them so the caller can link a filtered list itself
+	for _, manifest := range manifests {
+		manifest.ParentSnap = nil
+	}
+}
+
 func readManifest(ctx context.Context, manifestPath string, j *files.JobInfo) (*files.JobInfo, error) {
 	decodedManifest := new(files.JobInfo)
 	manifestVol, err := files.ExtractLocal(j, manifestPath)
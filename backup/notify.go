@@ -0,0 +1,155 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/events"
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/notify"
+	"github.com/someone1/zfsbackup-go/report"
+)
+
+// Operations reported in notification events.
+const (
+	operationSend    = "send"
+	operationReceive = "receive"
+	operationClean   = "clean"
+	operationCopy    = "copy"
+	operationVerify  = "verify"
+)
+
+// notifyStart will notify any hooks that the operation is starting for the provided job. The chain
+// lists the backup sets the operation will work on, if known.
+func (e *Engine) notifyStart(ctx context.Context, operation string, jobInfo *files.JobInfo, chain ...*files.JobInfo) {
+	e.notifier.Notify(ctx, newEvent(notify.Start, operation, jobInfo, chain, nil, nil))
+}
+
+// notifyDone will notify any hooks of the outcome of the operation, result should be what
+// the operation would output with --jsonOutput, if anything. The chain lists the backup sets
+// the operation worked on.
+func (e *Engine) notifyDone(ctx context.Context, operation string, err error, result interface{}, jobInfo *files.JobInfo, chain ...*files.JobInfo) {
+	event := notify.Success
+	switch {
+	case errors.Is(err, ErrDegraded):
+		event = notify.Degraded
+	case err != nil && !errors.Is(err, ErrNoOp):
+		event = notify.Failure
+	}
+	if event == notify.Failure {
+		e.emitError(ctx, operation, jobInfo, "", err)
+	}
+	e.notifier.Notify(ctx, newEvent(event, operation, jobInfo, chain, result, err))
+}
+
+func newEvent(
+	eventType notify.EventType,
+	operation string,
+	jobInfo *files.JobInfo,
+	chain []*files.JobInfo,
+	result interface{},
+	err error,
+) *notify.Event {
+	event := &notify.Event{Event: eventType, Operation: operation, VolumeName: jobInfo.VolumeName, Result: result}
+	if err != nil {
+		event.Code = ErrorCode(err)
+		event.Error = err.Error()
+	}
+
+	seen := make(map[string]bool)
+	for _, job := range append([]*files.JobInfo{jobInfo}, chain...) {
+		for _, destination := range job.Destinations {
+			if !seen[destination] && destination != backends.DeleteBackendPrefix+"://" {
+				seen[destination] = true
+				event.Destinations = append(event.Destinations, backends.RedactURI(destination))
+			}
+		}
+	}
+
+	for _, job := range chain {
+		event.Chain = append(event.Chain, notify.Link{
+			VolumeName:      job.VolumeName,
+			Snapshot:        job.BaseSnapshot.Name,
+			CreationTime:    job.BaseSnapshot.CreationTime,
+			IncrementalFrom: job.IncrementalSnapshot.Name,
+		})
+	}
+	return event
+}
+
+// eventStream will return the stream to emit events for the operation running with the context to,
+// falling back to the stream configured for the Engine.
+func (e *Engine) eventStream(ctx context.Context) *events.Stream {
+	if stream := events.FromContext(ctx); stream != nil {
+		return stream
+	}
+	return e.events
+}
+
+// recordResult will record the result of the operation running with the context, falling back to
+// the recorder configured for the Engine, if any.
+func (e *Engine) recordResult(ctx context.Context, result interface{}) {
+	if recorder := report.FromContext(ctx); recorder != nil {
+		recorder.Record(result)
+		return
+	}
+	if e.recorder != nil {
+		e.recorder.Record(result)
+	}
+}
+
+func (e *Engine) emitError(ctx context.Context, operation string, jobInfo *files.JobInfo, destination string, err error) {
+	e.eventStream(ctx).Emit(&events.Event{
+		Type:        events.Error,
+		Operation:   operation,
+		VolumeName:  jobInfo.VolumeName,
+		Destination: backends.RedactURI(destination),
+		Code:        ErrorCode(err),
+		Error:       err.Error(),
+	})
+}
+
+func (e *Engine) emitVolumeCreated(ctx context.Context, jobInfo *files.JobInfo, volume *files.VolumeInfo) {
+	e.eventStream(ctx).Emit(&events.Event{
+		Type:       events.VolumeCreated,
+		VolumeName: jobInfo.VolumeName,
+		Snapshot:   jobInfo.BaseSnapshot.Name,
+		ObjectName: volume.ObjectName,
+		Volume:     volume.VolumeNumber,
+		Bytes:      volume.Size,
+	})
+}
+
+func (e *Engine) emitRetry(ctx context.Context, jobInfo *files.JobInfo, destination string, volume *files.VolumeInfo, next time.Duration, err error) {
+	e.eventStream(ctx).Emit(&events.Event{
+		Type:        events.RetryScheduled,
+		VolumeName:  jobInfo.VolumeName,
+		Destination: backends.RedactURI(destination),
+		ObjectName:  volume.ObjectName,
+		Volume:      volume.VolumeNumber,
+		RetryIn:     next,
+		Error:       err.Error(),
+	})
+}
@@ -0,0 +1,266 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/config"
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/zfs"
+)
+
+// SmartOptions will return the number of "smart" options set on the job: Full, Incremental, and
+// FullIfOlderThan, which is unset when -1 minute.
+func SmartOptions(jobInfo *files.JobInfo) int {
+	count := 0
+	if jobInfo.Full {
+		count++
+	}
+	if jobInfo.Incremental {
+		count++
+	}
+	if jobInfo.FullIfOlderThan != -1*time.Minute {
+		count++
+	}
+	return count
+}
+
+// ParseRequire will convert the number of destinations a backup must complete to, all, any, or a number,
+// to the value of RequiredDestinations, 0 meaning all of them.
+func ParseRequire(value string) (int, error) {
+	switch strings.ToLower(value) {
+	case "all":
+		return 0, nil
+	case "any":
+		return 1, nil
+	}
+
+	required, err := strconv.Atoi(value)
+	if err != nil || required < 1 {
+		return 0, fmt.Errorf("%w: the required destinations must be all, any, or a number greater than 0, was given %s", ErrInvalidInput, value)
+	}
+	return required, nil
+}
+
+// ValidateDestinations will check that the job's destinations are supported and can be used together.
+func ValidateDestinations(jobInfo *files.JobInfo) error {
+	if len(jobInfo.Destinations) == 0 {
+		return fmt.Errorf("%w: at least one destination must be provided", ErrInvalidInput)
+	}
+
+	if len(jobInfo.Destinations) > 1 && jobInfo.MaxFileBuffer == 0 {
+		return fmt.Errorf("%w: specifying multiple destinations and a MaxFileBuffer size of 0 is unsupported", ErrInvalidInput)
+	}
+
+	if jobInfo.RequiredDestinations > len(jobInfo.Destinations) {
+		return fmt.Errorf(
+			"%w: cannot require %d destinations when only %d were provided",
+			ErrInvalidInput, jobInfo.RequiredDestinations, len(jobInfo.Destinations),
+		)
+	}
+
+	for _, destination := range jobInfo.Destinations {
+		if isReplicationTarget(destination) {
+			if _, err := parseReplicationTarget(destination); err != nil {
+				return err
+			}
+			if jobInfo.LockDuration > 0 {
+				return fmt.Errorf("%w: cannot lock the snapshots replicated to %s", ErrInvalidInput, destination)
+			}
+			continue
+		}
+		_, err := backends.GetBackendForURI(destination)
+		if errors.Is(err, backends.ErrInvalidPrefix) {
+			return fmt.Errorf("%w: unsupported prefix provided in destination URI, was given %s", err, destination)
+		} else if errors.Is(err, backends.ErrInvalidURI) {
+			return fmt.Errorf("%w: unsupported destination URI, was given %s", err, destination)
+		}
+	}
+
+	return nil
+}
+
+// PrepareSend will validate the job's send options and destinations and return the jobs to pass to
+// BackupJobs for the target, a volume@snapshot to backup, or just the volume when using a "smart" option.
+// nolint:gocyclo,funlen // Will do later
+func (e *Engine) PrepareSend(ctx context.Context, jobInfo *files.JobInfo, target string) ([]*files.JobInfo, error) {
+	jobInfo.StartTime = time.Now()
+	jobInfo.Version = config.VersionNumber
+
+	if err := jobInfo.ValidateSendFlags(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+
+	parts := strings.Split(target, "@")
+	jobInfo.VolumeName = parts[0]
+
+	if err := ValidateDestinations(jobInfo); err != nil {
+		return nil, err
+	}
+
+	if SmartOptions(jobInfo) > 1 {
+		return nil, fmt.Errorf("%w: please specify only one \"smart\" option at a time", ErrInvalidInput)
+	}
+
+	// If we aren't using a "smart" option, rely on the user to provide the snapshots to use!
+	if SmartOptions(jobInfo) == 0 {
+		if len(parts) != 2 {
+			return nil, fmt.Errorf(
+				"%w: invalid base snapshot provided, expected format <volume>@<snapshot>, got %s instead", ErrInvalidInput, target,
+			)
+		}
+		jobInfo.BaseSnapshot = files.SnapshotInfo{Name: parts[1]}
+		creationTime, err := zfs.GetCreationDate(ctx, e.zfs, target)
+		if err != nil {
+			e.logger.Errorf("Error trying to get creation date of specified base snapshot - %v", err)
+			return nil, fmt.Errorf("%w: %v", ErrSnapshotNotFound, err)
+		}
+		jobInfo.BaseSnapshot.CreationTime = creationTime
+
+		if jobInfo.IncrementalSnapshot.Name != "" {
+			var targetName string
+			jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, jobInfo.VolumeName)
+			if strings.HasPrefix(jobInfo.IncrementalSnapshot.Name, "#") {
+				jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, "#")
+				targetName = fmt.Sprintf("%s#%s", jobInfo.VolumeName, jobInfo.IncrementalSnapshot.Name)
+				jobInfo.IncrementalSnapshot.Bookmark = true
+			} else {
+				jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, "@")
+				targetName = fmt.Sprintf("%s@%s", jobInfo.VolumeName, jobInfo.IncrementalSnapshot.Name)
+			}
+
+			creationTime, err = zfs.GetCreationDate(ctx, e.zfs, targetName)
+			if err != nil {
+				e.logger.Errorf("Error trying to get creation date of specified incremental snapshot/bookmark - %v", err)
+				return nil, fmt.Errorf("%w: %v", ErrSnapshotNotFound, err)
+			}
+			jobInfo.IncrementalSnapshot.CreationTime = creationTime
+		}
+		return []*files.JobInfo{jobInfo}, nil
+	}
+
+	if len(parts) != 1 {
+		return nil, fmt.Errorf(
+			"%w: when using a smart option, please only specify the volume to backup, do not include any snapshot information",
+			ErrInvalidInput,
+		)
+	}
+	jobs, err := e.ProcessSmartOptions(ctx, jobInfo)
+	if err != nil {
+		e.logger.Errorf("Error while trying to process smart option - %v", err)
+		return nil, err
+	}
+	e.logger.Debugf("Utilizing smart option.")
+	return jobs, nil
+}
+
+// PrepareReceive will validate the job's receive options and destinations for the target, a
+// volume@snapshot to restore, or just the volume when using AutoRestore. The LocalVolume to
+// restore to must already be set on the job.
+// nolint:gocyclo // Will do later
+func (e *Engine) PrepareReceive(ctx context.Context, jobInfo *files.JobInfo, target string) error {
+	jobInfo.StartTime = time.Now()
+
+	parts := strings.Split(target, "@")
+	if len(parts) != 2 && !jobInfo.AutoRestore {
+		return fmt.Errorf("%w: invalid base snapshot provided, expected format <volume>@<snapshot>, got %s instead", ErrInvalidInput, target)
+	} else if len(parts) == 2 {
+		jobInfo.BaseSnapshot = files.SnapshotInfo{Name: parts[1]}
+	}
+
+	if jobInfo.FullPath && jobInfo.LastPath {
+		return fmt.Errorf("%w: the -d and -e options are mutually exclusive, please select only one", ErrInvalidInput)
+	}
+
+	if jobInfo.LocalVolume == "" {
+		return fmt.Errorf("%w: a local volume to restore to must be provided", ErrInvalidInput)
+	}
+
+	jobInfo.VolumeName = parts[0]
+
+	// Intelligently restore to the snapshot wanted
+	if jobInfo.AutoRestore && jobInfo.IncrementalSnapshot.Name != "" {
+		return fmt.Errorf("%w: cannot request auto restore option and provide an incremental snapshot to restore from", ErrInvalidInput)
+	}
+
+	// Remove 'origin=' from beginning of -o argument
+	jobInfo.Origin = strings.TrimPrefix(jobInfo.Origin, "origin=")
+
+	if !jobInfo.AutoRestore {
+		// Let's see if we already have this snap shot
+		creationTime, err := zfs.GetCreationDate(ctx, e.zfs, fmt.Sprintf("%s@%s", jobInfo.LocalVolume, jobInfo.BaseSnapshot.Name))
+		if err == nil {
+			jobInfo.BaseSnapshot.CreationTime = creationTime
+		}
+		if jobInfo.IncrementalSnapshot.Name != "" {
+			jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, jobInfo.VolumeName)
+			jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, "@")
+			creationTime, err = zfs.GetCreationDate(ctx, e.zfs, fmt.Sprintf("%s@%s", jobInfo.LocalVolume, jobInfo.IncrementalSnapshot.Name))
+			if err == nil {
+				jobInfo.IncrementalSnapshot.CreationTime = creationTime
+			}
+		}
+	}
+
+	return checkDestinationURIs(jobInfo.Destinations)
+}
+
+// PrepareVerify will validate the job's verify options and destination for the target, a volume@snapshot
+// to verify, or just the volume to verify its latest backup set.
+func (e *Engine) PrepareVerify(_ context.Context, jobInfo *files.JobInfo, target string) error {
+	jobInfo.StartTime = time.Now()
+
+	volume, snapshot, _ := strings.Cut(target, "@")
+	if volume == "" {
+		return fmt.Errorf("%w: a volume to verify must be provided", ErrInvalidInput)
+	}
+	jobInfo.VolumeName = volume
+	jobInfo.BaseSnapshot = files.SnapshotInfo{Name: snapshot}
+
+	if len(jobInfo.Destinations) == 0 {
+		return fmt.Errorf("%w: a destination to verify the backup sets in must be provided", ErrInvalidInput)
+	}
+
+	if jobInfo.MaxParallelUploads < 1 {
+		return fmt.Errorf("%w: at least one volume must be verified at a time, was given %d", ErrInvalidInput, jobInfo.MaxParallelUploads)
+	}
+
+	return checkDestinationURIs(jobInfo.Destinations)
+}
+
+func checkDestinationURIs(destinations []string) error {
+	for _, destination := range destinations {
+		_, err := backends.GetBackendForURI(destination)
+		if errors.Is(err, backends.ErrInvalidPrefix) {
+			return fmt.Errorf("%w: unsupported prefix provided in destination URI, was given %s", err, destination)
+		} else if errors.Is(err, backends.ErrInvalidURI) {
+			return fmt.Errorf("%w: invalid destination URI, was given %s", err, destination)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/zfs"
+)
+
+func TestParseRequire(t *testing.T) {
+	testCases := []struct {
+		value    string
+		required int
+		valid    bool
+	}{
+		{"all", 0, true},
+		{"ANY", 1, true},
+		{"2", 2, true},
+		{"0", 0, false},
+		{"some", 0, false},
+	}
+
+	for _, tc := range testCases {
+		required, err := ParseRequire(tc.value)
+		if !tc.valid {
+			assert.ErrorIs(t, err, ErrInvalidInput, tc.value)
+			continue
+		}
+		assert.NoError(t, err, tc.value)
+		assert.Equal(t, tc.required, required, tc.value)
+	}
+}
+
+func TestPrepareSendValidation(t *testing.T) {
+	newJob := func() *files.JobInfo {
+		return &files.JobInfo{
+			Destinations:       []string{"file:///tmp"},
+			FullIfOlderThan:    -1 * time.Minute,
+			MaxFileBuffer:      5,
+			MaxParallelUploads: 4,
+			Separator:          "|",
+			UploadChunkSize:    10,
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		target string
+		modify func(j *files.JobInfo)
+	}{
+		{"no destinations", "tank@snap", func(j *files.JobInfo) { j.Destinations = nil }},
+		{"bad destination", "tank@snap", func(j *files.JobInfo) { j.Destinations = []string{"nope://bucket"} }},
+		{"multiple destinations without buffer", "tank@snap", func(j *files.JobInfo) {
+			j.Destinations = []string{"file:///a", "file:///b"}
+			j.MaxFileBuffer = 0
+		}},
+		{"too many required", "tank@snap", func(j *files.JobInfo) { j.RequiredDestinations = 2 }},
+		{"no snapshot", "tank", func(j *files.JobInfo) {}},
+		{"multiple smart options", "tank", func(j *files.JobInfo) { j.Full, j.Incremental = true, true }},
+		{"smart option with snapshot", "tank@snap", func(j *files.JobInfo) { j.Full = true }},
+		{"invalid send flags", "tank@snap", func(j *files.JobInfo) { j.MaxParallelUploads = 0 }},
+	}
+
+	e := newTestEngine(t, zfs.NewFakePool())
+	for _, tc := range testCases {
+		j := newJob()
+		tc.modify(j)
+		_, err := e.PrepareSend(context.Background(), j, tc.target)
+		assert.Equal(t, "invalid_input", ErrorCode(err), tc.name)
+	}
+}
+
+func TestPrepareReceiveValidation(t *testing.T) {
+	testCases := []struct {
+		name string
+		job  files.JobInfo
+	}{
+		{"no snapshot", files.JobInfo{LocalVolume: "tank"}},
+		{"no local volume", files.JobInfo{AutoRestore: true}},
+		{"full and last path", files.JobInfo{AutoRestore: true, LocalVolume: "tank", FullPath: true, LastPath: true}},
+		{"auto restore with incremental", files.JobInfo{
+			AutoRestore: true, LocalVolume: "tank", IncrementalSnapshot: files.SnapshotInfo{Name: "snap"},
+		}},
+	}
+
+	e := newTestEngine(t, zfs.NewFakePool())
+	for _, tc := range testCases {
+		target := "tank/data"
+		err := e.PrepareReceive(context.Background(), &tc.job, target)
+		assert.True(t, errors.Is(err, ErrInvalidInput), tc.name)
+	}
+}
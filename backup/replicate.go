@@ -0,0 +1,295 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/juju/ratelimit"
+	"github.com/miolini/datacounter"
+	"github.com/schollz/progressbar/v3"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/events"
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/metrics"
+	"github.com/someone1/zfsbackup-go/zfs"
+)
+
+const (
+	// ReplicationPrefix is the URI prefix of a dataset, e.g. zfs://pool/dataset, that snapshots are
+	// replicated to with zfs receive on the same host zfs runs on for the send.
+	ReplicationPrefix = "zfs"
+	// RemoteReplicationPrefix is the URI prefix of a dataset on another host, e.g.
+	// ssh+zfs://user@host:22/pool/dataset, that snapshots are replicated to with zfs receive over SSH.
+	// The path to zfs on the host can be given with the zfsPath query parameter, and the keyFile and
+	// knownHosts query parameters of the ssh:// backend are passed on to the connection.
+	RemoteReplicationPrefix = "ssh+zfs"
+)
+
+// replicationTarget is a destination that the send stream is received into directly instead of being
+// stored as volumes and manifests.
+type replicationTarget struct {
+	// host is empty when replicating with the Engine's own zfs runner, and ends with the ssh options given.
+	host    string
+	zfsPath string
+	dataset string
+}
+
+// isReplicationTarget will return true if the destination is a dataset to replicate to.
+func isReplicationTarget(destination string) bool {
+	return strings.HasPrefix(destination, ReplicationPrefix+"://") || strings.HasPrefix(destination, RemoteReplicationPrefix+"://")
+}
+
+// hasVolumeDestinations will return true if any of the destinations store volumes.
+func hasVolumeDestinations(destinations []string) bool {
+	for _, destination := range destinations {
+		if !isReplicationTarget(destination) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseReplicationTarget(destination string) (*replicationTarget, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid replication target %s - %v", ErrInvalidInput, destination, err)
+	}
+
+	target := &replicationTarget{}
+	switch u.Scheme {
+	case ReplicationPrefix:
+		target.dataset = strings.Trim(u.Host+u.Path, "/")
+	case RemoteReplicationPrefix:
+		if u.Host == "" {
+			return nil, fmt.Errorf("%w: no host provided in replication target %s", ErrInvalidInput, destination)
+		}
+		target.host = u.Host
+		if u.User != nil {
+			target.host = u.User.String() + "@" + u.Host
+		}
+		query := u.Query()
+		target.zfsPath = query.Get("zfsPath")
+		query.Del("zfsPath")
+		if len(query) > 0 {
+			target.host += "?" + query.Encode()
+		}
+		target.dataset = strings.Trim(u.Path, "/")
+	default:
+		return nil, fmt.Errorf("%w: %s is not a replication target", ErrInvalidInput, destination)
+	}
+	if target.dataset == "" {
+		return nil, fmt.Errorf("%w: no dataset provided in replication target %s", ErrInvalidInput, destination)
+	}
+	return target, nil
+}
+
+// replicationRunner will return the runner to receive into the target with, and a function to call once done with it.
+func (e *Engine) replicationRunner(target *replicationTarget) (zfs.Runner, func(), error) {
+	if target.host == "" {
+		return e.zfs, func() {}, nil
+	}
+	runner, err := e.dialZFS(target.host, target.zfsPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: could not connect to %s - %v", backends.ErrUnreachable, target.host, err)
+	}
+	return runner, func() {
+		if closer, ok := runner.(io.Closer); ok {
+			if cerr := closer.Close(); cerr != nil {
+				e.logger.Warnf("Could not close connection to %s - %v", target.host, cerr)
+			}
+		}
+	}, nil
+}
+
+// getReplicasForTarget will return the snapshots of the volume found in the replication target, newest
+// first, as backup jobs incremental from the snapshot before them, so they can be compared like manifests.
+func (e *Engine) getReplicasForTarget(ctx context.Context, volume, destination string, jobInfo *files.JobInfo) ([]*files.JobInfo, error) {
+	target, err := parseReplicationTarget(destination)
+	if err != nil {
+		return nil, err
+	}
+	runner, done, err := e.replicationRunner(target)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	snapshots, err := runner.GetSnapshotsAndBookmarks(ctx, target.dataset)
+	if err != nil {
+		if strings.Contains(err.Error(), "dataset does not exist") {
+			// Nothing has been replicated to the target yet
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: could not list the snapshots of %s - %v", backends.ErrUnreachable, destination, err)
+	}
+
+	var replicas []*files.JobInfo
+	for _, snapshot := range snapshots {
+		if snapshot.Bookmark || !strings.HasPrefix(snapshot.Name, jobInfo.SnapshotPrefix) {
+			continue
+		}
+		if len(replicas) > 0 {
+			replicas[len(replicas)-1].IncrementalSnapshot = snapshot
+		}
+		replicas = append(replicas, &files.JobInfo{VolumeName: volume, BaseSnapshot: snapshot})
+	}
+	return replicas, nil
+}
+
+// replicate will send the job's snapshot straight into zfs receive on every replication target at once, reading
+// it from zfs a single time, and return the error of each target that could not be replicated to. A target
+// failing is dropped from the stream without stopping the others.
+func (e *Engine) replicate(ctx context.Context, jobInfo *files.JobInfo, destinations []string) map[string]error {
+	failed := make(map[string]error)
+	type receiver struct {
+		destination string
+		runner      zfs.Runner
+		dataset     string
+		pr          *io.PipeReader
+		pw          *io.PipeWriter
+		err         error
+	}
+	var receivers []*receiver
+	for _, destination := range destinations {
+		target, err := parseReplicationTarget(destination)
+		if err != nil {
+			failed[destination] = err
+			continue
+		}
+		runner, done, err := e.replicationRunner(target)
+		if err != nil {
+			failed[destination] = err
+			continue
+		}
+		defer done()
+		pr, pw := io.Pipe()
+		receivers = append(receivers, &receiver{destination: destination, runner: runner, dataset: target.dataset, pr: pr, pw: pw})
+	}
+	if len(receivers) == 0 {
+		return failed
+	}
+
+	e.logger.Infof("Replicating %s@%s to %d targets.", jobInfo.VolumeName, jobInfo.BaseSnapshot.Name, len(receivers))
+	var wg sync.WaitGroup
+	writers := make([]io.Writer, len(receivers))
+	for idx, r := range receivers {
+		writers[idx] = r.pw
+		var reader io.Reader = r.pr
+		if e.uploadBucket != nil {
+			reader = ratelimit.Reader(reader, e.uploadBucket)
+		}
+		if limiter := jobInfo.UploadLimiters[r.destination]; limiter != nil {
+			reader = limiter.Reader(reader)
+		}
+		receiveJob := &files.JobInfo{
+			VolumeName:          jobInfo.VolumeName,
+			BaseSnapshot:        jobInfo.BaseSnapshot,
+			IncrementalSnapshot: jobInfo.IncrementalSnapshot,
+			LocalVolume:         r.dataset,
+			NotMounted:          true,
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.err = r.runner.Receive(ctx, receiveJob, reader)
+			r.pr.CloseWithError(r.err)
+		}()
+	}
+
+	fanOut := &replicaWriter{writers: writers, errs: make([]error, len(writers))}
+	counter := datacounter.NewWriterCounter(fanOut)
+	var w io.Writer = counter
+	if jobInfo.ProgressBar {
+		w = io.MultiWriter(counter, progressbar.DefaultBytes(-1, "replicating"))
+	}
+	serr := e.zfs.Send(ctx, jobInfo, w)
+	for _, r := range receivers {
+		r.pw.CloseWithError(serr)
+	}
+	wg.Wait()
+
+	e.manifestMutex.Lock()
+	jobInfo.ZFSStreamBytes = counter.Count()
+	e.manifestMutex.Unlock()
+	// The volume pipeline reads the stream again and records it itself
+	if !hasVolumeDestinations(jobInfo.Destinations) {
+		metrics.ZFSStreamBytes.WithLabelValues(jobInfo.VolumeName).Add(float64(counter.Count()))
+	}
+
+	for idx, r := range receivers {
+		err := r.err
+		if err == nil {
+			err = fanOut.errs[idx]
+		}
+		if err == nil {
+			err = serr
+		}
+		if err != nil {
+			failed[r.destination] = err
+			continue
+		}
+		e.eventStream(ctx).Emit(&events.Event{
+			Type:        events.Replicated,
+			VolumeName:  jobInfo.VolumeName,
+			Snapshot:    jobInfo.BaseSnapshot.Name,
+			Incremental: jobInfo.IncrementalSnapshot.Name,
+			Destination: backends.RedactURI(r.destination),
+			Bytes:       counter.Count(),
+		})
+	}
+	return failed
+}
+
+// errNoReplicas stops the send once every replication target has failed.
+var errNoReplicas = errors.New("every replication target failed")
+
+// replicaWriter writes to every writer that has not failed yet, so one replication target failing does not
+// stop the stream to the others.
+type replicaWriter struct {
+	writers []io.Writer
+	errs    []error
+}
+
+func (w *replicaWriter) Write(p []byte) (int, error) {
+	written := false
+	for idx, writer := range w.writers {
+		if w.errs[idx] != nil {
+			continue
+		}
+		if _, err := writer.Write(p); err != nil {
+			w.errs[idx] = err
+			continue
+		}
+		written = true
+	}
+	if !written {
+		return 0, errNoReplicas
+	}
+	return len(p), nil
+}
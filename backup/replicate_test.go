@@ -0,0 +1,178 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/zfs"
+)
+
+func TestParseReplicationTarget(t *testing.T) {
+	testCases := []struct {
+		destination string
+		expected    *replicationTarget
+		valid       bool
+	}{
+		{"zfs://tank/replica", &replicationTarget{dataset: "tank/replica"}, true},
+		{"zfs://tank/replica/", &replicationTarget{dataset: "tank/replica"}, true},
+		{"ssh+zfs://dr.example.com/backup/data", &replicationTarget{host: "dr.example.com", dataset: "backup/data"}, true},
+		{
+			"ssh+zfs://root@dr.example.com:2222/backup/data?zfsPath=/sbin/zfs",
+			&replicationTarget{host: "root@dr.example.com:2222", zfsPath: "/sbin/zfs", dataset: "backup/data"},
+			true,
+		},
+		{
+			"ssh+zfs://dr.example.com/backup/data?zfsPath=zfs&keyFile=/root/.ssh/dr",
+			&replicationTarget{host: "dr.example.com?keyFile=%2Froot%2F.ssh%2Fdr", zfsPath: "zfs", dataset: "backup/data"},
+			true,
+		},
+		{"zfs://", nil, false},
+		{"ssh+zfs://dr.example.com", nil, false},
+		{"file:///tmp", nil, false},
+	}
+
+	for _, c := range testCases {
+		target, err := parseReplicationTarget(c.destination)
+		if !c.valid {
+			assert.ErrorIs(t, err, ErrInvalidInput, c.destination)
+			continue
+		}
+		require.NoError(t, err, c.destination)
+		assert.Equal(t, c.expected, target, c.destination)
+	}
+}
+
+func TestReplicate(t *testing.T) {
+	source := newTestPool(t, "tank/data", "auto-a")
+	remote := newTestPool(t, "backup")
+	fileDestination := fmt.Sprintf("%s://%s", backends.FileBackendPrefix, t.TempDir())
+	localTarget := fmt.Sprintf("%s://tank/replica", ReplicationPrefix)
+	remoteTarget := fmt.Sprintf("%s://root@dr/backup/data", RemoteReplicationPrefix)
+
+	var dialed []string
+	e := newTestEngine(t, source, WithZFSDialer(func(host, path string) (zfs.Runner, error) {
+		dialed = append(dialed, host)
+		if host != "root@dr" {
+			return nil, errors.New("no route to host")
+		}
+		return remote, nil
+	}))
+	backupJob := func(destinations ...string) error {
+		t.Helper()
+		jobInfo := &files.JobInfo{
+			VolumeName:         "tank/data",
+			VolumeSize:         1,
+			UploadChunkSize:    1,
+			Destinations:       destinations,
+			MaxParallelUploads: 5,
+			MaxFileBuffer:      5,
+			MaxBackoffTime:     5 * time.Millisecond,
+			MaxRetryTime:       1 * time.Second,
+			StartTime:          time.Now(),
+			ManifestPrefix:     "manifests",
+			Separator:          "|",
+			SnapshotPrefix:     "auto-",
+			Incremental:        true,
+			FullIfOlderThan:    -1 * time.Minute,
+		}
+		require.NoError(t, ValidateDestinations(jobInfo))
+		jobs, err := e.ProcessSmartOptions(context.Background(), jobInfo)
+		if err != nil {
+			return err
+		}
+		return e.BackupJobs(context.Background(), jobs)
+	}
+
+	// Nothing has been replicated yet so there is nothing to increment from
+	err := backupJob(localTarget)
+	require.ErrorIs(t, err, ErrOutOfSync)
+
+	// Seed the replicas and file backup with a full of auto-a
+	jobs, err := e.ProcessSmartOptions(context.Background(), &files.JobInfo{
+		VolumeName:         "tank/data",
+		VolumeSize:         1,
+		UploadChunkSize:    1,
+		Destinations:       []string{localTarget, remoteTarget, fileDestination},
+		MaxParallelUploads: 5,
+		MaxFileBuffer:      5,
+		MaxBackoffTime:     5 * time.Millisecond,
+		MaxRetryTime:       1 * time.Second,
+		StartTime:          time.Now(),
+		ManifestPrefix:     "manifests",
+		Separator:          "|",
+		SnapshotPrefix:     "auto-",
+		Full:               true,
+		FullIfOlderThan:    -1 * time.Minute,
+	})
+	require.NoError(t, err)
+	require.NoError(t, e.BackupJobs(context.Background(), jobs))
+	assert.Equal(t, []string{"auto-a"}, snapshotNames(t, source, "tank/replica"))
+	assert.Equal(t, []string{"auto-a"}, snapshotNames(t, remote, "backup/data"))
+
+	// Snapshots without the prefix are neither sent nor used as a base
+	mustSnapshot(t, source, "tank/data", "manual")
+	mustSnapshot(t, source, "tank/data", "auto-b")
+	sends := source.Sends()
+	require.NoError(t, backupJob(localTarget, remoteTarget, fileDestination))
+	assert.Equal(t, sends+2, source.Sends(), "one send for the replicas and one for the volumes")
+	assert.Equal(t, []string{"auto-b", "auto-a"}, snapshotNames(t, source, "tank/replica"))
+	assert.Equal(t, []string{"auto-b", "auto-a"}, snapshotNames(t, remote, "backup/data"))
+	for _, name := range []string{"auto-a", "auto-b"} {
+		sent, gerr := source.GetZFSProperty(context.Background(), "guid", "tank/data@"+name)
+		require.NoError(t, gerr)
+		received, gerr := remote.GetZFSProperty(context.Background(), "guid", "backup/data@"+name)
+		require.NoError(t, gerr)
+		assert.Equal(t, sent, received, "snapshot %s", name)
+	}
+
+	// The replicas are up to date
+	require.ErrorIs(t, backupJob(localTarget, remoteTarget), ErrNoOp)
+
+	// A target that is behind is brought up to date on its own
+	mustSnapshot(t, source, "tank/data", "auto-c")
+	require.NoError(t, backupJob(remoteTarget))
+	assert.Equal(t, []string{"auto-c", "auto-b", "auto-a"}, snapshotNames(t, remote, "backup/data"))
+	require.NoError(t, backupJob(localTarget))
+	assert.Equal(t, []string{"auto-c", "auto-b", "auto-a"}, snapshotNames(t, source, "tank/replica"))
+
+	// Every replica is fed from a single send
+	mustSnapshot(t, source, "tank/data", "auto-d")
+	sends = source.Sends()
+	require.NoError(t, backupJob(localTarget, remoteTarget))
+	assert.Equal(t, sends+1, source.Sends())
+	assert.Equal(t, []string{"auto-d", "auto-c", "auto-b", "auto-a"}, snapshotNames(t, remote, "backup/data"))
+	assert.Equal(t, []string{"auto-d", "auto-c", "auto-b", "auto-a"}, snapshotNames(t, source, "tank/replica"))
+
+	// An unreachable host fails the replication
+	err = backupJob(fmt.Sprintf("%s://dr2/backup/data", RemoteReplicationPrefix))
+	require.ErrorIs(t, err, backends.ErrUnreachable)
+	assert.Contains(t, dialed, "dr2")
+}
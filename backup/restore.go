@@ -23,7 +23,7 @@ package backup
 import (
 	"context"
 	"crypto/md5" // nolint:gosec // MD5 not used for cryptographic purposes here
-	"errors"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
@@ -33,14 +33,50 @@ import (
 
 	"github.com/cenkalti/backoff"
 	"github.com/schollz/progressbar/v3"
-	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/events"
 	"github.com/someone1/zfsbackup-go/files"
-	"github.com/someone1/zfsbackup-go/zfs"
+	"github.com/someone1/zfsbackup-go/metrics"
 )
 
+// restoreSummary is output once a restore completes.
+type restoreSummary struct {
+	Restored      []restoredSnapshot
+	TotalBytes    uint64
+	TotalZFSBytes uint64
+	ElapsedTime   time.Duration
+}
+
+type restoredSnapshot struct {
+	VolumeName          string
+	Snapshot            files.SnapshotInfo
+	IncrementalSnapshot *files.SnapshotInfo `json:",omitempty"`
+	Bytes               uint64
+	ZFSBytes            uint64
+}
+
+func summarizeRestore(restored []*files.JobInfo, startTime time.Time) *restoreSummary {
+	summary := &restoreSummary{Restored: make([]restoredSnapshot, 0, len(restored)), ElapsedTime: time.Since(startTime)}
+	for _, manifest := range restored {
+		snapshot := restoredSnapshot{
+			VolumeName: manifest.VolumeName,
+			Snapshot:   manifest.BaseSnapshot,
+			Bytes:      manifest.TotalBytesWritten(),
+			ZFSBytes:   manifest.ZFSStreamBytes,
+		}
+		if manifest.IncrementalSnapshot.Name != "" {
+			incremental := manifest.IncrementalSnapshot
+			snapshot.IncrementalSnapshot = &incremental
+		}
+		summary.Restored = append(summary.Restored, snapshot)
+		summary.TotalBytes += snapshot.Bytes
+		summary.TotalZFSBytes += snapshot.ZFSBytes
+	}
+	return summary
+}
+
 type downloadSequence struct {
 	volume *files.VolumeInfo
 	c      chan<- *files.VolumeInfo
@@ -48,50 +84,50 @@ type downloadSequence struct {
 
 // AutoRestore will compute which snapshots need to be restored to get to the snapshot provided,
 // or to the latest snapshot of the volume provided
+func (e *Engine) AutoRestore(ctx context.Context, jobInfo *files.JobInfo) error {
+	startTime := time.Now()
+	e.notifyStart(ctx, operationReceive, jobInfo)
+	restored, err := e.autoRestore(ctx, jobInfo)
+
+	var summary *restoreSummary
+	if err == nil {
+		summary = summarizeRestore(restored, startTime)
+		e.recordResult(ctx, summary)
+	}
+	e.notifyDone(ctx, operationReceive, err, summary, jobInfo, restored...)
+	return err
+}
+
+// autoRestore will return the backup jobs it restored, in the order they were restored.
 // nolint:funlen,gocyclo // Difficult to break this up
-func AutoRestore(ctx context.Context, jobInfo *files.JobInfo) error {
+func (e *Engine) autoRestore(ctx context.Context, jobInfo *files.JobInfo) ([]*files.JobInfo, error) {
 	// Prepare the backend client
 	target := jobInfo.Destinations[0]
-	backend, berr := prepareBackend(ctx, jobInfo, target, nil)
+	backend, berr := e.prepareBackend(ctx, jobInfo, target, nil)
 	if berr != nil {
-		zap.S().Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
-		return berr
+		e.logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
+		return nil, berr
 	}
 	defer backend.Close()
 
-	// Get the local cache dir
-	localCachePath, cerr := getCacheDir(jobInfo.Destinations[0])
-	if cerr != nil {
-		zap.S().Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
-		return cerr
-	}
-
-	// Sync the local cache
-	safeManifests, _, serr := syncCache(ctx, jobInfo, localCachePath, backend)
-	if serr != nil {
-		zap.S().Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
-		return serr
-	}
-
-	decodedManifests, derr := readAndSortManifests(ctx, localCachePath, safeManifests, jobInfo)
-	if derr != nil {
-		zap.S().Errorf("Could not decode manifests: %v", derr)
-		return derr
-	}
-	manifestTree := linkManifests(decodedManifests)
-	var ok bool
-	var volumeSnaps []*files.JobInfo
-	if volumeSnaps, ok = manifestTree[jobInfo.VolumeName]; !ok {
-		zap.S().Errorf("Could not find any snapshots for volume %s, none found on target.", jobInfo.VolumeName)
-		return errors.New("could not determine any snapshots for provided volume")
+	volumeSnaps, verr := e.volumeBackupSets(ctx, jobInfo, target, backend)
+	if verr != nil {
+		return nil, verr
 	}
 
 	// Restore to the latest snapshot available for the volume provided if no snapshot was provided
 	if jobInfo.BaseSnapshot.Name == "" {
-		zap.S().Infof("Trying to determine latest snapshot for volume %s.", jobInfo.VolumeName)
+		e.logger.Infof("Trying to determine latest snapshot for volume %s.", jobInfo.VolumeName)
 		jobInfo.BaseSnapshot = volumeSnaps[len(volumeSnaps)-1].BaseSnapshot
-		zap.S().Infof("Restoring to snapshot %s.", jobInfo.BaseSnapshot.Name)
-	}
+		e.logger.Infof("Restoring to snapshot %s.", jobInfo.BaseSnapshot.Name)
+	}
+	e.eventStream(ctx).Emit(&events.Event{
+		Type:        events.SnapshotSelected,
+		Operation:   operationReceive,
+		VolumeName:  jobInfo.VolumeName,
+		Snapshot:    jobInfo.BaseSnapshot.Name,
+		Destination: backends.RedactURI(target),
+	})
 
 	// Find the matching backup job for the snapshot we want to restore to
 	var jobToRestore *files.JobInfo
@@ -102,13 +138,13 @@ func AutoRestore(ctx context.Context, jobInfo *files.JobInfo) error {
 		}
 	}
 	if jobToRestore == nil {
-		zap.S().Errorf("Could not find the snapshot %v for volume %s on backend.", jobInfo.BaseSnapshot.Name, jobInfo.VolumeName)
-		return errors.New("could not find snapshot provided")
+		e.logger.Errorf("Could not find the snapshot %v for volume %s on backend.", jobInfo.BaseSnapshot.Name, jobInfo.VolumeName)
+		return nil, fmt.Errorf("%w: could not find snapshot provided", ErrSnapshotNotFound)
 	}
 
 	// We have the snapshot we'd like to restore to, let's figure out whats already found locally and restore as required
 	jobsToRestore := make([]*files.JobInfo, 0, 10)
-	zap.S().Infof("Calculating how to restore to %s.", jobInfo.BaseSnapshot.Name)
+	e.logger.Infof("Calculating how to restore to %s.", jobInfo.BaseSnapshot.Name)
 	volume := jobInfo.LocalVolume
 	parts := strings.Split(jobInfo.VolumeName, "/")
 	if jobInfo.FullPath {
@@ -120,25 +156,25 @@ func AutoRestore(ctx context.Context, jobInfo *files.JobInfo) error {
 		volume = fmt.Sprintf("%s/%s", volume, parts[len(parts)-1])
 	}
 
-	snapshots, err := zfs.GetSnapshotsAndBookmarks(ctx, volume)
+	snapshots, err := e.zfs.GetSnapshotsAndBookmarks(ctx, volume)
 	if err != nil {
 		// TODO: There are some error cases that are ok to ignore!
 		snapshots = []files.SnapshotInfo{}
 	}
 
 	if jobInfo.Origin != "" {
-		originSnapshot, oerr := zfs.GetSnapshotsAndBookmarks(ctx, jobInfo.Origin)
+		originSnapshot, oerr := e.zfs.GetSnapshotsAndBookmarks(ctx, jobInfo.Origin)
 		if oerr != nil {
-			zap.S().Errorf("Could not get origin snapshot %s info due to error: %v", jobInfo.Origin, oerr)
-			return oerr
+			e.logger.Errorf("Could not get origin snapshot %s info due to error: %v", jobInfo.Origin, oerr)
+			return nil, oerr
 		}
 
 		if len(originSnapshot) == 1 {
 			// The origin snapshot can be added as an existing snapshot we can start the restore from
 			snapshots = append(snapshots, originSnapshot[0])
 		} else {
-			zap.S().Errorf("Could not find origin snapshot %s", jobInfo.Origin)
-			return fmt.Errorf("could not find origin snapshot %s", jobInfo.Origin)
+			e.logger.Errorf("Could not find origin snapshot %s", jobInfo.Origin)
+			return nil, fmt.Errorf("%w: could not find origin snapshot %s", ErrSnapshotNotFound, jobInfo.Origin)
 		}
 	}
 
@@ -148,60 +184,113 @@ func AutoRestore(ctx context.Context, jobInfo *files.JobInfo) error {
 			break
 		}
 
-		zap.S().Infof("Adding backup job for %s to the restore list.", jobToRestore.BaseSnapshot.Name)
+		e.logger.Infof("Adding backup job for %s to the restore list.", jobToRestore.BaseSnapshot.Name)
 		jobsToRestore = append(jobsToRestore, jobToRestore)
 		if jobToRestore.IncrementalSnapshot.Name == "" {
 			// This is a full backup, no need to go further back
 			break
 		}
 		if jobToRestore.ParentSnap == nil {
-			zap.S().Errorf(
+			e.logger.Errorf(
 				"Want to restore parent snap %s but it is not found in the backend, aborting.",
 				jobToRestore.IncrementalSnapshot.Name,
 			)
-			return errors.New("could not find parent snapshot")
+			return nil, fmt.Errorf("%w: could not find parent snapshot", ErrSnapshotNotFound)
 		}
 		jobToRestore = jobToRestore.ParentSnap
 	}
 
-	zap.S().Infof("Need to restore %d snapshots.", len(jobsToRestore))
+	e.logger.Infof("Need to restore %d snapshots.", len(jobsToRestore))
 
 	// We have a list of snapshots we need to restore, start at the end and work our way down
+	restored := make([]*files.JobInfo, 0, len(jobsToRestore))
 	for i := len(jobsToRestore) - 1; i >= 0; i-- {
 		jobInfo.BaseSnapshot = jobsToRestore[i].BaseSnapshot
 		jobInfo.IncrementalSnapshot = jobsToRestore[i].IncrementalSnapshot
 		jobInfo.Volumes = jobsToRestore[i].Volumes
 		jobInfo.Separator = jobsToRestore[i].Separator
-		zap.S().Infof("Restoring snapshot %s (%d/%d)", jobInfo.BaseSnapshot.Name, len(jobsToRestore)-i, len(jobsToRestore))
-		if err := Receive(ctx, jobInfo); err != nil {
-			zap.S().Errorf("Failed to restore snapshot.")
-			return err
+		e.logger.Infof("Restoring snapshot %s (%d/%d)", jobInfo.BaseSnapshot.Name, len(jobsToRestore)-i, len(jobsToRestore))
+		if _, err := e.receive(ctx, jobInfo); err != nil {
+			e.logger.Errorf("Failed to restore snapshot.")
+			return restored, err
 		}
+		restored = append(restored, jobsToRestore[i])
 	}
 
-	zap.S().Debugf("Done.")
+	e.logger.Debugf("Done.")
 
-	return nil
+	return restored, nil
+}
+
+// volumeBackupSets will sync the local cache with the target and return the backup sets of the job's volume,
+// oldest first and linked to their parents.
+func (e *Engine) volumeBackupSets(
+	ctx context.Context, jobInfo *files.JobInfo, target string, backend backends.Backend,
+) ([]*files.JobInfo, error) {
+	// Get the local cache dir
+	localCachePath, cerr := e.getCacheDir(target)
+	if cerr != nil {
+		e.logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
+		return nil, cerr
+	}
+
+	// Sync the local cache
+	safeManifests, _, serr := e.syncCache(ctx, jobInfo, localCachePath, backend)
+	if serr != nil {
+		e.logger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
+		return nil, serr
+	}
+
+	decodedManifests, derr := e.readAndSortManifests(ctx, localCachePath, safeManifests, jobInfo)
+	if derr != nil {
+		e.logger.Errorf("Could not decode manifests: %v", derr)
+		return nil, derr
+	}
+	volumeSnaps, ok := linkManifests(decodedManifests)[jobInfo.VolumeName]
+	if !ok {
+		e.logger.Errorf("Could not find any snapshots for volume %s, none found on target.", jobInfo.VolumeName)
+		return nil, fmt.Errorf("%w: could not determine any snapshots for provided volume", ErrSnapshotNotFound)
+	}
+	return volumeSnaps, nil
 }
 
 // Receive will download and restore the backup job described to the Volume target provided.
+func (e *Engine) Receive(ctx context.Context, jobInfo *files.JobInfo) error {
+	startTime := time.Now()
+	e.notifyStart(ctx, operationReceive, jobInfo, jobInfo)
+	manifest, err := e.receive(ctx, jobInfo)
+
+	var summary *restoreSummary
+	if err == nil {
+		var restored []*files.JobInfo
+		if manifest != nil {
+			restored = append(restored, manifest)
+		}
+		summary = summarizeRestore(restored, startTime)
+		e.recordResult(ctx, summary)
+	}
+	e.notifyDone(ctx, operationReceive, err, summary, jobInfo, jobInfo)
+	return err
+}
+
 // nolint:funlen,gocyclo // Difficult to break this up
-func Receive(ctx context.Context, jobInfo *files.JobInfo) error {
+// receive will return the manifest of the backup set restored, or nil if the snapshot already exists.
+func (e *Engine) receive(ctx context.Context, jobInfo *files.JobInfo) (*files.JobInfo, error) {
 	target := jobInfo.Destinations[0]
 
 	// Prepare the backend client
-	backend, berr := prepareBackend(ctx, jobInfo, target, nil)
+	backend, berr := e.prepareBackend(ctx, jobInfo, target, nil)
 	if berr != nil {
-		zap.S().Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
-		return berr
+		e.logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
+		return nil, berr
 	}
 	defer backend.Close()
 
 	// Get the local cache dir
-	localCachePath, cerr := getCacheDir(target)
+	localCachePath, cerr := e.getCacheDir(target)
 	if cerr != nil {
-		zap.S().Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
-		return cerr
+		e.logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
+		return nil, cerr
 	}
 
 	// See if the snapshots we want to restore already exist
@@ -217,23 +306,23 @@ func Receive(ctx context.Context, jobInfo *files.JobInfo) error {
 	}
 
 	if jobInfo.BaseSnapshot.CreationTime.IsZero() {
-		if ok, verr := validateSnapShotExists(ctx, &jobInfo.BaseSnapshot, volume, false); verr != nil {
-			zap.S().Errorf("Cannot validate if selected base snapshot exists due to error - %v", verr)
-			return verr
+		if ok, verr := e.validateSnapShotExists(ctx, &jobInfo.BaseSnapshot, volume, false); verr != nil {
+			e.logger.Errorf("Cannot validate if selected base snapshot exists due to error - %v", verr)
+			return nil, verr
 		} else if ok {
-			zap.S().Infof("Selected base snapshot already exists, nothing to do!")
-			return nil
+			e.logger.Infof("Selected base snapshot already exists, nothing to do!")
+			return nil, nil
 		}
 	}
 
 	// Check that we have the parent snap shot this wants to restore from
 	if jobInfo.IncrementalSnapshot.Name != "" && jobInfo.IncrementalSnapshot.CreationTime.IsZero() {
-		if ok, verr := validateSnapShotExists(ctx, &jobInfo.IncrementalSnapshot, volume, false); verr != nil {
-			zap.S().Errorf("Cannot validate if selected incremental snapshot exists due to error - %v", verr)
-			return verr
+		if ok, verr := e.validateSnapShotExists(ctx, &jobInfo.IncrementalSnapshot, volume, false); verr != nil {
+			e.logger.Errorf("Cannot validate if selected incremental snapshot exists due to error - %v", verr)
+			return nil, verr
 		} else if !ok {
-			zap.S().Errorf("Selected incremental snapshot does not exist!")
-			return fmt.Errorf("selected incremental snapshot does not exist")
+			e.logger.Errorf("Selected incremental snapshot does not exist!")
+			return nil, fmt.Errorf("%w: selected incremental snapshot does not exist", ErrSnapshotNotFound)
 		}
 	}
 
@@ -247,18 +336,18 @@ func Receive(ctx context.Context, jobInfo *files.JobInfo) error {
 	if err != nil {
 		if os.IsNotExist(err) {
 			if bErr := backend.PreDownload(ctx, []string{manifestObjectName}); bErr != nil {
-				zap.S().Errorf("Error trying to pre download manifest volume %s - %v", manifestObjectName, bErr)
-				return bErr
+				e.logger.Errorf("Error trying to pre download manifest volume %s - %v", manifestObjectName, bErr)
+				return nil, bErr
 			}
 			// Try and download the manifest file from the backend
-			if dErr := downloadTo(ctx, backend, manifestObjectName, safeManifestPath); dErr != nil {
-				return dErr
+			if dErr := e.downloadTo(ctx, backend, manifestObjectName, safeManifestPath); dErr != nil {
+				return nil, dErr
 			}
 			manifest, err = readManifest(ctx, safeManifestPath, jobInfo)
 		}
 		if err != nil {
-			zap.S().Errorf("Error trying to retrieve manifest volume - %v", err)
-			return err
+			e.logger.Errorf("Error trying to retrieve manifest volume - %v", err)
+			return nil, err
 		}
 	}
 
@@ -274,11 +363,19 @@ func Receive(ctx context.Context, jobInfo *files.JobInfo) error {
 	// PreDownload step
 	err = backend.PreDownload(ctx, toDownload)
 	if err != nil {
-		zap.S().Errorf("Error trying to pre download backup set volumes - %v", err)
-		return err
+		e.logger.Errorf("Error trying to pre download backup set volumes - %v", err)
+		return nil, err
 	}
 	toDownload = nil
 
+	e.eventStream(ctx).Emit(&events.Event{
+		Type:        events.ReceiveStarted,
+		VolumeName:  jobInfo.VolumeName,
+		Snapshot:    jobInfo.BaseSnapshot.Name,
+		Incremental: jobInfo.IncrementalSnapshot.Name,
+		Destination: backends.RedactURI(target),
+	})
+
 	// Prepare Download Pipeline
 	usePipe := false
 	fileBufferSize := jobInfo.MaxFileBuffer
@@ -288,7 +385,11 @@ func Receive(ctx context.Context, jobInfo *files.JobInfo) error {
 	}
 
 	downloadChannel := make(chan downloadSequence)
+	// Every channel is made before the volumes are ordered so none is read before it is set
 	orderedChannels := make([]chan *files.VolumeInfo, len(manifest.Volumes))
+	for idx := range orderedChannels {
+		orderedChannels[idx] = make(chan *files.VolumeInfo, 1)
+	}
 
 	var wg *errgroup.Group
 	wg, ctx = errgroup.WithContext(ctx)
@@ -303,17 +404,21 @@ func Receive(ctx context.Context, jobInfo *files.JobInfo) error {
 				retryconf := backoff.WithContext(be, ctx)
 
 				operation := func() error {
-					if err := processSequence(ctx, sequence, backend, usePipe); err != nil {
-						zap.S().Warnf("error trying to download file %s - %v", sequence.volume.ObjectName, err)
+					if err := e.processSequence(ctx, sequence, backend, usePipe); err != nil {
+						e.logger.Warnf("error trying to download file %s - %v", sequence.volume.ObjectName, err)
 						return err
 					}
 					return nil
 				}
 
-				zap.S().Debugf("Downloading volume %s.", sequence.volume.ObjectName)
+				onRetry := func(err error, next time.Duration) {
+					e.emitRetry(ctx, jobInfo, target, sequence.volume, next, err)
+				}
+
+				e.logger.Debugf("Downloading volume %s.", sequence.volume.ObjectName)
 
-				if err := backoff.Retry(operation, retryconf); err != nil {
-					zap.S().Errorf("Failed to download volume %s due to error: %v, aborting...", sequence.volume.ObjectName, err)
+				if err := backoff.RetryNotify(operation, retryconf, onRetry); err != nil {
+					e.logger.Errorf("Failed to download volume %s due to error: %v, aborting...", sequence.volume.ObjectName, err)
 					close(sequence.c)
 					return err
 				}
@@ -328,11 +433,21 @@ func Receive(ctx context.Context, jobInfo *files.JobInfo) error {
 	wg.Go(func() error {
 		defer close(orderedVolumes)
 		for _, c := range orderedChannels {
+			var vol *files.VolumeInfo
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case vol = <-c:
+			}
+			if vol == nil {
+				// The volume could not be downloaded, leave it to its error to stop the restore
+				<-ctx.Done()
+				return ctx.Err()
+			}
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
-			case orderedVolumes <- <-c:
-				continue
+			case orderedVolumes <- vol:
 			}
 		}
 		return nil
@@ -340,40 +455,47 @@ func Receive(ctx context.Context, jobInfo *files.JobInfo) error {
 
 	// Prepare ZFS Receive command
 	wg.Go(func() error {
-		return receiveStream(ctx, jobInfo, manifest, orderedVolumes)
+		return e.receiveStream(ctx, jobInfo, manifest, orderedVolumes)
 	})
 
 	// Queue up files to download
 	for idx := range manifest.Volumes {
-		c := make(chan *files.VolumeInfo, 1)
-		orderedChannels[idx] = c
-		downloadChannel <- downloadSequence{manifest.Volumes[idx], c}
+		downloadChannel <- downloadSequence{manifest.Volumes[idx], orderedChannels[idx]}
 	}
 	close(downloadChannel)
 
 	// Wait for processes to finish
 	if err := wg.Wait(); err != nil {
-		zap.S().Errorf("There was an error during the restore process, aborting: %v", err)
-		return err
-	}
-
-	zap.S().Infof("Done. Elapsed Time: %v", time.Since(jobInfo.StartTime))
-	return nil
+		e.logger.Errorf("There was an error during the restore process, aborting: %v", err)
+		return nil, err
+	}
+
+	metrics.LastRestoreSuccess.WithLabelValues(jobInfo.VolumeName).SetToCurrentTime()
+	e.eventStream(ctx).Emit(&events.Event{
+		Type:        events.ReceiveCompleted,
+		VolumeName:  jobInfo.VolumeName,
+		Snapshot:    jobInfo.BaseSnapshot.Name,
+		Incremental: jobInfo.IncrementalSnapshot.Name,
+		Destination: backends.RedactURI(target),
+		Bytes:       manifest.TotalBytesWritten(),
+	})
+	e.logger.Infof("Done. Elapsed Time: %v", time.Since(jobInfo.StartTime))
+	return manifest, nil
 }
 
-func processSequence(ctx context.Context, sequence downloadSequence, backend backends.Backend, usePipe bool) error {
+func (e *Engine) processSequence(ctx context.Context, sequence downloadSequence, backend backends.Backend, usePipe bool) error {
 	r, rerr := backend.Download(ctx, sequence.volume.ObjectName)
 	if rerr != nil {
-		zap.S().Infof("Could not get %s due to error %v.", sequence.volume.ObjectName, rerr)
+		e.logger.Infof("Could not get %s due to error %v.", sequence.volume.ObjectName, rerr)
 		return rerr
 	}
 	defer r.Close()
 
-	zap.S().Infof("download of %s succeeded.", sequence.volume.ObjectName)
+	e.logger.Infof("download of %s succeeded.", sequence.volume.ObjectName)
 
-	vol, err := files.CreateSimpleVolume(ctx, usePipe)
+	vol, err := files.CreateSimpleVolume(ctx, usePipe, e.volumeOptions())
 	if err != nil {
-		zap.S().Infof("Could not create temporary file to download %s due to error - %v.", sequence.volume.ObjectName, err)
+		e.logger.Infof("Could not create temporary file to download %s due to error - %v.", sequence.volume.ObjectName, err)
 		return err
 	}
 
@@ -382,14 +504,19 @@ func processSequence(ctx context.Context, sequence downloadSequence, backend bac
 		sequence.c <- vol
 	}
 
-	_, err = io.Copy(vol, r)
+	var reader io.Reader = r
+	if e.downloadLimiter != nil {
+		reader = e.downloadLimiter.Reader(r)
+	}
+
+	_, err = io.Copy(vol, reader)
 	if err != nil {
-		zap.S().Infof("Could not download file %s to the local cache dir due to error - %v.", sequence.volume.ObjectName, err)
+		e.logger.Infof("Could not download file %s to the local cache dir due to error - %v.", sequence.volume.ObjectName, err)
 		if err = vol.Close(); err != nil {
-			zap.S().Warnf("Could not close volume %s due to error - %v", sequence.volume.ObjectName, err)
+			e.logger.Warnf("Could not close volume %s due to error - %v", sequence.volume.ObjectName, err)
 		}
 		if err = vol.DeleteVolume(); err != nil {
-			zap.S().Warnf("Could not delete volume %s due to error - %v", sequence.volume.ObjectName, err)
+			e.logger.Warnf("Could not delete volume %s due to error - %v", sequence.volume.ObjectName, err)
 		}
 		if usePipe {
 			return backoff.Permanent(fmt.Errorf("cannot retry when using no file buffer, aborting"))
@@ -397,13 +524,13 @@ func processSequence(ctx context.Context, sequence downloadSequence, backend bac
 		return err
 	}
 	if cerr := vol.Close(); cerr != nil {
-		zap.S().Infof("Could not close temporary file to download %s due to error - %v.", sequence.volume.ObjectName, cerr)
+		e.logger.Infof("Could not close temporary file to download %s due to error - %v.", sequence.volume.ObjectName, cerr)
 		return cerr
 	}
 
 	// Verify the SHA256 Hash, if it doesn't match, ditch it!
 	if vol.SHA256Sum != sequence.volume.SHA256Sum {
-		zap.S().Infof(
+		e.logger.Infof(
 			"Hash mismatch for %s, got %s but expected %s. Retrying.",
 			sequence.volume.ObjectName, vol.SHA256Sum, sequence.volume.SHA256Sum,
 		)
@@ -411,14 +538,21 @@ func processSequence(ctx context.Context, sequence downloadSequence, backend bac
 			return backoff.Permanent(fmt.Errorf("cannot retry when using no file buffer, aborting"))
 		}
 		if err = vol.DeleteVolume(); err != nil {
-			zap.S().Infof("Could not delete temporary file to download %s due to error - %v.", sequence.volume.ObjectName, err)
+			e.logger.Infof("Could not delete temporary file to download %s due to error - %v.", sequence.volume.ObjectName, err)
 		}
 		return fmt.Errorf(
-			"SHA256 hash mismatch for %s, got %s but expected %s",
-			sequence.volume.ObjectName, vol.SHA256Sum, sequence.volume.SHA256Sum,
+			"%w: SHA256 hash mismatch for %s, got %s but expected %s",
+			ErrHashMismatch, sequence.volume.ObjectName, vol.SHA256Sum, sequence.volume.SHA256Sum,
 		)
 	}
-	zap.S().Debugf("Downloaded %s.", sequence.volume.ObjectName)
+	e.logger.Debugf("Downloaded %s.", sequence.volume.ObjectName)
+	e.eventStream(ctx).Emit(&events.Event{
+		Type:       events.VolumeVerified,
+		ObjectName: sequence.volume.ObjectName,
+		Volume:     sequence.volume.VolumeNumber,
+		Bytes:      vol.Size,
+	})
+	metrics.DownloadedBytes.Add(float64(vol.Size))
 
 	if !usePipe {
 		sequence.c <- vol
@@ -427,87 +561,107 @@ func processSequence(ctx context.Context, sequence downloadSequence, backend bac
 	return nil
 }
 
-func receiveStream(ctx context.Context, jobInfo *files.JobInfo, manifest *files.JobInfo, c <-chan *files.VolumeInfo) error {
+// checkVolume will download the volume from the backend, without keeping it, and return an ErrHashMismatch
+// error if it does not match the hash recorded in its manifest.
+func (e *Engine) checkVolume(ctx context.Context, backend backends.Backend, volume *files.VolumeInfo) error {
+	r, err := backend.Download(ctx, volume.ObjectName)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var reader io.Reader = r
+	if e.downloadLimiter != nil {
+		reader = e.downloadLimiter.Reader(r)
+	}
+	hash := sha256.New()
+	if _, err = io.Copy(hash, reader); err != nil {
+		return err
+	}
+	if sum := fmt.Sprintf("%x", hash.Sum(nil)); sum != volume.SHA256Sum {
+		return fmt.Errorf("%w: SHA256 hash mismatch for %s, got %s but expected %s", ErrHashMismatch, volume.ObjectName, sum, volume.SHA256Sum)
+	}
+	return nil
+}
+
+func (e *Engine) receiveStream(ctx context.Context, jobInfo *files.JobInfo, manifest *files.JobInfo, c <-chan *files.VolumeInfo) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	cmd := zfs.GetZFSReceiveCommand(ctx, jobInfo)
 	cin, cout := io.Pipe()
-	cmd.Stdin = cin
-	cmd.Stderr = os.Stderr
 
 	var bar = io.Discard
 	if manifest.ProgressBar {
 		bar = progressbar.DefaultBytes(-1, "uploading")
 	}
 
-	// Extract ZFS stream from files and send it to the zfs command
+	// Extract ZFS stream from files and send it to the zfs receive
 	var group errgroup.Group
-	group.Go(func() error {
-		defer cout.Close()
+	group.Go(func() (err error) {
+		defer func() { cout.CloseWithError(err) }()
 		for vol := range c {
-			zap.S().Debugf("Processing %s.", vol.ObjectName)
+			e.logger.Debugf("Processing %s.", vol.ObjectName)
 
-			if err := vol.Extract(manifest); err != nil {
-				zap.S().Errorf("Error while trying to read from volume %s - %v", vol.ObjectName, err)
+			if err = vol.Extract(manifest); err != nil {
+				e.logger.Errorf("Error while trying to read from volume %s - %v", vol.ObjectName, err)
 				return err
 			}
 
-			if _, err := io.Copy(io.MultiWriter(cout, bar), vol); err != nil {
-				zap.S().Errorf("Error while trying to read from volume %s - %v", vol.ObjectName, err)
+			if _, err = io.Copy(io.MultiWriter(cout, bar), vol); err != nil {
+				e.logger.Errorf("Error while trying to read from volume %s - %v", vol.ObjectName, err)
 				return err
 			}
 
-			if err := vol.Close(); err != nil {
-				zap.S().Warnf("Could not close volume %s due to error - %v", vol.ObjectName, err)
+			if cerr := vol.Close(); cerr != nil {
+				e.logger.Warnf("Could not close volume %s due to error - %v", vol.ObjectName, cerr)
 			}
 
-			if err := vol.DeleteVolume(); err != nil {
-				zap.S().Warnf("Could not delete volume %s due to error - %v", vol.ObjectName, err)
+			if derr := vol.DeleteVolume(); derr != nil {
+				e.logger.Warnf("Could not delete volume %s due to error - %v", vol.ObjectName, derr)
 			}
 
-			zap.S().Debugf("Processed %s.", vol.ObjectName)
+			e.logger.Debugf("Processed %s.", vol.ObjectName)
 		}
 		return nil
 	})
 
-	// Start the zfs receive command
-	zap.S().Infof("Starting zfs receive command: %s", cmd.String())
-	if err := cmd.Run(); err != nil {
-		zap.S().Errorf("Error starting zfs command - %v", err)
+	// Run the zfs receive
+	if err := e.zfs.Receive(ctx, jobInfo, cin); err != nil {
+		e.logger.Errorf("Error running zfs receive - %v", err)
+		cin.CloseWithError(err)
 		return err
 	}
 
 	// Wait for the command to finish
 	if err := group.Wait(); err != nil {
-		zap.S().Errorf("Error waiting for zfs command to finish - %v", err)
+		e.logger.Errorf("Error waiting for zfs command to finish - %v", err)
 		return err
 	}
 
-	zap.S().Infof("zfs receive completed without error")
+	e.logger.Infof("zfs receive completed without error")
 
 	return nil
 }
 
-func downloadTo(ctx context.Context, backend backends.Backend, objectName, toPath string) error {
+func (e *Engine) downloadTo(ctx context.Context, backend backends.Backend, objectName, toPath string) error {
 	r, rerr := backend.Download(ctx, objectName)
 	if rerr == nil {
 		defer r.Close()
 		out, oerr := os.Create(toPath)
 		if oerr != nil {
-			zap.S().Errorf("Could not create file in the local cache dir due to error - %v.", oerr)
+			e.logger.Errorf("Could not create file in the local cache dir due to error - %v.", oerr)
 			return oerr
 		}
 		defer out.Close()
 
 		_, err := io.Copy(out, r)
 		if err != nil {
-			zap.S().Errorf("Could not download file %s to the local cache dir due to error - %v.", objectName, err)
+			e.logger.Errorf("Could not download file %s to the local cache dir due to error - %v.", objectName, err)
 			return err
 		}
-		zap.S().Debugf("Downloaded %s to local cache.", objectName)
+		e.logger.Debugf("Downloaded %s to local cache.", objectName)
 	} else {
-		zap.S().Errorf("Could not download file %s to the local cache dir due to error - %v.", objectName, rerr)
+		e.logger.Errorf("Could not download file %s to the local cache dir due to error - %v.", objectName, rerr)
 		return rerr
 	}
 	return nil
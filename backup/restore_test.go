@@ -0,0 +1,119 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/zfs"
+)
+
+// snapshotNames will return the names of the snapshots of the dataset in the pool, newest first.
+func snapshotNames(t *testing.T, pool *zfs.FakePool, dataset string) []string {
+	t.Helper()
+	snapshots, err := pool.GetSnapshotsAndBookmarks(context.Background(), dataset)
+	require.NoError(t, err)
+	names := make([]string, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		names = append(names, snapshot.Name)
+	}
+	return names
+}
+
+func TestAutoRestore(t *testing.T) {
+	source := newTestPool(t, "tank/data", "a")
+	target := newTestPool(t, "backup")
+	destination := fmt.Sprintf("%s://%s", backends.FileBackendPrefix, t.TempDir())
+
+	sender := newTestEngine(t, source)
+	backupJob := func(full bool) {
+		t.Helper()
+		jobs, err := sender.ProcessSmartOptions(context.Background(), &files.JobInfo{
+			VolumeName:         "tank/data",
+			VolumeSize:         1,
+			UploadChunkSize:    1,
+			Destinations:       []string{destination},
+			MaxParallelUploads: 5,
+			MaxFileBuffer:      5,
+			MaxBackoffTime:     5 * time.Millisecond,
+			MaxRetryTime:       1 * time.Second,
+			StartTime:          time.Now(),
+			ManifestPrefix:     "manifests",
+			Separator:          "|",
+			Full:               full,
+			Incremental:        !full,
+			FullIfOlderThan:    -1 * time.Minute,
+		})
+		require.NoError(t, err)
+		require.NoError(t, sender.BackupJobs(context.Background(), jobs))
+	}
+
+	// A full backup of a, an incremental from a to c skipping b, then from c to d
+	backupJob(true)
+	mustSnapshot(t, source, "tank/data", "b")
+	mustSnapshot(t, source, "tank/data", "c")
+	backupJob(false)
+	mustSnapshot(t, source, "tank/data", "d")
+	backupJob(false)
+
+	receiver := newTestEngine(t, target)
+	restoreJob := func(localVolume, snapshot string) *files.JobInfo {
+		return &files.JobInfo{
+			VolumeName:     "tank/data",
+			LocalVolume:    localVolume,
+			BaseSnapshot:   files.SnapshotInfo{Name: snapshot},
+			Destinations:   []string{destination},
+			MaxFileBuffer:  5,
+			MaxBackoffTime: 5 * time.Millisecond,
+			MaxRetryTime:   1 * time.Second,
+			ManifestPrefix: "manifests",
+			Separator:      "|",
+			AutoRestore:    true,
+		}
+	}
+
+	// The whole chain is restored to the latest snapshot
+	require.NoError(t, receiver.AutoRestore(context.Background(), restoreJob("backup/data", "")))
+	assert.Equal(t, []string{"d", "c", "a"}, snapshotNames(t, target, "backup/data"))
+	for _, name := range []string{"a", "c", "d"} {
+		sent, err := source.GetZFSProperty(context.Background(), "guid", "tank/data@"+name)
+		require.NoError(t, err)
+		received, err := target.GetZFSProperty(context.Background(), "guid", "backup/data@"+name)
+		require.NoError(t, err)
+		assert.Equal(t, sent, received, "snapshot %s", name)
+	}
+
+	// Restoring again has nothing left to do
+	require.NoError(t, receiver.AutoRestore(context.Background(), restoreJob("backup/data", "")))
+	assert.Equal(t, []string{"d", "c", "a"}, snapshotNames(t, target, "backup/data"))
+
+	// An older snapshot can be restored elsewhere
+	require.NoError(t, receiver.AutoRestore(context.Background(), restoreJob("backup/older", "c")))
+	assert.Equal(t, []string{"c", "a"}, snapshotNames(t, target, "backup/older"))
+}
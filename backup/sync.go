@@ -23,21 +23,23 @@ package backup
 import (
 	"context"
 	"crypto/md5" // nolint:gosec // MD5 not used for cryptographic purposes here
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"go.uber.org/zap"
-
 	"github.com/someone1/zfsbackup-go/backends"
-	"github.com/someone1/zfsbackup-go/config"
 	"github.com/someone1/zfsbackup-go/files"
-	"github.com/someone1/zfsbackup-go/zfs"
 )
 
-func prepareBackend(ctx context.Context, j *files.JobInfo, backendURI string, uploadBuffer chan bool) (backends.Backend, error) {
-	zap.S().Debugf("Initializing Backend %s", backendURI)
+func (e *Engine) prepareBackend(ctx context.Context, j *files.JobInfo, backendURI string, uploadBuffer chan bool) (backends.Backend, error) {
+	e.logger.Debugf("Initializing Backend %s", backendURI)
+	stateDir, err := e.getCacheDir(backendURI)
+	if err != nil {
+		return nil, err
+	}
 	conf := &backends.BackendConfig{
 		MaxParallelUploadBuffer: uploadBuffer,
 		TargetURI:               backendURI,
@@ -45,22 +47,52 @@ func prepareBackend(ctx context.Context, j *files.JobInfo, backendURI string, up
 		MaxBackoffTime:          j.MaxBackoffTime,
 		MaxRetryTime:            j.MaxRetryTime,
 		UploadChunkSize:         j.UploadChunkSize * 1024 * 1024,
+		StateDir:                stateDir,
+		LockUntil:               j.LockUntil,
+		LockCompliance:          j.LockCompliance,
 	}
 
 	backend, err := backends.GetBackendForURI(backendURI)
 	if err != nil {
 		return nil, err
 	}
+	if e.appendOnly {
+		backend = backends.NewAppendOnlyBackend(backend)
+	}
+	if e.writeOnly {
+		backend = backends.NewWriteOnlyBackend(backend)
+	}
 
 	err = backend.Init(ctx, conf)
+	if err != nil && !errors.Is(err, backends.ErrInvalidURI) && !errors.Is(err, backends.ErrInvalidPrefix) &&
+		!errors.Is(err, backends.ErrLockUnsupported) {
+		err = fmt.Errorf("%w: %v", backends.ErrUnreachable, err)
+	}
 
 	return backend, err
 }
 
-func getCacheDir(backendURI string) (string, error) {
+// cachePath will return the local cache directory of the destination. It is keyed on the URI with its
+// credentials redacted, so rotating those keeps the same cache while the options that tell destinations
+// apart, such as their endpoint, give each its own. The failures a chaos URI injects are left out too.
+func (e *Engine) cachePath(backendURI string) string {
+	key := backends.RedactURI(backends.ChaosTarget(backendURI))
+	// nolint:gosec // MD5 not used for cryptographic purposes here
+	return filepath.Join(e.workingDir, "cache", fmt.Sprintf("%x", md5.Sum([]byte(key))))
+}
+
+func (e *Engine) getCacheDir(backendURI string) (string, error) {
+	dest := e.cachePath(backendURI)
+	// Caches used to be keyed on the whole URI, keep using the one a destination with credentials in it already has
 	// nolint:gosec // MD5 not used for cryptographic purposes here
-	safeFolder := fmt.Sprintf("%x", md5.Sum([]byte(backendURI)))
-	dest := filepath.Join(config.WorkingDir, "cache", safeFolder)
+	legacy := filepath.Join(e.workingDir, "cache", fmt.Sprintf("%x", md5.Sum([]byte(backendURI))))
+	if legacy != dest {
+		if _, err := os.Stat(dest); os.IsNotExist(err) {
+			if rerr := os.Rename(legacy, dest); rerr != nil && !os.IsNotExist(rerr) {
+				e.logger.Warnf("Could not move the local cache %s to %s due to error - %v", legacy, dest, rerr)
+			}
+		}
+	}
 	oerr := os.MkdirAll(dest, os.ModePerm)
 	if oerr != nil {
 		return "", fmt.Errorf("could not create cache directory %s due to an error: %v", dest, oerr)
@@ -69,13 +101,55 @@ func getCacheDir(backendURI string) (string, error) {
 	return dest, nil
 }
 
+// incompleteCacheDir is the subdirectory of a destination's local cache holding the manifests of
+// backups that did not complete to that destination.
+const incompleteCacheDir = "incomplete"
+
+// markIncomplete will move the manifest for the given job out of the destination's local cache and
+// into its incomplete directory so it is not mistaken for a backup found in the destination.
+func (e *Engine) markIncomplete(j *files.JobInfo, destination string) error {
+	localCachePath, err := e.getCacheDir(destination)
+	if err != nil {
+		return err
+	}
+	incompletePath := filepath.Join(localCachePath, incompleteCacheDir)
+	if err = os.MkdirAll(incompletePath, os.ModePerm); err != nil {
+		return err
+	}
+
+	// nolint:gosec // MD5 not used for cryptographic purposes here
+	safeManifestFile := fmt.Sprintf("%x", md5.Sum([]byte(j.ManifestObjectName())))
+	return os.Rename(filepath.Join(localCachePath, safeManifestFile), filepath.Join(incompletePath, safeManifestFile))
+}
+
+// getIncompleteManifests will return the paths of the manifests marked incomplete in the local cache.
+func getIncompleteManifests(localCachePath string) ([]string, error) {
+	manifestFiles, err := os.ReadDir(filepath.Join(localCachePath, incompleteCacheDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(manifestFiles))
+	for _, file := range manifestFiles {
+		paths = append(paths, filepath.Join(localCachePath, incompleteCacheDir, file.Name()))
+	}
+	return paths, nil
+}
+
 // Returns local manifest paths that exist in the backend and those that do not
 // nolint:gocritic // Don't need to name the results
-func syncCache(ctx context.Context, j *files.JobInfo, localCache string, backend backends.Backend) ([]string, []string, error) {
+func (e *Engine) syncCache(ctx context.Context, j *files.JobInfo, localCache string, backend backends.Backend) ([]string, []string, error) {
 	// List all manifests at the destination
 	manifests, merr := backend.List(ctx, j.ManifestPrefix)
-	if merr != nil {
-		return nil, nil, fmt.Errorf("could not list manifest files from the backed due to error - %v", merr)
+	if errors.Is(merr, backends.ErrWriteOnly) {
+		// Nothing at the destination can be seen, so what was uploaded from here is all there is to go on
+		e.logger.Debugf("Using the manifests in the local cache %s as the destination is write-only.", localCache)
+		localManifests, lerr := listCachedManifests(localCache)
+		return localManifests, nil, lerr
+	} else if merr != nil {
+		return nil, nil, fmt.Errorf("%w: could not list manifest files from the backed due to error - %v", backends.ErrUnreachable, merr)
 	}
 
 	// Make it safe for local file system storage
@@ -94,7 +168,7 @@ func syncCache(ctx context.Context, j *files.JobInfo, localCache string, backend
 	var localOnlyFiles []string
 	var foundFiles []string
 	for _, file := range manifestFiles {
-		if file.IsDir() {
+		if !isCachedManifest(file) {
 			continue
 		}
 		found := false
@@ -120,11 +194,11 @@ func syncCache(ctx context.Context, j *files.JobInfo, localCache string, backend
 	}
 
 	if len(manifests) > 0 {
-		zap.S().Debugf("Syncing %d manifests to local cache.", len(manifests))
+		e.logger.Debugf("Syncing %d manifests to local cache.", len(manifests))
 
 		// manifests should only contain what we don't have locally
 		for idx, manifest := range manifests {
-			if err := downloadTo(ctx, backend, manifest, filepath.Join(localCache, safeManifests[idx])); err != nil {
+			if err := e.downloadTo(ctx, backend, manifest, filepath.Join(localCache, safeManifests[idx])); err != nil {
 				return nil, nil, err
 			}
 		}
@@ -135,11 +209,37 @@ func syncCache(ctx context.Context, j *files.JobInfo, localCache string, backend
 	return safeManifests, localOnlyFiles, nil
 }
 
+// isCachedManifest will return true if the entry in a local cache is a manifest, rather than a directory or
+// state a backend keeps there.
+func isCachedManifest(file os.DirEntry) bool {
+	if file.IsDir() || len(file.Name()) != md5.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(file.Name())
+	return err == nil
+}
+
+// listCachedManifests will return the manifests in the local cache.
+func listCachedManifests(localCache string) ([]string, error) {
+	manifestFiles, err := os.ReadDir(localCache)
+	if err != nil {
+		return nil, fmt.Errorf("could not list files from the local cache dir due to error - %v", err)
+	}
+
+	var manifests []string
+	for _, file := range manifestFiles {
+		if isCachedManifest(file) {
+			manifests = append(manifests, file.Name())
+		}
+	}
+	return manifests, nil
+}
+
 // nolint:unparam // Some errors are not ok to ignore
-func validateSnapShotExists(ctx context.Context, snapshot *files.SnapshotInfo, target string, includeBookmarks bool) (bool, error) {
-	snapshots, err := zfs.GetSnapshotsAndBookmarks(ctx, target)
+func (e *Engine) validateSnapShotExists(ctx context.Context, snapshot *files.SnapshotInfo, target string, includeBookmarks bool) (bool, error) {
+	snapshots, err := e.zfs.GetSnapshotsAndBookmarks(ctx, target)
 	if err != nil {
-		zap.S().Debugf("Could not list snapshots for %s: %v", target, err)
+		e.logger.Debugf("Could not list snapshots for %s: %v", target, err)
 		// TODO: There are some error cases that are ok to ignore!
 		return false, nil
 	}
@@ -0,0 +1,159 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/events"
+	"github.com/someone1/zfsbackup-go/files"
+)
+
+// verifySummary is output once a verify completes.
+type verifySummary struct {
+	Verified    []verifiedSet
+	TotalBytes  uint64
+	ElapsedTime time.Duration
+}
+
+type verifiedSet struct {
+	VolumeName          string
+	Snapshot            files.SnapshotInfo
+	IncrementalSnapshot *files.SnapshotInfo `json:",omitempty"`
+	Volumes             int
+	Bytes               uint64
+}
+
+func summarizeVerify(verified []*files.JobInfo, startTime time.Time) *verifySummary {
+	summary := &verifySummary{Verified: make([]verifiedSet, 0, len(verified)), ElapsedTime: time.Since(startTime)}
+	for _, manifest := range verified {
+		set := verifiedSet{
+			VolumeName: manifest.VolumeName,
+			Snapshot:   manifest.BaseSnapshot,
+			Volumes:    len(manifest.Volumes),
+			Bytes:      manifest.TotalBytesWritten(),
+		}
+		if manifest.IncrementalSnapshot.Name != "" {
+			incremental := manifest.IncrementalSnapshot
+			set.IncrementalSnapshot = &incremental
+		}
+		summary.Verified = append(summary.Verified, set)
+		summary.TotalBytes += set.Bytes
+	}
+	return summary
+}
+
+// Verify will download every volume of the backup set for the job's snapshot, or the latest one of the volume
+// if no snapshot was provided, along with the backup sets it is restored from, and check them against the
+// hashes recorded in their manifests without running zfs receive.
+func (e *Engine) Verify(ctx context.Context, jobInfo *files.JobInfo) error {
+	startTime := time.Now()
+	e.notifyStart(ctx, operationVerify, jobInfo)
+	verified, err := e.verify(ctx, jobInfo)
+
+	var summary *verifySummary
+	if err == nil {
+		summary = summarizeVerify(verified, startTime)
+		e.recordResult(ctx, summary)
+	}
+	e.notifyDone(ctx, operationVerify, err, summary, jobInfo, verified...)
+	return err
+}
+
+// verify will return the backup sets it verified, from the full backup to the one for the job's snapshot.
+func (e *Engine) verify(ctx context.Context, jobInfo *files.JobInfo) ([]*files.JobInfo, error) {
+	target := jobInfo.Destinations[0]
+	backend, berr := e.prepareBackend(ctx, jobInfo, target, nil)
+	if berr != nil {
+		e.logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
+		return nil, berr
+	}
+	defer backend.Close()
+
+	volumeSnaps, err := e.volumeBackupSets(ctx, jobInfo, target, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	if jobInfo.BaseSnapshot.Name == "" {
+		jobInfo.BaseSnapshot = volumeSnaps[len(volumeSnaps)-1].BaseSnapshot
+	}
+	e.eventStream(ctx).Emit(&events.Event{
+		Type:        events.SnapshotSelected,
+		Operation:   operationVerify,
+		VolumeName:  jobInfo.VolumeName,
+		Snapshot:    jobInfo.BaseSnapshot.Name,
+		Destination: backends.RedactURI(target),
+	})
+
+	var jobToVerify *files.JobInfo
+	for _, job := range volumeSnaps {
+		if job.BaseSnapshot.Name == jobInfo.BaseSnapshot.Name {
+			jobToVerify = job
+			break
+		}
+	}
+	if jobToVerify == nil {
+		e.logger.Errorf("Could not find the snapshot %v for volume %s on backend.", jobInfo.BaseSnapshot.Name, jobInfo.VolumeName)
+		return nil, fmt.Errorf("%w: could not find snapshot provided", ErrSnapshotNotFound)
+	}
+
+	// The backup set is only as good as the ones it is restored from
+	var chain []*files.JobInfo
+	for manifest := jobToVerify; ; manifest = manifest.ParentSnap {
+		chain = append([]*files.JobInfo{manifest}, chain...)
+		if manifest.IncrementalSnapshot.Name == "" {
+			break
+		}
+		if manifest.ParentSnap == nil {
+			e.logger.Errorf("Want to verify parent snap %s but it is not found in the backend, aborting.", manifest.IncrementalSnapshot.Name)
+			return nil, fmt.Errorf("%w: could not find parent snapshot", ErrSnapshotNotFound)
+		}
+	}
+
+	var volumes []*files.VolumeInfo
+	for _, manifest := range chain {
+		volumes = append(volumes, manifest.Volumes...)
+	}
+	e.logger.Infof(
+		"Verifying %d volumes of %d backup sets for %s@%s.", len(volumes), len(chain), jobInfo.VolumeName, jobInfo.BaseSnapshot.Name,
+	)
+
+	mismatched, err := e.verifyVolumes(ctx, jobInfo, backend, target, volumes)
+	if err != nil {
+		return nil, err
+	}
+	if len(mismatched) > 0 {
+		objectNames := make([]string, len(mismatched))
+		for idx := range mismatched {
+			objectNames[idx] = mismatched[idx].ObjectName
+		}
+		return nil, fmt.Errorf("%w: %d volumes in %s do not match the hash recorded in their manifest: %s",
+			ErrHashMismatch, len(mismatched), backends.RedactURI(target), strings.Join(objectNames, ", "))
+	}
+
+	e.logger.Infof("Done. Elapsed Time: %v", time.Since(jobInfo.StartTime))
+	return chain, nil
+}
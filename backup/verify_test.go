@@ -0,0 +1,143 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/notify"
+	"github.com/someone1/zfsbackup-go/report"
+)
+
+func TestVerify(t *testing.T) {
+	source := newTestPool(t, "tank/data", "a")
+	dir := t.TempDir()
+	destination := fmt.Sprintf("%s://%s", backends.FileBackendPrefix, dir)
+
+	sender := newTestEngine(t, source)
+	backupJob := func(full bool) {
+		t.Helper()
+		jobs, err := sender.ProcessSmartOptions(context.Background(), &files.JobInfo{
+			VolumeName:         "tank/data",
+			VolumeSize:         1,
+			UploadChunkSize:    1,
+			Destinations:       []string{destination},
+			MaxParallelUploads: 5,
+			MaxFileBuffer:      5,
+			MaxBackoffTime:     5 * time.Millisecond,
+			MaxRetryTime:       1 * time.Second,
+			StartTime:          time.Now(),
+			ManifestPrefix:     "manifests",
+			Separator:          "|",
+			Full:               full,
+			Incremental:        !full,
+			FullIfOlderThan:    -1 * time.Minute,
+		})
+		require.NoError(t, err)
+		require.NoError(t, sender.BackupJobs(context.Background(), jobs))
+	}
+
+	// A full backup of a, then incrementals from a to c and from c to d
+	backupJob(true)
+	mustSnapshot(t, source, "tank/data", "c")
+	backupJob(false)
+	mustSnapshot(t, source, "tank/data", "d")
+	backupJob(false)
+
+	recorder := new(report.Recorder)
+	hook := new(recordingHook)
+	verifier := newTestEngine(
+		t,
+		newTestPool(t, "backup"),
+		WithJSONOutput(recorder),
+		WithNotifier(notify.NewNotifier([]notify.Hook{hook}, nil)),
+	)
+	verify := func(target string) error {
+		t.Helper()
+		jobInfo := &files.JobInfo{
+			Destinations:       []string{destination},
+			MaxParallelUploads: 2,
+			MaxBackoffTime:     5 * time.Millisecond,
+			MaxRetryTime:       1 * time.Second,
+			ManifestPrefix:     "manifests",
+			Separator:          "|",
+		}
+		require.NoError(t, verifier.PrepareVerify(context.Background(), jobInfo, target))
+		return verifier.Verify(context.Background(), jobInfo)
+	}
+
+	// The latest backup set is verified along with the ones it is restored from
+	require.NoError(t, verify("tank/data"))
+	var summary verifySummary
+	require.NoError(t, decodeLastResult(recorder, &summary))
+	if assert.Len(t, summary.Verified, 3) {
+		for idx, name := range []string{"a", "c", "d"} {
+			assert.Equal(t, name, summary.Verified[idx].Snapshot.Name)
+			assert.NotZero(t, summary.Verified[idx].Volumes)
+		}
+	}
+	assert.NotZero(t, summary.TotalBytes)
+	if assert.Len(t, hook.events, 2) {
+		assert.Equal(t, notify.Start, hook.events[0].Event)
+		assert.Equal(t, notify.Success, hook.events[1].Event)
+		assert.Equal(t, "verify", hook.events[1].Operation)
+		assert.Len(t, hook.events[1].Chain, 3)
+	}
+
+	// A corrupted volume fails every backup set restored through it
+	backups, err := verifier.ListBackups(context.Background(), &files.JobInfo{
+		Destinations:   []string{destination},
+		ManifestPrefix: "manifests",
+	}, "tank/data", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, backups["tank/data"], 3)
+	corrupted := filepath.Join(dir, backups["tank/data"][1].Volumes[0].ObjectName)
+	data, err := os.ReadFile(corrupted)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(corrupted, data[:len(data)/2], 0o600))
+
+	require.NoError(t, verify("tank/data@a"))
+	hook.events, hook.payloads = nil, nil
+	err = verify("tank/data@c")
+	require.ErrorIs(t, err, ErrHashMismatch)
+	if assert.Len(t, hook.events, 2) {
+		assert.Equal(t, notify.Failure, hook.events[1].Event)
+		assert.Equal(t, "verify", hook.events[1].Operation)
+		assert.Equal(t, "hash_mismatch", hook.events[1].Code)
+	}
+	require.ErrorIs(t, verify("tank/data"), ErrHashMismatch)
+	require.ErrorIs(t, verify("tank/data@b"), ErrSnapshotNotFound)
+
+	// Nothing was received
+	snapshots, err := verifier.zfs.GetSnapshotsAndBookmarks(context.Background(), "backup")
+	require.NoError(t, err)
+	assert.Empty(t, snapshots)
+}
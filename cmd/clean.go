@@ -22,22 +22,38 @@ package cmd
 
 import (
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 
 	"github.com/someone1/zfsbackup-go/backup"
 )
 
-var cleanLocal bool
+var (
+	cleanLocal bool
+	planFile   string
+	applyPlan  string
+)
 
 // cleanCmd represents the clean command
 var cleanCmd = &cobra.Command{
-	Use:           "clean [flags] uri",
-	Short:         "Clean will delete any objects in the target that are not found in the manifest files found in the target.",
-	Long:          `Clean will delete any objects in the target that are not found in the manifest files found in the target.`,
+	Use:   "clean [flags] uri",
+	Short: "Clean will delete any objects in the target that are not found in the manifest files found in the target.",
+	Long: `Clean will delete any objects in the target that are not found in the manifest files found in the target.
+Backup sets older than the retention recorded for their dataset, from its zfsbackup:retention property, are deleted too.
+
+With --planFile, or in --appendOnly mode, nothing is deleted and the objects that would be are written to a plan
+instead. Someone with credentials that allow deleting can then delete them with --applyPlan.`,
 	SilenceErrors: true,
 	PreRunE:       validateCleanFlags,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if applyPlan != "" {
+			plan, err := backup.ReadCleanPlan(applyPlan)
+			if err != nil {
+				return err
+			}
+			return engine.ApplyCleanPlan(cmd.Context(), &jobInfo, plan)
+		}
 		jobInfo.Destinations = []string{args[0]}
-		return backup.Clean(cmd.Context(), &jobInfo, cleanLocal)
+		return engine.Clean(cmd.Context(), &jobInfo, cleanLocal, planFile)
 	},
 }
 
@@ -48,9 +64,19 @@ func init() {
 	cleanCmd.Flags().BoolVarP(&jobInfo.Force, "force", "", false,
 		"This will force the deletion of broken backup sets (sets where volumes expected in the manifest file are not found). Use with caution.",
 	)
+	cleanCmd.Flags().StringVar(&planFile, "planFile", "", "Write the objects that would be deleted to this file instead of deleting them.")
+	cleanCmd.Flags().StringVar(&applyPlan, "applyPlan", "", "Delete the objects listed in a plan written with --planFile, instead of a uri.")
 }
 
 func validateCleanFlags(cmd *cobra.Command, args []string) error {
+	if applyPlan != "" {
+		if len(args) != 0 || planFile != "" {
+			zap.S().Errorf("The --applyPlan flag takes the destination from the plan and cannot be used with a uri or --planFile.")
+			return errInvalidInput
+		}
+		return nil
+	}
+
 	if len(args) != 1 {
 		_ = cmd.Usage()
 		return errInvalidInput
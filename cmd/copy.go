@@ -0,0 +1,150 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/someone1/zfsbackup-go/backends"
+)
+
+var copyVolumeName string
+
+// copyCmd represents the copy command
+var copyCmd = &cobra.Command{
+	Use:   "copy [flags] source-uri target-uri(s)",
+	Short: "copy will replicate backup sets found in the source to the target(s) without needing access to ZFS.",
+	Long: `copy will replicate backup sets found in the source to the target(s) without needing access to ZFS.
+Only manifests and volumes missing from a target are transferred and every volume is verified against
+the hash recorded in its manifest before being uploaded. Use this to repair a destination that fell
+behind or to seed a new offsite copy from an existing one.`,
+	PreRunE: validateCopyFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		zap.S().Infof("Limiting the number of parallel uploads to %d", jobInfo.MaxParallelUploads)
+		zap.S().Infof("Max Backoff Time will be %v", jobInfo.MaxBackoffTime)
+		zap.S().Infof("Max Retry Time will be %v", jobInfo.MaxRetryTime)
+
+		return engine.Copy(cmd.Context(), &jobInfo, copyVolumeName)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(copyCmd)
+
+	copyCmd.Flags().StringVar(
+		&copyVolumeName,
+		"volumeName",
+		"",
+		"Only copy backup sets for this volume name, can end with a '*' to match as only a prefix",
+	)
+	copyCmd.Flags().IntVar(
+		&jobInfo.MaxParallelUploads,
+		"maxParallelUploads",
+		4,
+		"the maximum number of volumes to copy in parallel.",
+	)
+	copyCmd.Flags().DurationVar(
+		&jobInfo.MaxRetryTime,
+		"maxRetryTime",
+		12*time.Hour,
+		"the maximum time that can elapse when retrying a failed copy. Use 0 for no limit.",
+	)
+	copyCmd.Flags().DurationVar(
+		&jobInfo.MaxBackoffTime,
+		"maxBackoffTime",
+		30*time.Minute,
+		"the maximum delay you'd want a worker to sleep before retrying a copy.",
+	)
+	copyCmd.Flags().StringArrayVar(
+		&uploadLimits,
+		"uploadLimit",
+		nil,
+		"limit the upload speed to each target following a schedule of time-of-day windows and rates in bytes per second, "+
+			"e.g. \"08:00-18:00=5MiB,*=0\" (0 is unlimited). Prefix the schedule with a target URI and \"=\" to only limit that "+
+			"target. Can be given multiple times.",
+	)
+	copyCmd.Flags().StringVar(
+		&maxDownloadSpeed,
+		"maxDownloadSpeed",
+		"",
+		"limit the download speed from the source following a schedule of time-of-day windows and rates in bytes per second, "+
+			"e.g. \"08:00-18:00=5MiB,*=0\" (0 is unlimited), or a single rate for all day, e.g. \"5MiB\".",
+	)
+	copyCmd.Flags().IntVar(
+		&jobInfo.UploadChunkSize,
+		"uploadChunkSize",
+		10,
+		"the chunk size, in MiB, to use when uploading. A minimum of 5MiB and maximum of 100MiB is enforced.",
+	)
+}
+
+// ResetCopyJobInfo exists solely for integration testing
+func ResetCopyJobInfo() {
+	resetRootFlags()
+	copyVolumeName = ""
+	jobInfo.MaxParallelUploads = 4
+	jobInfo.MaxRetryTime = 12 * time.Hour
+	jobInfo.MaxBackoffTime = 30 * time.Minute
+	jobInfo.UploadChunkSize = 10
+	uploadLimits = nil
+	maxDownloadSpeed = ""
+}
+
+func validateCopyFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		_ = cmd.Usage()
+		return errInvalidInput
+	}
+
+	if jobInfo.MaxParallelUploads <= 0 {
+		zap.S().Errorf("The number of parallel uploads must be set to a value greater than 0. Was given %d", jobInfo.MaxParallelUploads)
+		return errInvalidInput
+	}
+
+	if jobInfo.UploadChunkSize < 5 || jobInfo.UploadChunkSize > 100 {
+		zap.S().Errorf("The uploadChunkSize provided (%d) is not between 5 and 100", jobInfo.UploadChunkSize)
+		return errInvalidInput
+	}
+
+	jobInfo.Destinations = append([]string{args[0]}, strings.Split(args[1], ",")...)
+	for _, destination := range jobInfo.Destinations {
+		_, err := backends.GetBackendForURI(destination)
+		if errors.Is(err, backends.ErrInvalidPrefix) {
+			zap.S().Errorf("Unsupported prefix provided in destination URI, was given %s", destination)
+			return errInvalidInput
+		} else if errors.Is(err, backends.ErrInvalidURI) {
+			zap.S().Errorf("Invalid destination URI, was given %s", destination)
+			return errInvalidInput
+		}
+	}
+
+	if err := applyUploadLimits(&jobInfo, uploadLimits); err != nil {
+		zap.S().Error(err)
+		return errInvalidInput
+	}
+
+	return nil
+}
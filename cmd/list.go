@@ -25,8 +25,6 @@ import (
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
-
-	"github.com/someone1/zfsbackup-go/backup"
 )
 
 var (
@@ -61,7 +59,7 @@ var listCmd = &cobra.Command{
 		}
 
 		jobInfo.Destinations = []string{args[0]}
-		return backup.List(cmd.Context(), &jobInfo, startsWith, before, after)
+		return engine.List(cmd.Context(), &jobInfo, startsWith, before, after)
 	},
 }
 
@@ -21,20 +21,17 @@
 package cmd
 
 import (
-	"errors"
-	"fmt"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
-	"github.com/someone1/zfsbackup-go/backends"
-	"github.com/someone1/zfsbackup-go/backup"
 	"github.com/someone1/zfsbackup-go/files"
-	"github.com/someone1/zfsbackup-go/zfs"
 )
 
+var maxDownloadSpeed string
+
 // receiveCmd represents the receive command
 var receiveCmd = &cobra.Command{
 	Use:     "receive [flags] filesystem|volume|snapshot-to-restore uri local_volume",
@@ -45,10 +42,10 @@ var receiveCmd = &cobra.Command{
 		zap.S().Infof("Limiting the number of active files to %d", jobInfo.MaxFileBuffer)
 
 		if jobInfo.AutoRestore {
-			return backup.AutoRestore(cmd.Context(), &jobInfo)
+			return engine.AutoRestore(cmd.Context(), &jobInfo)
 		}
 
-		return backup.Receive(cmd.Context(), &jobInfo)
+		return engine.Receive(cmd.Context(), &jobInfo)
 	},
 }
 
@@ -130,6 +127,13 @@ func init() {
 		"|",
 		"the separator to use between object component names (used only for the initial manifest we are looking for).",
 	)
+	receiveCmd.Flags().StringVar(
+		&maxDownloadSpeed,
+		"maxDownloadSpeed",
+		"",
+		"limit the download speed following a schedule of time-of-day windows and rates in bytes per second, "+
+			"e.g. \"08:00-18:00=5MiB,*=0\" (0 is unlimited), or a single rate for all day, e.g. \"5MiB\".",
+	)
 	receiveCmd.Flags().BoolVar(
 		&jobInfo.ProgressBar,
 		"progressBar",
@@ -153,68 +157,21 @@ func ResetReceiveJobInfo() {
 	jobInfo.MaxRetryTime = 12 * time.Hour
 	jobInfo.MaxBackoffTime = 30 * time.Minute
 	jobInfo.Separator = "|"
+	maxDownloadSpeed = ""
 }
 
-// nolint:gocyclo // Will do later
 func validateReceiveFlags(cmd *cobra.Command, args []string) error {
 	if len(args) != 3 {
 		_ = cmd.Usage()
 		return errInvalidInput
 	}
 
-	jobInfo.StartTime = time.Now()
-
-	parts := strings.Split(args[0], "@")
-	if len(parts) != 2 && !jobInfo.AutoRestore {
-		zap.S().Errorf("Invalid base snapshot provided. Expected format <volume>@<snapshot>, got %s instead", args[0])
-		return errInvalidInput
-	} else if len(parts) == 2 {
-		jobInfo.BaseSnapshot = files.SnapshotInfo{Name: parts[1]}
-	}
-
-	if jobInfo.FullPath && jobInfo.LastPath {
-		zap.S().Errorf("The -d and -e options are mutually exclusive, please select only one!")
-		return errInvalidInput
-	}
-
-	jobInfo.VolumeName = parts[0]
 	jobInfo.Destinations = strings.Split(args[1], ",")
 	jobInfo.LocalVolume = args[2]
 
-	// Intelligently restore to the snapshot wanted
-	if jobInfo.AutoRestore && jobInfo.IncrementalSnapshot.Name != "" {
-		zap.S().Errorf("Cannot request auto restore option and provide an incremental snapshot to restore from.")
-		return errInvalidInput
-	}
-
-	// Remove 'origin=' from beginning of -o argument
-	jobInfo.Origin = strings.TrimPrefix(jobInfo.Origin, "origin=")
-
-	if !jobInfo.AutoRestore {
-		// Let's see if we already have this snap shot
-		creationTime, err := zfs.GetCreationDate(cmd.Context(), fmt.Sprintf("%s@%s", jobInfo.LocalVolume, jobInfo.BaseSnapshot.Name))
-		if err == nil {
-			jobInfo.BaseSnapshot.CreationTime = creationTime
-		}
-		if jobInfo.IncrementalSnapshot.Name != "" {
-			jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, jobInfo.VolumeName)
-			jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, "@")
-			creationTime, err = zfs.GetCreationDate(cmd.Context(), fmt.Sprintf("%s@%s", jobInfo.LocalVolume, jobInfo.IncrementalSnapshot.Name))
-			if err == nil {
-				jobInfo.IncrementalSnapshot.CreationTime = creationTime
-			}
-		}
-	}
-
-	for _, destination := range jobInfo.Destinations {
-		_, err := backends.GetBackendForURI(destination)
-		if errors.Is(err, backends.ErrInvalidPrefix) {
-			zap.S().Errorf("Unsupported prefix provided in destination URI, was given %s", destination)
-			return errInvalidInput
-		} else if errors.Is(err, backends.ErrInvalidURI) {
-			zap.S().Errorf("Invalid destination URI, was given %s", destination)
-			return errInvalidInput
-		}
+	if err := engine.PrepareReceive(cmd.Context(), &jobInfo, args[0]); err != nil {
+		zap.S().Error(err)
+		return err
 	}
 
 	return nil
@@ -22,7 +22,8 @@ package cmd
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -36,8 +37,14 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
+	"github.com/someone1/zfsbackup-go/backup"
 	"github.com/someone1/zfsbackup-go/config"
+	"github.com/someone1/zfsbackup-go/events"
 	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/metrics"
+	"github.com/someone1/zfsbackup-go/notify"
+	"github.com/someone1/zfsbackup-go/report"
+	"github.com/someone1/zfsbackup-go/throttle"
 	"github.com/someone1/zfsbackup-go/zfs"
 )
 
@@ -45,7 +52,26 @@ var (
 	numCores         int
 	logLevel         string
 	workingDirectory string
-	errInvalidInput  = errors.New("invalid input")
+	metricsListen    string
+	metricsTextfile  string
+	notifyWebhooks   []string
+	notifyCommands   []string
+	notifyOn         []string
+	eventsFormat     string
+	eventsPath       string
+	eventsOutput     *os.File
+	zfsPath          string
+	zfsHost          string
+	remoteZFS        *zfs.Remote
+	backupTempdir    string
+	uploadBucket     *ratelimit.Bucket
+	downloadLimiter  *throttle.Limiter
+	notifier         *notify.Notifier
+	eventStream      *events.Stream
+	engine           *backup.Engine
+	appendOnly       bool
+	writeOnly        bool
+	errInvalidInput  = backup.ErrInvalidInput
 )
 
 // RootCmd represents the base command when called without any subcommands
@@ -67,12 +93,37 @@ destination of your choosing.`,
 
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// The process exits with the code documented for the error returned, if any.
 func Execute(ctx context.Context) {
-	if err := RootCmd.ExecuteContext(ctx); err != nil {
-		os.Exit(-1)
+	if code := backup.ExitCode(ExecuteCommand(ctx)); code != 0 {
+		os.Exit(code)
 	}
 }
 
+// ExecuteCommand will run the command given on the command line and output its results,
+// as a single JSON document if requested, whether the command succeeded or not.
+func ExecuteCommand(ctx context.Context) error {
+	report.Reset()
+	executed, err := RootCmd.ExecuteContextC(ctx)
+	if metricsTextfile != "" {
+		if merr := metrics.WriteTextfile(metricsTextfile); merr != nil {
+			zap.S().Errorf("Could not write metrics to %s due to error - %v", metricsTextfile, merr)
+		}
+	}
+
+	if config.JSONOutput {
+		command := RootCmd.Name()
+		if executed != nil {
+			command = executed.Name()
+		}
+		if werr := report.Write(config.Stdout, command, backup.Status(err), backup.ReportError(err)); werr != nil {
+			zap.S().Errorf("could not output json due to error - %v", werr)
+		}
+	}
+
+	return err
+}
+
 func init() {
 	cobra.OnInitialize(func() {
 		zapConfig := zap.NewDevelopmentConfig()
@@ -121,16 +172,78 @@ func init() {
 		"aes encryption key used to encrypt/decrypt files (or use `ENCRYPTION_KEY` environment variable).)",
 	)
 	RootCmd.PersistentFlags().StringVar(
-		&zfs.ZFSPath,
+		&zfsPath,
 		"zfsPath",
 		"zfs",
 		"the path to the zfs executable.",
 	)
+	RootCmd.PersistentFlags().StringVar(
+		&zfsHost,
+		"zfsHost",
+		"",
+		"run zfs on this host over SSH, e.g. user@host:22, instead of locally. Authentication works the same as the ssh:// backend.",
+	)
 	RootCmd.PersistentFlags().BoolVar(
 		&config.JSONOutput,
 		"jsonOutput",
 		false,
-		"dump results as a JSON string - on success only",
+		"output the results of the command, or the error it failed with, as a single versioned JSON document.",
+	)
+	RootCmd.PersistentFlags().StringVar(
+		&metricsListen,
+		"metricsListen",
+		"",
+		"the address to serve Prometheus metrics on at /metrics while the command runs, e.g. \":9153\".",
+	)
+	RootCmd.PersistentFlags().StringVar(
+		&metricsTextfile,
+		"metricsTextfile",
+		"",
+		"the path to write Prometheus metrics to once the command completes, for use with the node_exporter textfile collector.",
+	)
+	RootCmd.PersistentFlags().StringVar(
+		&eventsFormat,
+		"events",
+		"",
+		"emit a stream of progress events in the given format while the command runs. The only supported format is ndjson.",
+	)
+	RootCmd.PersistentFlags().StringVar(
+		&eventsPath,
+		"eventsFile",
+		"",
+		"the path to append the event stream to instead of stdout. Required when using --events with --jsonOutput.",
+	)
+	RootCmd.PersistentFlags().StringArrayVar(
+		&notifyWebhooks,
+		"notifyWebhook",
+		nil,
+		"a URL to POST a JSON event to when an operation starts and completes. Failed deliveries are retried. Can be given multiple times.",
+	)
+	RootCmd.PersistentFlags().StringArrayVar(
+		&notifyCommands,
+		"notifyExec",
+		nil,
+		"a command to run with a JSON event on its stdin when an operation starts and completes. Can be given multiple times.",
+	)
+	RootCmd.PersistentFlags().StringSliceVar(
+		&notifyOn,
+		"notifyOn",
+		[]string{"start", "success", "degraded", "failure"},
+		"the events to notify the hooks of: start, success, degraded, and/or failure.",
+	)
+	RootCmd.PersistentFlags().BoolVar(
+		&appendOnly,
+		"appendOnly",
+		false,
+		"never delete objects from destinations, so credentials that only allow adding objects can be used. "+
+			"clean writes out the objects it would delete for someone else to apply with --applyPlan instead.",
+	)
+	RootCmd.PersistentFlags().BoolVar(
+		&writeOnly,
+		"writeOnly",
+		false,
+		"only ever upload objects to destinations, so credentials that cannot list, download or delete them can be used. "+
+			"The local cache becomes the index of the backups made, restore and clean without this flag using credentials that allow it.",
 	)
 	_ = []byte(os.Getenv("PGP_PASSPHRASE"))
 }
@@ -141,8 +254,24 @@ func resetRootFlags() {
 	logLevel = "info"
 	workingDirectory = "~/.zfsbackup"
 	jobInfo.ManifestPrefix = "manifests"
-	zfs.ZFSPath = "zfs"
+	zfsPath = "zfs"
+	zfsHost = ""
+	remoteZFS = nil
 	config.JSONOutput = false
+	metricsListen = ""
+	metricsTextfile = ""
+	notifyWebhooks = nil
+	notifyCommands = nil
+	notifyOn = []string{"start", "success", "degraded", "failure"}
+	notifier = nil
+	appendOnly = false
+	writeOnly = false
+	eventsFormat = ""
+	eventsPath = ""
+	eventStream = nil
+	uploadBucket = nil
+	downloadLimiter = nil
+	engine = nil
 }
 
 // nolint:gocyclo,funlen // Will do later
@@ -166,14 +295,151 @@ func processFlags(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	zap.S().Infof("Setting working directory to %s", workingDirectory)
+
+	if err := setupEvents(); err != nil {
+		zap.S().Error(err)
+		return errInvalidInput
+	}
+
+	if err := setupNotifier(); err != nil {
+		zap.S().Error(err)
+		return errInvalidInput
+	}
+
+	if err := applyDownloadLimit(maxDownloadSpeed); err != nil {
+		zap.S().Error(err)
+		return errInvalidInput
+	}
+
+	var err error
+	if engine, err = newEngine(); err != nil {
+		zap.S().Error(err)
+		return err
+	}
+
+	if metricsListen != "" {
+		go func() {
+			if err := metrics.Serve(cmd.Context(), metricsListen); err != nil {
+				zap.S().Errorf("Could not serve metrics on %s due to error - %v", metricsListen, err)
+			}
+		}()
+	}
+	return nil
+}
+
+func setupEvents() error {
+	switch eventsFormat {
+	case "":
+		if eventsPath != "" {
+			return fmt.Errorf("an events format must be provided with --events when using --eventsFile")
+		}
+		return nil
+	case "ndjson":
+	default:
+		return fmt.Errorf("unsupported events format %s, the only supported format is ndjson", eventsFormat)
+	}
+
+	if eventsPath == "" {
+		if config.JSONOutput {
+			return fmt.Errorf("--jsonOutput already writes to stdout, provide an --eventsFile to use with --events")
+		}
+		eventStream = events.NewStream(config.Stdout)
+		return nil
+	}
+
+	f, err := os.OpenFile(eventsPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open events file %s - %v", eventsPath, err)
+	}
+	eventsOutput = f
+	eventStream = events.NewStream(f)
+	return nil
+}
+
+func setupNotifier() error {
+	if len(notifyWebhooks) == 0 && len(notifyCommands) == 0 {
+		return nil
+	}
+
+	events, err := notify.ParseEvents(notifyOn)
+	if err != nil {
+		return err
+	}
+
+	hooks := make([]notify.Hook, 0, len(notifyWebhooks)+len(notifyCommands))
+	for _, webhook := range notifyWebhooks {
+		if u, perr := url.Parse(webhook); perr != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid webhook URL provided, was given %s", webhook)
+		}
+		hooks = append(hooks, &notify.Webhook{URL: webhook})
+	}
+	for _, command := range notifyCommands {
+		hooks = append(hooks, &notify.Command{Command: command})
+	}
+
+	notifier = notify.NewNotifier(hooks, events)
 	return nil
 }
 
+// newEngine will return the Engine to run the command with, configured from the flags it was given.
+func newEngine() (*backup.Engine, error) {
+	var runner zfs.Runner = &zfs.Local{Path: zfsPath}
+	if zfsHost != "" {
+		remote, err := zfs.DialRemote(zfsHost, zfsPath)
+		if err != nil {
+			zap.S().Errorf("Could not connect to zfs host %s - %v", zfsHost, err)
+			return nil, err
+		}
+		remoteZFS = remote
+		runner = remote
+	}
+
+	// The event stream takes over stdout unless it is written to a file
+	output := config.Stdout
+	if eventStream != nil && eventsPath == "" {
+		output = os.Stderr
+	}
+
+	opts := []backup.Option{
+		backup.WithTempDir(backupTempdir),
+		backup.WithOutput(output),
+		backup.WithUploadLimit(uploadBucket),
+		backup.WithDownloadLimiter(downloadLimiter),
+		backup.WithNotifier(notifier),
+		backup.WithEvents(eventStream),
+		backup.WithZFS(runner),
+	}
+	if config.JSONOutput {
+		opts = append(opts, backup.WithJSONOutput(report.Default))
+	}
+	if appendOnly {
+		opts = append(opts, backup.WithAppendOnly())
+	}
+	if writeOnly {
+		opts = append(opts, backup.WithWriteOnly())
+	}
+	return backup.NewEngine(workingDirectory, opts...)
+}
+
 func postRunCleanup(cmd *cobra.Command, args []string) {
-	err := os.RemoveAll(config.BackupTempdir)
+	err := os.RemoveAll(backupTempdir)
 	if err != nil {
 		zap.S().Errorf("Could not clean working temporary directory - %v", err)
 	}
+
+	if eventsOutput != nil {
+		if err = eventsOutput.Close(); err != nil {
+			zap.S().Errorf("Could not close events file - %v", err)
+		}
+		eventsOutput = nil
+	}
+
+	if remoteZFS != nil {
+		if err = remoteZFS.Close(); err != nil {
+			zap.S().Errorf("Could not close connection to zfs host - %v", err)
+		}
+		remoteZFS = nil
+	}
 }
 
 // nolint:gocyclo,funlen // Will do later
@@ -227,8 +493,7 @@ func setupGlobalVars() error {
 		return err
 	}
 
-	config.BackupTempdir = tempdir
-	config.WorkingDir = workingDirectory
+	backupTempdir = tempdir
 
 	dirPath = filepath.Join(workingDirectory, "cache")
 	if dir, serr := os.Stat(dirPath); serr == nil && !dir.IsDir() {
@@ -247,7 +512,67 @@ func setupGlobalVars() error {
 
 	if maxUploadSpeed != 0 {
 		zap.S().Infof("Limiting the upload speed to %s/s.", humanize.Bytes(maxUploadSpeed*humanize.KByte))
-		config.BackupUploadBucket = ratelimit.NewBucketWithRate(float64(maxUploadSpeed*humanize.KByte), int64(maxUploadSpeed*humanize.KByte))
+		uploadBucket = ratelimit.NewBucketWithRate(float64(maxUploadSpeed*humanize.KByte), int64(maxUploadSpeed*humanize.KByte))
+	}
+	return nil
+}
+
+// applyUploadLimits will set the bandwidth limit for each of the job's destinations. Each limit is either
+// a schedule that applies to every destination without a limit of its own, or a destination URI followed
+// by "=" and a schedule, e.g. "s3://bucket=08:00-18:00=5MiB,*=0".
+func applyUploadLimits(j *files.JobInfo, limits []string) error {
+	var defaultLimit *throttle.Schedule
+	schedules := make(map[string]*throttle.Schedule)
+	for _, limit := range limits {
+		destination, value := "", limit
+		for _, d := range j.Destinations {
+			if strings.HasPrefix(limit, d+"=") {
+				destination, value = d, strings.TrimPrefix(limit, d+"=")
+				break
+			}
+		}
+		if destination == "" && strings.Contains(limit, "://") {
+			zap.S().Warnf("The upload limit %s does not match any of the destinations for %s, ignoring.", limit, j.VolumeName)
+			continue
+		}
+
+		schedule, err := throttle.ParseSchedule(value)
+		if err != nil {
+			return err
+		}
+		if destination == "" {
+			defaultLimit = schedule
+		} else {
+			schedules[destination] = schedule
+		}
+	}
+
+	j.UploadLimiters = make(map[string]*throttle.Limiter)
+	for _, destination := range j.Destinations {
+		schedule, ok := schedules[destination]
+		if !ok {
+			schedule = defaultLimit
+		}
+		if schedule != nil {
+			zap.S().Infof("Limiting the upload speed to %s with the schedule %s.", destination, schedule)
+			j.UploadLimiters[destination] = throttle.NewLimiter(schedule)
+		}
+	}
+	return nil
+}
+
+// applyDownloadLimit will set the bandwidth limit used when downloading volumes from the given schedule.
+func applyDownloadLimit(value string) error {
+	downloadLimiter = nil
+	if value == "" {
+		return nil
+	}
+
+	schedule, err := throttle.ParseSchedule(value)
+	if err != nil {
+		return err
 	}
+	zap.S().Infof("Limiting the download speed with the schedule %s.", schedule)
+	downloadLimiter = throttle.NewLimiter(schedule)
 	return nil
 }
@@ -30,24 +30,33 @@ import (
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
-	"github.com/someone1/zfsbackup-go/backends"
 	"github.com/someone1/zfsbackup-go/backup"
 	"github.com/someone1/zfsbackup-go/config"
 	"github.com/someone1/zfsbackup-go/files"
-	"github.com/someone1/zfsbackup-go/zfs"
 )
 
 var (
 	jobInfo         files.JobInfo
 	fullIncremental string
 	maxUploadSpeed  uint64
+	discover        bool
+	sendJobs        []*files.JobInfo
+	require         string
+	uploadLimits    []string
+	lockDays        int
 )
 
 // sendCmd represents the send command
 var sendCmd = &cobra.Command{
-	Use:     "send [flags] filesystem|volume|snapshot uri(s)",
-	Short:   "send will backup of a ZFS volume similar to how the \"zfs send\" command works.",
-	Long:    `send take a subset of the`,
+	Use:   "send [flags] filesystem|volume|snapshot uri(s)",
+	Short: "send will backup of a ZFS volume similar to how the \"zfs send\" command works.",
+	Long: `send take a subset of the
+
+With --discover, the first argument is the root dataset to walk and the uri(s) are optional. Every
+dataset with the zfsbackup:enabled user property set to true (inherited values included) is backed up
+using the provided "smart" option, e.g.:
+
+	zfs set zfsbackup:enabled=true zfsbackup:destinations=gs://bucket Tank/Dataset`,
 	PreRunE: validateSendFlags,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		zap.S().Infof("Limiting the number of active files to %d", jobInfo.MaxFileBuffer)
@@ -56,7 +65,11 @@ var sendCmd = &cobra.Command{
 		zap.S().Infof("Max Upload Retry Time will be %v", jobInfo.MaxRetryTime)
 		zap.S().Infof("Upload Chunk Size will be %dMiB", jobInfo.UploadChunkSize)
 
-		return backup.Backup(cmd.Context(), &jobInfo)
+		if discover {
+			return sendDiscovered(cmd.Context(), args)
+		}
+
+		return engine.BackupJobs(cmd.Context(), sendJobs)
 	},
 }
 
@@ -125,7 +138,7 @@ func init() {
 		&jobInfo.MaxParallelUploads,
 		"maxParallelUploads",
 		4,
-		"the maximum number of uploads to run in parallel.",
+		"the maximum number of uploads to run in parallel to each destination. Volumes are uploaded to all destinations at once.",
 	)
 	sendCmd.Flags().Uint64Var(
 		&maxUploadSpeed,
@@ -133,6 +146,14 @@ func init() {
 		0,
 		"the maximum upload speed (in KB/s) the program should use between all upload workers. Use 0 for no limit",
 	)
+	sendCmd.Flags().StringArrayVar(
+		&uploadLimits,
+		"uploadLimit",
+		nil,
+		"limit the upload speed to each destination following a schedule of time-of-day windows and rates in bytes per second, "+
+			"e.g. \"08:00-18:00=5MiB,*=0\" (0 is unlimited). Prefix the schedule with a destination URI and \"=\" to only limit that "+
+			"destination. Limits change during a transfer as the schedule does. Can be given multiple times.",
+	)
 	sendCmd.Flags().DurationVar(
 		&jobInfo.MaxRetryTime,
 		"maxRetryTime",
@@ -163,6 +184,35 @@ func init() {
 		true,
 		"Enable progressbar during upload.",
 	)
+	sendCmd.Flags().StringVar(
+		&require,
+		"require",
+		"all",
+		"how many destinations the backup must complete to: all, any, or a number. Destinations that fail are marked incomplete "+
+			"in the local cache and the command exits with a distinct exit code if the backup did not complete to every destination.",
+	)
+	sendCmd.Flags().BoolVar(
+		&discover,
+		"discover",
+		false,
+		"walk the provided dataset and backup every descendant that sets the zfsbackup:enabled user property. "+
+			"Per-dataset destinations, snapshot prefix, and retention can be set with the zfsbackup:destinations, "+
+			"zfsbackup:snapshotprefix, and zfsbackup:retention user properties. Requires a \"smart\" option.",
+	)
+	sendCmd.Flags().IntVar(
+		&lockDays,
+		"lockDays",
+		0,
+		"lock the uploaded objects so they cannot be deleted or overwritten for this many days, using S3 Object Lock, Azure "+
+			"immutability policies, or GCS object retention, which must be enabled on the bucket or container. Use with --appendOnly.",
+	)
+	sendCmd.Flags().BoolVar(
+		&jobInfo.LockCompliance,
+		"lockCompliance",
+		false,
+		"lock objects so nobody, including the account's administrators, can remove or shorten the lock, rather than only those "+
+			"with the permissions to.",
+	)
 }
 
 // ResetSendJobInfo exists solely for integration testing
@@ -175,6 +225,7 @@ func ResetSendJobInfo() {
 	jobInfo.IncrementalSnapshot = files.SnapshotInfo{}
 	jobInfo.BaseSnapshot = files.SnapshotInfo{}
 	fullIncremental = ""
+	sendJobs = nil
 	jobInfo.Properties = false
 
 	// Specific to download only
@@ -191,106 +242,107 @@ func ResetSendJobInfo() {
 	jobInfo.MaxBackoffTime = 30 * time.Minute
 	jobInfo.Separator = "|"
 	jobInfo.UploadChunkSize = 10
+	discover = false
+	require = "all"
+	uploadLimits = nil
+	lockDays = 0
+	jobInfo.LockCompliance = false
 }
 
-// nolint:gocyclo,funlen // Will do later
 func updateJobInfo(ctx context.Context, args []string) error {
-	jobInfo.StartTime = time.Now()
-	jobInfo.Version = config.VersionNumber
-
 	if fullIncremental != "" {
 		jobInfo.IncrementalSnapshot.Name = fullIncremental
 		jobInfo.IntermediaryIncremental = true
 	}
 
-	parts := strings.Split(args[0], "@")
-	jobInfo.VolumeName = parts[0]
 	jobInfo.Destinations = strings.Split(args[1], ",")
+	if err := applyUploadLimits(&jobInfo, uploadLimits); err != nil {
+		zap.S().Error(err)
+		return errInvalidInput
+	}
+
+	jobs, err := engine.PrepareSend(ctx, &jobInfo, args[0])
+	if err != nil {
+		zap.S().Error(err)
+		return err
+	}
+	sendJobs = jobs
+	return nil
+}
 
-	if len(jobInfo.Destinations) > 1 && jobInfo.MaxFileBuffer == 0 {
-		zap.S().Errorf("Specifying multiple destinations and a MaxFileBuffer size of 0 is unsupported.")
+func validateDestinations(j *files.JobInfo) error {
+	if err := backup.ValidateDestinations(j); err != nil {
+		zap.S().Error(err)
+		return err
+	}
+
+	if err := applyUploadLimits(j, uploadLimits); err != nil {
+		zap.S().Error(err)
 		return errInvalidInput
 	}
 
-	for _, destination := range jobInfo.Destinations {
-		_, err := backends.GetBackendForURI(destination)
-		if errors.Is(err, backends.ErrInvalidPrefix) {
-			zap.S().Errorf("Unsupported prefix provided in destination URI, was given %s", destination)
-			return err
-		} else if errors.Is(err, backends.ErrInvalidURI) {
-			zap.S().Errorf("Unsupported destination URI, was given %s", destination)
-			return err
-		}
+	return nil
+}
+
+// sendDiscovered will backup every dataset under the provided root that opted in via user properties.
+// A failure for one dataset does not stop the others from being backed up.
+func sendDiscovered(ctx context.Context, args []string) error {
+	jobs, err := engine.DiscoverJobs(ctx, args[0], &jobInfo)
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		zap.S().Warnf("No datasets under %s have %s set, nothing to backup.", args[0], backup.PropertyEnabled)
+		return nil
 	}
 
-	// If we aren't using a "smart" option, rely on the user to provide the snapshots to use!
-	if !usingSmartOption() {
-		if len(parts) != 2 {
-			zap.S().Errorf("Invalid base snapshot provided. Expected format <volume>@<snapshot>, got %s instead", args[0])
-			return errInvalidInput
-		}
-		jobInfo.BaseSnapshot = files.SnapshotInfo{Name: parts[1]}
-		creationTime, err := zfs.GetCreationDate(ctx, args[0])
-		if err != nil {
-			zap.S().Errorf("Error trying to get creation date of specified base snapshot - %v", err)
-			return err
+	var errs []error
+	var degraded bool
+	for _, job := range jobs {
+		if err := validateDestinations(job); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", job.VolumeName, err))
+			continue
 		}
-		jobInfo.BaseSnapshot.CreationTime = creationTime
-
-		if jobInfo.IncrementalSnapshot.Name != "" {
-			var targetName string
-			jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, jobInfo.VolumeName)
-			if strings.HasPrefix(jobInfo.IncrementalSnapshot.Name, "#") {
-				jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, "#")
-				targetName = fmt.Sprintf("%s#%s", jobInfo.VolumeName, jobInfo.IncrementalSnapshot.Name)
-				jobInfo.IncrementalSnapshot.Bookmark = true
-			} else {
-				jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, "@")
-				targetName = fmt.Sprintf("%s@%s", jobInfo.VolumeName, jobInfo.IncrementalSnapshot.Name)
-			}
-
-			creationTime, err = zfs.GetCreationDate(ctx, targetName)
-			if err != nil {
-				zap.S().Errorf("Error trying to get creation date of specified incremental snapshot/bookmark - %v", err)
-				return err
-			}
-			jobInfo.IncrementalSnapshot.CreationTime = creationTime
-		}
-	} else {
-		// Some basic checks here
-		onlyOneCheck := 0
-		if jobInfo.Full {
-			onlyOneCheck++
-		}
-		if jobInfo.Incremental {
-			onlyOneCheck++
-		}
-		if jobInfo.FullIfOlderThan != -1*time.Minute {
-			onlyOneCheck++
-		}
-		if onlyOneCheck > 1 {
-			zap.S().Errorf("Please specify only one \"smart\" option at a time")
-			return errInvalidInput
-		}
-		if len(parts) != 1 {
-			zap.S().Errorf("When using a smart option, please only specify the volume to backup, do not include any snapshot information.")
-			return errInvalidInput
+
+		job.StartTime = time.Now()
+		planned, err := engine.ProcessSmartOptions(ctx, job)
+		if errors.Is(err, backup.ErrNoOp) {
+			zap.S().Infof("Nothing new to backup for %s, skipping.", job.VolumeName)
+			continue
+		} else if err != nil {
+			zap.S().Errorf("Error while trying to process smart option for %s - %v", job.VolumeName, err)
+			errs = append(errs, fmt.Errorf("%s: %w", job.VolumeName, err))
+			continue
 		}
-		if err := backup.ProcessSmartOptions(ctx, &jobInfo); err != nil {
-			zap.S().Errorf("Error while trying to process smart option - %v", err)
-			return err
+
+		zap.S().Infof("Starting backup of %s.", job.VolumeName)
+		if err := engine.BackupJobs(ctx, planned); errors.Is(err, backup.ErrDegraded) {
+			degraded = true
+		} else if err != nil {
+			zap.S().Errorf("Backup of %s failed - %v", job.VolumeName, err)
+			errs = append(errs, fmt.Errorf("%s: %w", job.VolumeName, err))
 		}
-		zap.S().Debugf("Utilizing smart option.")
 	}
 
-	return nil
-}
-
-func usingSmartOption() bool {
-	return jobInfo.Full || jobInfo.Incremental || jobInfo.FullIfOlderThan != -1*time.Minute
+	if len(errs) == 0 && degraded {
+		return backup.ErrDegraded
+	}
+	return errors.Join(errs...)
 }
 
 func validateSendFlags(cmd *cobra.Command, args []string) error {
+	required, err := backup.ParseRequire(require)
+	if err != nil {
+		zap.S().Error(err)
+		return err
+	}
+	jobInfo.RequiredDestinations = required
+	jobInfo.LockDuration = time.Duration(lockDays) * 24 * time.Hour
+
+	if discover {
+		return validateDiscoverFlags(cmd, args)
+	}
+
 	if len(args) != 2 {
 		_ = cmd.Usage()
 		return errInvalidInput
@@ -301,10 +353,39 @@ func validateSendFlags(cmd *cobra.Command, args []string) error {
 		return errInvalidInput
 	}
 
+	return updateJobInfo(cmd.Context(), args)
+}
+
+func validateDiscoverFlags(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		_ = cmd.Usage()
+		return errInvalidInput
+	}
+
+	if backup.SmartOptions(&jobInfo) == 0 {
+		zap.S().Errorf("The --discover flag requires a \"smart\" option (--full, --increment, or --fullIfOlderThan).")
+		return errInvalidInput
+	}
+
+	if backup.SmartOptions(&jobInfo) > 1 {
+		zap.S().Errorf("Please specify only one \"smart\" option at a time")
+		return errInvalidInput
+	}
+
+	if strings.Contains(args[0], "@") {
+		zap.S().Errorf("When using --discover, please only specify the root dataset, do not include any snapshot information.")
+		return errInvalidInput
+	}
+
 	if err := jobInfo.ValidateSendFlags(); err != nil {
 		zap.S().Error(err)
 		return err
 	}
 
-	return updateJobInfo(cmd.Context(), args)
+	jobInfo.Version = config.VersionNumber
+	if len(args) == 2 {
+		jobInfo.Destinations = strings.Split(args[1], ",")
+	}
+
+	return nil
 }
@@ -0,0 +1,160 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/someone1/zfsbackup-go/api"
+	"github.com/someone1/zfsbackup-go/files"
+)
+
+var (
+	serveListen   string
+	serveToken    string
+	serveCert     string
+	serveKey      string
+	serveClientCA string
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve [flags]",
+	Short: "serve will run an HTTP API to list backup sets and to start, follow, and cancel backups, restores, and verifies remotely.",
+	Long: `serve will run an HTTP API to list backup sets and to start, follow, and cancel backups, restores, and verifies remotely.
+
+Clients must authenticate with a bearer token (--token or the ZFSBACKUP_API_TOKEN environment variable), with a
+client certificate signed by the CA provided with --tlsClientCA, or both. The global options such as the encryption
+key, manifest prefix, and notification hooks apply to every job started through the API.`,
+	PreRunE: validateServeFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tlsConfig, err := serveTLSConfig()
+		if err != nil {
+			zap.S().Error(err)
+			return errInvalidInput
+		}
+
+		template := files.JobInfo{ManifestPrefix: jobInfo.ManifestPrefix, AesEncryptionKey: jobInfo.AesEncryptionKey}
+		server := api.NewServer(cmd.Context(), engine, &template, serveToken)
+		return server.ListenAndServe(serveListen, tlsConfig)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(
+		&serveListen,
+		"listen",
+		"127.0.0.1:8585",
+		"the address to serve the API on.",
+	)
+	serveCmd.Flags().StringVar(
+		&serveToken,
+		"token",
+		"",
+		"the bearer token clients must provide (or use `ZFSBACKUP_API_TOKEN` environment variable).",
+	)
+	serveCmd.Flags().StringVar(
+		&serveCert,
+		"tlsCert",
+		"",
+		"the path to the PEM encoded certificate to serve the API over TLS with.",
+	)
+	serveCmd.Flags().StringVar(
+		&serveKey,
+		"tlsKey",
+		"",
+		"the path to the PEM encoded private key for the certificate provided with --tlsCert.",
+	)
+	serveCmd.Flags().StringVar(
+		&serveClientCA,
+		"tlsClientCA",
+		"",
+		"the path to the PEM encoded CA certificate(s) client certificates must be signed by. Requires --tlsCert and --tlsKey.",
+	)
+}
+
+func validateServeFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		_ = cmd.Usage()
+		return errInvalidInput
+	}
+
+	if serveToken == "" {
+		serveToken = os.Getenv("ZFSBACKUP_API_TOKEN")
+	}
+
+	if (serveCert == "") != (serveKey == "") {
+		zap.S().Errorf("The --tlsCert and --tlsKey options must be provided together.")
+		return errInvalidInput
+	}
+
+	if serveClientCA != "" && serveCert == "" {
+		zap.S().Errorf("The --tlsClientCA option requires --tlsCert and --tlsKey.")
+		return errInvalidInput
+	}
+
+	if serveToken == "" && serveClientCA == "" {
+		zap.S().Errorf("Please provide a bearer token with --token, a CA to verify client certificates with --tlsClientCA, or both.")
+		return errInvalidInput
+	}
+
+	if serveCert == "" {
+		zap.S().Warnf("Serving the API without TLS, the bearer token will be sent in the clear.")
+	}
+
+	return nil
+}
+
+// serveTLSConfig will return the TLS configuration to serve the API with, nil when not using TLS.
+func serveTLSConfig() (*tls.Config, error) {
+	if serveCert == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(serveCert, serveKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not load the TLS certificate and key - %v", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if serveClientCA != "" {
+		pem, err := os.ReadFile(serveClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the client CA %s - %v", serveClientCA, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in the client CA %s", serveClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
@@ -21,15 +21,14 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	"runtime"
 	"time"
 
 	"github.com/spf13/cobra"
-	"go.uber.org/zap"
 
 	"github.com/someone1/zfsbackup-go/config"
+	"github.com/someone1/zfsbackup-go/report"
 )
 
 var (
@@ -56,9 +55,8 @@ var versionCmd = &cobra.Command{
 	Long: `This will output the version of zfsbackup in use and information about
 the runtime and architecture.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		var output string
 		if config.JSONOutput {
-			j, err := json.Marshal(Version{
+			report.Record(Version{
 				Name:        config.ProgramName,
 				Version:     ReleaseVersion,
 				OS:          runtime.GOOS,
@@ -68,17 +66,12 @@ the runtime and architecture.`,
 				Commit:      Commit,
 				CompileTime: CompileTime,
 			})
-			if err != nil {
-				zap.S().Errorf("could not dump version info to JSON - %v", err)
-				return err
-			}
-			output = string(j)
-		} else {
-			output = fmt.Sprintf(
-				"\tProgram Name:\t%s\n\tVersion:\t%s\n\tOS Target:\t%s\n\tArch Target:\t%s\n\tCompiled With:\t%s\n\tGo Version:\t%s\n\tCommit:\t%s\n\tCompile time:\t%s",
-				config.ProgramName, ReleaseVersion, runtime.GOOS, runtime.GOARCH, runtime.Compiler, runtime.Version(), Commit, CompileTime)
+			return nil
 		}
-		fmt.Fprintln(config.Stdout, output)
+		fmt.Fprintf(
+			config.Stdout,
+			"\tProgram Name:\t%s\n\tVersion:\t%s\n\tOS Target:\t%s\n\tArch Target:\t%s\n\tCompiled With:\t%s\n\tGo Version:\t%s\n\tCommit:\t%s\n\tCompile time:\t%s\n",
+			config.ProgramName, ReleaseVersion, runtime.GOOS, runtime.GOARCH, runtime.Compiler, runtime.Version(), Commit, CompileTime)
 		return nil
 	},
 }
@@ -0,0 +1,107 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package events writes a stream of structured, newline delimited JSON events describing the
+// progress of an operation so other programs can follow along without parsing logs.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Type identifies what an event describes.
+type Type string
+
+// The events that can be emitted.
+const (
+	SnapshotSelected Type = "snapshot_selected"
+	VolumeCreated    Type = "volume_created"
+	VolumeUploaded   Type = "volume_uploaded"
+	VolumeVerified   Type = "volume_verified"
+	RetryScheduled   Type = "retry_scheduled"
+	Replicated       Type = "replicated"
+	ReceiveStarted   Type = "receive_started"
+	ReceiveCompleted Type = "receive_completed"
+	Error            Type = "error"
+)
+
+// Event is a single line in the stream. Only the fields relevant to the event's type are set.
+type Event struct {
+	Type        Type
+	Time        time.Time
+	Operation   string        `json:",omitempty"`
+	VolumeName  string        `json:",omitempty"`
+	Snapshot    string        `json:",omitempty"`
+	Incremental string        `json:",omitempty"`
+	Destination string        `json:",omitempty"`
+	ObjectName  string        `json:",omitempty"`
+	Volume      int64         `json:",omitempty"`
+	Bytes       uint64        `json:",omitempty"`
+	RetryIn     time.Duration `json:",omitempty"`
+	Code        string        `json:",omitempty"`
+	Error       string        `json:",omitempty"`
+}
+
+// Stream writes events as newline delimited JSON. A nil Stream discards all events.
+type Stream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewStream will return a Stream that writes events to w.
+func NewStream(w io.Writer) *Stream {
+	return &Stream{enc: json.NewEncoder(w)}
+}
+
+// Emit will write the event to the stream, setting its time if it was not provided.
+func (s *Stream) Emit(event *Event) {
+	if s == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(event); err != nil {
+		zap.S().Warnf("Could not write %s event due to error - %v", event.Type, err)
+	}
+}
+
+type contextKey struct{}
+
+// NewContext will return a copy of the context that carries the stream. Operations running with
+// the context emit their events to it instead of the stream configured for the command.
+func NewContext(ctx context.Context, s *Stream) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext will return the stream carried by the context, if any.
+func FromContext(ctx context.Context) *Stream {
+	s, _ := ctx.Value(contextKey{}).(*Stream)
+	return s
+}
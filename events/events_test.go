@@ -0,0 +1,36 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream(t *testing.T) {
+	var nilStream *Stream
+	nilStream.Emit(&Event{Type: VolumeCreated})
+
+	buf := new(bytes.Buffer)
+	stream := NewStream(buf)
+	stream.Emit(&Event{Type: VolumeCreated, VolumeName: "tank/data", Volume: 1, Bytes: 1024})
+	stream.Emit(&Event{Type: Error, Code: "backend_unreachable", Error: "boom"})
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+
+	require.Len(t, lines, 2)
+	assert.Equal(t, "volume_created", lines[0]["Type"])
+	assert.EqualValues(t, 1024, lines[0]["Bytes"])
+	assert.NotContains(t, lines[0], "Error")
+	assert.NotEmpty(t, lines[0]["Time"])
+	assert.Equal(t, "backend_unreachable", lines[1]["Code"])
+}
@@ -28,6 +28,8 @@ import (
 
 	"github.com/dustin/go-humanize"
 	"go.uber.org/zap"
+
+	"github.com/someone1/zfsbackup-go/throttle"
 )
 
 var disallowedSeps = regexp.MustCompile(`^[\w\-:\.]+`) // Disallowed by ZFS
@@ -52,8 +54,11 @@ type JobInfo struct {
 	Deduplication           bool
 	Properties              bool
 	IntermediaryIncremental bool
-	Resume                  bool `json:"-"`
-	ProgressBar             bool `json:"-"`
+	LockUntil               time.Time
+	// Retention is how long the backup sets of the volume are kept for, if set, which clean deletes them after.
+	Retention   time.Duration `json:",omitempty"`
+	Resume      bool          `json:"-"`
+	ProgressBar bool          `json:"-"`
 	// "Smart" Options
 	Full            bool          `json:"-"`
 	Incremental     bool          `json:"-"`
@@ -78,6 +83,15 @@ type JobInfo struct {
 	AesEncryptionKey   string        `json:"-"`
 	ParentSnap         *JobInfo      `json:"-"`
 	UploadChunkSize    int           `json:"-"`
+	// LockDuration is how long after the backup starts its objects are locked for, if at all, and
+	// LockCompliance makes it a lock nobody can remove or shorten.
+	LockDuration   time.Duration `json:"-"`
+	LockCompliance bool          `json:"-"`
+	// RequiredDestinations is the number of destinations a backup must complete to, 0 means all of them.
+	RequiredDestinations int      `json:"-"`
+	FailedDestinations   []string `json:"-"`
+	// UploadLimiters holds the bandwidth limit to use for each destination, if any.
+	UploadLimiters map[string]*throttle.Limiter `json:"-"`
 }
 
 // SnapshotInfo represents a snapshot with relevant information.
@@ -132,8 +146,12 @@ func (j *JobInfo) String() string {
 		fmt.Sprintf("Raw: %v", j.Raw),
 		fmt.Sprintf("Archives: %d - %d bytes (%s)", len(j.Volumes), totalWrittenBytes, humanize.IBytes(totalWrittenBytes)),
 		fmt.Sprintf("Volume Size (Raw): %d bytes (%s)", j.ZFSStreamBytes, humanize.IBytes(j.ZFSStreamBytes)),
-		fmt.Sprintf("Uploaded: %v (took %v)\n\n", j.StartTime, j.EndTime.Sub(j.StartTime)),
+		fmt.Sprintf("Uploaded: %v (took %v)", j.StartTime, j.EndTime.Sub(j.StartTime)),
 	)
+	if !j.LockUntil.IsZero() {
+		output = append(output, fmt.Sprintf("Locked Until: %v", j.LockUntil))
+	}
+	output[len(output)-1] += "\n\n"
 	return strings.Join(output, "\n\t")
 }
 
@@ -178,6 +196,10 @@ func (j *JobInfo) ValidateSendFlags() error {
 		return fmt.Errorf("The max retry time must be set to a value greater than or equal to 0. Was given %d", j.MaxRetryTime)
 	}
 
+	if j.LockDuration < 0 {
+		return fmt.Errorf("The lock duration must be set to a value greater than or equal to 0. Was given %v", j.LockDuration)
+	}
+
 	if j.MaxBackoffTime <= 0 {
 		return fmt.Errorf("The max backoff time must be set to a value greater than 0. Was given %d", j.MaxBackoffTime)
 	}
@@ -22,10 +22,13 @@ package files
 
 import (
 	"bufio"
+	"compress/gzip"
 	"context"
+	"crypto/aes"
 	"crypto/md5"  // nolint:gosec // MD5 not used for cryptographic purposes here
 	"crypto/sha1" // nolint:gosec // SHA1 not used for cryptographic purposes here
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"hash"
 	"hash/crc32"
@@ -40,6 +43,7 @@ import (
 
 	"github.com/someone1/zfsbackup-go/compencrypt"
 	"github.com/someone1/zfsbackup-go/config"
+	"github.com/someone1/zfsbackup-go/throttle"
 )
 
 const (
@@ -47,6 +51,9 @@ const (
 	BufferSize = 256 * humanize.KiByte // 256KiB
 )
 
+// ErrWrongKey is returned when a volume could not be decrypted, likely because the encryption key is missing or incorrect.
+var ErrWrongKey = errors.New("files: could not decrypt volume, the encryption key may be missing or incorrect")
+
 // VolumeInfo holds all necessary information for a Volume as part of a backup
 type VolumeInfo struct {
 	ObjectName      string
@@ -79,6 +86,8 @@ type VolumeInfo struct {
 	rw io.ReadCloser
 	// Detail Objects
 	counter   *datacounter.WriterCounter
+	limiter   *throttle.Limiter
+	bucket    *ratelimit.Bucket
 	usingPipe bool
 	isClosed  bool
 	isOpened  bool
@@ -133,7 +142,7 @@ func (v *VolumeInfo) ReadAt(p []byte, off int64) (int, error) {
 // Only valid to be called after creating a new Volume and closing it or when
 // a MaxFileBuffer of 0 in which case this does nothing.
 func (v *VolumeInfo) OpenVolume() error {
-	if v.isOpened {
+	if v.isOpened || v.usingPipe {
 		return nil
 	}
 	f, err := os.Open(v.filename)
@@ -144,13 +153,59 @@ func (v *VolumeInfo) OpenVolume() error {
 	v.r = f
 	v.isClosed = false
 	v.isOpened = true
-	if config.BackupUploadBucket != nil {
-		v.r = ratelimit.Reader(v.r, config.BackupUploadBucket)
+	if v.bucket != nil {
+		v.r = ratelimit.Reader(v.r, v.bucket)
+	}
+	if v.limiter != nil {
+		v.r = v.limiter.Reader(v.r)
 	}
 
 	return nil
 }
 
+// SetRateLimiter will limit the rate this volume is read at when uploading, in addition to any
+// global upload limit. A volume using a pipe is limited right away since it is already open.
+func (v *VolumeInfo) SetRateLimiter(l *throttle.Limiter) {
+	if v.limiter == l {
+		return
+	}
+	v.limiter = l
+	if l != nil && v.usingPipe && v.r != nil {
+		v.r = l.Reader(v.r)
+	}
+}
+
+// WrapReader will pass reads of the opened volume through the reader returned by wrap until it is closed.
+// It does not apply to Seek or ReadAt.
+func (v *VolumeInfo) WrapReader(wrap func(io.Reader) io.Reader) {
+	if v.r != nil {
+		v.r = wrap(v.r)
+	}
+}
+
+// Duplicate will return a closed copy of this VolumeInfo referencing the same underlying file so that
+// it can be opened and read independently of the original, e.g. when uploading to several destinations at once.
+// Only valid to be called after creating a new Volume and closing it.
+func (v *VolumeInfo) Duplicate() *VolumeInfo {
+	return &VolumeInfo{
+		ObjectName:      v.ObjectName,
+		VolumeNumber:    v.VolumeNumber,
+		SHA256Sum:       v.SHA256Sum,
+		MD5Sum:          v.MD5Sum,
+		SHA1Sum:         v.SHA1Sum,
+		CRC32CSum32:     v.CRC32CSum32,
+		Size:            v.Size,
+		ZFSStreamBytes:  v.ZFSStreamBytes,
+		CreateTime:      v.CreateTime,
+		CloseTime:       v.CloseTime,
+		IsManifest:      v.IsManifest,
+		IsFinalManifest: v.IsFinalManifest,
+		filename:        v.filename,
+		usingPipe:       v.usingPipe,
+		bucket:          v.bucket,
+	}
+}
+
 // ExtractLocal will try and open a local file for extraction
 func ExtractLocal(j *JobInfo, path string) (*VolumeInfo, error) {
 	v := new(VolumeInfo)
@@ -175,6 +230,10 @@ func (v *VolumeInfo) Extract(j *JobInfo) error {
 
 	reader, err := compencrypt.NewDecryptAndDecompressReader(io.NopCloser(v.r), []byte(j.AesEncryptionKey))
 	if err != nil {
+		var keySizeErr aes.KeySizeError
+		if errors.Is(err, gzip.ErrHeader) || errors.As(err, &keySizeErr) {
+			return fmt.Errorf("%w - %v", ErrWrongKey, err)
+		}
 		return err
 	}
 	v.rw = reader
@@ -319,8 +378,8 @@ func (v *VolumeInfo) CopyTo(dest string) (err error) {
 
 // prepareVolume returns a VolumeInfo, filename parts, extension parts, and an error
 // compress -> encrypt/sign -> output
-func prepareVolume(ctx context.Context, j *JobInfo, pipe bool) (*VolumeInfo, error) {
-	v, err := CreateSimpleVolume(ctx, pipe)
+func prepareVolume(ctx context.Context, j *JobInfo, pipe bool, opts VolumeOptions) (*VolumeInfo, error) {
+	v, err := CreateSimpleVolume(ctx, pipe, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -338,9 +397,9 @@ func prepareVolume(ctx context.Context, j *JobInfo, pipe bool) (*VolumeInfo, err
 // CreateManifestVolume will call CreateSimpleVolume and add options to compress,
 // encrypt, and/or sign the file as it is written depending on the provided options.
 // It will also name the file accordingly as a manifest file.
-func CreateManifestVolume(ctx context.Context, j *JobInfo) (*VolumeInfo, error) {
+func CreateManifestVolume(ctx context.Context, j *JobInfo, opts VolumeOptions) (*VolumeInfo, error) {
 	// Create and name the manifest file
-	v, err := prepareVolume(ctx, j, false)
+	v, err := prepareVolume(ctx, j, false, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -354,13 +413,13 @@ func CreateManifestVolume(ctx context.Context, j *JobInfo) (*VolumeInfo, error)
 // CreateBackupVolume will call CreateSimpleVolume and add options to compress,
 // encrypt, and/or sign the file as it is written depending on the provided options.
 // It will also name the file accordingly as a volume as part of backup set.
-func CreateBackupVolume(ctx context.Context, j *JobInfo, volnum int64) (*VolumeInfo, error) {
+func CreateBackupVolume(ctx context.Context, j *JobInfo, volnum int64, opts VolumeOptions) (*VolumeInfo, error) {
 	pipe := false
 	if j.MaxFileBuffer == 0 {
 		pipe = true
 	}
 
-	v, err := prepareVolume(ctx, j, pipe)
+	v, err := prepareVolume(ctx, j, pipe, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -371,11 +430,21 @@ func CreateBackupVolume(ctx context.Context, j *JobInfo, volnum int64) (*VolumeI
 	return v, nil
 }
 
+// VolumeOptions control where volumes are written and how fast they are read when uploaded.
+type VolumeOptions struct {
+	// TempDir is the directory temporary files are written to, the default directory for
+	// temporary files if empty.
+	TempDir string
+	// UploadBucket limits how fast volumes are read when uploaded, shared by every volume using it.
+	UploadBucket *ratelimit.Bucket
+}
+
 // CreateSimpleVolume will create a temporary file to write to. If
 // MaxParallelUploads is set to 0, no temporary file will be used and an OS Pipe
 // will be used instead.
-func CreateSimpleVolume(ctx context.Context, pipe bool) (*VolumeInfo, error) {
+func CreateSimpleVolume(ctx context.Context, pipe bool, opts VolumeOptions) (*VolumeInfo, error) {
 	v := &VolumeInfo{
+		bucket:     opts.UploadBucket,
 		SHA256:     sha256.New(),
 		CRC32C:     crc32.New(crc32.MakeTable(crc32.Castagnoli)),
 		MD5:        md5.New(),  // nolint:gosec // MD5 not used for cryptographic purposes here
@@ -389,11 +458,11 @@ func CreateSimpleVolume(ctx context.Context, pipe bool) (*VolumeInfo, error) {
 		v.w = v.pw
 		v.isOpened = true
 		v.usingPipe = true
-		if config.BackupUploadBucket != nil {
-			v.r = ratelimit.Reader(v.r, config.BackupUploadBucket)
+		if v.bucket != nil {
+			v.r = ratelimit.Reader(v.r, v.bucket)
 		}
 	} else {
-		tempFile, err := os.CreateTemp(config.BackupTempdir, config.ProgramName)
+		tempFile, err := os.CreateTemp(opts.TempDir, config.ProgramName)
 		if err != nil {
 			return nil, err
 		}
@@ -24,30 +24,35 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"net/url"
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/storage"
-	"github.com/Azure/azure-storage-blob-go/azblob"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 
 	"github.com/someone1/zfsbackup-go/cmd"
 	"github.com/someone1/zfsbackup-go/config"
 	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/report"
 )
 
 const (
 	s3TestBucketName    = "s3integrationbuckettest"
 	azureTestBucketName = "azureintegrationbuckettest"
 	logLevel            = "debug"
+
+	// The well known account and key of the Azure storage emulator, Azurite.
+	azureEmulatorAccountName = "devstoreaccount1"
+	azureEmulatorAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
 )
 
 func setupAzureBucket(t *testing.T) func() {
@@ -56,34 +61,32 @@ func setupAzureBucket(t *testing.T) func() {
 		t.Skip("No custom Azure Endpoint provided to test against")
 		return nil
 	}
-	err := os.Setenv("AZURE_ACCOUNT_NAME", storage.StorageEmulatorAccountName)
+	err := os.Setenv("AZURE_ACCOUNT_NAME", azureEmulatorAccountName)
 	if err != nil {
 		t.Fatalf("could not set environmental variable due to error: %v", err)
 	}
-	err = os.Setenv("AZURE_ACCOUNT_KEY", storage.StorageEmulatorAccountKey)
+	err = os.Setenv("AZURE_ACCOUNT_KEY", azureEmulatorAccountKey)
 	if err != nil {
 		t.Fatalf("could not set environmental variable due to error: %v", err)
 	}
 
 	ctx := context.Background()
 
-	credential, err := azblob.NewSharedKeyCredential(storage.StorageEmulatorAccountName, storage.StorageEmulatorAccountKey)
+	credential, err := container.NewSharedKeyCredential(azureEmulatorAccountName, azureEmulatorAccountKey)
 	if err != nil {
 		t.Fatalf("failed to parse SAS key: %v", err)
 	}
-	destURL, err := url.Parse(os.Getenv("AZURE_CUSTOM_ENDPOINT"))
+	containerURL := strings.TrimSuffix(os.Getenv("AZURE_CUSTOM_ENDPOINT"), "/") + "/" + azureTestBucketName
+	containerSvc, err := container.NewClientWithSharedKeyCredential(containerURL, credential, nil)
 	if err != nil {
 		t.Fatalf("failed to construct Azure API URL: %v", err)
 	}
-	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{})
-	svcURL := azblob.NewServiceURL(*destURL, pipeline)
-	containerSvc := svcURL.NewContainerURL(azureTestBucketName)
-	if _, err = containerSvc.Create(ctx, azblob.Metadata{}, azblob.PublicAccessNone); err != nil {
+	if _, err = containerSvc.Create(ctx, nil); err != nil {
 		t.Fatalf("error while creating bucket: %v", err)
 	}
 
 	return func() {
-		if _, err := containerSvc.Delete(ctx, azblob.ContainerAccessConditions{}); err != nil {
+		if _, err := containerSvc.Delete(ctx, nil); err != nil {
 			t.Errorf("could not delete container - %v", err)
 		}
 	}
@@ -94,52 +97,51 @@ func setupS3Bucket(t *testing.T) func() {
 		t.Skip("No custom S3 Endpoint provided to test against")
 	}
 
-	awsconf := aws.NewConfig().
-		WithS3ForcePathStyle(true).
-		WithEndpoint(os.Getenv("AWS_S3_CUSTOM_ENDPOINT"))
+	ctx := context.Background()
 
-	sess, err := session.NewSession(awsconf)
+	awsconf, err := awsconfig.LoadDefaultConfig(ctx)
 	if err != nil {
 		t.Fatalf("could not create AWS client due to error: %v", err)
 	}
 
-	client := s3.New(sess)
-	_, err = client.CreateBucket(&s3.CreateBucketInput{
+	client := s3.NewFromConfig(awsconf, func(o *s3.Options) {
+		o.UsePathStyle = true
+		o.BaseEndpoint = aws.String(os.Getenv("AWS_S3_CUSTOM_ENDPOINT"))
+	})
+	_, err = client.CreateBucket(ctx, &s3.CreateBucketInput{
 		Bucket: aws.String(s3TestBucketName),
 	})
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			if aerr.Code() != "BucketAlreadyOwnedByYou" {
-				t.Fatalf("could not create S3 bucket due to error: %v", err)
-			}
-		}
+	var owned *types.BucketAlreadyOwnedByYou
+	if err != nil && !errors.As(err, &owned) {
+		t.Fatalf("could not create S3 bucket due to error: %v", err)
 	}
 
 	return func() {
-		objects, err := client.ListObjects(&s3.ListObjectsInput{
+		objects, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
 			Bucket: aws.String(s3TestBucketName),
 		})
 		if err != nil {
-			t.Errorf("could not list objects: %v", err)
+			t.Fatalf("could not list objects: %v", err)
 		}
 
-		objectsToDelete := make([]*s3.ObjectIdentifier, 0, len(objects.Contents))
+		objectsToDelete := make([]types.ObjectIdentifier, 0, len(objects.Contents))
 		for _, object := range objects.Contents {
-			obj := s3.ObjectIdentifier{
+			objectsToDelete = append(objectsToDelete, types.ObjectIdentifier{
 				Key: object.Key,
-			}
-			objectsToDelete = append(objectsToDelete, &obj)
+			})
 		}
 
-		if _, err := client.DeleteObjects(&s3.DeleteObjectsInput{
-			Bucket: aws.String(s3TestBucketName),
-			Delete: &s3.Delete{
-				Objects: objectsToDelete,
-			},
-		}); err != nil {
-			t.Errorf("could not delete objects: %v", err)
+		if len(objectsToDelete) > 0 {
+			if _, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(s3TestBucketName),
+				Delete: &types.Delete{
+					Objects: objectsToDelete,
+				},
+			}); err != nil {
+				t.Errorf("could not delete objects: %v", err)
+			}
 		}
-		if _, err := client.DeleteBucket(&s3.DeleteBucketInput{
+		if _, err := client.DeleteBucket(ctx, &s3.DeleteBucketInput{
 			Bucket: aws.String(s3TestBucketName),
 		}); err != nil {
 			t.Errorf("could not delete bucket - %v", err)
@@ -256,14 +258,22 @@ func listWrapper(dataset, bucket string) func(*testing.T) {
 				cmd.ResetListJobInfo()
 
 				cmd.RootCmd.SetArgs(append(opts, bucket))
-				if err := cmd.RootCmd.ExecuteContext(ctx); err != nil {
+				if err := cmd.ExecuteCommand(ctx); err != nil {
 					t.Fatalf("error performing backup: %v", err)
 				}
 
-				jout := make(map[string][]*files.JobInfo)
-				if err := json.Unmarshal(buf.Bytes(), &jout); err != nil {
+				var envelope struct {
+					SchemaVersion int
+					Status        string
+					Result        map[string][]*files.JobInfo
+				}
+				if err := json.Unmarshal(buf.Bytes(), &envelope); err != nil {
 					t.Fatalf("error parsing json output: %v", err)
 				}
+				if envelope.SchemaVersion != report.SchemaVersion || envelope.Status != report.StatusSuccess {
+					t.Fatalf("unexpected json envelope, got schema version %d and status %s", envelope.SchemaVersion, envelope.Status)
+				}
+				jout := envelope.Result
 
 				if len(jout) != test.keys || len(jout[dataset]) != test.entries {
 					t.Fatalf("expected %d keys and %d entries, got %d keys and %d entries", test.keys, test.entries, len(jout), len(jout[dataset]))
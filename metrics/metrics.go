@@ -0,0 +1,152 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package metrics holds the Prometheus metrics collected while running backups and restores.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+const namespace = "zfsbackup"
+
+// Registry is the registry all of the metrics below are registered with.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// ZFSStreamBytes counts the bytes read from the zfs send stream per dataset.
+	ZFSStreamBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "zfs_stream_bytes_total",
+		Help:      "Bytes read from the zfs send stream.",
+	}, []string{"dataset"})
+
+	// UploadedBytes counts the bytes uploaded per destination.
+	UploadedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploaded_bytes_total",
+		Help:      "Bytes uploaded to a destination.",
+	}, []string{"destination"})
+
+	// DownloadedBytes counts the bytes downloaded while restoring or copying.
+	DownloadedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloaded_bytes_total",
+		Help:      "Bytes downloaded from a destination.",
+	})
+
+	// VolumesInFlight is the number of volumes created that have yet to make it to every destination.
+	VolumesInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "volumes_in_flight",
+		Help:      "Volumes created that have not finished uploading to every destination.",
+	})
+
+	// UploadRetries counts the failed upload attempts that were retried per destination.
+	UploadRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_retries_total",
+		Help:      "Failed upload attempts that were retried.",
+	}, []string{"destination"})
+
+	// UploadDuration observes how long it took to upload a volume per destination, retries included.
+	UploadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Time taken to upload a volume, retries included.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 14),
+	}, []string{"destination"})
+
+	// LastSuccess is the time of the last successful backup per dataset and destination.
+	LastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful backup.",
+	}, []string{"dataset", "destination"})
+
+	// LastRestoreSuccess is the time of the last successful restore per dataset.
+	LastRestoreSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_restore_success_timestamp_seconds",
+		Help:      "Unix time of the last successful restore.",
+	}, []string{"dataset"})
+
+	// ChainLength is the number of backups in the most recent chain (a full backup and its incrementals).
+	ChainLength = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backup_chain_length",
+		Help:      "Number of backups in the most recent backup chain, the full backup included.",
+	}, []string{"dataset", "destination"})
+
+	// StoredBytes is the size of all backups stored in a destination per dataset.
+	StoredBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backup_stored_bytes",
+		Help:      "Bytes stored in the destination across all backups.",
+	}, []string{"dataset", "destination"})
+)
+
+func init() {
+	Registry.MustRegister(
+		ZFSStreamBytes,
+		UploadedBytes,
+		DownloadedBytes,
+		VolumesInFlight,
+		UploadRetries,
+		UploadDuration,
+		LastSuccess,
+		LastRestoreSuccess,
+		ChainLength,
+		StoredBytes,
+	)
+}
+
+// Serve will expose the metrics at /metrics on the given address until the context is done.
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		if err := server.Close(); err != nil {
+			zap.S().Warnf("Could not close metrics listener due to error - %v", err)
+		}
+	}()
+
+	zap.S().Infof("Serving metrics on %s/metrics", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// WriteTextfile will write the current metrics to the given path in a format the node_exporter
+// textfile collector can read. The file is replaced atomically.
+func WriteTextfile(path string) error {
+	return prometheus.WriteToTextfile(path, Registry)
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTextfile(t *testing.T) {
+	UploadedBytes.WithLabelValues("file:///backups").Add(1024)
+	LastSuccess.WithLabelValues("tank/data", "file:///backups").Set(1700000000)
+
+	path := filepath.Join(t.TempDir(), "zfsbackup.prom")
+	require.NoError(t, WriteTextfile(path))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	output := string(content)
+	assert.True(t, strings.Contains(output, `zfsbackup_uploaded_bytes_total{destination="file:///backups"} 1024`), output)
+	assert.True(t, strings.Contains(output, `zfsbackup_last_success_timestamp_seconds{dataset="tank/data",destination="file:///backups"} 1.7e+09`), output)
+	assert.True(t, strings.Contains(output, "zfsbackup_volumes_in_flight 0"), output)
+}
@@ -0,0 +1,252 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package notify delivers events describing the start and outcome of operations to
+// webhooks and local commands so failures can be acted upon.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"go.uber.org/zap"
+)
+
+// EventType describes what stage of an operation an event reports on.
+type EventType string
+
+// The events that can be delivered to hooks.
+const (
+	Start    EventType = "start"
+	Success  EventType = "success"
+	Degraded EventType = "degraded"
+	Failure  EventType = "failure"
+)
+
+// AllEvents lists every event type in the order they are documented.
+var AllEvents = []EventType{Start, Success, Degraded, Failure}
+
+// hookTimeout bounds how long a single hook may take, retries included.
+const hookTimeout = 2 * time.Minute
+
+// Link describes a single backup set in the chain of backups an operation worked on.
+type Link struct {
+	VolumeName      string
+	Snapshot        string
+	CreationTime    time.Time
+	IncrementalFrom string `json:",omitempty"`
+}
+
+// Event is the payload delivered to hooks. The fields of Result, such as the JSON output of
+// the operation, are included at the top level of the payload alongside the other fields. The Operation
+// is send, receive, verify, clean, or copy, pruning expired backup sets being part of clean.
+type Event struct {
+	Event        EventType
+	Operation    string
+	Time         time.Time
+	VolumeName   string   `json:",omitempty"`
+	Destinations []string `json:",omitempty"`
+	Code         string   `json:",omitempty"`
+	Error        string   `json:",omitempty"`
+	Chain        []Link   `json:",omitempty"`
+	Result       interface{}
+}
+
+// MarshalJSON will flatten the Result into the event payload.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]interface{})
+	if e.Result != nil {
+		raw, err := json.Marshal(e.Result)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(raw, &fields); err != nil {
+			return nil, fmt.Errorf("the result of an event must be a JSON object - %v", err)
+		}
+		if fields == nil {
+			fields = make(map[string]interface{})
+		}
+	}
+
+	fields["Event"] = e.Event
+	fields["Operation"] = e.Operation
+	fields["Time"] = e.Time
+	if e.VolumeName != "" {
+		fields["VolumeName"] = e.VolumeName
+	}
+	if len(e.Destinations) > 0 {
+		fields["Destinations"] = e.Destinations
+	}
+	if e.Code != "" {
+		fields["Code"] = e.Code
+	}
+	if e.Error != "" {
+		fields["Error"] = e.Error
+	}
+	if len(e.Chain) > 0 {
+		fields["Chain"] = e.Chain
+	}
+	return json.Marshal(fields)
+}
+
+// Hook is a destination for events.
+type Hook interface {
+	Notify(ctx context.Context, event *Event, payload []byte) error
+	String() string
+}
+
+// Notifier delivers events to its hooks.
+type Notifier struct {
+	Hooks  []Hook
+	Events map[EventType]bool
+}
+
+// NewNotifier will return a Notifier that delivers the given events to the hooks provided.
+// If no events are provided, all events are delivered.
+func NewNotifier(hooks []Hook, events []EventType) *Notifier {
+	if len(events) == 0 {
+		events = AllEvents
+	}
+	n := &Notifier{Hooks: hooks, Events: make(map[EventType]bool, len(events))}
+	for _, event := range events {
+		n.Events[event] = true
+	}
+	return n
+}
+
+// ParseEvents will parse a list of event names.
+func ParseEvents(values []string) ([]EventType, error) {
+	events := make([]EventType, 0, len(values))
+	for _, value := range values {
+		event := EventType(strings.ToLower(strings.TrimSpace(value)))
+		switch event {
+		case Start, Success, Degraded, Failure:
+			events = append(events, event)
+		default:
+			return nil, fmt.Errorf("unknown event %q, must be one of start, success, degraded or failure", value)
+		}
+	}
+	return events, nil
+}
+
+// Notify will deliver the event to every hook. Hooks are still notified if the context
+// is canceled so failures can be reported. Errors are logged and never returned as a
+// failed notification should not fail the operation being reported on.
+func (n *Notifier) Notify(ctx context.Context, event *Event) {
+	if n == nil || len(n.Hooks) == 0 || !n.Events[event.Event] {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		zap.S().Errorf("Could not marshal %s %s event due to error - %v", event.Operation, event.Event, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), hookTimeout)
+	defer cancel()
+	for _, hook := range n.Hooks {
+		if err := hook.Notify(ctx, event, payload); err != nil {
+			zap.S().Errorf("Could not deliver %s %s event to %s due to error - %v", event.Operation, event.Event, hook, err)
+		} else {
+			zap.S().Debugf("Delivered %s %s event to %s", event.Operation, event.Event, hook)
+		}
+	}
+}
+
+// Webhook will POST events as JSON to a URL, retrying on network errors and server errors.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// Notify implements the Hook interface.
+func (w *Webhook) Notify(ctx context.Context, _ *Event, payload []byte) error {
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	operation := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(payload))
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests:
+			return fmt.Errorf("received status %s", resp.Status)
+		case resp.StatusCode >= http.StatusBadRequest:
+			return backoff.Permanent(fmt.Errorf("received status %s", resp.Status))
+		}
+		return nil
+	}
+
+	be := backoff.NewExponentialBackOff()
+	be.MaxElapsedTime = 0
+	return backoff.Retry(operation, backoff.WithContext(be, ctx))
+}
+
+func (w *Webhook) String() string {
+	return w.URL
+}
+
+// Command will run a command through the shell with the event payload on its stdin. The event
+// type and operation are also provided in the ZFSBACKUP_EVENT and ZFSBACKUP_OPERATION environment variables.
+type Command struct {
+	Command string
+}
+
+// Notify implements the Hook interface.
+func (c *Command) Notify(ctx context.Context, event *Event, payload []byte) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Command)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(
+		os.Environ(),
+		fmt.Sprintf("ZFSBACKUP_EVENT=%s", event.Event),
+		fmt.Sprintf("ZFSBACKUP_OPERATION=%s", event.Operation),
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v - %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (c *Command) String() string {
+	return c.Command
+}
@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventMarshalJSON(t *testing.T) {
+	event := &Event{
+		Event:     Success,
+		Operation: "send",
+		Chain:     []Link{{VolumeName: "tank/data", Snapshot: "snap2", IncrementalFrom: "snap1"}},
+		Result:    struct{ Status string }{"success"},
+	}
+
+	payload, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(payload, &fields))
+	assert.Equal(t, "success", fields["Event"])
+	assert.Equal(t, "send", fields["Operation"])
+	assert.Equal(t, "success", fields["Status"])
+	assert.NotContains(t, fields, "Error")
+	assert.Len(t, fields["Chain"], 1)
+}
+
+func TestWebhookRetries(t *testing.T) {
+	var calls int32
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	hook := &Webhook{URL: server.URL}
+	require.NoError(t, hook.Notify(context.Background(), &Event{Event: Failure}, []byte(`{"Event":"failure"}`)))
+	assert.EqualValues(t, 2, calls)
+	assert.Equal(t, `{"Event":"failure"}`, string(body))
+
+	badRequest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer badRequest.Close()
+
+	hook = &Webhook{URL: badRequest.URL}
+	assert.Error(t, hook.Notify(context.Background(), &Event{Event: Failure}, nil))
+}
+
+func TestNotifier(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "event")
+	notifier := NewNotifier([]Hook{&Command{Command: `cat > "` + out + `"; echo "$ZFSBACKUP_EVENT" >> "` + out + `"`}}, []EventType{Failure})
+
+	// Events that were not asked for are not delivered
+	notifier.Notify(context.Background(), &Event{Event: Start, Operation: "receive"})
+	_, err := os.Stat(out)
+	assert.True(t, os.IsNotExist(err))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	notifier.Notify(ctx, &Event{Event: Failure, Operation: "receive", Error: "boom"})
+	content, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"Error":"boom"`)
+	assert.Contains(t, string(content), "failure\n")
+
+	_, err = ParseEvents([]string{"success", "bogus"})
+	assert.Error(t, err)
+}
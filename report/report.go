@@ -0,0 +1,151 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package report collects the results of a command so they can be output as a single,
+// versioned JSON document once the command completes, whether it succeeded or not.
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// SchemaVersion is the version of the Envelope output. It is incremented whenever a
+// field is removed or its meaning changes, adding fields does not change the version.
+const SchemaVersion = 1
+
+// Status values reported in the Envelope.
+const (
+	StatusSuccess  = "success"
+	StatusDegraded = "degraded"
+	StatusNoOp     = "no_op"
+	StatusFailure  = "failure"
+)
+
+// Error describes why a command failed.
+type Error struct {
+	Code     string
+	Message  string
+	ExitCode int
+}
+
+// Envelope is the JSON document output by every command. Result holds the command's
+// result, or a list of results for commands that work on several volumes at once.
+type Envelope struct {
+	SchemaVersion int
+	Command       string
+	Status        string
+	Result        interface{} `json:",omitempty"`
+	Error         *Error      `json:",omitempty"`
+}
+
+// Default collects the results of the command, it is what Record, Results, Reset, and Write use.
+var Default = new(Recorder)
+
+// Record will add the result to the results to output once the command completes.
+func Record(result interface{}) {
+	Default.Record(result)
+}
+
+// Results will return the results recorded so far.
+func Results() []interface{} {
+	return Default.Results()
+}
+
+// Reset will clear the results recorded so far.
+func Reset() {
+	Default.Reset()
+}
+
+// Recorder collects the results of operations, callers that run several operations at once in the
+// same process can give each its own Recorder instead of sharing the results of the command.
+type Recorder struct {
+	mu      sync.Mutex
+	results []interface{}
+}
+
+// Record will add the result to the results collected so far.
+func (r *Recorder) Record(result interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, result)
+}
+
+// Results will return the results collected so far.
+func (r *Recorder) Results() []interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]interface{}(nil), r.results...)
+}
+
+// Reset will clear the results collected so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = nil
+}
+
+// Result will return the result collected, a list of the results if more than one was collected, or nil if there were none.
+func (r *Recorder) Result() interface{} {
+	return collapse(r.Results())
+}
+
+type contextKey struct{}
+
+// NewContext will return a copy of the context that carries the recorder. Operations running
+// with the context record their results to it instead of the results of the command.
+func NewContext(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// FromContext will return the recorder carried by the context, if any.
+func FromContext(ctx context.Context) *Recorder {
+	r, _ := ctx.Value(contextKey{}).(*Recorder)
+	return r
+}
+
+// Write will output the Envelope for the command with the results recorded so far, and
+// the error it failed with, if any.
+func Write(w io.Writer, command, status string, e *Error) error {
+	envelope := Envelope{SchemaVersion: SchemaVersion, Command: command, Status: status, Error: e}
+
+	envelope.Result = collapse(Results())
+
+	j, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(j))
+	return err
+}
+
+func collapse(results []interface{}) interface{} {
+	switch len(results) {
+	case 0:
+		return nil
+	case 1:
+		return results[0]
+	default:
+		return results
+	}
+}
@@ -0,0 +1,31 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	defer Reset()
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, Write(buf, "clean", StatusFailure, &Error{Code: "backend_unreachable", Message: "boom", ExitCode: 8}))
+	assert.JSONEq(t, `{"SchemaVersion":1,"Command":"clean","Status":"failure","Error":{"Code":"backend_unreachable","Message":"boom","ExitCode":8}}`, buf.String())
+
+	Record(map[string]int{"a": 1})
+	buf.Reset()
+	require.NoError(t, Write(buf, "send", StatusSuccess, nil))
+	assert.JSONEq(t, `{"SchemaVersion":1,"Command":"send","Status":"success","Result":{"a":1}}`, buf.String())
+
+	Record(map[string]int{"b": 2})
+	buf.Reset()
+	require.NoError(t, Write(buf, "send", StatusSuccess, nil))
+
+	var envelope Envelope
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &envelope))
+	assert.Len(t, envelope.Result, 2)
+}
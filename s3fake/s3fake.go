@@ -0,0 +1,934 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package s3fake provides an in-memory, S3 compatible server to run the s3 backend against without
+// a real endpoint, whether in tests or on a machine without access to the cloud. It serves path-style
+// requests, e.g. http://127.0.0.1:9000/bucket/key, and ignores request signatures.
+package s3fake
+
+import (
+	"bufio"
+	"crypto/md5"  //nolint:gosec // MD5 is what S3 uses for ETags and Content-MD5
+	"crypto/sha1" //nolint:gosec // SHA1 is one of the checksums S3 supports
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The operations requests are counted by and faults can be injected into.
+const (
+	CreateBucket            = "CreateBucket"
+	DeleteBucket            = "DeleteBucket"
+	HeadBucket              = "HeadBucket"
+	ListObjectsV2           = "ListObjectsV2"
+	PutObject               = "PutObject"
+	GetObject               = "GetObject"
+	HeadObject              = "HeadObject"
+	DeleteObject            = "DeleteObject"
+	RestoreObject           = "RestoreObject"
+	CreateMultipartUpload   = "CreateMultipartUpload"
+	UploadPart              = "UploadPart"
+	CompleteMultipartUpload = "CompleteMultipartUpload"
+	AbortMultipartUpload    = "AbortMultipartUpload"
+)
+
+// StorageClasses are the storage classes objects can be written with, STANDARD if none is given.
+// Objects in GLACIER or DEEP_ARCHIVE, or moved to an archive access tier of INTELLIGENT_TIERING with
+// Archive, must be restored before they can be read.
+var StorageClasses = []string{
+	"STANDARD", "REDUCED_REDUNDANCY", "STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING",
+	"GLACIER", "DEEP_ARCHIVE", "GLACIER_IR", "OUTPOSTS",
+}
+
+const defaultMaxKeys = 1000
+
+// Fault makes the requests it matches fail with an S3 error instead of being served.
+type Fault struct {
+	// Operation is the operation to fail, e.g. PutObject, or every operation if empty.
+	Operation string
+	// Key is the prefix of the object keys to fail requests for, or every key if empty.
+	Key string
+	// Status is the HTTP status to respond with, 500 if unset.
+	Status int
+	// Code is the S3 error code to respond with, InternalError if unset.
+	Code string
+	// Times is the number of requests to fail, or every request if 0.
+	Times int
+}
+
+// Server is an http.Handler that keeps its buckets in memory.
+type Server struct {
+	// RestoreDelay is how long restoring an archived object takes.
+	RestoreDelay time.Duration
+	// MaxKeys caps the number of keys listed per page, 1000 if unset.
+	MaxKeys int
+	// Now is used as the current time, e.g. to let a test move the clock past RestoreDelay.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]map[string]*object
+	uploads map[string]*upload
+	faults  []*Fault
+	calls   map[string]int
+	seq     int
+}
+
+type object struct {
+	data         []byte
+	etag         string
+	storageClass string
+	metadata     http.Header
+	modified     time.Time
+	restoring    bool
+	restoredAt   time.Time
+	expiry       time.Time
+
+	// archiveStatus is the archive access tier of an INTELLIGENT_TIERING object, if it was moved to one.
+	archiveStatus string
+}
+
+type upload struct {
+	bucket       string
+	key          string
+	storageClass string
+	metadata     http.Header
+	parts        map[int][]byte
+}
+
+// NewServer will return a Server with the buckets given.
+func NewServer(buckets ...string) *Server {
+	s := &Server{
+		Now:     time.Now,
+		buckets: make(map[string]map[string]*object),
+		uploads: make(map[string]*upload),
+		calls:   make(map[string]int),
+	}
+	for _, bucket := range buckets {
+		s.buckets[bucket] = make(map[string]*object)
+	}
+	return s
+}
+
+// InjectFault will make requests matching the fault fail until it has been used up.
+func (s *Server) InjectFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f.Status == 0 {
+		f.Status = http.StatusInternalServerError
+	}
+	if f.Code == "" {
+		f.Code = "InternalError"
+	}
+	s.faults = append(s.faults, &f)
+}
+
+// Calls will return the number of requests made for the operation, including failed ones.
+func (s *Server) Calls(operation string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[operation]
+}
+
+// Put will store the object as if it were uploaded with the storage class, STANDARD if empty.
+func (s *Server) Put(bucket, key, storageClass string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]*object)
+	}
+	s.put(bucket, key, storageClass, data, etag(data), nil)
+}
+
+// Remove will delete the object, ignoring any Object Lock retention it has, as if it were lost.
+func (s *Server) Remove(bucket, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.buckets[bucket], key)
+}
+
+// Metadata will return the encryption, tagging and Object Lock headers the object was uploaded with.
+func (s *Server) Metadata(bucket, key string) http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if obj, ok := s.buckets[bucket][key]; ok {
+		return obj.metadata.Clone()
+	}
+	return nil
+}
+
+// Archive will move an INTELLIGENT_TIERING object to the archive access tier given, ARCHIVE_ACCESS or
+// DEEP_ARCHIVE_ACCESS, as if it had not been accessed for long enough.
+func (s *Server) Archive(bucket, key, archiveStatus string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if obj, ok := s.buckets[bucket][key]; ok && obj.storageClass == "INTELLIGENT_TIERING" {
+		obj.archiveStatus = archiveStatus
+		obj.restoring, obj.restoredAt, obj.expiry = false, time.Time{}, time.Time{}
+	}
+}
+
+// Get will return the object's data and storage class, ignoring whether it needs to be restored first.
+func (s *Server) Get(bucket, key string) (data []byte, storageClass string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, "", false
+	}
+	return obj.data, obj.storageClass, true
+}
+
+// Keys will return the keys of the objects in the bucket, sorted.
+func (s *Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Uploads will return the number of multipart uploads that have been neither completed nor aborted.
+func (s *Server) Uploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+// ServeHTTP will serve the S3 request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	operation := operationFor(r, bucket, key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[operation]++
+	if operation == "" {
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "the operation is not supported")
+		return
+	}
+	if f := s.fault(operation, key); f != nil {
+		writeError(w, r, f.Status, f.Code, "injected fault")
+		return
+	}
+	if operation != CreateBucket && s.buckets[bucket] == nil {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist")
+		return
+	}
+
+	switch operation {
+	case CreateBucket:
+		s.createBucket(w, r, bucket)
+	case DeleteBucket:
+		s.deleteBucket(w, r, bucket)
+	case HeadBucket:
+		w.WriteHeader(http.StatusOK)
+	case ListObjectsV2:
+		s.listObjects(w, r, bucket)
+	case PutObject:
+		s.putObject(w, r, bucket, key)
+	case GetObject, HeadObject:
+		s.getObject(w, r, bucket, key)
+	case DeleteObject:
+		s.deleteObject(w, r, bucket, key)
+	case RestoreObject:
+		s.restoreObject(w, r, bucket, key)
+	case CreateMultipartUpload:
+		s.createMultipartUpload(w, r, bucket, key)
+	case UploadPart:
+		s.uploadPart(w, r)
+	case CompleteMultipartUpload:
+		s.completeMultipartUpload(w, r, bucket, key)
+	case AbortMultipartUpload:
+		if _, ok := s.uploads[r.URL.Query().Get("uploadId")]; !ok {
+			writeError(w, r, http.StatusNotFound, "NoSuchUpload", "the specified upload does not exist")
+			return
+		}
+		delete(s.uploads, r.URL.Query().Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// nolint:gocyclo // A switch over the S3 REST API
+func operationFor(r *http.Request, bucket, key string) string {
+	query := r.URL.Query()
+	switch {
+	case bucket == "":
+		return ""
+	case key == "":
+		switch {
+		case r.Method == http.MethodPut:
+			return CreateBucket
+		case r.Method == http.MethodDelete:
+			return DeleteBucket
+		case r.Method == http.MethodHead:
+			return HeadBucket
+		case r.Method == http.MethodGet && query.Get("list-type") == "2":
+			return ListObjectsV2
+		}
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		return ""
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		return UploadPart
+	case r.Method == http.MethodPut:
+		return PutObject
+	case r.Method == http.MethodGet:
+		return GetObject
+	case r.Method == http.MethodHead:
+		return HeadObject
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		return AbortMultipartUpload
+	case r.Method == http.MethodDelete:
+		return DeleteObject
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		return CreateMultipartUpload
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		return CompleteMultipartUpload
+	case r.Method == http.MethodPost && query.Has("restore"):
+		return RestoreObject
+	}
+	return ""
+}
+
+func (s *Server) fault(operation, key string) *Fault {
+	for i, f := range s.faults {
+		if (f.Operation != "" && f.Operation != operation) || !strings.HasPrefix(key, f.Key) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+// deleteObject will delete the object unless it is under an Object Lock retention. A GOVERNANCE
+// retention can be bypassed with the x-amz-bypass-governance-retention header.
+func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if obj, ok := s.buckets[bucket][key]; ok {
+		mode := obj.metadata.Get("X-Amz-Object-Lock-Mode")
+		until, _ := time.Parse(time.RFC3339, obj.metadata.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+		bypass := mode == "GOVERNANCE" && strings.EqualFold(r.Header.Get("X-Amz-Bypass-Governance-Retention"), "true")
+		if mode != "" && s.Now().Before(until) && !bypass {
+			writeError(w, r, http.StatusForbidden, "AccessDenied", "the object is protected by Object Lock")
+			return
+		}
+	}
+	delete(s.buckets[bucket], key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) createBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if s.buckets[bucket] != nil {
+		writeError(w, r, http.StatusConflict, "BucketAlreadyOwnedByYou", "the bucket already exists")
+		return
+	}
+	s.buckets[bucket] = make(map[string]*object)
+	w.Header().Set("Location", "/"+bucket)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) deleteBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if len(s.buckets[bucket]) > 0 {
+		writeError(w, r, http.StatusConflict, "BucketNotEmpty", "the bucket you tried to delete is not empty")
+		return
+	}
+	delete(s.buckets, bucket)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type listedObject struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	KeyCount              int
+	MaxKeys               int
+	IsTruncated           bool
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	Contents              []listedObject
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	result := &listBucketResult{
+		Name:              bucket,
+		Prefix:            query.Get("prefix"),
+		MaxKeys:           defaultMaxKeys,
+		ContinuationToken: query.Get("continuation-token"),
+		StartAfter:        query.Get("start-after"),
+	}
+	if value := query.Get("max-keys"); value != "" {
+		maxKeys, err := strconv.Atoi(value)
+		if err != nil || maxKeys < 0 {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "max-keys must be a number that is not negative")
+			return
+		}
+		result.MaxKeys = maxKeys
+	}
+	if s.MaxKeys > 0 && result.MaxKeys > s.MaxKeys {
+		result.MaxKeys = s.MaxKeys
+	}
+
+	// The continuation token is the last key of the previous page, base64 encoded
+	after := result.StartAfter
+	if result.ContinuationToken != "" {
+		decoded, err := base64.StdEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "the continuation token provided is incorrect")
+			return
+		}
+		after = string(decoded)
+	}
+
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		if strings.HasPrefix(key, result.Prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if result.MaxKeys == 0 {
+		keys = nil
+	} else if len(keys) > result.MaxKeys {
+		keys = keys[:result.MaxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(keys[len(keys)-1]))
+	}
+	for _, key := range keys {
+		obj := s.buckets[bucket][key]
+		result.Contents = append(result.Contents, listedObject{
+			Key:          key,
+			LastModified: obj.modified.UTC().Format(time.RFC3339),
+			ETag:         obj.etag,
+			Size:         len(obj.data),
+			StorageClass: obj.storageClass,
+		})
+	}
+	result.KeyCount = len(result.Contents)
+	writeXML(w, http.StatusOK, result)
+}
+
+// checksumHashes are the flexible checksums uploads are checked against, by the header or trailer they are sent in.
+var checksumHashes = map[string]func() hash.Hash{
+	"X-Amz-Checksum-Crc32":  func() hash.Hash { return crc32.NewIEEE() },
+	"X-Amz-Checksum-Crc32c": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"X-Amz-Checksum-Sha1":   sha1.New,
+	"X-Amz-Checksum-Sha256": sha256.New,
+}
+
+// readBody will read the request's body, decoding the aws-chunked encoding checksums can be streamed with as
+// trailers, and check it against the Content-MD5 header and the checksums sent, which it returns. One of them
+// must be sent when the object has an Object Lock retention.
+func readBody(w http.ResponseWriter, r *http.Request, metadata http.Header) ([]byte, http.Header, bool) {
+	sums := make(http.Header)
+	for name := range r.Header {
+		if name == "Content-Md5" || checksumHashes[name] != nil {
+			sums.Set(name, r.Header.Get(name))
+		}
+	}
+
+	var data []byte
+	var err error
+	if strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") ||
+		strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		var trailers http.Header
+		data, trailers, err = decodeChunked(r.Body)
+		for name := range trailers {
+			sums.Set(name, trailers.Get(name))
+		}
+	} else {
+		data, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return nil, nil, false
+	}
+
+	if metadata.Get("X-Amz-Object-Lock-Mode") != "" && len(sums) == 0 {
+		writeError(w, r, http.StatusBadRequest, "InvalidRequest", "Content-MD5 or a checksum is required for objects with an Object Lock retention")
+		return nil, nil, false
+	}
+	if header := sums.Get("Content-MD5"); header != "" {
+		sum := md5.Sum(data) //nolint:gosec // MD5 is what S3 uses for Content-MD5
+		if header != base64.StdEncoding.EncodeToString(sum[:]) {
+			writeError(w, r, http.StatusBadRequest, "BadDigest", "the Content-MD5 you specified did not match what was received")
+			return nil, nil, false
+		}
+	}
+	for name, newHash := range checksumHashes {
+		if header := sums.Get(name); header != "" {
+			h := newHash()
+			_, _ = h.Write(data)
+			if header != base64.StdEncoding.EncodeToString(h.Sum(nil)) {
+				writeError(w, r, http.StatusBadRequest, "BadDigest", "the "+name+" you specified did not match what was received")
+				return nil, nil, false
+			}
+		}
+	}
+	return data, sums, true
+}
+
+// decodeChunked will decode a body sent with the aws-chunked content encoding, returning its data and trailers.
+func decodeChunked(body io.Reader) ([]byte, http.Header, error) {
+	br := bufio.NewReader(body)
+	var data []byte
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size < 0 {
+			return nil, nil, fmt.Errorf("invalid chunk size %q", sizeHex)
+		}
+		if size == 0 {
+			break
+		}
+		chunk := make([]byte, size+2)
+		if _, err = io.ReadFull(br, chunk); err != nil {
+			return nil, nil, err
+		}
+		if string(chunk[size:]) != "\r\n" {
+			return nil, nil, errors.New("chunk is not terminated by CRLF")
+		}
+		data = append(data, chunk[:size]...)
+	}
+
+	trailers := make(http.Header)
+	for {
+		line, err := br.ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			name, value, ok := strings.Cut(line, ":")
+			if !ok {
+				return nil, nil, fmt.Errorf("invalid trailer %q", line)
+			}
+			trailers.Set(name, strings.TrimSpace(value))
+		}
+		if line == "" || err != nil {
+			break
+		}
+	}
+	trailers.Del("X-Amz-Trailer-Signature")
+	return data, trailers, nil
+}
+
+func storageClassFor(w http.ResponseWriter, r *http.Request) (string, bool) {
+	storageClass := r.Header.Get("X-Amz-Storage-Class")
+	if storageClass == "" {
+		return "STANDARD", true
+	}
+	for _, class := range StorageClasses {
+		if class == storageClass {
+			return storageClass, true
+		}
+	}
+	writeError(w, r, http.StatusBadRequest, "InvalidStorageClass", "the storage class you specified is not valid")
+	return "", false
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	storageClass, ok := storageClassFor(w, r)
+	if !ok {
+		return
+	}
+	metadata, ok := metadataFor(w, r)
+	if !ok {
+		return
+	}
+	data, sums, ok := readBody(w, r, metadata)
+	if !ok {
+		return
+	}
+	// The checksums of objects uploaded in a single request are kept and returned with it like S3 does
+	for name := range checksumHashes {
+		if value := sums.Get(name); value != "" {
+			metadata.Set(name, value)
+		}
+	}
+	obj := s.put(bucket, key, storageClass, data, etag(data), metadata)
+	writeMetadata(w, obj.metadata)
+	w.Header().Set("ETag", obj.etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// metadataHeaders are the headers kept with an object when it is uploaded, and returned when it is read.
+var metadataHeaders = []string{
+	"X-Amz-Server-Side-Encryption",
+	"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id",
+	"X-Amz-Server-Side-Encryption-Customer-Algorithm",
+	"X-Amz-Server-Side-Encryption-Customer-Key-Md5",
+	"X-Amz-Tagging",
+	"X-Amz-Object-Lock-Mode",
+	"X-Amz-Object-Lock-Retain-Until-Date",
+	"X-Amz-Object-Lock-Legal-Hold",
+}
+
+// metadataFor will return the metadata headers of the upload request after checking the Object Lock ones.
+func metadataFor(w http.ResponseWriter, r *http.Request) (http.Header, bool) {
+	metadata := make(http.Header)
+	for _, name := range metadataHeaders {
+		if value := r.Header.Get(name); value != "" {
+			metadata.Set(name, value)
+		}
+	}
+
+	mode, until := metadata.Get("X-Amz-Object-Lock-Mode"), metadata.Get("X-Amz-Object-Lock-Retain-Until-Date")
+	if (mode == "") != (until == "") {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "the Object Lock mode and retain until date must be given together")
+		return nil, false
+	}
+	if mode != "" && mode != "GOVERNANCE" && mode != "COMPLIANCE" {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "the Object Lock mode is not valid")
+		return nil, false
+	}
+	if _, err := time.Parse(time.RFC3339, until); until != "" && err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "the Object Lock retain until date is not valid")
+		return nil, false
+	}
+	return metadata, true
+}
+
+func writeMetadata(w http.ResponseWriter, metadata http.Header) {
+	for name, values := range metadata {
+		if name != "X-Amz-Tagging" {
+			w.Header()[name] = values
+		}
+	}
+}
+
+// checkCustomerKey will check the request gives the key an object encrypted with SSE-C was uploaded with.
+func checkCustomerKey(w http.ResponseWriter, r *http.Request, metadata http.Header) bool {
+	want := metadata.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5")
+	if want == "" || r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5") == want {
+		return true
+	}
+	writeError(w, r, http.StatusBadRequest, "InvalidRequest", "the object was stored using a form of server side encryption that requires the same key")
+	return false
+}
+
+func (s *Server) put(bucket, key, storageClass string, data []byte, tag string, metadata http.Header) *object {
+	if storageClass == "" {
+		storageClass = "STANDARD"
+	}
+	if metadata == nil {
+		metadata = make(http.Header)
+	}
+	obj := &object{data: data, etag: tag, storageClass: storageClass, metadata: metadata, modified: s.Now()}
+	s.buckets[bucket][key] = obj
+	return obj
+}
+
+// archiveOf will return the storage class or archive access tier the object must be restored from, if any.
+func archiveOf(obj *object) string {
+	switch {
+	case obj.storageClass == "GLACIER" || obj.storageClass == "DEEP_ARCHIVE":
+		return obj.storageClass
+	default:
+		return obj.archiveStatus
+	}
+}
+
+// archived will return whether the object is archived and has not been restored.
+func (s *Server) archived(obj *object) bool {
+	if archiveOf(obj) == "" {
+		return false
+	}
+	s.refreshRestore(obj)
+	return obj.restoring || obj.restoredAt.IsZero()
+}
+
+// refreshRestore will complete the object's restore once RestoreDelay has passed, and expire it after its days are up.
+// Restoring an INTELLIGENT_TIERING object moves it back to the frequent access tier for good instead.
+func (s *Server) refreshRestore(obj *object) {
+	now := s.Now()
+	if obj.restoring && !now.Before(obj.restoredAt) {
+		obj.restoring = false
+		if obj.archiveStatus != "" {
+			obj.archiveStatus, obj.restoredAt = "", time.Time{}
+			return
+		}
+	}
+	if !obj.restoring && !obj.restoredAt.IsZero() && !now.Before(obj.expiry) {
+		obj.restoredAt, obj.expiry = time.Time{}, time.Time{}
+	}
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+		return
+	}
+	if !checkCustomerKey(w, r, obj.metadata) {
+		return
+	}
+	archived := s.archived(obj)
+	if r.Method == http.MethodGet && archived {
+		writeError(w, r, http.StatusForbidden, "InvalidObjectState", "the operation is not valid for the object's storage class")
+		return
+	}
+
+	writeMetadata(w, obj.metadata)
+	w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+	w.Header().Set("ETag", obj.etag)
+	w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+	if obj.storageClass != "STANDARD" {
+		w.Header().Set("X-Amz-Storage-Class", obj.storageClass)
+	}
+	if obj.archiveStatus != "" {
+		w.Header().Set("X-Amz-Archive-Status", obj.archiveStatus)
+	}
+	switch {
+	case obj.restoring:
+		w.Header().Set("X-Amz-Restore", `ongoing-request="true"`)
+	case !obj.restoredAt.IsZero():
+		w.Header().Set("X-Amz-Restore", fmt.Sprintf(`ongoing-request="false", expiry-date="%s"`, obj.expiry.UTC().Format(http.TimeFormat)))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(obj.data)
+	}
+}
+
+type restoreRequest struct {
+	Days                 int
+	GlacierJobParameters struct {
+		Tier string
+	}
+}
+
+func (s *Server) restoreObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+		return
+	}
+	req := new(restoreRequest)
+	if err := xml.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", "the restore request is not valid")
+		return
+	}
+	archive := archiveOf(obj)
+	if archive == "" {
+		writeError(w, r, http.StatusForbidden, "InvalidObjectState", "the object is not in an archive storage class")
+		return
+	}
+	// Restored INTELLIGENT_TIERING objects stay restored, so only the other archives take a number of days
+	if (obj.archiveStatus == "") != (req.Days > 0) {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "the restore days are not valid for the object")
+		return
+	}
+	switch req.GlacierJobParameters.Tier {
+	case "", "Standard", "Bulk":
+	case "Expedited":
+		if archive == "GLACIER" {
+			break
+		}
+		fallthrough
+	default:
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "the restore tier is not valid for the object")
+		return
+	}
+	if s.archived(obj) && obj.restoring {
+		writeError(w, r, http.StatusConflict, "RestoreAlreadyInProgress", "the object restore is already in progress")
+		return
+	}
+
+	status := http.StatusOK
+	if obj.restoredAt.IsZero() {
+		// A new restore, rather than extending the days of a restored copy
+		obj.restoring = true
+		obj.restoredAt = s.Now().Add(s.RestoreDelay)
+		s.refreshRestore(obj)
+		status = http.StatusAccepted
+	}
+	if req.Days > 0 {
+		obj.expiry = obj.restoredAt.Add(time.Duration(req.Days) * 24 * time.Hour)
+	}
+	w.WriteHeader(status)
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string
+	Key      string
+	UploadID string `xml:"UploadId"`
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	storageClass, ok := storageClassFor(w, r)
+	if !ok {
+		return
+	}
+	metadata, ok := metadataFor(w, r)
+	if !ok {
+		return
+	}
+	s.seq++
+	id := fmt.Sprintf("upload-%d", s.seq)
+	s.uploads[id] = &upload{bucket: bucket, key: key, storageClass: storageClass, metadata: metadata, parts: make(map[int][]byte)}
+	writeXML(w, http.StatusOK, &initiateMultipartUploadResult{Bucket: bucket, Key: key, UploadID: id})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request) {
+	u, ok := s.uploads[r.URL.Query().Get("uploadId")]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "the specified upload does not exist")
+		return
+	}
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "part number must be an integer between 1 and 10000")
+		return
+	}
+	if !checkCustomerKey(w, r, u.metadata) {
+		return
+	}
+	data, _, ok := readBody(w, r, u.metadata)
+	if !ok {
+		return
+	}
+	u.parts[partNumber] = data
+	w.Header().Set("ETag", etag(data))
+	w.WriteHeader(http.StatusOK)
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+// minPartSize is the smallest size allowed for every part of a multipart upload but the last.
+const minPartSize = 5 * 1024 * 1024
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	id := r.URL.Query().Get("uploadId")
+	u, ok := s.uploads[id]
+	if !ok || u.bucket != bucket || u.key != key {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "the specified upload does not exist")
+		return
+	}
+	req := new(completeMultipartUpload)
+	if err := xml.NewDecoder(r.Body).Decode(req); err != nil || len(req.Parts) == 0 {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", "the list of parts is not valid")
+		return
+	}
+
+	var data []byte
+	sums := md5.New() //nolint:gosec // MD5 is what S3 uses for ETags
+	for i, part := range req.Parts {
+		content, ok := u.parts[part.PartNumber]
+		if !ok || etag(content) != part.ETag {
+			writeError(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d could not be found", part.PartNumber))
+			return
+		}
+		if i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber {
+			writeError(w, r, http.StatusBadRequest, "InvalidPartOrder", "the parts must be listed in ascending order")
+			return
+		}
+		if i < len(req.Parts)-1 && len(content) < minPartSize {
+			writeError(w, r, http.StatusBadRequest, "EntityTooSmall", fmt.Sprintf("part %d is smaller than the minimum allowed", part.PartNumber))
+			return
+		}
+		data = append(data, content...)
+		sum, _ := hex.DecodeString(strings.Trim(part.ETag, `"`))
+		sums.Write(sum)
+	}
+
+	delete(s.uploads, id)
+	obj := s.put(bucket, key, u.storageClass, data, fmt.Sprintf(`"%x-%d"`, sums.Sum(nil), len(req.Parts)), u.metadata)
+	writeXML(w, http.StatusOK, &completeMultipartUploadResult{
+		Location: fmt.Sprintf("http://%s/%s/%s", r.Host, bucket, key),
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     obj.etag,
+	})
+}
+
+func etag(data []byte) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum(data)) //nolint:gosec // MD5 is what S3 uses for ETags
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string
+	Message   string
+	Resource  string
+	RequestID string `xml:"RequestId"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, &errorResponse{Code: code, Message: message, Resource: r.URL.Path, RequestID: "s3fake"})
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, xml.Header)
+	_, _ = w.Write(body)
+}
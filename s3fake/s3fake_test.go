@@ -0,0 +1,129 @@
+package s3fake
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func do(t *testing.T, method, url, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequestWithContext(t.Context(), method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return resp
+}
+
+func TestRestore(t *testing.T) {
+	server := NewServer("bucket")
+	now := time.Now()
+	server.Now = func() time.Time { return now }
+	server.RestoreDelay = time.Hour
+	server.Put("bucket", "archived", "DEEP_ARCHIVE", []byte("data"))
+	server.Put("bucket", "standard", "", []byte("data"))
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	restore := `<RestoreRequest><Days>1</Days><GlacierJobParameters><Tier>Bulk</Tier></GlacierJobParameters></RestoreRequest>`
+	assert.Equal(t, http.StatusForbidden, do(t, http.MethodGet, ts.URL+"/bucket/archived", "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do(t, http.MethodPost, ts.URL+"/bucket/standard?restore", restore).StatusCode)
+
+	assert.Equal(t, http.StatusAccepted, do(t, http.MethodPost, ts.URL+"/bucket/archived?restore", restore).StatusCode)
+	assert.Equal(t, http.StatusConflict, do(t, http.MethodPost, ts.URL+"/bucket/archived?restore", restore).StatusCode)
+	resp := do(t, http.MethodHead, ts.URL+"/bucket/archived", "")
+	assert.Equal(t, `ongoing-request="true"`, resp.Header.Get("X-Amz-Restore"))
+	assert.Equal(t, "DEEP_ARCHIVE", resp.Header.Get("X-Amz-Storage-Class"))
+
+	now = now.Add(time.Hour)
+	resp = do(t, http.MethodHead, ts.URL+"/bucket/archived", "")
+	assert.True(t, strings.HasPrefix(resp.Header.Get("X-Amz-Restore"), `ongoing-request="false", expiry-date=`))
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/bucket/archived", "").StatusCode)
+	assert.Equal(t, http.StatusOK, do(t, http.MethodPost, ts.URL+"/bucket/archived?restore", restore).StatusCode)
+
+	// The restored copy expires after the days requested
+	now = now.Add(25 * time.Hour)
+	assert.Equal(t, http.StatusForbidden, do(t, http.MethodGet, ts.URL+"/bucket/archived", "").StatusCode)
+	assert.Empty(t, do(t, http.MethodHead, ts.URL+"/bucket/archived", "").Header.Get("X-Amz-Restore"))
+}
+
+func TestInjectFault(t *testing.T) {
+	server := NewServer("bucket")
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	server.InjectFault(Fault{Operation: PutObject, Key: "bad", Status: http.StatusServiceUnavailable, Code: "SlowDown", Times: 2})
+	assert.Equal(t, http.StatusOK, do(t, http.MethodPut, ts.URL+"/bucket/good", "data").StatusCode)
+	assert.Equal(t, http.StatusServiceUnavailable, do(t, http.MethodPut, ts.URL+"/bucket/bad", "data").StatusCode)
+	assert.Equal(t, http.StatusServiceUnavailable, do(t, http.MethodPut, ts.URL+"/bucket/bad", "data").StatusCode)
+	assert.Equal(t, http.StatusOK, do(t, http.MethodPut, ts.URL+"/bucket/bad", "data").StatusCode)
+	assert.Equal(t, 4, server.Calls(PutObject))
+	assert.Equal(t, []string{"bad", "good"}, server.Keys("bucket"))
+}
+
+func TestRestoreIntelligentTiering(t *testing.T) {
+	server := NewServer("bucket")
+	now := time.Now()
+	server.Now = func() time.Time { return now }
+	server.RestoreDelay = time.Hour
+	server.Put("bucket", "tiered", "INTELLIGENT_TIERING", []byte("data"))
+	server.Archive("bucket", "tiered", "DEEP_ARCHIVE_ACCESS")
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	assert.Equal(t, http.StatusForbidden, do(t, http.MethodGet, ts.URL+"/bucket/tiered", "").StatusCode)
+	assert.Equal(t, "DEEP_ARCHIVE_ACCESS", do(t, http.MethodHead, ts.URL+"/bucket/tiered", "").Header.Get("X-Amz-Archive-Status"))
+
+	// Restores from the archive access tiers take no days, and cannot be expedited
+	withDays := `<RestoreRequest><Days>1</Days><GlacierJobParameters><Tier>Bulk</Tier></GlacierJobParameters></RestoreRequest>`
+	assert.Equal(t, http.StatusBadRequest, do(t, http.MethodPost, ts.URL+"/bucket/tiered?restore", withDays).StatusCode)
+	expedited := `<RestoreRequest><GlacierJobParameters><Tier>Expedited</Tier></GlacierJobParameters></RestoreRequest>`
+	assert.Equal(t, http.StatusBadRequest, do(t, http.MethodPost, ts.URL+"/bucket/tiered?restore", expedited).StatusCode)
+	restore := `<RestoreRequest><GlacierJobParameters><Tier>Standard</Tier></GlacierJobParameters></RestoreRequest>`
+	assert.Equal(t, http.StatusAccepted, do(t, http.MethodPost, ts.URL+"/bucket/tiered?restore", restore).StatusCode)
+
+	// Once restored the object is back in the frequent access tier for good
+	now = now.Add(time.Hour)
+	resp := do(t, http.MethodHead, ts.URL+"/bucket/tiered", "")
+	assert.Empty(t, resp.Header.Get("X-Amz-Archive-Status"))
+	now = now.Add(30 * 24 * time.Hour)
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/bucket/tiered", "").StatusCode)
+}
+
+func TestChecksums(t *testing.T) {
+	server := NewServer("bucket")
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	sum := sha256.Sum256([]byte("data"))
+	checksum := base64.StdEncoding.EncodeToString(sum[:])
+	put := func(body string, header http.Header) *http.Response {
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, ts.URL+"/bucket/key", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+
+	assert.Equal(t, http.StatusBadRequest, put("tada", http.Header{"X-Amz-Checksum-Sha256": {checksum}}).StatusCode)
+	assert.Equal(t, http.StatusOK, put("data", http.Header{"X-Amz-Checksum-Sha256": {checksum}}).StatusCode)
+	assert.Equal(t, checksum, do(t, http.MethodHead, ts.URL+"/bucket/key", "").Header.Get("X-Amz-Checksum-Sha256"))
+
+	// Checksums can be streamed as a trailer of an aws-chunked body
+	chunked := http.Header{"Content-Encoding": {"aws-chunked"}, "X-Amz-Trailer": {"x-amz-checksum-sha256"}}
+	assert.Equal(t, http.StatusBadRequest, put("4\r\ntada\r\n0\r\nx-amz-checksum-sha256:"+checksum+"\r\n\r\n", chunked).StatusCode)
+	assert.Equal(t, http.StatusOK, put("4\r\ndata\r\n0\r\nx-amz-checksum-sha256:"+checksum+"\r\n\r\n", chunked).StatusCode)
+	data, _, ok := server.Get("bucket", "key")
+	require.True(t, ok)
+	assert.Equal(t, []byte("data"), data)
+}
@@ -0,0 +1,206 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package throttle provides bandwidth limits that can change with the time of day.
+package throttle
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/juju/ratelimit"
+)
+
+// Rule limits the rate to Rate bytes per second between the Start and End times of the day.
+// A Rate of 0 means unlimited.
+type Rule struct {
+	Start time.Duration
+	End   time.Duration
+	Rate  uint64
+}
+
+// Contains will return true if the time of day falls within this rule, rules may wrap around midnight.
+func (r Rule) Contains(timeOfDay time.Duration) bool {
+	if r.Start <= r.End {
+		return timeOfDay >= r.Start && timeOfDay < r.End
+	}
+	return timeOfDay >= r.Start || timeOfDay < r.End
+}
+
+// Schedule is a list of rules where the first one matching the time of day wins, falling back to
+// the Default rate when none match.
+type Schedule struct {
+	Rules   []Rule
+	Default uint64
+}
+
+// ParseSchedule will parse a comma separated list of time-of-day rules in the form
+// "HH:MM-HH:MM=RATE" with an optional "*=RATE" fallback, e.g. "08:00-18:00=5MiB,*=0".
+// A single rate without a time range (e.g. "5MiB") applies all day. Rates are in bytes per
+// second and accept units such as KB, MiB, etc. A rate of 0 is unlimited.
+func ParseSchedule(value string) (*Schedule, error) {
+	schedule := new(Schedule)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		window, rawRate, found := strings.Cut(part, "=")
+		if !found {
+			window, rawRate = "*", part
+		}
+		rate, err := humanize.ParseBytes(strings.TrimSpace(rawRate))
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate %q in schedule: %w", rawRate, err)
+		}
+
+		window = strings.TrimSpace(window)
+		if window == "*" {
+			schedule.Default = rate
+			continue
+		}
+
+		rawStart, rawEnd, found := strings.Cut(window, "-")
+		if !found {
+			return nil, fmt.Errorf("invalid time range %q in schedule, expected HH:MM-HH:MM", window)
+		}
+		start, err := parseTimeOfDay(rawStart)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(rawEnd)
+		if err != nil {
+			return nil, err
+		}
+		schedule.Rules = append(schedule.Rules, Rule{Start: start, End: end, Rate: rate})
+	}
+
+	return schedule, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q in schedule, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// RateAt will return the rate, in bytes per second, that applies at the given time. 0 is unlimited.
+func (s *Schedule) RateAt(t time.Time) uint64 {
+	timeOfDay := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	for _, rule := range s.Rules {
+		if rule.Contains(timeOfDay) {
+			return rule.Rate
+		}
+	}
+	return s.Default
+}
+
+// String will return a human readable description of the schedule.
+func (s *Schedule) String() string {
+	parts := make([]string, 0, len(s.Rules)+1)
+	for _, rule := range s.Rules {
+		parts = append(parts, fmt.Sprintf("%s-%s=%s", formatTimeOfDay(rule.Start), formatTimeOfDay(rule.End), formatRate(rule.Rate)))
+	}
+	parts = append(parts, fmt.Sprintf("*=%s", formatRate(s.Default)))
+	return strings.Join(parts, ",")
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+func formatRate(rate uint64) string {
+	if rate == 0 {
+		return "unlimited"
+	}
+	return humanize.IBytes(rate) + "/s"
+}
+
+// Limiter limits the rate of the readers it wraps according to its Schedule. All readers wrapped
+// by the same Limiter share its bandwidth, and the schedule is re-evaluated on every read so a
+// change in rate takes effect during long transfers.
+type Limiter struct {
+	schedule *Schedule
+	now      func() time.Time
+
+	mu     sync.Mutex
+	rate   uint64
+	bucket *ratelimit.Bucket
+}
+
+// NewLimiter will return a Limiter for the given schedule.
+func NewLimiter(schedule *Schedule) *Limiter {
+	return &Limiter{schedule: schedule, now: time.Now}
+}
+
+// Schedule will return the schedule this Limiter follows.
+func (l *Limiter) Schedule() *Schedule {
+	return l.schedule
+}
+
+// currentBucket will return the bucket for the rate that currently applies, nil when unlimited.
+func (l *Limiter) currentBucket() *ratelimit.Bucket {
+	rate := l.schedule.RateAt(l.now())
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if rate != l.rate || (rate != 0 && l.bucket == nil) {
+		l.rate = rate
+		l.bucket = nil
+		if rate != 0 {
+			l.bucket = ratelimit.NewBucketWithRate(float64(rate), int64(rate))
+		}
+	}
+	return l.bucket
+}
+
+// Reader will return an io.Reader that reads from r at the rate allowed by this Limiter.
+func (l *Limiter) Reader(r io.Reader) io.Reader {
+	return &reader{r: r, l: l}
+}
+
+type reader struct {
+	r io.Reader
+	l *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	bucket := r.l.currentBucket()
+	if bucket == nil {
+		return r.r.Read(p)
+	}
+
+	// Never read more than a second's worth at a time so rate changes apply quickly
+	if capacity := bucket.Capacity(); int64(len(p)) > capacity {
+		p = p[:capacity]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		bucket.Wait(int64(n))
+	}
+	return n, err
+}
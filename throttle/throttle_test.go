@@ -0,0 +1,69 @@
+package throttle
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+}
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := ParseSchedule("08:00-18:00=5MiB, 22:00-06:00=1MB, *=100KiB")
+	require.NoError(t, err)
+	require.Len(t, schedule.Rules, 2)
+
+	assert.EqualValues(t, 5*1024*1024, schedule.RateAt(at(8, 0)))
+	assert.EqualValues(t, 5*1024*1024, schedule.RateAt(at(17, 59)))
+	assert.EqualValues(t, 100*1024, schedule.RateAt(at(18, 0)))
+	assert.EqualValues(t, 1000*1000, schedule.RateAt(at(23, 30)))
+	assert.EqualValues(t, 1000*1000, schedule.RateAt(at(2, 0)))
+	assert.EqualValues(t, 100*1024, schedule.RateAt(at(7, 0)))
+	assert.Equal(t, "08:00-18:00=5.0 MiB/s,22:00-06:00=977 KiB/s,*=100 KiB/s", schedule.String())
+
+	schedule, err = ParseSchedule("2MiB")
+	require.NoError(t, err)
+	assert.Empty(t, schedule.Rules)
+	assert.EqualValues(t, 2*1024*1024, schedule.RateAt(at(12, 0)))
+
+	schedule, err = ParseSchedule("08:00-18:00=5MiB")
+	require.NoError(t, err)
+	assert.Zero(t, schedule.RateAt(at(20, 0)))
+
+	for _, invalid := range []string{"08:00=5MiB", "8-18=5MiB", "08:00-18:00=fast", "25:00-26:00=1MiB"} {
+		_, err = ParseSchedule(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	schedule, err := ParseSchedule("08:00-18:00=1KiB,*=0")
+	require.NoError(t, err)
+
+	now := at(20, 0)
+	limiter := NewLimiter(schedule)
+	limiter.now = func() time.Time { return now }
+
+	data := bytes.Repeat([]byte("a"), 64*1024)
+
+	// Unlimited outside of business hours
+	start := time.Now()
+	read, err := io.ReadAll(limiter.Reader(bytes.NewReader(data)))
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// The rate applies to reads in progress as soon as the schedule changes
+	now = at(9, 0)
+	r := limiter.Reader(bytes.NewReader(data))
+	buf := make([]byte, len(data))
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 1024, n)
+}
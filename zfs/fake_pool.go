@@ -0,0 +1,603 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/files"
+)
+
+// DefaultFakeStreamSize is the number of bytes of data in the streams a FakePool sends by default.
+const DefaultFakeStreamSize = 64 * 1024
+
+const fakeStreamMagic = "zfsbackup-fake-stream"
+
+// FakePool is an in-memory Runner for tests that need neither root nor a real pool. It tracks datasets,
+// their user properties, snapshots, bookmarks, and GUIDs. The data of the stream sent for a snapshot is
+// derived from the snapshot's GUID, so receiving a stream verifies it arrived intact, and the received
+// snapshots keep the GUIDs and creation times they were sent with. The replication, skip missing,
+// deduplication, properties, and raw send options are accepted but have no effect on the stream.
+type FakePool struct {
+	// StreamSize is the number of bytes of data in each stream sent, DefaultFakeStreamSize if 0.
+	StreamSize int64
+	// Now returns the creation time of new datasets and snapshots, time.Now if nil.
+	Now func() time.Time
+
+	mu       sync.Mutex
+	datasets map[string]*fakeDataset
+	seq      uint64
+	sends    int
+}
+
+var _ Runner = (*FakePool)(nil)
+
+type fakeDataset struct {
+	creation   time.Time
+	properties map[string]string
+	snapshots  []*fakeSnapshot // oldest first
+	bookmarks  []*fakeSnapshot
+}
+
+type fakeSnapshot struct {
+	Name     string
+	GUID     uint64
+	Creation time.Time
+
+	// seq orders snapshots and bookmarks created within the same second
+	seq uint64
+}
+
+// fakeStreamHeader starts every stream, it is followed by the stream's data.
+type fakeStreamHeader struct {
+	Magic     string
+	Dataset   string
+	FromGUID  uint64 `json:",omitempty"`
+	Snapshots []*fakeSnapshot
+	Size      int64
+}
+
+// NewFakePool will return a FakePool with the given datasets, and their parents, created.
+func NewFakePool(datasets ...string) *FakePool {
+	p := &FakePool{datasets: make(map[string]*fakeDataset)}
+	for _, name := range datasets {
+		p.CreateDataset(name, nil)
+	}
+	return p
+}
+
+// CreateDataset will create the dataset and any missing parents, like "zfs create -p", setting the
+// given user properties on it. The properties of an existing dataset are updated.
+func (p *FakePool) CreateDataset(name string, properties map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		p.dataset(strings.Join(parts[:i+1], "/"), true)
+	}
+	for prop, value := range properties {
+		p.datasets[name].properties[prop] = value
+	}
+}
+
+// Sends will return the number of streams the pool has been asked to send.
+func (p *FakePool) Sends() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sends
+}
+
+// Datasets will return the names of every dataset in the pool, sorted.
+func (p *FakePool) Datasets() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make([]string, 0, len(p.datasets))
+	for name := range p.datasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetSnapshotsAndBookmarks will return the snapshots and bookmarks of the dataset, newest first, or
+// just the snapshot if the target is one.
+func (p *FakePool) GetSnapshotsAndBookmarks(_ context.Context, target string) ([]files.SnapshotInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if strings.Contains(target, "@") {
+		_, snap, err := p.snapshot(target)
+		if err != nil {
+			return nil, err
+		}
+		return []files.SnapshotInfo{{Name: snap.Name, CreationTime: snap.Creation}}, nil
+	}
+
+	ds, err := p.existing(target)
+	if err != nil {
+		return nil, err
+	}
+	all := make([]*fakeSnapshot, 0, len(ds.snapshots)+len(ds.bookmarks))
+	all = append(append(all, ds.snapshots...), ds.bookmarks...)
+	sort.SliceStable(all, func(i, j int) bool { return all[i].seq > all[j].seq })
+
+	snapshots := make([]files.SnapshotInfo, 0, len(all))
+	for _, snap := range all {
+		snapshots = append(snapshots, files.SnapshotInfo{
+			Name:         snap.Name,
+			CreationTime: snap.Creation,
+			Bookmark:     containsSnapshot(ds.bookmarks, snap),
+		})
+	}
+	return snapshots, nil
+}
+
+// GetZFSProperty will return the creation, guid, or name of the target, or the value of a user property.
+func (p *FakePool) GetZFSProperty(_ context.Context, prop, target string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var creation time.Time
+	var guid uint64
+	switch {
+	case strings.Contains(target, "@"):
+		_, snap, err := p.snapshot(target)
+		if err != nil {
+			return "", err
+		}
+		creation, guid = snap.Creation, snap.GUID
+	case strings.Contains(target, "#"):
+		_, mark, err := p.bookmark(target)
+		if err != nil {
+			return "", err
+		}
+		creation, guid = mark.Creation, mark.GUID
+	default:
+		ds, err := p.existing(target)
+		if err != nil {
+			return "", err
+		}
+		if strings.Contains(prop, ":") {
+			return p.property(target, prop).Value, nil
+		}
+		creation = ds.creation
+	}
+
+	switch prop {
+	case "creation":
+		return strconv.FormatInt(creation.Unix(), 10), nil
+	case "guid":
+		if guid == 0 {
+			return "", fmt.Errorf("cannot get the guid of '%s'", target)
+		}
+		return strconv.FormatUint(guid, 10), nil
+	case "name":
+		return target, nil
+	default:
+		return "", fmt.Errorf("bad property list: invalid property '%s'", prop)
+	}
+}
+
+// GetDatasetProperties will return the user properties requested for the target and its descendants,
+// with the source of each value as reported by "zfs get".
+func (p *FakePool) GetDatasetProperties(_ context.Context, target string, props ...string) (map[string]map[string]DatasetProperty, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.existing(target); err != nil {
+		return nil, err
+	}
+
+	datasets := make(map[string]map[string]DatasetProperty)
+	for name := range p.datasets {
+		if name != target && !strings.HasPrefix(name, target+"/") {
+			continue
+		}
+		datasets[name] = make(map[string]DatasetProperty)
+		for _, prop := range props {
+			datasets[name][prop] = p.property(name, prop)
+		}
+	}
+	return datasets, nil
+}
+
+// Send will write the stream for the snapshot, or the snapshots since the incremental source, to w.
+func (p *FakePool) Send(ctx context.Context, j *files.JobInfo, w io.Writer) error {
+	p.mu.Lock()
+	p.sends++
+	p.mu.Unlock()
+
+	header, err := p.sendHeader(j)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(append(encoded, '\n')); err != nil {
+		return err
+	}
+
+	data := io.LimitReader(fakeStreamData(header.Snapshots[len(header.Snapshots)-1].GUID), header.Size)
+	buf := make([]byte, 32*1024)
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		n, rerr := data.Read(buf)
+		if _, err = w.Write(buf[:n]); err != nil {
+			return err
+		}
+		if errors.Is(rerr, io.EOF) {
+			return nil
+		}
+	}
+}
+
+func (p *FakePool) sendHeader(j *files.JobInfo) (*fakeStreamHeader, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ds, to, err := p.snapshot(fmt.Sprintf("%s@%s", j.VolumeName, j.BaseSnapshot.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	header := &fakeStreamHeader{Magic: fakeStreamMagic, Dataset: j.VolumeName, Snapshots: []*fakeSnapshot{to}, Size: p.StreamSize}
+	if header.Size == 0 {
+		header.Size = DefaultFakeStreamSize
+	}
+	if j.IncrementalSnapshot.Name == "" {
+		return header, nil
+	}
+
+	var from *fakeSnapshot
+	if j.IncrementalSnapshot.Bookmark {
+		_, from, err = p.bookmark(fmt.Sprintf("%s#%s", j.VolumeName, j.IncrementalSnapshot.Name))
+	} else {
+		_, from, err = p.snapshot(fmt.Sprintf("%s@%s", j.VolumeName, j.IncrementalSnapshot.Name))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if from.seq >= to.seq {
+		return nil, fmt.Errorf("incremental source (%s) must be earlier than the snapshot sent (%s)", from.Name, to.Name)
+	}
+
+	header.FromGUID = from.GUID
+	if j.IntermediaryIncremental {
+		header.Snapshots = nil
+		for _, snap := range ds.snapshots {
+			if snap.seq > from.seq && snap.seq <= to.seq {
+				header.Snapshots = append(header.Snapshots, snap)
+			}
+		}
+	}
+	return header, nil
+}
+
+// Receive will read a stream sent by a FakePool from r and add its snapshots to the dataset the
+// JobInfo's receive options resolve to, after verifying the stream's data.
+func (p *FakePool) Receive(ctx context.Context, j *files.JobInfo, r io.Reader) error {
+	br := bufio.NewReader(r)
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("invalid stream header - %v", err)
+	}
+	header := new(fakeStreamHeader)
+	if err = json.Unmarshal(line, header); err != nil || header.Magic != fakeStreamMagic || len(header.Snapshots) == 0 {
+		return errors.New("invalid stream header")
+	}
+
+	expected := io.LimitReader(fakeStreamData(header.Snapshots[len(header.Snapshots)-1].GUID), header.Size)
+	want, got := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		n, rerr := io.ReadFull(expected, want)
+		if n == 0 {
+			break
+		}
+		if _, err = io.ReadFull(br, got[:n]); err != nil {
+			return fmt.Errorf("stream is truncated - %v", err)
+		}
+		if !bytes.Equal(want[:n], got[:n]) {
+			return errors.New("stream is corrupt, its data does not match what was sent")
+		}
+		if rerr != nil {
+			break
+		}
+	}
+	if extra, _ := io.Copy(io.Discard, br); extra != 0 {
+		return fmt.Errorf("stream has %d unexpected trailing bytes", extra)
+	}
+
+	return p.receive(j, header)
+}
+
+// nolint:gocyclo // Mirrors the checks zfs receive makes
+func (p *FakePool) receive(j *files.JobInfo, header *fakeStreamHeader) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	target := j.LocalVolume
+	parts := strings.Split(header.Dataset, "/")
+	if j.FullPath {
+		target = strings.Join(append([]string{target}, parts[1:]...), "/")
+	}
+	if j.LastPath {
+		target = fmt.Sprintf("%s/%s", target, parts[len(parts)-1])
+	}
+
+	ds, exists := p.datasets[target]
+	if header.FromGUID == 0 {
+		if exists && len(ds.snapshots) > 0 && !j.Force {
+			return fmt.Errorf("destination '%s' exists, must specify -F to overwrite it", target)
+		}
+		if idx := strings.LastIndex(target, "/"); idx != -1 {
+			if _, ok := p.datasets[target[:idx]]; !ok {
+				return fmt.Errorf("destination parent '%s' does not exist", target[:idx])
+			}
+		}
+		if j.Origin != "" {
+			if _, _, err := p.snapshot(j.Origin); err != nil {
+				return err
+			}
+		}
+		ds = p.dataset(target, true)
+		ds.snapshots, ds.bookmarks = nil, nil
+	} else {
+		if !exists {
+			return fmt.Errorf("destination '%s' does not exist", target)
+		}
+		idx := -1
+		for i, snap := range ds.snapshots {
+			if snap.GUID == header.FromGUID {
+				idx = i
+			}
+		}
+		switch {
+		case idx == -1:
+			return fmt.Errorf("destination '%s' does not have the incremental source snapshot", target)
+		case idx != len(ds.snapshots)-1 && !j.Force:
+			return fmt.Errorf("destination '%s' has been modified since most recent snapshot", target)
+		}
+		ds.snapshots = ds.snapshots[:idx+1]
+	}
+
+	for _, snap := range header.Snapshots {
+		for _, existing := range ds.snapshots {
+			if existing.Name == snap.Name {
+				return fmt.Errorf("destination snapshot '%s@%s' exists", target, snap.Name)
+			}
+		}
+		p.seq++
+		ds.snapshots = append(ds.snapshots, &fakeSnapshot{Name: snap.Name, GUID: snap.GUID, Creation: snap.Creation, seq: p.seq})
+	}
+	return nil
+}
+
+// Snapshot will create the snapshot of an existing dataset with a new GUID.
+func (p *FakePool) Snapshot(_ context.Context, snapshot string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	name, snapName, ok := strings.Cut(snapshot, "@")
+	if !ok || snapName == "" {
+		return fmt.Errorf("invalid snapshot name '%s'", snapshot)
+	}
+	ds, err := p.existing(name)
+	if err != nil {
+		return err
+	}
+	if _, _, err = p.snapshot(snapshot); err == nil {
+		return fmt.Errorf("snapshot '%s' already exists", snapshot)
+	}
+
+	p.seq++
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%d", snapshot, p.seq)
+	ds.snapshots = append(ds.snapshots, &fakeSnapshot{Name: snapName, GUID: h.Sum64(), Creation: p.now(), seq: p.seq})
+	return nil
+}
+
+// Bookmark will create the bookmark from the snapshot, keeping the snapshot's GUID and creation time.
+func (p *FakePool) Bookmark(_ context.Context, snapshot, bookmark string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ds, snap, err := p.snapshot(snapshot)
+	if err != nil {
+		return err
+	}
+	name, markName, ok := strings.Cut(bookmark, "#")
+	if !ok || markName == "" || name != strings.SplitN(snapshot, "@", 2)[0] {
+		return fmt.Errorf("invalid bookmark name '%s'", bookmark)
+	}
+	if _, _, err = p.bookmark(bookmark); err == nil {
+		return fmt.Errorf("bookmark '%s' already exists", bookmark)
+	}
+
+	ds.bookmarks = append(ds.bookmarks, &fakeSnapshot{Name: markName, GUID: snap.GUID, Creation: snap.Creation, seq: snap.seq})
+	return nil
+}
+
+// Destroy will destroy the snapshot, bookmark, or dataset. Datasets with snapshots or children cannot be destroyed.
+func (p *FakePool) Destroy(_ context.Context, target string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case strings.Contains(target, "@"):
+		ds, snap, err := p.snapshot(target)
+		if err != nil {
+			return err
+		}
+		ds.snapshots = removeSnapshot(ds.snapshots, snap)
+	case strings.Contains(target, "#"):
+		ds, mark, err := p.bookmark(target)
+		if err != nil {
+			return err
+		}
+		ds.bookmarks = removeSnapshot(ds.bookmarks, mark)
+	default:
+		ds, err := p.existing(target)
+		if err != nil {
+			return err
+		}
+		if len(ds.snapshots) > 0 {
+			return fmt.Errorf("cannot destroy '%s': filesystem has children (snapshots)", target)
+		}
+		for name := range p.datasets {
+			if strings.HasPrefix(name, target+"/") {
+				return fmt.Errorf("cannot destroy '%s': filesystem has children", target)
+			}
+		}
+		delete(p.datasets, target)
+	}
+	return nil
+}
+
+func (p *FakePool) now() time.Time {
+	if p.Now != nil {
+		return p.Now().Truncate(time.Second)
+	}
+	return time.Now().Truncate(time.Second)
+}
+
+// dataset will return the dataset, creating it if asked to. The caller must hold the lock.
+func (p *FakePool) dataset(name string, create bool) *fakeDataset {
+	if p.datasets == nil {
+		p.datasets = make(map[string]*fakeDataset)
+	}
+	ds, ok := p.datasets[name]
+	if !ok && create {
+		ds = &fakeDataset{creation: p.now(), properties: make(map[string]string)}
+		p.datasets[name] = ds
+	}
+	return ds
+}
+
+func (p *FakePool) existing(name string) (*fakeDataset, error) {
+	if ds := p.dataset(name, false); ds != nil {
+		return ds, nil
+	}
+	return nil, fmt.Errorf("cannot open '%s': dataset does not exist", name)
+}
+
+func (p *FakePool) snapshot(target string) (*fakeDataset, *fakeSnapshot, error) {
+	name, snapName, _ := strings.Cut(target, "@")
+	ds, err := p.existing(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, snap := range ds.snapshots {
+		if snap.Name == snapName {
+			return ds, snap, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("cannot open '%s': snapshot does not exist", target)
+}
+
+func (p *FakePool) bookmark(target string) (*fakeDataset, *fakeSnapshot, error) {
+	name, markName, _ := strings.Cut(target, "#")
+	ds, err := p.existing(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, mark := range ds.bookmarks {
+		if mark.Name == markName {
+			return ds, mark, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("cannot open '%s': bookmark does not exist", target)
+}
+
+// property will return the value of the user property on the dataset, inherited from its nearest
+// ancestor with the property set if it is not set locally.
+func (p *FakePool) property(name, prop string) DatasetProperty {
+	for ancestor := name; ; {
+		if value, ok := p.datasets[ancestor].property(prop); ok {
+			if ancestor == name {
+				return DatasetProperty{Value: value, Source: "local"}
+			}
+			return DatasetProperty{Value: value, Source: "inherited from " + ancestor}
+		}
+		idx := strings.LastIndex(ancestor, "/")
+		if idx == -1 {
+			return DatasetProperty{Value: "-", Source: "-"}
+		}
+		ancestor = ancestor[:idx]
+	}
+}
+
+func (d *fakeDataset) property(prop string) (string, bool) {
+	if d == nil {
+		return "", false
+	}
+	value, ok := d.properties[prop]
+	return value, ok
+}
+
+func containsSnapshot(snapshots []*fakeSnapshot, snap *fakeSnapshot) bool {
+	for _, s := range snapshots {
+		if s == snap {
+			return true
+		}
+	}
+	return false
+}
+
+func removeSnapshot(snapshots []*fakeSnapshot, snap *fakeSnapshot) []*fakeSnapshot {
+	kept := snapshots[:0]
+	for _, s := range snapshots {
+		if s != snap {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// fakeStreamData will return the endless, deterministic data of the stream for the snapshot with the GUID.
+func fakeStreamData(guid uint64) io.Reader {
+	var seed [32]byte
+	binary.LittleEndian.PutUint64(seed[:], guid)
+	return rand.NewChaCha8(seed)
+}
@@ -0,0 +1,129 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/someone1/zfsbackup-go/files"
+)
+
+func snapshotNames(t *testing.T, pool *FakePool, dataset string) []string {
+	t.Helper()
+	snapshots, err := pool.GetSnapshotsAndBookmarks(context.Background(), dataset)
+	require.NoError(t, err)
+	names := make([]string, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		names = append(names, snapshot.Name)
+	}
+	return names
+}
+
+func sendTo(t *testing.T, from, to *FakePool, j *files.JobInfo) error {
+	t.Helper()
+	var stream bytes.Buffer
+	require.NoError(t, from.Send(context.Background(), j, &stream))
+	return to.Receive(context.Background(), j, &stream)
+}
+
+func TestFakePoolSendReceive(t *testing.T) {
+	ctx := context.Background()
+	source := NewFakePool("tank/data")
+	target := NewFakePool("backup")
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, source.Snapshot(ctx, "tank/data@"+name))
+	}
+
+	j := &files.JobInfo{VolumeName: "tank/data", LocalVolume: "backup/data", BaseSnapshot: files.SnapshotInfo{Name: "a"}}
+	require.NoError(t, sendTo(t, source, target, j))
+	assert.Equal(t, []string{"a"}, snapshotNames(t, target, "backup/data"))
+
+	// A full stream cannot be received over existing snapshots
+	assert.Error(t, sendTo(t, source, target, j))
+
+	// Intermediary snapshots are only received with -I
+	j.BaseSnapshot.Name, j.IncrementalSnapshot.Name, j.IntermediaryIncremental = "c", "a", true
+	require.NoError(t, sendTo(t, source, target, j))
+	assert.Equal(t, []string{"c", "b", "a"}, snapshotNames(t, target, "backup/data"))
+
+	sent, err := source.GetZFSProperty(ctx, "guid", "tank/data@b")
+	require.NoError(t, err)
+	received, err := target.GetZFSProperty(ctx, "guid", "backup/data@b")
+	require.NoError(t, err)
+	assert.Equal(t, sent, received)
+
+	// An incremental stream needs its source on the receiving side
+	other := NewFakePool("backup")
+	assert.Error(t, sendTo(t, source, other, j))
+}
+
+func TestFakePoolBookmarkIncremental(t *testing.T) {
+	ctx := context.Background()
+	source := NewFakePool("tank/data")
+	target := NewFakePool("backup")
+	require.NoError(t, source.Snapshot(ctx, "tank/data@a"))
+
+	j := &files.JobInfo{VolumeName: "tank/data", LocalVolume: "backup/data", BaseSnapshot: files.SnapshotInfo{Name: "a"}}
+	require.NoError(t, sendTo(t, source, target, j))
+
+	require.NoError(t, source.Bookmark(ctx, "tank/data@a", "tank/data#a"))
+	require.NoError(t, source.Destroy(ctx, "tank/data@a"))
+	require.NoError(t, source.Snapshot(ctx, "tank/data@b"))
+
+	j.BaseSnapshot.Name = "b"
+	j.IncrementalSnapshot = files.SnapshotInfo{Name: "a", Bookmark: true}
+	require.NoError(t, sendTo(t, source, target, j))
+	assert.Equal(t, []string{"b", "a"}, snapshotNames(t, target, "backup/data"))
+}
+
+func TestFakePoolCorruptStream(t *testing.T) {
+	source := NewFakePool("tank/data")
+	target := NewFakePool("backup")
+	require.NoError(t, source.Snapshot(context.Background(), "tank/data@a"))
+
+	j := &files.JobInfo{VolumeName: "tank/data", LocalVolume: "backup/data", BaseSnapshot: files.SnapshotInfo{Name: "a"}}
+	var stream bytes.Buffer
+	require.NoError(t, source.Send(context.Background(), j, &stream))
+	corrupt := stream.Bytes()
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	assert.Error(t, target.Receive(context.Background(), j, bytes.NewReader(corrupt)))
+	assert.Error(t, target.Receive(context.Background(), j, bytes.NewReader(corrupt[:len(corrupt)/2])))
+	assert.Equal(t, []string{"backup"}, target.Datasets())
+}
+
+func TestFakePoolDestroy(t *testing.T) {
+	ctx := context.Background()
+	pool := NewFakePool("tank/data/child")
+	require.NoError(t, pool.Snapshot(ctx, "tank/data@a"))
+
+	assert.Error(t, pool.Destroy(ctx, "tank/data"), "dataset has snapshots and children")
+	require.NoError(t, pool.Destroy(ctx, "tank/data@a"))
+	assert.Error(t, pool.Destroy(ctx, "tank/data"), "dataset has children")
+	require.NoError(t, pool.Destroy(ctx, "tank/data/child"))
+	require.NoError(t, pool.Destroy(ctx, "tank/data"))
+	assert.Equal(t, []string{"tank"}, pool.Datasets())
+}
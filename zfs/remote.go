@@ -0,0 +1,155 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/someone1/zfsbackup-go/files"
+	"github.com/someone1/zfsbackup-go/sshutil"
+)
+
+// Remote runs the zfs binary found at Path on another machine over SSH, so datasets on that machine
+// can be backed up and restored to from this one.
+type Remote struct {
+	// Client is the connection to the remote machine.
+	Client *ssh.Client
+	// Path is the path to the zfs binary on the remote machine, "zfs" if empty.
+	Path string
+}
+
+var _ Runner = (*Remote)(nil)
+
+// DialRemote will connect to the host given, e.g. user@host:22, and return a Remote running the zfs
+// binary found at path on it. Connections are authenticated, and the host verified, the same way
+// the ssh backend does.
+func DialRemote(host, path string) (*Remote, error) {
+	target, err := url.Parse("ssh://" + host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote host %s - %v", host, err)
+	}
+	client, err := sshutil.Dial(target)
+	if err != nil {
+		return nil, err
+	}
+	return &Remote{Client: client, Path: path}, nil
+}
+
+// Close will close the connection to the remote machine.
+func (r *Remote) Close() error {
+	return r.Client.Close()
+}
+
+func (r *Remote) command(ctx context.Context, stdin io.Reader, stdout io.Writer, args ...string) error {
+	path := r.Path
+	if path == "" {
+		path = "zfs"
+	}
+	quoted := make([]string, 0, len(args)+1)
+	for _, arg := range append([]string{path}, args...) {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	command := strings.Join(quoted, " ")
+
+	session, err := r.Client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	errB := new(bytes.Buffer)
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = errB
+	zap.S().Debugf("Running ZFS command \"%s\" on %s", command, r.Client.RemoteAddr())
+	if err = session.Start(command); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		_ = session.Close()
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("%s (%v)", strings.TrimSpace(errB.String()), err)
+	}
+	return nil
+}
+
+// shellQuote will quote the argument so the remote shell passes it to the command as is.
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// GetSnapshotsAndBookmarks will retrieve all snapshots and bookmarks for the given target
+func (r *Remote) GetSnapshotsAndBookmarks(ctx context.Context, target string) ([]files.SnapshotInfo, error) {
+	return getSnapshotsAndBookmarks(ctx, r, target)
+}
+
+// GetZFSProperty will return the raw value returned by the "zfs get" command for
+// the given property on the given target.
+func (r *Remote) GetZFSProperty(ctx context.Context, prop, target string) (string, error) {
+	return getZFSProperty(ctx, r, prop, target)
+}
+
+// GetDatasetProperties will return the requested properties for the target and all of its
+// descendant filesystems and volumes, keyed by dataset name and then by property name.
+func (r *Remote) GetDatasetProperties(ctx context.Context, target string, props ...string) (map[string]map[string]DatasetProperty, error) {
+	return getDatasetProperties(ctx, r, target, props...)
+}
+
+// Send will run the zfs send command for the given JobInfo, writing the stream to w.
+func (r *Remote) Send(ctx context.Context, j *files.JobInfo, w io.Writer) error {
+	return send(ctx, r, j, w)
+}
+
+// Receive will run the zfs receive command for the given JobInfo, reading the stream from reader.
+func (r *Remote) Receive(ctx context.Context, j *files.JobInfo, reader io.Reader) error {
+	return receive(ctx, r, j, reader)
+}
+
+// Snapshot will run the zfs snapshot command to create the snapshot.
+func (r *Remote) Snapshot(ctx context.Context, snapshot string) error {
+	return r.command(ctx, nil, nil, "snapshot", snapshot)
+}
+
+// Bookmark will run the zfs bookmark command to create the bookmark from the snapshot.
+func (r *Remote) Bookmark(ctx context.Context, snapshot, bookmark string) error {
+	return r.command(ctx, nil, nil, "bookmark", snapshot, bookmark)
+}
+
+// Destroy will run the zfs destroy command on the target.
+func (r *Remote) Destroy(ctx context.Context, target string) error {
+	return r.command(ctx, nil, nil, "destroy", target)
+}
@@ -0,0 +1,188 @@
+// Copyright © 2016 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/someone1/zfsbackup-go/files"
+)
+
+// fakeSSHHost is an SSH server that records the commands it is asked to run, answers them with the
+// output and exit status of the handler, and keeps what was written to their stdin.
+type fakeSSHHost struct {
+	listener net.Listener
+	handler  func(command string, stdin []byte) (stdout, stderr string, status uint32)
+
+	mu       sync.Mutex
+	commands []string
+	stdins   [][]byte
+}
+
+func startFakeSSHHost(t *testing.T, user, password string) *fakeSSHHost {
+	t.Helper()
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == user && string(pass) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %q", c.User())
+		},
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	host := &fakeSSHHost{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go host.serve(conn, config)
+		}
+	}()
+	return host
+}
+
+func (h *fakeSSHHost) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				if req.Type != "exec" {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				_ = req.Reply(true, nil)
+				command := string(req.Payload[4:])
+				stdin, _ := io.ReadAll(channel)
+
+				h.mu.Lock()
+				h.commands = append(h.commands, command)
+				h.stdins = append(h.stdins, stdin)
+				h.mu.Unlock()
+
+				stdout, stderr, status := h.handler(command, stdin)
+				_, _ = io.WriteString(channel, stdout)
+				_, _ = io.WriteString(channel.Stderr(), stderr)
+				_, _ = channel.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, status))
+				_ = channel.Close()
+			}
+		}()
+	}
+}
+
+func TestRemote(t *testing.T) {
+	t.Setenv("SSH_KNOWN_HOSTS", "ignore")
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Setenv("SSH_KEY_FILE", "")
+
+	host := startFakeSSHHost(t, "test", "password")
+	host.handler = func(command string, _ []byte) (string, string, uint32) {
+		switch {
+		case strings.Contains(command, "'list'"):
+			return "tank/data@b\t200\tsnapshot\ntank/data#a\t100\tbookmark\n", "", 0
+		case strings.Contains(command, "'send'"):
+			return "stream", "", 0
+		case strings.Contains(command, "'destroy'"):
+			return "", "cannot destroy 'tank/data': filesystem has children", 1
+		}
+		return "", "", 0
+	}
+
+	remote, err := DialRemote("test:password@"+host.listener.Addr().String(), "/sbin/zfs")
+	require.NoError(t, err)
+	defer remote.Close()
+
+	ctx := context.Background()
+	snapshots, err := remote.GetSnapshotsAndBookmarks(ctx, "tank/data")
+	require.NoError(t, err)
+	if assert.Len(t, snapshots, 2) {
+		assert.Equal(t, "b", snapshots[0].Name)
+		assert.Equal(t, "a", snapshots[1].Name)
+		assert.True(t, snapshots[1].Bookmark)
+	}
+
+	j := &files.JobInfo{VolumeName: "tank/data", BaseSnapshot: files.SnapshotInfo{Name: "b"}, LocalVolume: "tank/it's"}
+	stream := new(bytes.Buffer)
+	require.NoError(t, remote.Send(ctx, j, stream))
+	assert.Equal(t, "stream", stream.String())
+
+	require.NoError(t, remote.Receive(ctx, j, strings.NewReader("received stream")))
+
+	err = remote.Destroy(ctx, "tank/data")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "filesystem has children")
+	}
+
+	host.mu.Lock()
+	defer host.mu.Unlock()
+	assert.Equal(t, []string{
+		`'/sbin/zfs' 'list' '-H' '-d' '1' '-p' '-t' 'snapshot,bookmark' '-r' '-o' 'name,creation,type' '-S' 'creation' 'tank/data'`,
+		`'/sbin/zfs' 'send' 'tank/data@b'`,
+		`'/sbin/zfs' 'receive' 'tank/it'\''s'`,
+		`'/sbin/zfs' 'destroy' 'tank/data'`,
+	}, host.commands)
+	assert.Equal(t, "received stream", string(host.stdins[2]))
+}
+
+func TestRemoteBadPassword(t *testing.T) {
+	t.Setenv("SSH_KNOWN_HOSTS", "ignore")
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Setenv("SSH_KEY_FILE", "")
+
+	host := startFakeSSHHost(t, "test", "password")
+	_, err := DialRemote("test:wrong@"+host.listener.Addr().String(), "")
+	assert.Error(t, err)
+	var netErr *net.OpError
+	assert.False(t, errors.As(err, &netErr), "the host was reachable")
+}
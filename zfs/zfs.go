@@ -24,6 +24,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -34,17 +35,107 @@ import (
 	"github.com/someone1/zfsbackup-go/files"
 )
 
-// ZFSPath is the path to the zfs binary
-var (
-	ZFSPath = "zfs"
-)
+// Runner runs the zfs commands needed to backup and restore datasets.
+type Runner interface {
+	// GetSnapshotsAndBookmarks will retrieve all snapshots and bookmarks for the given target, newest first.
+	GetSnapshotsAndBookmarks(ctx context.Context, target string) ([]files.SnapshotInfo, error)
+	// GetZFSProperty will return the raw value of the given property on the given target.
+	GetZFSProperty(ctx context.Context, prop, target string) (string, error)
+	// GetDatasetProperties will return the requested properties for the target and all of its
+	// descendant filesystems and volumes, keyed by dataset name and then by property name.
+	GetDatasetProperties(ctx context.Context, target string, props ...string) (map[string]map[string]DatasetProperty, error)
+	// Send will write the send stream described by the JobInfo to w.
+	Send(ctx context.Context, j *files.JobInfo, w io.Writer) error
+	// Receive will receive the stream read from r as described by the JobInfo.
+	Receive(ctx context.Context, j *files.JobInfo, r io.Reader) error
+	// Snapshot will create the snapshot, e.g. "tank/data@snap".
+	Snapshot(ctx context.Context, snapshot string) error
+	// Bookmark will create the bookmark, e.g. "tank/data#mark", from the snapshot.
+	Bookmark(ctx context.Context, snapshot, bookmark string) error
+	// Destroy will destroy the dataset, snapshot, or bookmark.
+	Destroy(ctx context.Context, target string) error
+}
 
-// GetCreationDate will use the zfs command to get and parse the creation datetime
-// of the specified volume/snapshot
-var GetCreationDate = getCreationDate
+// commander runs a zfs command with the given arguments, reading its input from stdin and writing its
+// output to stdout, either of which may be nil. Errors include what the command wrote to stderr.
+type commander interface {
+	command(ctx context.Context, stdin io.Reader, stdout io.Writer, args ...string) error
+}
+
+// Local runs the zfs binary found at Path on this machine.
+type Local struct {
+	// Path is the path to the zfs binary, "zfs" if empty.
+	Path string
+}
+
+var _ Runner = (*Local)(nil)
+
+func (l *Local) path() string {
+	if l.Path == "" {
+		return "zfs"
+	}
+	return l.Path
+}
+
+func (l *Local) command(ctx context.Context, stdin io.Reader, stdout io.Writer, args ...string) error {
+	errB := new(bytes.Buffer)
+	cmd := exec.CommandContext(ctx, l.path(), args...)
+	zap.S().Debugf("Running ZFS command \"%s\"", strings.Join(cmd.Args, " "))
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = errB
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s (%v)", strings.TrimSpace(errB.String()), err)
+	}
+	return nil
+}
+
+// GetSnapshotsAndBookmarks will retrieve all snapshots and bookmarks for the given target
+func (l *Local) GetSnapshotsAndBookmarks(ctx context.Context, target string) ([]files.SnapshotInfo, error) {
+	return getSnapshotsAndBookmarks(ctx, l, target)
+}
+
+// GetZFSProperty will return the raw value returned by the "zfs get" command for
+// the given property on the given target.
+func (l *Local) GetZFSProperty(ctx context.Context, prop, target string) (string, error) {
+	return getZFSProperty(ctx, l, prop, target)
+}
+
+// GetDatasetProperties will return the requested properties for the target and all of its
+// descendant filesystems and volumes, keyed by dataset name and then by property name.
+func (l *Local) GetDatasetProperties(ctx context.Context, target string, props ...string) (map[string]map[string]DatasetProperty, error) {
+	return getDatasetProperties(ctx, l, target, props...)
+}
 
-func getCreationDate(ctx context.Context, target string) (time.Time, error) {
-	rawTime, err := GetZFSProperty(ctx, "creation", target)
+// Send will run the zfs send command for the given JobInfo, writing the stream to w.
+func (l *Local) Send(ctx context.Context, j *files.JobInfo, w io.Writer) error {
+	return send(ctx, l, j, w)
+}
+
+// Receive will run the zfs receive command for the given JobInfo, reading the stream from r.
+func (l *Local) Receive(ctx context.Context, j *files.JobInfo, r io.Reader) error {
+	return receive(ctx, l, j, r)
+}
+
+// Snapshot will run the zfs snapshot command to create the snapshot.
+func (l *Local) Snapshot(ctx context.Context, snapshot string) error {
+	return l.command(ctx, nil, nil, "snapshot", snapshot)
+}
+
+// Bookmark will run the zfs bookmark command to create the bookmark from the snapshot.
+func (l *Local) Bookmark(ctx context.Context, snapshot, bookmark string) error {
+	return l.command(ctx, nil, nil, "bookmark", snapshot, bookmark)
+}
+
+// Destroy will run the zfs destroy command on the target.
+func (l *Local) Destroy(ctx context.Context, target string) error {
+	return l.command(ctx, nil, nil, "destroy", target)
+}
+
+// GetCreationDate will use the runner to get and parse the creation datetime
+// of the specified volume/snapshot
+func GetCreationDate(ctx context.Context, r Runner, target string) (time.Time, error) {
+	rawTime, err := r.GetZFSProperty(ctx, "creation", target)
 	if err != nil {
 		return time.Time{}, err
 	}
@@ -55,32 +146,21 @@ func getCreationDate(ctx context.Context, target string) (time.Time, error) {
 	return time.Unix(epochTime, 0), nil
 }
 
-// GetSnapshotsAndBookmarks will retrieve all snapshots and bookmarks for the given target
-var GetSnapshotsAndBookmarks = getSnapshotsAndBookmarks
-
-func getSnapshotsAndBookmarks(ctx context.Context, target string) ([]files.SnapshotInfo, error) {
-	errB := new(bytes.Buffer)
-	cmd := exec.CommandContext(
-		ctx, ZFSPath, "list", "-H", "-d", "1", "-p", "-t", "snapshot,bookmark", "-r", "-o", "name,creation,type", "-S", "creation", target,
+func getSnapshotsAndBookmarks(ctx context.Context, c commander, target string) ([]files.SnapshotInfo, error) {
+	b := new(bytes.Buffer)
+	err := c.command(
+		ctx, nil, b, "list", "-H", "-d", "1", "-p", "-t", "snapshot,bookmark", "-r", "-o", "name,creation,type", "-S", "creation", target,
 	)
-	zap.S().Debugf("Getting ZFS Snapshots with command \"%s\"", strings.Join(cmd.Args, " "))
-	cmd.Stderr = errB
-
-	rpipe, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, err
 	}
 
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("%s (%v)", strings.TrimSpace(errB.String()), err)
-	}
-
 	var snapshots []files.SnapshotInfo
 	for {
 		snapInfo := files.SnapshotInfo{}
 		var creation int64
 		var objectType string
-		n, nerr := fmt.Fscanln(rpipe, &snapInfo.Name, &creation, &objectType)
+		n, nerr := fmt.Fscanln(b, &snapInfo.Name, &creation, &objectType)
 		if n == 0 || nerr != nil {
 			break
 		}
@@ -93,59 +173,88 @@ func getSnapshotsAndBookmarks(ctx context.Context, target string) ([]files.Snaps
 		}
 		snapshots = append(snapshots, snapInfo)
 	}
-	err = cmd.Wait()
-	if err != nil {
-		return nil, fmt.Errorf("%s (%v)", strings.TrimSpace(errB.String()), err)
-	}
 
 	return snapshots, nil
 }
 
-// GetZFSProperty will return the raw value returned by the "zfs get" command for
-// the given property on the given target.
-func GetZFSProperty(ctx context.Context, prop, target string) (string, error) {
+func getZFSProperty(ctx context.Context, c commander, prop, target string) (string, error) {
 	b := new(bytes.Buffer)
-	errB := new(bytes.Buffer)
-	cmd := exec.CommandContext(ctx, ZFSPath, "get", "-H", "-p", "-o", "value", prop, target)
-	zap.S().Debugf("Getting ZFS Property with command \"%s\"", strings.Join(cmd.Args, " "))
-	cmd.Stdout = b
-	cmd.Stderr = errB
-	err := cmd.Run()
-	if err != nil {
-		return "", fmt.Errorf("%s (%v)", strings.TrimSpace(errB.String()), err)
+	if err := c.command(ctx, nil, b, "get", "-H", "-p", "-o", "value", prop, target); err != nil {
+		return "", err
 	}
 	return strings.TrimSpace(b.String()), nil
 }
 
-// GetZFSSendCommand will return the send command to use for the given JobInfo
-var GetZFSSendCommand = getZFSSendCommand
+// DatasetProperty represents the value of a property on a dataset along with where
+// the value came from (e.g. "local", "default", "inherited from tank").
+type DatasetProperty struct {
+	Value  string
+	Source string
+}
+
+// IsSet will return true if the property has a value, either locally or inherited.
+func (d DatasetProperty) IsSet() bool {
+	return d.Source != "-" && d.Source != "" && d.Source != "default"
+}
 
-func getZFSSendCommand(ctx context.Context, j *files.JobInfo) *exec.Cmd {
-	// Prepare the zfs send command
+// IsInherited will return true if the property value was inherited from a parent dataset.
+func (d DatasetProperty) IsInherited() bool {
+	return strings.HasPrefix(d.Source, "inherited")
+}
+
+func getDatasetProperties(ctx context.Context, c commander, target string, props ...string) (map[string]map[string]DatasetProperty, error) {
+	b := new(bytes.Buffer)
+	err := c.command(
+		ctx, nil, b, "get", "-H", "-p", "-r", "-t", "filesystem,volume", "-o", "name,property,value,source", strings.Join(props, ","), target,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	datasets := make(map[string]map[string]DatasetProperty)
+	for _, line := range strings.Split(b.String(), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected output from zfs get: %q", line)
+		}
+		if _, ok := datasets[fields[0]]; !ok {
+			datasets[fields[0]] = make(map[string]DatasetProperty)
+		}
+		datasets[fields[0]][fields[1]] = DatasetProperty{Value: fields[2], Source: fields[3]}
+	}
+
+	return datasets, nil
+}
+
+// SendCommandLine will return the zfs send command line for the given JobInfo.
+func SendCommandLine(j *files.JobInfo) string {
+	return strings.Join(append([]string{"zfs"}, SendArgs(j)...), " ")
+}
+
+// SendArgs will return the arguments to the zfs command to send the given JobInfo
+func SendArgs(j *files.JobInfo) []string {
 	zfsArgs := []string{"send"}
 
 	if j.Replication {
-		zap.S().Infof("Enabling the replication (-R) flag on the send.")
 		zfsArgs = append(zfsArgs, "-R")
 	}
 
 	if j.SkipMissing {
-		zap.S().Infof("Enabling the skip-missing (-s) flag on the send.")
 		zfsArgs = append(zfsArgs, "-s")
 	}
 
 	if j.Deduplication {
-		zap.S().Infof("Enabling the deduplication (-D) flag on the send.")
 		zfsArgs = append(zfsArgs, "-D")
 	}
 
 	if j.Properties {
-		zap.S().Infof("Enabling the properties (-p) flag on the send.")
 		zfsArgs = append(zfsArgs, "-p")
 	}
 
 	if j.Raw {
-		zap.S().Infof("Enabling the raw (-w) flag on the send.")
 		zfsArgs = append(zfsArgs, "-w")
 	}
 
@@ -156,48 +265,48 @@ func getZFSSendCommand(ctx context.Context, j *files.JobInfo) *exec.Cmd {
 		}
 
 		if j.IntermediaryIncremental {
-			zap.S().Infof("Enabling an incremental stream with all intermediary snapshots (-I) on the send to snapshot %s", incrementalName)
 			zfsArgs = append(zfsArgs, "-I", incrementalName)
 		} else {
-			zap.S().Infof("Enabling an incremental stream (-i) on the send to snapshot %s", incrementalName)
 			zfsArgs = append(zfsArgs, "-i", incrementalName)
 		}
 	}
 
-	return exec.CommandContext(ctx, ZFSPath, append(zfsArgs, fmt.Sprintf("%s@%s", j.VolumeName, j.BaseSnapshot.Name))...)
+	return append(zfsArgs, fmt.Sprintf("%s@%s", j.VolumeName, j.BaseSnapshot.Name))
 }
 
-// GetZFSReceiveCommand will return the recv command to use for the given JobInfo
-var GetZFSReceiveCommand = getZFSReceiveCommand
-
-func getZFSReceiveCommand(ctx context.Context, j *files.JobInfo) *exec.Cmd {
-	// Prepare the zfs send command
+// ReceiveArgs will return the arguments to the zfs command to receive the given JobInfo
+func ReceiveArgs(j *files.JobInfo) []string {
 	zfsArgs := []string{"receive"}
 
 	if j.FullPath {
-		zap.S().Infof("Enabling the full path (-d) flag on the receive.")
 		zfsArgs = append(zfsArgs, "-d")
 	}
 
 	if j.LastPath {
-		zap.S().Infof("Enabling the last path (-e) flag on the receive.")
 		zfsArgs = append(zfsArgs, "-e")
 	}
 
 	if j.NotMounted {
-		zap.S().Infof("Enabling the not mounted (-u) flag on the receive.")
 		zfsArgs = append(zfsArgs, "-u")
 	}
 
 	if j.Force {
-		zap.S().Infof("Enabling the forced rollback (-F) flag on the receive.")
 		zfsArgs = append(zfsArgs, "-F")
 	}
 
 	if j.Origin != "" {
-		zap.S().Infof("Enabling the origin flag (-o) on the receive to %s", j.Origin)
 		zfsArgs = append(zfsArgs, "-o", "origin="+j.Origin)
 	}
 
-	return exec.CommandContext(ctx, ZFSPath, append(zfsArgs, j.LocalVolume)...)
+	return append(zfsArgs, j.LocalVolume)
+}
+
+func send(ctx context.Context, c commander, j *files.JobInfo, w io.Writer) error {
+	zap.S().Infof("Starting zfs send command: %s", SendCommandLine(j))
+	return c.command(ctx, nil, w, SendArgs(j)...)
+}
+
+func receive(ctx context.Context, c commander, j *files.JobInfo, r io.Reader) error {
+	zap.S().Infof("Starting zfs receive command: zfs %s", strings.Join(ReceiveArgs(j), " "))
+	return c.command(ctx, r, nil, ReceiveArgs(j)...)
 }